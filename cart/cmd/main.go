@@ -1,22 +1,74 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
 	"github.com/madrabit/mini-market/cart/internal"
+	"github.com/madrabit/mini-market/cart/internal/common"
+	"github.com/madrabit/mini-market/cart/internal/validator"
 	"github.com/madrabit/mini-market/cart/internal/web"
+	"go.uber.org/zap"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	controller := internal.NewController()
+	cfg, err := common.Load()
+	if err != nil {
+		log.Fatalf("config load error: %v", err)
+	}
+	logger := common.NewLogger(cfg)
+	db := sqlx.MustConnect("postgres", cfg.DB.DSN())
+	defer func() {
+		err := db.Close()
+		if err != nil {
+			logger.Error("failed to close db")
+		}
+	}()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := build(ctx, db, logger, cfg)
+	httpServer := &http.Server{Addr: cfg.Server.Address + ":" + cfg.Server.Port, Handler: server.Router}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("failed to shutdown server", zap.Error(err))
+		}
+	}()
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Fatal("server failed", zap.Error(err))
+	}
+}
+
+// build собирает зависимости и запускает фоновые задачи, которые живут до отмены ctx
+func build(ctx context.Context, db *sqlx.DB, logger *common.Logger, cfg common.Config) *web.Server {
 	server := web.NewServer()
+	vld := validator.New()
+	repository := internal.NewRepository(db)
+	catalog := internal.NewCatalogClient(cfg.Services.CatalogURL)
+	users := internal.NewUsersClient(cfg.Services.UsersURL)
+	notifier := internal.NewNotificationClient(cfg.Services.NotificationURL)
+	service := internal.NewService(repository, vld, catalog, internal.NewOrderClient(cfg.Services.OrderURL), users,
+		internal.NewOrderSigner(cfg.Callback))
+	wishlists := internal.NewWishlistService(repository, vld, catalog, notifier, users,
+		internal.NewInventoryClient(cfg.Services.InventoryURL))
+	abandoned := internal.NewAbandonedCartJob(repository, notifier, users,
+		internal.NewAnalyticsClient(cfg.Services.AnalyticsURL), logger, cfg.AbandonedCart)
+	go abandoned.Start(ctx)
+	controller := internal.NewController(service, wishlists, *logger)
 	server.Router.Route("/api", func(r chi.Router) {
-		r.Route("v1", func(r chi.Router) {
+		r.Route("/v1", func(r chi.Router) {
 			r.Mount("/carts", controller.Routes())
 		})
 	})
-	err := http.ListenAndServe("8081", server.Router)
-	if err != nil {
-		return
-	}
+	return server
 }
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/cart/internal/common"
+	"go.uber.org/zap"
+	"time"
+)
+
+const CartAbandonedEvent = "cart.abandoned"
+
+type AbandonedCartRepo interface {
+	FindAbandonedCarts(idleSince time.Time, limit int) ([]AbandonedCart, error)
+	MarkCartReminded(cartID uuid.UUID, at time.Time) error
+	MarkCartAbandoned(cartID uuid.UUID, at time.Time) error
+}
+
+type EventPublisher interface {
+	Publish(event Event) error
+}
+
+// AbandonedCartJob периодически ищет корзины, которые не менялись дольше порога и не ждут
+// оплаты оформленного заказа, напоминает о них пользователю и отправляет событие cart.abandoned в Analytics.
+// Корзина помечается брошенной только после публикации события, иначе она будет обработана на следующем
+// запуске; повторное напоминание при этом не отправляется.
+type AbandonedCartJob struct {
+	repo     AbandonedCartRepo
+	notifier Notifier
+	users    Users
+	events   EventPublisher
+	logger   *common.Logger
+	cfg      common.AbandonedCartConfig
+}
+
+func NewAbandonedCartJob(repo AbandonedCartRepo, notifier Notifier, users Users, events EventPublisher,
+	logger *common.Logger, cfg common.AbandonedCartConfig) *AbandonedCartJob {
+	return &AbandonedCartJob{
+		repo:     repo,
+		notifier: notifier,
+		users:    users,
+		events:   events,
+		logger:   logger,
+		cfg:      cfg,
+	}
+}
+
+func (j *AbandonedCartJob) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.RunOnce(time.Now()); err != nil {
+				j.logger.Error("abandoned cart job failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (j *AbandonedCartJob) RunOnce(now time.Time) error {
+	carts, err := j.repo.FindAbandonedCarts(now.Add(-j.cfg.IdleThreshold), j.cfg.BatchSize)
+	if err != nil {
+		return fmt.Errorf("abandoned cart job: failed to find carts: %w", err)
+	}
+	for _, cart := range carts {
+		if !cart.OptedOut && !cart.Reminded {
+			if err = j.remind(cart); err != nil {
+				j.logger.Error("failed to send abandoned cart reminder", zap.Error(err),
+					zap.String("cart_id", cart.CartID.String()))
+				continue
+			}
+			if err = j.repo.MarkCartReminded(cart.CartID, now); err != nil {
+				return fmt.Errorf("abandoned cart job: failed to mark cart %s reminded: %w", cart.CartID, err)
+			}
+			cart.Reminded = true
+		}
+		if err = j.publish(cart, now); err != nil {
+			j.logger.Error("failed to publish cart.abandoned event", zap.Error(err),
+				zap.String("cart_id", cart.CartID.String()))
+			continue
+		}
+		if err = j.repo.MarkCartAbandoned(cart.CartID, now); err != nil {
+			return fmt.Errorf("abandoned cart job: failed to mark cart %s: %w", cart.CartID, err)
+		}
+	}
+	return nil
+}
+
+func (j *AbandonedCartJob) remind(cart AbandonedCart) error {
+	user, err := j.users.GetUser(cart.UserID)
+	if err != nil {
+		return err
+	}
+	return j.notifier.Notify(NotificationRequest{
+		UserID:  cart.UserID,
+		To:      user.Email,
+		Type:    "email",
+		Subject: "You left items in your cart",
+		Text:    fmt.Sprintf("You have %d item(s) waiting in your cart", cart.ItemsQty),
+	})
+}
+
+func (j *AbandonedCartJob) publish(cart AbandonedCart, now time.Time) error {
+	payload, err := json.Marshal(CartAbandonedPayload{
+		Version:   1,
+		CartID:    cart.CartID,
+		UserID:    cart.UserID,
+		ItemsQty:  cart.ItemsQty,
+		IdleSince: cart.UpdatedAt,
+		Reminded:  cart.Reminded,
+	})
+	if err != nil {
+		return err
+	}
+	return j.events.Publish(Event{
+		ID:         uuid.New(),
+		Type:       CartAbandonedEvent,
+		Payload:    payload,
+		OccurredAt: now,
+	})
+}
@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/cart/internal/common"
+	"github.com/madrabit/mini-market/cart/internal/validator"
+	"testing"
+	"time"
+)
+
+// checkoutRepo добавляет к cartRepo оформление заказа и списание промокодов
+type checkoutRepo struct {
+	*cartRepo
+	checkouts []Checkout
+	locks     int
+	recorded  map[uuid.UUID][]uuid.UUID
+}
+
+func (r *checkoutRepo) GetCart(userID uuid.UUID) (Cart, error) {
+	r.cart.Userid = userID
+	return r.cart, nil
+}
+
+func (r *checkoutRepo) GetCartPromotions(_ uuid.UUID) ([]Promotion, error) { return r.applied, nil }
+
+func (r *checkoutRepo) GetPurchaseRules(_ []uuid.UUID) ([]PurchaseRule, error) { return nil, nil }
+
+func (r *checkoutRepo) GetPromotionsForUpdate(_ *sqlx.Tx, ids []uuid.UUID) ([]Promotion, error) {
+	r.locks++
+	var promos []Promotion
+	for _, p := range r.promos {
+		for _, id := range ids {
+			if p.ID == id {
+				promos = append(promos, p)
+			}
+		}
+	}
+	return promos, nil
+}
+
+func (r *checkoutRepo) GetPendingCheckout(_ *sqlx.Tx, cartID uuid.UUID) (Checkout, error) {
+	for _, c := range r.checkouts {
+		if c.CartID == cartID && c.Status == CheckoutPending {
+			return c, nil
+		}
+	}
+	return Checkout{}, sql.ErrNoRows
+}
+
+func (r *checkoutRepo) CreateCheckout(_ *sqlx.Tx, checkout Checkout) error {
+	r.checkouts = append(r.checkouts, checkout)
+	return nil
+}
+
+func (r *checkoutRepo) SetCheckoutOrder(checkoutID, orderID uuid.UUID) error {
+	for i := range r.checkouts {
+		if r.checkouts[i].ID == checkoutID {
+			r.checkouts[i].OrderID = &orderID
+		}
+	}
+	return nil
+}
+
+func (r *checkoutRepo) GetCheckoutByOrder(_ *sqlx.Tx, orderID uuid.UUID) (Checkout, error) {
+	for _, c := range r.checkouts {
+		if c.OrderID != nil && *c.OrderID == orderID {
+			return c, nil
+		}
+	}
+	return Checkout{}, sql.ErrNoRows
+}
+
+func (r *checkoutRepo) UpdateCheckoutStatus(_ *sqlx.Tx, checkoutID uuid.UUID, status CheckoutStatus) error {
+	for i := range r.checkouts {
+		if r.checkouts[i].ID == checkoutID {
+			r.checkouts[i].Status = status
+		}
+	}
+	return nil
+}
+
+func (r *checkoutRepo) AddPromotionUsages(_ *sqlx.Tx, _, orderID uuid.UUID, ids []uuid.UUID) error {
+	r.recorded[orderID] = ids
+	for _, id := range ids {
+		r.usages[id]++
+	}
+	return nil
+}
+
+func (r *checkoutRepo) ClearCart(_ *sqlx.Tx, _ uuid.UUID) error {
+	r.cart.Items = make(map[uuid.UUID]Product)
+	r.applied = nil
+	return nil
+}
+
+type priceList map[uuid.UUID]int64
+
+func (p priceList) GetProducts(req CatalogRequest) (CatalogResponse, error) {
+	var resp CatalogResponse
+	for _, id := range req.ProductIds {
+		resp.Products = append(resp.Products, CatalogProduct{Id: id, Price: p[id]})
+	}
+	return resp, nil
+}
+
+type orderStub struct{}
+
+func (orderStub) CreateOrder(req CreatOrderRequest, _ string) (OrderResponse, error) {
+	return OrderResponse{ID: uuid.New(), UserId: req.UserID, Promotions: req.Promotions}, nil
+}
+
+func TestCheckoutPromotionUsages(t *testing.T) {
+	product := uuid.New()
+	once := Promotion{ID: uuid.New(), Code: "ONCE", Type: FixedAmount, Value: 100, Stackable: true, UsageLimit: 1,
+		StartsAt: time.Now().Add(-time.Hour)}
+	base := newCartRepo(once)
+	base.cart.Items[product] = Product{ProductId: product, Qty: 1}
+	base.applied = []Promotion{once}
+	repo := &checkoutRepo{cartRepo: base, recorded: make(map[uuid.UUID][]uuid.UUID)}
+	signer := NewOrderSigner(common.CallbackConfig{OrderSecret: callbackSecret, MaxSkew: time.Minute})
+	svc := NewService(repo, validator.New(), priceList{product: 500}, orderStub{}, nil, signer)
+	userID := uuid.New()
+
+	order, err := svc.Checkout(CheckoutRequest{UserID: userID})
+	if err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if ids := repo.checkouts[0].PromotionIDs; len(ids) != 1 || ids[0] != once.ID {
+		t.Fatalf("checkout promotions = %v, want [%s]", ids, once.ID)
+	}
+	if repo.locks != 1 {
+		t.Errorf("promotions locked %d times at checkout, want 1", repo.locks)
+	}
+
+	// промокод сняли с корзины до оплаты: списывается то, с чем ушел заказ
+	repo.applied = nil
+	if err = svc.ConfirmCheckoutPayment(signedConfirm(signer, order.ID, time.Now())); err != nil {
+		t.Fatalf("confirm payment: %v", err)
+	}
+	if ids := repo.recorded[order.ID]; len(ids) != 1 || ids[0] != once.ID {
+		t.Errorf("recorded usages = %v, want [%s]", ids, once.ID)
+	}
+	if repo.locks != 2 {
+		t.Errorf("promotions locked %d times after payment, want 2", repo.locks)
+	}
+
+	repo.cart.Items[product] = Product{ProductId: product, Qty: 2}
+	repo.applied = []Promotion{once}
+	var conflict *common.ConflictError
+	if _, err = svc.Checkout(CheckoutRequest{UserID: userID}); !errors.As(err, &conflict) {
+		t.Errorf("second checkout with ONCE: err = %v, want ConflictError", err)
+	}
+}
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/cart/internal/common"
+	"net/http"
+	"time"
+)
+
+type CatalogClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewCatalogClient(baseURL string) *CatalogClient {
+	return &CatalogClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (c *CatalogClient) GetProducts(req CatalogRequest) (CatalogResponse, error) {
+	var resp common.Response[CatalogResponse]
+	if err := postJSON(c.client, c.baseURL+"/api/v1/catalogs/bulk-get", nil, req, &resp); err != nil {
+		return CatalogResponse{}, fmt.Errorf("catalog client: get products: %w", err)
+	}
+	return resp.Data, nil
+}
+
+type InventoryClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewInventoryClient(baseURL string) *InventoryClient {
+	return &InventoryClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (c *InventoryClient) GetItems(req InventoryItemsRequest) (InventoryItemsResponse, error) {
+	var resp common.Response[InventoryItemsResponse]
+	if err := postJSON(c.client, c.baseURL+"/api/v1/inventories/bulk-get", nil, req, &resp); err != nil {
+		return InventoryItemsResponse{}, fmt.Errorf("inventory client: get items: %w", err)
+	}
+	return resp.Data, nil
+}
+
+type OrderClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewOrderClient(baseURL string) *OrderClient {
+	return &OrderClient{baseURL: baseURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// CreateOrder создает заказ в сервисе Order. Повтор с тем же ключом идемпотентности вернет уже созданный заказ.
+func (c *OrderClient) CreateOrder(req CreatOrderRequest, idempotencyKey string) (OrderResponse, error) {
+	var resp common.Response[OrderResponse]
+	header := http.Header{}
+	header.Set("Idempotency-Key", idempotencyKey)
+	if err := postJSON(c.client, c.baseURL+"/api/v1/orders", header, req, &resp); err != nil {
+		return OrderResponse{}, fmt.Errorf("order client: create order: %w", err)
+	}
+	return resp.Data, nil
+}
+
+type NotificationClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewNotificationClient(baseURL string) *NotificationClient {
+	return &NotificationClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (c *NotificationClient) Notify(req NotificationRequest) error {
+	if err := postJSON(c.client, c.baseURL+"/api/v1/notifications/notify", nil, req, nil); err != nil {
+		return fmt.Errorf("notification client: notify: %w", err)
+	}
+	return nil
+}
+
+type AnalyticsClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewAnalyticsClient(baseURL string) *AnalyticsClient {
+	return &AnalyticsClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (c *AnalyticsClient) Publish(event Event) error {
+	if err := postJSON(c.client, c.baseURL+"/api/v1/analytics/orders/", nil, event, nil); err != nil {
+		return fmt.Errorf("analytics client: publish %s: %w", event.Type, err)
+	}
+	return nil
+}
+
+type UsersClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewUsersClient(baseURL string) *UsersClient {
+	return &UsersClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (c *UsersClient) GetUser(userID uuid.UUID) (UserProfile, error) {
+	var resp common.Response[UserProfile]
+	if err := getJSON(c.client, c.baseURL+"/api/v1/users/"+userID.String(), &resp); err != nil {
+		return UserProfile{}, fmt.Errorf("users client: get user %s: %w", userID, err)
+	}
+	return resp.Data, nil
+}
+
+func getJSON(client *http.Client, url string, out any) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func postJSON(client *http.Client, url string, header http.Header, body any, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
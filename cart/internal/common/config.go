@@ -1,14 +1,19 @@
 package common
 
 import (
+	"fmt"
 	"github.com/kelseyhightower/envconfig"
 	"os"
 	"strings"
+	"time"
 )
 
 type Config struct {
 	DB             DBConfig
 	Server         ServerConfig
+	Services       ServicesConfig
+	AbandonedCart  AbandonedCartConfig
+	Callback       CallbackConfig
 	LogLevel       string
 	LogDevelopMode bool
 	AllowedOrigins []string
@@ -27,6 +32,27 @@ type ServerConfig struct {
 	Port    string `envconfig:"PORT" required:"true"`
 }
 
+type ServicesConfig struct {
+	CatalogURL      string `envconfig:"CATALOG_URL" required:"true"`
+	OrderURL        string `envconfig:"ORDER_URL" required:"true"`
+	NotificationURL string `envconfig:"NOTIFICATION_URL" required:"true"`
+	AnalyticsURL    string `envconfig:"ANALYTICS_URL" required:"true"`
+	UsersURL        string `envconfig:"USERS_URL" required:"true"`
+	InventoryURL    string `envconfig:"INVENTORY_URL" required:"true"`
+}
+
+type AbandonedCartConfig struct {
+	IdleThreshold time.Duration `envconfig:"IDLE_THRESHOLD" default:"24h"`
+	CheckInterval time.Duration `envconfig:"CHECK_INTERVAL" default:"15m"`
+	BatchSize     int           `envconfig:"BATCH_SIZE" default:"100"`
+}
+
+// CallbackConfig проверка подтверждения оплаты от Order
+type CallbackConfig struct {
+	OrderSecret string        `envconfig:"ORDER_SECRET" required:"true"` // общий с Order ключ подписи
+	MaxSkew     time.Duration `envconfig:"MAX_SKEW" default:"5m"`        // насколько старую подпись еще принимаем
+}
+
 func Load() (Config, error) {
 	var cfg Config = Config{
 		LogLevel:       os.Getenv("LOG_LEVEL"),
@@ -43,6 +69,21 @@ func Load() (Config, error) {
 	} else {
 		cfg.Server = server
 	}
+	if services, err := LoadServicesConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Services = services
+	}
+	if abandoned, err := LoadAbandonedCartConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.AbandonedCart = abandoned
+	}
+	if callback, err := LoadCallbackConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Callback = callback
+	}
 	return cfg, nil
 }
 
@@ -63,3 +104,37 @@ func LoadServerConfig() (ServerConfig, error) {
 	}
 	return cfg, nil
 }
+
+func LoadServicesConfig() (ServicesConfig, error) {
+	var cfg ServicesConfig
+	err := envconfig.Process("SERVICES", &cfg)
+	if err != nil {
+		return ServicesConfig{}, err
+	}
+	return cfg, nil
+}
+
+func LoadAbandonedCartConfig() (AbandonedCartConfig, error) {
+	var cfg AbandonedCartConfig
+	err := envconfig.Process("ABANDONED_CART", &cfg)
+	if err != nil {
+		return AbandonedCartConfig{}, err
+	}
+	return cfg, nil
+}
+
+func LoadCallbackConfig() (CallbackConfig, error) {
+	var cfg CallbackConfig
+	err := envconfig.Process("CALLBACK", &cfg)
+	if err != nil {
+		return CallbackConfig{}, err
+	}
+	return cfg, nil
+}
+
+func (db DBConfig) DSN() string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		db.Server, db.Port, db.User, db.Pass, db.Database,
+	)
+}
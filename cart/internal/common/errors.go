@@ -23,3 +23,44 @@ type NotFoundError struct {
 func (err *NotFoundError) Error() string {
 	return err.Message
 }
+
+type ConflictError struct {
+	Message string
+}
+
+func (err *ConflictError) Error() string {
+	return err.Message
+}
+
+type UnauthorizedError struct {
+	Message string
+}
+
+func (err *UnauthorizedError) Error() string {
+	return err.Message
+}
+
+const (
+	RuleMaxQtyExceeded   = "max_qty_exceeded"
+	RuleMinQtyNotMet     = "min_qty_not_met"
+	RulePackMultiple     = "pack_multiple"
+	RuleAgeRestricted    = "age_restricted"
+	RuleRegionRestricted = "region_restricted"
+)
+
+// RuleViolation нарушение правила покупки с машиночитаемым кодом для локализации на фронте
+type RuleViolation struct {
+	Code      string         `json:"code"`
+	ProductID string         `json:"product_id"`
+	Message   string         `json:"message"`
+	Params    map[string]any `json:"params,omitempty"`
+}
+
+type PurchaseRuleError struct {
+	RequestValidationError
+	Violations []RuleViolation
+}
+
+func (err *PurchaseRuleError) Unwrap() error {
+	return &err.RequestValidationError
+}
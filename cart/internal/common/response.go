@@ -38,3 +38,13 @@ func OkResponse[T any](w http.ResponseWriter, data T) {
 func OkResponseMsg[T any](w http.ResponseWriter, data T, msg string) {
 	okResponseInternal(w, data, &msg)
 }
+
+func ErrResponseData[T any](w http.ResponseWriter, code int, msg string, data T) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(Response[T]{
+		Success: false,
+		Message: &msg,
+		Data:    data,
+	})
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/madrabit/mini-market/cart/internal/common"
@@ -21,19 +22,44 @@ TODO
 */
 
 type Controller struct {
-	svc    Svc
-	logger *common.Logger
+	svc       Svc
+	wishlists SvcWishlists
+	logger    *common.Logger
 }
 
-func NewController(svc Svc, logger common.Logger) *Controller {
-	return &Controller{svc: svc, logger: &logger}
+func NewController(svc Svc, wishlists SvcWishlists, logger common.Logger) *Controller {
+	return &Controller{svc: svc, wishlists: wishlists, logger: &logger}
 }
 
 type Svc interface {
-	GetCart(userID uuid.UUID) (Cart, error)
+	GetCart(userID uuid.UUID) (PricedCart, error)
 	AddToCart(item AddToCartRequest) error
 	UpdateCart(item UpdateCartItemRequest) error
-	DeleteProduct(id uuid.UUID) error
+	DeleteProduct(userID, productID uuid.UUID) error
+	CreatePromotion(req CreatePromotionRequest) (Promotion, error)
+	ApplyPromoCode(req ApplyPromoCodeRequest) (PricedCart, error)
+	RemovePromoCode(req RemovePromoCodeRequest) (PricedCart, error)
+	QuotePromotions(req PromotionQuoteRequest) (PromotionQuote, error)
+	Checkout(req CheckoutRequest) (OrderResponse, error)
+	ConfirmCheckoutPayment(req ConfirmCheckoutPaymentRequest) error
+	OptOutReminders(req ReminderOptOutRequest) error
+	OptInReminders(userID uuid.UUID) error
+	GetPurchaseRule(productID uuid.UUID) (PurchaseRule, error)
+	SetPurchaseRule(productID uuid.UUID, req PurchaseRuleRequest) (PurchaseRule, error)
+}
+
+type SvcWishlists interface {
+	CreateWishlist(req CreateWishlistRequest) (Wishlist, error)
+	UpdateWishlist(id uuid.UUID, req UpdateWishlistRequest) (Wishlist, error)
+	DeleteWishlist(userID, id uuid.UUID) error
+	GetWishlists(userID uuid.UUID) ([]Wishlist, error)
+	GetSharedWishlist(token string) (Wishlist, error)
+	AddWishlistItem(wishlistID uuid.UUID, req AddWishlistItemRequest) error
+	DeleteWishlistItem(userID, wishlistID, productID uuid.UUID) error
+	SaveForLater(req MoveItemRequest) error
+	MoveToWishlist(req MoveItemRequest) error
+	MoveToCart(req MoveItemRequest) error
+	HandleProductUpdate(req ProductUpdateRequest) error
 }
 
 func (c *Controller) Routes() chi.Router {
@@ -46,20 +72,69 @@ func (c *Controller) Routes() chi.Router {
 	r.Patch("/items/{productID}", c.UpdateCart)
 	// удалить товар из корзины
 	r.Delete("/items/{productID}", c.DeleteProduct)
+	// создать промо-акцию
+	r.Post("/promotions", c.CreatePromotion)
+	// применить промокод к корзине
+	r.Post("/promo-codes", c.ApplyPromoCode)
+	// убрать промокод из корзины
+	r.Delete("/promo-codes/{code}", c.RemovePromoCode)
+	// пересчитать скидки промо-акций для заказа (вызывает Order)
+	r.Post("/promotions/quote", c.QuotePromotions)
+	// оформить корзину: создать заказ в сервисе Order
+	r.Post("/checkout", c.Checkout)
+	// получает от сервиса Order что заказ оплачен, корзина очищается
+	r.Post("/checkout/{orderID}/paid", c.ConfirmCheckoutPayment)
+	// отказаться от напоминаний о брошенной корзине
+	r.Post("/reminders/opt-out", c.OptOutReminders)
+	// снова получать напоминания
+	r.Delete("/reminders/opt-out", c.OptInReminders)
+	// ограничения на покупку товара
+	r.Get("/purchase-rules/{productID}", c.GetPurchaseRule)
+	r.Put("/purchase-rules/{productID}", c.SetPurchaseRule)
+	// списки желаний
+	r.Mount("/wishlists", c.wishlistRoutes())
+	return r
+}
+
+// wishlistRoutes списки желаний и отложенные товары
+func (c *Controller) wishlistRoutes() chi.Router {
+	r := chi.NewRouter()
+	// списки пользователя вместе с отложенными
+	r.Get("/", c.GetWishlists)
+	// создать список желаний
+	r.Post("/", c.CreateWishlist)
+	// переименовать список, открыть/закрыть публичную ссылку
+	r.Patch("/{wishlistID}", c.UpdateWishlist)
+	// удалить список
+	r.Delete("/{wishlistID}", c.DeleteWishlist)
+	// публичный список по ссылке
+	r.Get("/shared/{token}", c.GetSharedWishlist)
+	// добавить товар в список
+	r.Post("/{wishlistID}/items", c.AddWishlistItem)
+	// удалить товар из списка
+	r.Delete("/{wishlistID}/items/{productID}", c.DeleteWishlistItem)
+	// перенести товар из списка в корзину
+	r.Post("/{wishlistID}/items/{productID}/move-to-cart", c.MoveToCart)
+	// отложить товар из корзины
+	r.Post("/cart-items/{productID}/save-for-later", c.SaveForLater)
+	// перенести товар из корзины в список желаний
+	r.Post("/cart-items/{productID}/move-to-wishlist", c.MoveToWishlist)
+	// получает от Catalog/Inventory изменение цены или наличия товара
+	r.Post("/product-updates", c.HandleProductUpdate)
 	return r
 }
 
 func (c *Controller) GetCart(w http.ResponseWriter, r *http.Request) {
-	mockID, err := uuid.Parse("1b98a34a-cbcf-4e24-a4b8-2a218f5b68fc")
-	if err != nil {
-		c.logger.Error("failed to create uuid", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+	userID, err := uuid.Parse(r.URL.Query().Get("userID"))
+	if err != nil || userID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
 		return
 	}
-	cart, err := c.svc.GetCart(mockID)
+	cart, err := c.svc.GetCart(userID)
 	if err != nil {
 		c.logger.Error("failed to get cart", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
 		return
 	}
 	common.OkResponse(w, cart)
@@ -75,20 +150,18 @@ func (c *Controller) AddToCart(w http.ResponseWriter, r *http.Request) {
 	var item AddToCartRequest
 	err := json.NewDecoder(r.Body).Decode(&item)
 	if err != nil {
-		if err != nil {
-			c.logger.Error("failed add to cart", zap.Error(err))
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		err := c.svc.AddToCart(item)
-		if err != nil {
-			c.logger.Error("failed add to cart", zap.Error(err))
-			common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
+		c.logger.Error("failed add to cart", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = c.svc.AddToCart(item)
+	if err != nil {
+		c.logger.Error("failed add to cart", zap.Error(err))
+		errResponse(w, err)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 }
 
 func (c *Controller) UpdateCart(w http.ResponseWriter, r *http.Request) {
@@ -108,7 +181,7 @@ func (c *Controller) UpdateCart(w http.ResponseWriter, r *http.Request) {
 	err = c.svc.UpdateCart(item)
 	if err != nil {
 		c.logger.Error("failed to update cart", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		errResponse(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -116,20 +189,498 @@ func (c *Controller) UpdateCart(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *Controller) DeleteProduct(w http.ResponseWriter, r *http.Request) {
-	productID := r.URL.Query().Get("productID")
-	id, err := uuid.Parse(productID)
-	if productID == "" {
+	productID, err := uuid.Parse(chi.URLParam(r, "productID"))
+	if err != nil || productID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	userID, err := uuid.Parse(r.URL.Query().Get("userID"))
+	if err != nil || userID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	err = c.svc.DeleteProduct(userID, productID)
+	if err != nil {
+		c.logger.Error("failed to delete item from cart", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+}
+
+func (c *Controller) CreatePromotion(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req CreatePromotionRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to create promotion", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	promo, err := c.svc.CreatePromotion(req)
+	if err != nil {
+		c.logger.Error("failed to create promotion", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, promo)
+}
+
+func (c *Controller) ApplyPromoCode(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req ApplyPromoCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to apply promo code", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	cart, err := c.svc.ApplyPromoCode(req)
+	if err != nil {
+		c.logger.Error("failed to apply promo code", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, cart)
+}
+
+func (c *Controller) RemovePromoCode(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	userID, err := uuid.Parse(r.URL.Query().Get("userID"))
+	if err != nil || userID == uuid.Nil || code == "" {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	cart, err := c.svc.RemovePromoCode(RemovePromoCodeRequest{UserID: userID, Code: code})
+	if err != nil {
+		c.logger.Error("failed to remove promo code", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, cart)
+}
+
+func (c *Controller) QuotePromotions(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req PromotionQuoteRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to quote promotions", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	quote, err := c.svc.QuotePromotions(req)
+	if err != nil {
+		c.logger.Error("failed to quote promotions", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, quote)
+}
+
+func (c *Controller) Checkout(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req CheckoutRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to checkout", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	order, err := c.svc.Checkout(req)
+	if err != nil {
+		c.logger.Error("failed to checkout", zap.Error(err))
+		errResponse(w, err)
+		return
+	}
+	common.OkResponse(w, order)
+}
+
+func (c *Controller) ConfirmCheckoutPayment(w http.ResponseWriter, r *http.Request) {
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	err = c.svc.ConfirmCheckoutPayment(ConfirmCheckoutPaymentRequest{
+		OrderID:   orderID,
+		Timestamp: r.Header.Get("X-Order-Timestamp"),
+		Signature: r.Header.Get("X-Order-Signature"),
+	})
+	if err != nil {
+		c.logger.Error("failed to confirm checkout payment", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) OptOutReminders(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req ReminderOptOutRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to opt out reminders", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = c.svc.OptOutReminders(req)
+	if err != nil {
+		c.logger.Error("failed to opt out reminders", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) OptInReminders(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.URL.Query().Get("userID"))
+	if err != nil || userID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	err = c.svc.OptInReminders(userID)
+	if err != nil {
+		c.logger.Error("failed to opt in reminders", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) GetPurchaseRule(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(chi.URLParam(r, "productID"))
+	if err != nil || productID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	rule, err := c.svc.GetPurchaseRule(productID)
+	if err != nil {
+		c.logger.Error("failed to get purchase rule", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, rule)
+}
+
+func (c *Controller) SetPurchaseRule(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	productID, err := uuid.Parse(chi.URLParam(r, "productID"))
+	if err != nil || productID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req PurchaseRuleRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to set purchase rule", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rule, err := c.svc.SetPurchaseRule(productID, req)
+	if err != nil {
+		c.logger.Error("failed to set purchase rule", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, rule)
+}
+
+func (c *Controller) GetWishlists(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.URL.Query().Get("userID"))
+	if err != nil || userID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	wishlists, err := c.wishlists.GetWishlists(userID)
+	if err != nil {
+		c.logger.Error("failed to get wishlists", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, wishlists)
+}
+
+func (c *Controller) CreateWishlist(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req CreateWishlistRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to create wishlist", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	wishlist, err := c.wishlists.CreateWishlist(req)
+	if err != nil {
+		c.logger.Error("failed to create wishlist", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, wishlist)
+}
+
+func (c *Controller) UpdateWishlist(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	wishlistID, err := uuid.Parse(chi.URLParam(r, "wishlistID"))
+	if err != nil || wishlistID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req UpdateWishlistRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to update wishlist", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	wishlist, err := c.wishlists.UpdateWishlist(wishlistID, req)
+	if err != nil {
+		c.logger.Error("failed to update wishlist", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, wishlist)
+}
+
+func (c *Controller) DeleteWishlist(w http.ResponseWriter, r *http.Request) {
+	wishlistID, errWishlist := uuid.Parse(chi.URLParam(r, "wishlistID"))
+	userID, errUser := uuid.Parse(r.URL.Query().Get("userID"))
+	if errWishlist != nil || errUser != nil || wishlistID == uuid.Nil || userID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	err := c.wishlists.DeleteWishlist(userID, wishlistID)
+	if err != nil {
+		c.logger.Error("failed to delete wishlist", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) GetSharedWishlist(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if token == "" {
 		c.logger.Warn("empty params")
 		common.ErrResponse(w, http.StatusBadRequest, "empty param")
 		return
 	}
-	err = c.svc.DeleteProduct(id)
+	wishlist, err := c.wishlists.GetSharedWishlist(token)
 	if err != nil {
-		c.logger.Error("failed to delete item from cart", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		c.logger.Error("failed to get shared wishlist", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, wishlist)
+}
+
+func (c *Controller) AddWishlistItem(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	wishlistID, err := uuid.Parse(chi.URLParam(r, "wishlistID"))
+	if err != nil || wishlistID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req AddWishlistItemRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to add item to wishlist", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = c.wishlists.AddWishlistItem(wishlistID, req)
+	if err != nil {
+		c.logger.Error("failed to add item to wishlist", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) DeleteWishlistItem(w http.ResponseWriter, r *http.Request) {
+	wishlistID, errWishlist := uuid.Parse(chi.URLParam(r, "wishlistID"))
+	productID, errProduct := uuid.Parse(chi.URLParam(r, "productID"))
+	userID, errUser := uuid.Parse(r.URL.Query().Get("userID"))
+	if errWishlist != nil || errProduct != nil || errUser != nil || userID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	err := c.wishlists.DeleteWishlistItem(userID, wishlistID, productID)
+	if err != nil {
+		c.logger.Error("failed to delete item from wishlist", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) MoveToCart(w http.ResponseWriter, r *http.Request) {
+	c.moveItem(w, r, "failed to move item to cart", c.wishlists.MoveToCart)
+}
+
+func (c *Controller) SaveForLater(w http.ResponseWriter, r *http.Request) {
+	c.moveItem(w, r, "failed to save item for later", c.wishlists.SaveForLater)
+}
+
+func (c *Controller) MoveToWishlist(w http.ResponseWriter, r *http.Request) {
+	c.moveItem(w, r, "failed to move item to wishlist", c.wishlists.MoveToWishlist)
+}
+
+func (c *Controller) moveItem(w http.ResponseWriter, r *http.Request, msg string, move func(MoveItemRequest) error) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	productID, err := uuid.Parse(chi.URLParam(r, "productID"))
+	if err != nil || productID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req MoveItemRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error(msg, zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req.ProductID = productID
+	if id := chi.URLParam(r, "wishlistID"); id != "" {
+		req.WishlistID, err = uuid.Parse(id)
+		if err != nil {
+			c.logger.Warn("invalid param")
+			common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+			return
+		}
+	}
+	err = move(req)
+	if err != nil {
+		c.logger.Error(msg, zap.Error(err))
+		errResponse(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) HandleProductUpdate(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req ProductUpdateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to handle product update", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = c.wishlists.HandleProductUpdate(req)
+	if err != nil {
+		c.logger.Error("failed to handle product update", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+}
 
+// errResponse отдает нарушения правил покупки списком, остальные ошибки - сообщением
+func errResponse(w http.ResponseWriter, err error) {
+	var ruleErr *common.PurchaseRuleError
+	if errors.As(err, &ruleErr) {
+		common.ErrResponseData(w, http.StatusBadRequest, ruleErr.Error(), ruleErr.Violations)
+		return
+	}
+	common.ErrResponse(w, errStatus(err), error.Error(err))
+}
+
+func errStatus(err error) int {
+	var validationErr *common.RequestValidationError
+	var notFoundErr *common.NotFoundError
+	var existsErr *common.AlreadyExistsError
+	var conflictErr *common.ConflictError
+	var unauthorizedErr *common.UnauthorizedError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.As(err, &unauthorizedErr):
+		return http.StatusUnauthorized
+	case errors.As(err, &notFoundErr):
+		return http.StatusNotFound
+	case errors.As(err, &existsErr), errors.As(err, &conflictErr):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
 }
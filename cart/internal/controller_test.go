@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/cart/internal/common"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// cartSvc запоминает, чью корзину запросили
+type cartSvc struct {
+	Svc
+	userID uuid.UUID
+}
+
+func (s *cartSvc) GetCart(userID uuid.UUID) (PricedCart, error) {
+	s.userID = userID
+	return PricedCart{}, nil
+}
+
+func newCartRouter(svc Svc) chi.Router {
+	router := chi.NewRouter()
+	router.Mount("/carts", NewController(svc, nil, common.Logger{Logger: zap.NewNop()}).Routes())
+	return router
+}
+
+func TestGetCart(t *testing.T) {
+	userID := uuid.New()
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		userID uuid.UUID
+	}{
+		{name: "user from query", query: "?userID=" + userID.String(), status: http.StatusOK, userID: userID},
+		{name: "missing user", status: http.StatusBadRequest},
+		{name: "malformed user", query: "?userID=abc", status: http.StatusBadRequest},
+		{name: "nil user", query: "?userID=" + uuid.Nil.String(), status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &cartSvc{}
+			rec := httptest.NewRecorder()
+			newCartRouter(svc).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/carts/"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if svc.userID != tt.userID {
+				t.Errorf("cart of %s requested, want %s", svc.userID, tt.userID)
+			}
+		})
+	}
+}
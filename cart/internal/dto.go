@@ -1,6 +1,10 @@
 package internal
 
-import "github.com/google/uuid"
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
 
 type Cart struct {
 	Id     uuid.UUID
@@ -16,13 +20,48 @@ type Product struct {
 }
 
 type AddToCartRequest struct {
-	ProductId uuid.UUID
-	Qty       int64
+	UserID    uuid.UUID `json:"user_id" validate:"required"`
+	ProductId uuid.UUID `json:"product_id" validate:"required"`
+	Qty       int64     `json:"qty" validate:"gte=1"`
 }
 
 type UpdateCartItemRequest struct {
-	ProductId uuid.UUID
-	Qty       int64
+	UserID    uuid.UUID `json:"user_id" validate:"required"`
+	ProductId uuid.UUID `json:"product_id" validate:"required"`
+	Qty       int64     `json:"qty" validate:"gte=1"`
+}
+
+// Shopper данные покупателя, нужные для проверки ограничений по возрасту и региону.
+// Берутся из профиля в сервисе Users, а не из запроса.
+type Shopper struct {
+	Age    int64
+	Region string
+}
+
+// UserProfile профиль пользователя из сервиса Users
+type UserProfile struct {
+	ID        uuid.UUID  `json:"id"`
+	Email     string     `json:"email"`
+	BirthDate *time.Time `json:"birth_date"`
+	Region    string     `json:"region"`
+}
+
+// PurchaseRule ограничения на покупку товара. Нулевые значения означают отсутствие ограничения.
+type PurchaseRule struct {
+	ProductID      uuid.UUID `json:"product_id" db:"product_id"`
+	MaxQty         int64     `json:"max_qty" db:"max_qty"`
+	MinQty         int64     `json:"min_qty" db:"min_qty"`
+	PackSize       int64     `json:"pack_size" db:"pack_size"`
+	MinAge         int64     `json:"min_age" db:"min_age"`
+	AllowedRegions string    `json:"allowed_regions" db:"allowed_regions"` // через запятую, пусто - везде
+}
+
+type PurchaseRuleRequest struct {
+	MaxQty         int64    `json:"max_qty" validate:"gte=0"`
+	MinQty         int64    `json:"min_qty" validate:"gte=0"`
+	PackSize       int64    `json:"pack_size" validate:"gte=0"`
+	MinAge         int64    `json:"min_age" validate:"gte=0"`
+	AllowedRegions []string `json:"allowed_regions" validate:"dive,required"`
 }
 
 type CatalogRequest struct {
@@ -52,3 +91,284 @@ type InventoryStatus struct {
 	Available bool
 	Quantity  int64
 }
+
+// InventoryItemsRequest запрос остатков в bulk-get сервиса Inventory
+type InventoryItemsRequest struct {
+	IDs []uuid.UUID
+}
+
+type InventoryItemsResponse struct {
+	Items []InventoryItem
+}
+
+type InventoryItem struct {
+	ID        uuid.UUID
+	Available int64
+}
+
+type PromotionType string
+
+const (
+	PercentOff   PromotionType = "percent_off"   // скидка в процентах
+	FixedAmount  PromotionType = "fixed_amount"  // фиксированная сумма
+	BuyXGetY     PromotionType = "buy_x_get_y"   // купи X получи Y бесплатно
+	FreeShipping PromotionType = "free_shipping" // бесплатная доставка
+)
+
+type Promotion struct {
+	ID         uuid.UUID     `json:"id" db:"id"`
+	Code       string        `json:"code" db:"code"`
+	Type       PromotionType `json:"type" db:"type"`
+	Value      int64         `json:"value" db:"value"` // проценты для percent_off, копейки для fixed_amount
+	ProductID  *uuid.UUID    `json:"product_id,omitempty" db:"product_id"`
+	BuyQty     int64         `json:"buy_qty" db:"buy_qty"`
+	GetQty     int64         `json:"get_qty" db:"get_qty"`
+	MinBasket  int64         `json:"min_basket" db:"min_basket"`
+	Stackable  bool          `json:"stackable" db:"stackable"`
+	UsageLimit int64         `json:"usage_limit" db:"usage_limit"` // на одного пользователя, 0 - без ограничений
+	StartsAt   time.Time     `json:"starts_at" db:"starts_at"`
+	ExpiresAt  *time.Time    `json:"expires_at,omitempty" db:"expires_at"`
+	CreatedAt  time.Time     `json:"created_at" db:"created_at"`
+}
+
+type CreatePromotionRequest struct {
+	Code       string        `json:"code" validate:"required,min=3,max=32"`
+	Type       PromotionType `json:"type" validate:"required,oneof=percent_off fixed_amount buy_x_get_y free_shipping"`
+	Value      int64         `json:"value" validate:"gte=0"`
+	ProductID  *uuid.UUID    `json:"product_id"`
+	BuyQty     int64         `json:"buy_qty" validate:"gte=0"`
+	GetQty     int64         `json:"get_qty" validate:"gte=0"`
+	MinBasket  int64         `json:"min_basket" validate:"gte=0"`
+	Stackable  bool          `json:"stackable"`
+	UsageLimit int64         `json:"usage_limit" validate:"gte=0"`
+	StartsAt   time.Time     `json:"starts_at"`
+	ExpiresAt  *time.Time    `json:"expires_at"`
+}
+
+type ApplyPromoCodeRequest struct {
+	UserID uuid.UUID `json:"user_id" validate:"required"`
+	Code   string    `json:"code" validate:"required"`
+}
+
+type RemovePromoCodeRequest struct {
+	UserID uuid.UUID `json:"user_id" validate:"required"`
+	Code   string    `json:"code" validate:"required"`
+}
+
+type AppliedPromotion struct {
+	PromotionID uuid.UUID     `json:"promotion_id"`
+	Code        string        `json:"code"`
+	Type        PromotionType `json:"type"`
+	Discount    int64         `json:"discount"`
+}
+
+// PromotionQuoteRequest запрос Order на пересчет скидок: промо-акции проверяются и считаются
+// заново по текущим ценам, скидки из тела заказа не используются
+type PromotionQuoteRequest struct {
+	UserID       uuid.UUID   `json:"user_id" validate:"required"`
+	Items        []QuoteItem `json:"items" validate:"min=1,dive"`
+	PromotionIDs []uuid.UUID `json:"promotion_ids" validate:"dive,required"`
+}
+
+type QuoteItem struct {
+	ProductID uuid.UUID `json:"product_id" validate:"required"`
+	Qty       int64     `json:"qty" validate:"gte=1"`
+}
+
+type PromotionQuote struct {
+	Subtotal   int64              `json:"subtotal"`
+	Total      int64              `json:"total"`
+	Promotions []AppliedPromotion `json:"promotions"`
+}
+
+type PricedLine struct {
+	ProductId uuid.UUID `json:"product_id"`
+	Name      string    `json:"name"`
+	Qty       int64     `json:"qty"`
+	UnitPrice int64     `json:"unit_price"`
+	Subtotal  int64     `json:"subtotal"`
+	Discount  int64     `json:"discount"`
+	Total     int64     `json:"total"`
+}
+
+type PricedCart struct {
+	CartID        uuid.UUID          `json:"cart_id"`
+	UserID        uuid.UUID          `json:"user_id"`
+	Lines         []PricedLine       `json:"lines"`
+	Subtotal      int64              `json:"subtotal"`
+	LineDiscount  int64              `json:"line_discount"`
+	OrderDiscount int64              `json:"order_discount"`
+	FreeShipping  bool               `json:"free_shipping"`
+	Total         int64              `json:"total"`
+	Promotions    []AppliedPromotion `json:"promotions"`
+}
+
+type CheckoutStatus string
+
+const (
+	CheckoutPending    CheckoutStatus = "pending"    // заказ создан, ждем оплату
+	CheckoutPaid       CheckoutStatus = "paid"       // заказ оплачен, корзина очищена
+	CheckoutSuperseded CheckoutStatus = "superseded" // корзину изменили и оформили заново, заказ ждет оплату или истечет
+)
+
+type Checkout struct {
+	ID             uuid.UUID      `db:"id"`
+	CartID         uuid.UUID      `db:"cart_id"`
+	UserID         uuid.UUID      `db:"user_id"`
+	OrderID        *uuid.UUID     `db:"order_id"`
+	IdempotencyKey string         `db:"idempotency_key"`
+	ContentHash    string         `db:"content_hash"`  // хэш состава заказа, см. checkoutHash
+	PromotionIDs   []uuid.UUID    `db:"promotion_ids"` // акции заказа, списываются с лимита после оплаты
+	Status         CheckoutStatus `db:"status"`
+	CreatedAt      time.Time      `db:"created_at"`
+	UpdatedAt      time.Time      `db:"updated_at"`
+}
+
+// ConfirmCheckoutPaymentRequest подтверждение оплаты от Order. Заказ берется из пути,
+// подпись и время подписи - из заголовков X-Order-Signature и X-Order-Timestamp.
+type ConfirmCheckoutPaymentRequest struct {
+	OrderID   uuid.UUID `json:"-" validate:"required"`
+	Timestamp string    `json:"-" validate:"required"`
+	Signature string    `json:"-" validate:"required"`
+}
+
+type CheckoutRequest struct {
+	UserID         uuid.UUID `json:"user_id" validate:"required"`
+	IdempotencyKey string    `json:"-"`
+}
+
+// ItemQty строка заказа для сервиса Order
+type ItemQty struct {
+	ID       uuid.UUID `json:"id"`
+	Quantity int       `json:"quantity"`
+}
+
+// CreatOrderRequest запрос на создание заказа в сервисе Order
+type CreatOrderRequest struct {
+	UserID     uuid.UUID          `json:"user_id"`
+	Items      []ItemQty          `json:"items"`
+	Promotions []AppliedPromotion `json:"promotions"`
+}
+
+type OrderItemResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Quantity  int       `json:"quantity"`
+	UnitPrice int64     `json:"unit_price"`
+}
+
+type OrderResponse struct {
+	ID         uuid.UUID           `json:"id"`
+	UserId     uuid.UUID           `json:"user_id"`
+	Status     string              `json:"status"`
+	GrandTotal int64               `json:"grand_total"`
+	Created    time.Time           `json:"created"`
+	Items      []OrderItemResponse `json:"items"`
+	Promotions []AppliedPromotion  `json:"promotions"`
+}
+
+type WishlistKind string
+
+const (
+	SavedForLater WishlistKind = "saved_for_later" // отложенные из корзины
+	NamedWishlist WishlistKind = "wishlist"        // именованный список желаний
+)
+
+type Wishlist struct {
+	ID         uuid.UUID      `json:"id" db:"id"`
+	UserID     uuid.UUID      `json:"user_id" db:"user_id"`
+	Name       string         `json:"name" db:"name"`
+	Kind       WishlistKind   `json:"kind" db:"kind"`
+	Public     bool           `json:"public" db:"public"`
+	ShareToken *string        `json:"share_token,omitempty" db:"share_token"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+	Items      []WishlistItem `json:"items" db:"-"`
+}
+
+type WishlistItem struct {
+	ID         uuid.UUID `json:"id" db:"id"`
+	WishlistID uuid.UUID `json:"wishlist_id" db:"wishlist_id"`
+	ProductID  uuid.UUID `json:"product_id" db:"product_id"`
+	Qty        int64     `json:"qty" db:"qty"`
+	LastPrice  int64     `json:"last_price" db:"last_price"` // последняя известная цена, для уведомлений о снижении
+	InStock    bool      `json:"in_stock" db:"in_stock"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// WishlistWatcher пользователь, у которого товар лежит в одном из списков
+type WishlistWatcher struct {
+	UserID    uuid.UUID `db:"user_id"`
+	ProductID uuid.UUID `db:"product_id"`
+	LastPrice int64     `db:"last_price"`
+	InStock   bool      `db:"in_stock"`
+}
+
+type CreateWishlistRequest struct {
+	UserID uuid.UUID `json:"user_id" validate:"required"`
+	Name   string    `json:"name" validate:"required,min=1,max=100"`
+	Public bool      `json:"public"`
+}
+
+type UpdateWishlistRequest struct {
+	UserID uuid.UUID `json:"user_id" validate:"required"`
+	Name   string    `json:"name" validate:"required,min=1,max=100"`
+	Public bool      `json:"public"`
+}
+
+type AddWishlistItemRequest struct {
+	UserID    uuid.UUID `json:"user_id" validate:"required"`
+	ProductID uuid.UUID `json:"product_id" validate:"required"`
+	Qty       int64     `json:"qty" validate:"gte=1"`
+}
+
+type MoveItemRequest struct {
+	UserID     uuid.UUID `json:"user_id" validate:"required"`
+	ProductID  uuid.UUID `json:"-"`
+	WishlistID uuid.UUID `json:"wishlist_id"`
+}
+
+// ProductUpdateRequest событие от Catalog/Inventory об изменении цены или наличия товара
+type ProductUpdateRequest struct {
+	ProductID uuid.UUID `json:"product_id" validate:"required"`
+	Price     *int64    `json:"price" validate:"omitempty,gte=0"`
+	Available *bool     `json:"available"`
+}
+
+type NotificationRequest struct {
+	UserID  uuid.UUID
+	To      string
+	Type    string
+	Subject string
+	Text    string
+}
+
+// AbandonedCart корзина, которую не меняли дольше порога
+type AbandonedCart struct {
+	CartID    uuid.UUID `db:"cart_id"`
+	UserID    uuid.UUID `db:"user_id"`
+	ItemsQty  int64     `db:"items_qty"`
+	UpdatedAt time.Time `db:"updated_at"`
+	OptedOut  bool      `db:"opted_out"`
+	Reminded  bool      `db:"reminded"` // напоминание за текущий простой уже отправлено
+}
+
+type ReminderOptOutRequest struct {
+	UserID uuid.UUID `json:"user_id" validate:"required"`
+}
+
+// Event событие для сервиса Analytics
+type Event struct {
+	ID         uuid.UUID
+	Type       string
+	Payload    json.RawMessage
+	OccurredAt time.Time
+}
+
+type CartAbandonedPayload struct {
+	Version   int       `json:"version"`
+	CartID    uuid.UUID `json:"cart_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	ItemsQty  int64     `json:"items_qty"`
+	IdleSince time.Time `json:"idle_since"`
+	Reminded  bool      `json:"reminded"`
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/madrabit/mini-market/cart/internal/common"
+	"strconv"
+	"time"
+)
+
+// OrderSigner проверяет подпись подтверждения оплаты. Order подписывает общим ключом строку
+// "<timestamp>.<order_id>", timestamp - unix-время в секундах, подпись - HMAC-SHA256 в hex.
+type OrderSigner struct {
+	secret  []byte
+	maxSkew time.Duration
+}
+
+func NewOrderSigner(cfg common.CallbackConfig) *OrderSigner {
+	return &OrderSigner{secret: []byte(cfg.OrderSecret), maxSkew: cfg.MaxSkew}
+}
+
+func (o *OrderSigner) Sign(req ConfirmCheckoutPaymentRequest) string {
+	mac := hmac.New(sha256.New, o.secret)
+	_, _ = fmt.Fprintf(mac, "%s.%s", req.Timestamp, req.OrderID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify отклоняет чужую подпись и слишком старую, чтобы перехваченный запрос нельзя было проиграть позже
+func (o *OrderSigner) Verify(req ConfirmCheckoutPaymentRequest, now time.Time) error {
+	sec, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	if err != nil {
+		return &common.UnauthorizedError{Message: "invalid callback timestamp"}
+	}
+	if skew := now.Sub(time.Unix(sec, 0)).Abs(); skew > o.maxSkew {
+		return &common.UnauthorizedError{Message: "callback signature expired"}
+	}
+	if !hmac.Equal([]byte(o.Sign(req)), []byte(req.Signature)) {
+		return &common.UnauthorizedError{Message: "invalid callback signature"}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/cart/internal/common"
+	"github.com/madrabit/mini-market/cart/internal/validator"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const callbackSecret = "callback-secret"
+
+func signedConfirm(signer *OrderSigner, orderID uuid.UUID, at time.Time) ConfirmCheckoutPaymentRequest {
+	req := ConfirmCheckoutPaymentRequest{OrderID: orderID, Timestamp: strconv.FormatInt(at.Unix(), 10)}
+	req.Signature = signer.Sign(req)
+	return req
+}
+
+func TestConfirmCheckoutPaymentSignature(t *testing.T) {
+	cfg := common.CallbackConfig{OrderSecret: callbackSecret, MaxSkew: 5 * time.Minute}
+	signer := NewOrderSigner(cfg)
+	otherSigner := NewOrderSigner(common.CallbackConfig{OrderSecret: "other-secret", MaxSkew: cfg.MaxSkew})
+	orderID := uuid.New()
+
+	tests := []struct {
+		name    string
+		req     ConfirmCheckoutPaymentRequest
+		status  int
+		cleared bool
+	}{
+		{name: "valid signature", req: signedConfirm(signer, orderID, time.Now()), status: http.StatusOK, cleared: true},
+		{name: "signed with another key", req: signedConfirm(otherSigner, orderID, time.Now()), status: http.StatusUnauthorized},
+		{name: "signed for another order", req: signedConfirm(signer, uuid.New(), time.Now()), status: http.StatusUnauthorized},
+		{name: "expired timestamp", req: signedConfirm(signer, orderID, time.Now().Add(-time.Hour)), status: http.StatusUnauthorized},
+		{name: "missing signature", req: ConfirmCheckoutPaymentRequest{Timestamp: strconv.FormatInt(time.Now().Unix(), 10)}, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := uuid.New()
+			base := newCartRepo()
+			base.cart.Items[product] = Product{ProductId: product, Qty: 1}
+			repo := &checkoutRepo{cartRepo: base, recorded: make(map[uuid.UUID][]uuid.UUID)}
+			repo.checkouts = []Checkout{{ID: uuid.New(), CartID: base.cart.Id, OrderID: &orderID, Status: CheckoutPending}}
+			svc := NewService(repo, validator.New(), nil, nil, nil, signer)
+
+			httpReq := httptest.NewRequest(http.MethodPost, "/carts/checkout/"+orderID.String()+"/paid", nil)
+			httpReq.Header.Set("X-Order-Timestamp", tt.req.Timestamp)
+			httpReq.Header.Set("X-Order-Signature", tt.req.Signature)
+			rec := httptest.NewRecorder()
+			newCartRouter(svc).ServeHTTP(rec, httpReq)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if cleared := len(repo.cart.Items) == 0; cleared != tt.cleared {
+				t.Errorf("cart cleared = %v, want %v", cleared, tt.cleared)
+			}
+			if paid := repo.checkouts[0].Status == CheckoutPaid; paid != tt.cleared {
+				t.Errorf("checkout paid = %v, want %v", paid, tt.cleared)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/cart/internal/common"
+	"github.com/madrabit/mini-market/cart/internal/validator"
+	"testing"
+	"time"
+)
+
+// cartRepo корзина и акции в памяти. Чтения вне транзакции не реализованы:
+// если сервис до них дойдет, тест упадет на панике встроенного nil-интерфейса.
+type cartRepo struct {
+	Repo
+	cart    Cart
+	promos  map[string]Promotion
+	applied []Promotion
+	usages  map[uuid.UUID]int64
+	locked  bool
+}
+
+func newCartRepo(promos ...Promotion) *cartRepo {
+	repo := &cartRepo{
+		cart:   Cart{Id: uuid.New(), Items: make(map[uuid.UUID]Product)},
+		promos: make(map[string]Promotion),
+		usages: make(map[uuid.UUID]int64),
+	}
+	for _, p := range promos {
+		repo.promos[p.Code] = p
+	}
+	return repo
+}
+
+func (r *cartRepo) BeginTransaction() (*sqlx.Tx, error) { return beginNoopTx() }
+
+func (r *cartRepo) GetCartForUpdate(_ *sqlx.Tx, userID uuid.UUID) (Cart, error) {
+	r.locked = true
+	r.cart.Userid = userID
+	return r.cart, nil
+}
+
+func (r *cartRepo) GetAppliedPromotions(_ *sqlx.Tx, _ uuid.UUID) ([]Promotion, error) {
+	if !r.locked {
+		return nil, errors.New("cart is not locked")
+	}
+	return r.applied, nil
+}
+
+func (r *cartRepo) GetPromotionByCode(_ *sqlx.Tx, code string) (Promotion, error) {
+	p, ok := r.promos[code]
+	if !ok {
+		return Promotion{}, errors.New("promotion not found")
+	}
+	return p, nil
+}
+
+func (r *cartRepo) CountPromotionUsages(_ *sqlx.Tx, promotionID, _ uuid.UUID) (int64, error) {
+	return r.usages[promotionID], nil
+}
+
+func (r *cartRepo) AddCartPromotion(_ *sqlx.Tx, _, promotionID uuid.UUID) error {
+	for _, p := range r.promos {
+		if p.ID == promotionID {
+			r.applied = append(r.applied, p)
+		}
+	}
+	return nil
+}
+
+func TestApplyPromoCode(t *testing.T) {
+	started := time.Now().Add(-time.Hour)
+	save10 := Promotion{ID: uuid.New(), Code: "SAVE10", Type: PercentOff, Value: 10, Stackable: true, StartsAt: started}
+	ship := Promotion{ID: uuid.New(), Code: "SHIP", Type: FreeShipping, Stackable: true, StartsAt: started}
+	only := Promotion{ID: uuid.New(), Code: "ONLY", Type: FixedAmount, Value: 100, StartsAt: started}
+	once := Promotion{ID: uuid.New(), Code: "ONCE", Type: FixedAmount, Value: 100, Stackable: true, UsageLimit: 1, StartsAt: started}
+	repo := newCartRepo(save10, ship, only, once)
+	repo.usages[once.ID] = 1
+	svc := NewService(repo, validator.New(), nil, nil, nil, nil)
+	userID := uuid.New()
+
+	if _, err := svc.ApplyPromoCode(ApplyPromoCodeRequest{UserID: userID, Code: "save10"}); err != nil {
+		t.Fatalf("apply SAVE10: %v", err)
+	}
+	if _, err := svc.ApplyPromoCode(ApplyPromoCodeRequest{UserID: userID, Code: "SHIP"}); err != nil {
+		t.Fatalf("apply SHIP: %v", err)
+	}
+
+	var exists *common.AlreadyExistsError
+	if _, err := svc.ApplyPromoCode(ApplyPromoCodeRequest{UserID: userID, Code: "SAVE10"}); !errors.As(err, &exists) {
+		t.Errorf("apply SAVE10 twice: err = %v, want AlreadyExistsError", err)
+	}
+	var conflict *common.ConflictError
+	if _, err := svc.ApplyPromoCode(ApplyPromoCodeRequest{UserID: userID, Code: "ONLY"}); !errors.As(err, &conflict) {
+		t.Errorf("apply non-stackable ONLY: err = %v, want ConflictError", err)
+	}
+	if _, err := svc.ApplyPromoCode(ApplyPromoCodeRequest{UserID: userID, Code: "ONCE"}); !errors.As(err, &conflict) {
+		t.Errorf("apply used-up ONCE: err = %v, want ConflictError", err)
+	}
+	if len(repo.applied) != 2 {
+		t.Errorf("applied = %d promotions, want 2", len(repo.applied))
+	}
+}
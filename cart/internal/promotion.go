@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"github.com/google/uuid"
+	"sort"
+	"time"
+)
+
+// PriceCart считает корзину по ценам каталога и применяет промо-акции.
+// Нестекуемые акции не комбинируются с другими: выбирается вариант с наибольшей скидкой
+// (все стекуемые вместе либо одна нестекуемая).
+func PriceCart(cart Cart, products map[uuid.UUID]CatalogProduct, promos []Promotion, now time.Time) PricedCart {
+	base := PricedCart{
+		CartID:     cart.Id,
+		UserID:     cart.Userid,
+		Lines:      make([]PricedLine, 0, len(cart.Items)),
+		Promotions: []AppliedPromotion{},
+	}
+	for _, item := range cart.Items {
+		product := products[item.ProductId]
+		subtotal := product.Price * item.Qty
+		base.Lines = append(base.Lines, PricedLine{
+			ProductId: item.ProductId,
+			Name:      product.Name,
+			Qty:       item.Qty,
+			UnitPrice: product.Price,
+			Subtotal:  subtotal,
+			Total:     subtotal,
+		})
+		base.Subtotal += subtotal
+	}
+	sort.Slice(base.Lines, func(i, j int) bool {
+		return base.Lines[i].ProductId.String() < base.Lines[j].ProductId.String()
+	})
+	base.Total = base.Subtotal
+
+	var stackable []Promotion
+	var exclusive []Promotion
+	for _, p := range promos {
+		if !p.IsActive(now) || base.Subtotal < p.MinBasket {
+			continue
+		}
+		if p.Stackable {
+			stackable = append(stackable, p)
+		} else {
+			exclusive = append(exclusive, p)
+		}
+	}
+	best := applyPromotions(base, stackable)
+	for _, p := range exclusive {
+		candidate := applyPromotions(base, []Promotion{p})
+		if candidate.Total < best.Total || (candidate.Total == best.Total && candidate.FreeShipping && !best.FreeShipping) {
+			best = candidate
+		}
+	}
+	return best
+}
+
+// IsActive проверяет, что акция уже началась и еще не истекла.
+func (p Promotion) IsActive(now time.Time) bool {
+	if now.Before(p.StartsAt) {
+		return false
+	}
+	return p.ExpiresAt == nil || now.Before(*p.ExpiresAt)
+}
+
+// isLineLevel акции, которые считаются по строкам корзины, а не по всему заказу.
+func (p Promotion) isLineLevel() bool {
+	return p.Type == BuyXGetY || (p.ProductID != nil && (p.Type == PercentOff || p.Type == FixedAmount))
+}
+
+func (p Promotion) matches(productID uuid.UUID) bool {
+	return p.ProductID == nil || *p.ProductID == productID
+}
+
+func applyPromotions(base PricedCart, promos []Promotion) PricedCart {
+	cart := base
+	cart.Lines = make([]PricedLine, len(base.Lines))
+	copy(cart.Lines, base.Lines)
+	cart.Promotions = []AppliedPromotion{}
+
+	ordered := make([]Promotion, len(promos))
+	copy(ordered, promos)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].isLineLevel() && !ordered[j].isLineLevel()
+	})
+
+	for _, p := range ordered {
+		var discount int64
+		if p.isLineLevel() {
+			for i := range cart.Lines {
+				line := &cart.Lines[i]
+				if !p.matches(line.ProductId) {
+					continue
+				}
+				d := min(lineDiscount(p, *line), line.Total)
+				line.Discount += d
+				line.Total -= d
+				cart.LineDiscount += d
+				discount += d
+			}
+		} else {
+			remaining := cart.Subtotal - cart.LineDiscount - cart.OrderDiscount
+			switch p.Type {
+			case PercentOff:
+				discount = remaining * p.Value / 100
+			case FixedAmount:
+				discount = min(p.Value, remaining)
+			case FreeShipping:
+				cart.FreeShipping = true
+			}
+			cart.OrderDiscount += discount
+		}
+		if discount > 0 || p.Type == FreeShipping {
+			cart.Promotions = append(cart.Promotions, AppliedPromotion{
+				PromotionID: p.ID,
+				Code:        p.Code,
+				Type:        p.Type,
+				Discount:    discount,
+			})
+		}
+	}
+	cart.Total = cart.Subtotal - cart.LineDiscount - cart.OrderDiscount
+	return cart
+}
+
+func lineDiscount(p Promotion, line PricedLine) int64 {
+	switch p.Type {
+	case PercentOff:
+		return line.Total * p.Value / 100
+	case FixedAmount:
+		return p.Value * line.Qty
+	case BuyXGetY:
+		if p.BuyQty <= 0 || p.GetQty <= 0 {
+			return 0
+		}
+		free := line.Qty / (p.BuyQty + p.GetQty) * p.GetQty
+		return free * line.UnitPrice
+	}
+	return 0
+}
@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestPriceCart(t *testing.T) {
+	productA := uuid.MustParse("00000000-0000-0000-0000-00000000000a")
+	productB := uuid.MustParse("00000000-0000-0000-0000-00000000000b")
+	productC := uuid.MustParse("00000000-0000-0000-0000-00000000000c") // нет в корзине
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	yesterday := now.Add(-24 * time.Hour)
+	tomorrow := now.Add(24 * time.Hour)
+	// подытог 3 * 999 + 500 = 3497
+	cart := Cart{Items: map[uuid.UUID]Product{
+		productA: {ProductId: productA, Qty: 3},
+		productB: {ProductId: productB, Qty: 1},
+	}}
+	products := map[uuid.UUID]CatalogProduct{
+		productA: {Id: productA, Price: 999},
+		productB: {Id: productB, Price: 500},
+	}
+
+	tests := []struct {
+		name          string
+		promos        []Promotion
+		total         int64
+		lineDiscount  int64
+		orderDiscount int64
+		freeShipping  bool
+		applied       int
+	}{
+		{
+			name:  "no promotions",
+			total: 3497,
+		},
+		{
+			name:          "order percent rounds down",
+			promos:        []Promotion{{Type: PercentOff, Value: 10}},
+			total:         3148,
+			orderDiscount: 349,
+			applied:       1,
+		},
+		{
+			name:         "line percent rounds down",
+			promos:       []Promotion{{Type: PercentOff, Value: 15, ProductID: &productA}},
+			total:        3048,
+			lineDiscount: 449,
+			applied:      1,
+		},
+		{
+			name:          "order fixed amount capped by subtotal",
+			promos:        []Promotion{{Type: FixedAmount, Value: 5000}},
+			total:         0,
+			orderDiscount: 3497,
+			applied:       1,
+		},
+		{
+			name:         "line fixed amount capped by line total",
+			promos:       []Promotion{{Type: FixedAmount, Value: 600, ProductID: &productB}},
+			total:        2997,
+			lineDiscount: 500,
+			applied:      1,
+		},
+		{
+			name:         "buy two get one",
+			promos:       []Promotion{{Type: BuyXGetY, BuyQty: 2, GetQty: 1, ProductID: &productA}},
+			total:        2498,
+			lineDiscount: 999,
+			applied:      1,
+		},
+		{
+			name:   "buy x get y without quantities is ignored",
+			promos: []Promotion{{Type: BuyXGetY, BuyQty: 0, GetQty: 1, ProductID: &productA}},
+			total:  3497,
+		},
+		{
+			name:   "min basket not reached",
+			promos: []Promotion{{Type: PercentOff, Value: 10, MinBasket: 4000}},
+			total:  3497,
+		},
+		{
+			name:   "expired",
+			promos: []Promotion{{Type: PercentOff, Value: 10, ExpiresAt: &yesterday}},
+			total:  3497,
+		},
+		{
+			name:   "not started",
+			promos: []Promotion{{Type: PercentOff, Value: 10, StartsAt: tomorrow}},
+			total:  3497,
+		},
+		{
+			name: "stackable order discount applies after line discounts",
+			promos: []Promotion{
+				{Type: FixedAmount, Value: 100, Stackable: true},
+				{Type: PercentOff, Value: 10, ProductID: &productA, Stackable: true},
+			},
+			total:         3098,
+			lineDiscount:  299,
+			orderDiscount: 100,
+			applied:       2,
+		},
+		{
+			name: "order percent on remainder after line fixed amount",
+			promos: []Promotion{
+				{Type: PercentOff, Value: 10, Stackable: true},
+				{Type: FixedAmount, Value: 100, ProductID: &productA, Stackable: true},
+			},
+			total:         2878,
+			lineDiscount:  300,
+			orderDiscount: 319,
+			applied:       2,
+		},
+		{
+			name: "best exclusive wins over stackable",
+			promos: []Promotion{
+				{Type: PercentOff, Value: 10, Stackable: true},
+				{Type: FixedAmount, Value: 500},
+			},
+			total:         2997,
+			orderDiscount: 500,
+			applied:       1,
+		},
+		{
+			name: "stackable wins over smaller exclusive",
+			promos: []Promotion{
+				{Type: PercentOff, Value: 10, Stackable: true},
+				{Type: FixedAmount, Value: 300},
+			},
+			total:         3148,
+			orderDiscount: 349,
+			applied:       1,
+		},
+		{
+			name:         "free shipping alone",
+			promos:       []Promotion{{Type: FreeShipping}},
+			total:        3497,
+			freeShipping: true,
+			applied:      1,
+		},
+		{
+			name: "exclusive free shipping wins a tie with zero discount",
+			promos: []Promotion{
+				{Type: PercentOff, Value: 10, ProductID: &productC, Stackable: true},
+				{Type: FreeShipping},
+			},
+			total:        3497,
+			freeShipping: true,
+			applied:      1,
+		},
+		{
+			name: "stackable free shipping kept on equal discount",
+			promos: []Promotion{
+				{Type: FixedAmount, Value: 500, Stackable: true},
+				{Type: FreeShipping, Stackable: true},
+				{Type: FixedAmount, Value: 500},
+			},
+			total:         2997,
+			orderDiscount: 500,
+			freeShipping:  true,
+			applied:       2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PriceCart(cart, products, tt.promos, now)
+			if got.Subtotal != 3497 {
+				t.Fatalf("subtotal = %d, want 3497", got.Subtotal)
+			}
+			if got.Total != tt.total || got.LineDiscount != tt.lineDiscount || got.OrderDiscount != tt.orderDiscount {
+				t.Errorf("total/line/order = %d/%d/%d, want %d/%d/%d", got.Total, got.LineDiscount, got.OrderDiscount,
+					tt.total, tt.lineDiscount, tt.orderDiscount)
+			}
+			if got.FreeShipping != tt.freeShipping {
+				t.Errorf("free shipping = %v, want %v", got.FreeShipping, tt.freeShipping)
+			}
+			if len(got.Promotions) != tt.applied {
+				t.Errorf("applied promotions = %d, want %d", len(got.Promotions), tt.applied)
+			}
+			var lines int64
+			for _, l := range got.Lines {
+				if l.Total != l.Subtotal-l.Discount {
+					t.Errorf("line %s total %d != subtotal %d - discount %d", l.ProductId, l.Total, l.Subtotal, l.Discount)
+				}
+				lines += l.Discount
+			}
+			if lines != got.LineDiscount {
+				t.Errorf("sum of line discounts = %d, want %d", lines, got.LineDiscount)
+			}
+		})
+	}
+}
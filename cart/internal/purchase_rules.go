@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/cart/internal/common"
+	"strings"
+	"time"
+)
+
+// Check возвращает нарушения правила для указанного количества товара и покупателя.
+func (r PurchaseRule) Check(qty int64, shopper Shopper) []common.RuleViolation {
+	var violations []common.RuleViolation
+	productID := r.ProductID.String()
+	if r.MaxQty > 0 && qty > r.MaxQty {
+		violations = append(violations, common.RuleViolation{
+			Code:      common.RuleMaxQtyExceeded,
+			ProductID: productID,
+			Message:   fmt.Sprintf("maximum quantity is %d", r.MaxQty),
+			Params:    map[string]any{"max_qty": r.MaxQty, "qty": qty},
+		})
+	}
+	if r.MinQty > 0 && qty < r.MinQty {
+		violations = append(violations, common.RuleViolation{
+			Code:      common.RuleMinQtyNotMet,
+			ProductID: productID,
+			Message:   fmt.Sprintf("minimum quantity is %d", r.MinQty),
+			Params:    map[string]any{"min_qty": r.MinQty, "qty": qty},
+		})
+	}
+	if r.PackSize > 1 && qty%r.PackSize != 0 {
+		violations = append(violations, common.RuleViolation{
+			Code:      common.RulePackMultiple,
+			ProductID: productID,
+			Message:   fmt.Sprintf("quantity must be a multiple of %d", r.PackSize),
+			Params:    map[string]any{"pack_size": r.PackSize, "qty": qty},
+		})
+	}
+	if r.MinAge > 0 && shopper.Age < r.MinAge {
+		violations = append(violations, common.RuleViolation{
+			Code:      common.RuleAgeRestricted,
+			ProductID: productID,
+			Message:   fmt.Sprintf("product is restricted to customers aged %d+", r.MinAge),
+			Params:    map[string]any{"min_age": r.MinAge},
+		})
+	}
+	if regions := r.Regions(); len(regions) > 0 && !containsFold(regions, shopper.Region) {
+		violations = append(violations, common.RuleViolation{
+			Code:      common.RuleRegionRestricted,
+			ProductID: productID,
+			Message:   "product is not available in your region",
+			Params:    map[string]any{"region": shopper.Region},
+		})
+	}
+	return violations
+}
+
+func (r PurchaseRule) Regions() []string {
+	if r.AllowedRegions == "" {
+		return nil
+	}
+	return strings.Split(r.AllowedRegions, ",")
+}
+
+// checkPurchaseRules проверяет строки корзины по правилам покупки и собирает все нарушения в одну ошибку.
+func checkPurchaseRules(rules []PurchaseRule, qty map[uuid.UUID]int64, shopper Shopper) error {
+	var violations []common.RuleViolation
+	for _, rule := range rules {
+		q, ok := qty[rule.ProductID]
+		if !ok {
+			continue
+		}
+		violations = append(violations, rule.Check(q, shopper)...)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &common.PurchaseRuleError{
+		RequestValidationError: common.RequestValidationError{Message: "purchase rules violated"},
+		Violations:             violations,
+	}
+}
+
+type Users interface {
+	GetUser(userID uuid.UUID) (UserProfile, error)
+}
+
+type rulesRepo interface {
+	GetPurchaseRules(productIDs []uuid.UUID) ([]PurchaseRule, error)
+}
+
+// checkCartRules проверяет итоговые количества товаров в корзине пользователя.
+// Возраст и регион берутся из профиля Users и только если у товаров есть такие ограничения.
+func checkCartRules(repo rulesRepo, users Users, userID uuid.UUID, qty map[uuid.UUID]int64, now time.Time) error {
+	ids := make([]uuid.UUID, 0, len(qty))
+	for id := range qty {
+		ids = append(ids, id)
+	}
+	rules, err := repo.GetPurchaseRules(ids)
+	if err != nil {
+		return fmt.Errorf("purchase rules: failed to get rules: %w", err)
+	}
+	var shopper Shopper
+	for _, rule := range rules {
+		if rule.MinAge == 0 && rule.AllowedRegions == "" {
+			continue
+		}
+		profile, err := users.GetUser(userID)
+		if err != nil {
+			return fmt.Errorf("purchase rules: failed to get shopper profile: %w", err)
+		}
+		shopper = Shopper{Age: ageAt(profile.BirthDate, now), Region: profile.Region}
+		break
+	}
+	return checkPurchaseRules(rules, qty, shopper)
+}
+
+// ageAt полных лет на дату now. Без даты рождения возраст считается нулевым.
+func ageAt(birthDate *time.Time, now time.Time) int64 {
+	if birthDate == nil {
+		return 0
+	}
+	years := now.Year() - birthDate.Year()
+	if now.Month() < birthDate.Month() || (now.Month() == birthDate.Month() && now.Day() < birthDate.Day()) {
+		years--
+	}
+	if years < 0 {
+		return 0
+	}
+	return int64(years)
+}
+
+func containsFold(values []string, v string) bool {
+	for _, s := range values {
+		if strings.EqualFold(strings.TrimSpace(s), v) {
+			return true
+		}
+	}
+	return false
+}
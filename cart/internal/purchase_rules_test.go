@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/cart/internal/common"
+	"github.com/madrabit/mini-market/cart/internal/validator"
+	"testing"
+	"time"
+)
+
+// ruleCartRepo корзина с правилами покупки, отмечает открытие транзакции
+type ruleCartRepo struct {
+	Repo
+	rules []PurchaseRule
+	inTx  bool
+	qty   map[uuid.UUID]int64
+}
+
+func (r *ruleCartRepo) BeginTransaction() (*sqlx.Tx, error) {
+	r.inTx = true
+	return beginNoopTx()
+}
+
+func (r *ruleCartRepo) GetPurchaseRules(_ []uuid.UUID) ([]PurchaseRule, error) { return r.rules, nil }
+
+func (r *ruleCartRepo) GetOrCreateCart(_ *sqlx.Tx, _ uuid.UUID) (uuid.UUID, error) {
+	return uuid.New(), nil
+}
+
+func (r *ruleCartRepo) FindItemById(_ *sqlx.Tx, _, productID uuid.UUID) (bool, error) {
+	_, ok := r.qty[productID]
+	return ok, nil
+}
+
+func (r *ruleCartRepo) AddToCart(_ *sqlx.Tx, _ uuid.UUID, item AddToCartRequest) error {
+	r.qty[item.ProductId] = item.Qty
+	return nil
+}
+
+func (r *ruleCartRepo) UpdateCart(_ *sqlx.Tx, _ uuid.UUID, item UpdateCartItemRequest) error {
+	r.qty[item.ProductId] = item.Qty
+	return nil
+}
+
+// profiles отдает профиль и проверяет, что Users не вызывают из открытой транзакции
+type profiles struct {
+	t       *testing.T
+	repo    *ruleCartRepo
+	profile UserProfile
+}
+
+func (p profiles) GetUser(_ uuid.UUID) (UserProfile, error) {
+	if p.repo.inTx {
+		p.t.Error("users service called inside a transaction")
+	}
+	return p.profile, nil
+}
+
+func TestCartRulesBeforeTransaction(t *testing.T) {
+	product := uuid.New()
+	adult := time.Now().AddDate(-30, 0, 0)
+	tests := []struct {
+		name    string
+		qty     int64
+		update  bool
+		violate bool
+	}{
+		{name: "add within limit", qty: 2},
+		{name: "add over limit", qty: 3, violate: true},
+		{name: "update within limit", qty: 1, update: true},
+		{name: "update over limit", qty: 5, update: true, violate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &ruleCartRepo{
+				rules: []PurchaseRule{{ProductID: product, MaxQty: 2, MinAge: 18}},
+				qty:   make(map[uuid.UUID]int64),
+			}
+			users := profiles{t: t, repo: repo, profile: UserProfile{BirthDate: &adult}}
+			svc := NewService(repo, validator.New(), nil, nil, users, nil)
+			var err error
+			if tt.update {
+				err = svc.UpdateCart(UpdateCartItemRequest{UserID: uuid.New(), ProductId: product, Qty: tt.qty})
+			} else {
+				err = svc.AddToCart(AddToCartRequest{UserID: uuid.New(), ProductId: product, Qty: tt.qty})
+			}
+			var ruleErr *common.PurchaseRuleError
+			if tt.violate {
+				if !errors.As(err, &ruleErr) {
+					t.Fatalf("err = %v, want PurchaseRuleError", err)
+				}
+				if repo.inTx {
+					t.Error("transaction opened for a rejected line")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if repo.qty[product] != tt.qty {
+				t.Errorf("qty = %d, want %d", repo.qty[product], tt.qty)
+			}
+		})
+	}
+}
@@ -1 +1,581 @@
 package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+// GetCart корзина пользователя со строками. Корзина создается при первом обращении.
+func (r *Repository) GetCart(userID uuid.UUID) (Cart, error) {
+	cart := Cart{Userid: userID, Items: make(map[uuid.UUID]Product)}
+	err := r.db.Get(&cart.Id, `INSERT INTO carts (id, user_id) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET updated_at = carts.updated_at
+		RETURNING id`, uuid.New(), userID)
+	if err != nil {
+		return Cart{}, err
+	}
+	if err = loadCartItems(r.db, &cart); err != nil {
+		return Cart{}, err
+	}
+	return cart, nil
+}
+
+// GetCartForUpdate корзина пользователя под блокировкой до конца транзакции
+func (r *Repository) GetCartForUpdate(tx *sqlx.Tx, userID uuid.UUID) (Cart, error) {
+	cartID, err := r.GetOrCreateCart(tx, userID)
+	if err != nil {
+		return Cart{}, err
+	}
+	cart := Cart{Userid: userID, Items: make(map[uuid.UUID]Product)}
+	if err = tx.Get(&cart.Id, `SELECT id FROM carts WHERE id = $1 FOR UPDATE`, cartID); err != nil {
+		return Cart{}, err
+	}
+	if err = loadCartItems(tx, &cart); err != nil {
+		return Cart{}, err
+	}
+	return cart, nil
+}
+
+func loadCartItems(q sqlx.Queryer, cart *Cart) error {
+	rows, err := q.Queryx(`SELECT id, cart_id, product_id, qty FROM cart_items WHERE cart_id = $1`, cart.Id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var item Product
+		if err = rows.Scan(&item.Id, &item.CartId, &item.ProductId, &item.Qty); err != nil {
+			return err
+		}
+		cart.Items[item.ProductId] = item
+	}
+	return rows.Err()
+}
+
+// FindItemById есть ли товар в корзине
+func (r *Repository) FindItemById(tx *sqlx.Tx, cartID, productID uuid.UUID) (bool, error) {
+	var exists bool
+	err := tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM cart_items WHERE cart_id = $1 AND product_id = $2)`,
+		cartID, productID)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (r *Repository) AddToCart(tx *sqlx.Tx, cartID uuid.UUID, item AddToCartRequest) error {
+	_, err := tx.Exec(`INSERT INTO cart_items (id, cart_id, product_id, qty) VALUES ($1, $2, $3, $4)`,
+		uuid.New(), cartID, item.ProductId, item.Qty)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateCart задает количество товара в корзине, sql.ErrNoRows - товара в корзине нет
+func (r *Repository) UpdateCart(tx *sqlx.Tx, cartID uuid.UUID, item UpdateCartItemRequest) error {
+	result, err := tx.Exec(`UPDATE cart_items SET qty = $1, updated_at = NOW() WHERE cart_id = $2 AND product_id = $3`,
+		item.Qty, cartID, item.ProductId)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteProduct убирает товар из корзины пользователя, sql.ErrNoRows - товара в корзине нет
+func (r *Repository) DeleteProduct(userID, productID uuid.UUID) error {
+	result, err := r.db.Exec(`DELETE FROM cart_items
+		WHERE cart_id = (SELECT id FROM carts WHERE user_id = $1) AND product_id = $2`, userID, productID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *Repository) CreatePromotion(promo Promotion) error {
+	_, err := r.db.Exec(`INSERT INTO promotions (id, code, type, value, product_id, buy_qty, get_qty, min_basket,
+                        stackable, usage_limit, starts_at, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		promo.ID, promo.Code, promo.Type, promo.Value, promo.ProductID, promo.BuyQty, promo.GetQty, promo.MinBasket,
+		promo.Stackable, promo.UsageLimit, promo.StartsAt, promo.ExpiresAt, promo.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetPromotionByCode(tx *sqlx.Tx, code string) (Promotion, error) {
+	var promo Promotion
+	err := tx.Get(&promo, `SELECT id, code, type, value, product_id, buy_qty, get_qty, min_basket, stackable,
+       usage_limit, starts_at, expires_at, created_at FROM promotions WHERE code = $1`, code)
+	if err != nil {
+		return Promotion{}, err
+	}
+	return promo, nil
+}
+
+func (r *Repository) GetPromotions(ids []uuid.UUID) ([]Promotion, error) {
+	var promos []Promotion
+	q, args, err := sqlx.In(`SELECT id, code, type, value, product_id, buy_qty, get_qty, min_basket, stackable,
+       usage_limit, starts_at, expires_at, created_at FROM promotions WHERE id IN (?)`, ids)
+	if err != nil {
+		return nil, err
+	}
+	q = r.db.Rebind(q)
+	err = r.db.Select(&promos, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return promos, nil
+}
+
+// GetPromotionsForUpdate блокирует акции до конца транзакции, чтобы подсчет и списание
+// использований по лимиту не шли параллельно. Порядок блокировки по id исключает взаимоблокировки.
+func (r *Repository) GetPromotionsForUpdate(tx *sqlx.Tx, ids []uuid.UUID) ([]Promotion, error) {
+	var promos []Promotion
+	q, args, err := sqlx.In(`SELECT id, code, type, value, product_id, buy_qty, get_qty, min_basket, stackable,
+       usage_limit, starts_at, expires_at, created_at FROM promotions WHERE id IN (?) ORDER BY id FOR UPDATE`, ids)
+	if err != nil {
+		return nil, err
+	}
+	q = tx.Rebind(q)
+	err = tx.Select(&promos, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return promos, nil
+}
+
+func (r *Repository) GetCartPromotions(cartID uuid.UUID) ([]Promotion, error) {
+	return selectCartPromotions(r.db, cartID)
+}
+
+// GetAppliedPromotions промо-акции корзины внутри транзакции, корзина должна быть заблокирована
+func (r *Repository) GetAppliedPromotions(tx *sqlx.Tx, cartID uuid.UUID) ([]Promotion, error) {
+	return selectCartPromotions(tx, cartID)
+}
+
+func selectCartPromotions(q sqlx.Queryer, cartID uuid.UUID) ([]Promotion, error) {
+	var promos []Promotion
+	err := sqlx.Select(q, &promos, `
+	SELECT p.id, p.code, p.type, p.value, p.product_id, p.buy_qty, p.get_qty, p.min_basket, p.stackable,
+	       p.usage_limit, p.starts_at, p.expires_at, p.created_at
+	FROM cart_promotions cp
+	INNER JOIN promotions p ON cp.promotion_id = p.id
+	WHERE cp.cart_id = $1
+	ORDER BY cp.created_at`, cartID)
+	if err != nil {
+		return nil, err
+	}
+	return promos, nil
+}
+
+func (r *Repository) AddCartPromotion(tx *sqlx.Tx, cartID, promotionID uuid.UUID) error {
+	_, err := tx.Exec(`INSERT INTO cart_promotions (cart_id, promotion_id) VALUES ($1, $2)
+		ON CONFLICT (cart_id, promotion_id) DO NOTHING`, cartID, promotionID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) DeleteCartPromotion(cartID, promotionID uuid.UUID) error {
+	result, err := r.db.Exec(`DELETE FROM cart_promotions WHERE cart_id = $1 AND promotion_id = $2`, cartID, promotionID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *Repository) CountPromotionUsages(tx *sqlx.Tx, promotionID, userID uuid.UUID) (int64, error) {
+	var count int64
+	err := tx.Get(&count, `SELECT COUNT(*) FROM promotion_usages WHERE promotion_id = $1 AND user_id = $2`,
+		promotionID, userID)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *Repository) AddPromotionUsages(tx *sqlx.Tx, userID, orderID uuid.UUID, promotionIDs []uuid.UUID) error {
+	values := make([]string, 0, len(promotionIDs))
+	args := make([]interface{}, 0, 2+len(promotionIDs))
+	args = append(args, userID, orderID)
+	for i, id := range promotionIDs {
+		values = append(values, fmt.Sprintf("($%d, $1, $2)", i+3))
+		args = append(args, id)
+	}
+	query := fmt.Sprintf(`INSERT INTO promotion_usages (promotion_id, user_id, order_id)
+		VALUES %s ON CONFLICT (promotion_id, order_id) DO NOTHING`, strings.Join(values, ","))
+	_, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) CreateCheckout(tx *sqlx.Tx, checkout Checkout) error {
+	_, err := tx.Exec(`INSERT INTO checkouts (id, cart_id, user_id, idempotency_key, content_hash, promotion_ids, status,
+		created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		checkout.ID, checkout.CartID, checkout.UserID, checkout.IdempotencyKey, checkout.ContentHash,
+		pq.Array(checkout.PromotionIDs), checkout.Status, checkout.CreatedAt, checkout.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetPendingCheckout(tx *sqlx.Tx, cartID uuid.UUID) (Checkout, error) {
+	return getCheckout(tx, `SELECT id, cart_id, user_id, order_id, idempotency_key, content_hash, promotion_ids, status,
+		created_at, updated_at
+		FROM checkouts WHERE cart_id = $1 AND status = $2 FOR UPDATE`, cartID, CheckoutPending)
+}
+
+func (r *Repository) SetCheckoutOrder(checkoutID, orderID uuid.UUID) error {
+	_, err := r.db.Exec(`UPDATE checkouts SET order_id = $1, updated_at = NOW() WHERE id = $2`, orderID, checkoutID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetCheckoutByOrder(tx *sqlx.Tx, orderID uuid.UUID) (Checkout, error) {
+	return getCheckout(tx, `SELECT id, cart_id, user_id, order_id, idempotency_key, content_hash, promotion_ids, status,
+		created_at, updated_at
+		FROM checkouts WHERE order_id = $1 FOR UPDATE`, orderID)
+}
+
+// getCheckout читает оформление построчно: массив акций sqlx в структуру сам не разберет
+func getCheckout(tx *sqlx.Tx, query string, args ...interface{}) (Checkout, error) {
+	var c Checkout
+	err := tx.QueryRowx(query, args...).Scan(&c.ID, &c.CartID, &c.UserID, &c.OrderID, &c.IdempotencyKey, &c.ContentHash,
+		pq.Array(&c.PromotionIDs), &c.Status, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return Checkout{}, err
+	}
+	return c, nil
+}
+
+func (r *Repository) UpdateCheckoutStatus(tx *sqlx.Tx, checkoutID uuid.UUID, status CheckoutStatus) error {
+	_, err := tx.Exec(`UPDATE checkouts SET status = $1, updated_at = NOW() WHERE id = $2`, status, checkoutID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) ClearCart(tx *sqlx.Tx, cartID uuid.UUID) error {
+	if _, err := tx.Exec(`DELETE FROM cart_items WHERE cart_id = $1`, cartID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM cart_promotions WHERE cart_id = $1`, cartID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) CreateWishlist(tx *sqlx.Tx, wishlist Wishlist) error {
+	_, err := tx.Exec(`INSERT INTO wishlists (id, user_id, name, kind, public, share_token, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		wishlist.ID, wishlist.UserID, wishlist.Name, wishlist.Kind, wishlist.Public, wishlist.ShareToken, wishlist.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) UpdateWishlist(wishlist Wishlist) error {
+	result, err := r.db.Exec(`UPDATE wishlists SET name = $1, public = $2, share_token = $3 WHERE id = $4`,
+		wishlist.Name, wishlist.Public, wishlist.ShareToken, wishlist.ID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *Repository) DeleteWishlist(id uuid.UUID) error {
+	_, err := r.db.Exec("DELETE FROM wishlists WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetWishlist(tx *sqlx.Tx, id uuid.UUID) (Wishlist, error) {
+	var wishlist Wishlist
+	err := tx.Get(&wishlist, `SELECT id, user_id, name, kind, public, share_token, created_at
+		FROM wishlists WHERE id = $1`, id)
+	if err != nil {
+		return Wishlist{}, err
+	}
+	return wishlist, nil
+}
+
+func (r *Repository) GetWishlistByToken(token string) (Wishlist, error) {
+	var wishlist Wishlist
+	err := r.db.Get(&wishlist, `SELECT id, user_id, name, kind, public, share_token, created_at
+		FROM wishlists WHERE share_token = $1`, token)
+	if err != nil {
+		return Wishlist{}, err
+	}
+	return wishlist, nil
+}
+
+func (r *Repository) GetSavedForLater(tx *sqlx.Tx, userID uuid.UUID) (Wishlist, error) {
+	var wishlist Wishlist
+	err := tx.Get(&wishlist, `SELECT id, user_id, name, kind, public, share_token, created_at
+		FROM wishlists WHERE user_id = $1 AND kind = $2`, userID, SavedForLater)
+	if err != nil {
+		return Wishlist{}, err
+	}
+	return wishlist, nil
+}
+
+func (r *Repository) GetUserWishlists(userID uuid.UUID) ([]Wishlist, error) {
+	var wishlists []Wishlist
+	err := r.db.Select(&wishlists, `SELECT id, user_id, name, kind, public, share_token, created_at
+		FROM wishlists WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return wishlists, nil
+}
+
+func (r *Repository) GetWishlistItems(wishlistIDs []uuid.UUID) ([]WishlistItem, error) {
+	var items []WishlistItem
+	q, args, err := sqlx.In(`SELECT id, wishlist_id, product_id, qty, last_price, in_stock, created_at
+		FROM wishlist_items WHERE wishlist_id IN (?) ORDER BY created_at`, wishlistIDs)
+	if err != nil {
+		return nil, err
+	}
+	q = r.db.Rebind(q)
+	err = r.db.Select(&items, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *Repository) GetWishlistItem(tx *sqlx.Tx, wishlistID, productID uuid.UUID) (WishlistItem, error) {
+	var item WishlistItem
+	err := tx.Get(&item, `SELECT id, wishlist_id, product_id, qty, last_price, in_stock, created_at
+		FROM wishlist_items WHERE wishlist_id = $1 AND product_id = $2`, wishlistID, productID)
+	if err != nil {
+		return WishlistItem{}, err
+	}
+	return item, nil
+}
+
+func (r *Repository) AddWishlistItem(tx *sqlx.Tx, item WishlistItem) error {
+	_, err := tx.Exec(`INSERT INTO wishlist_items (id, wishlist_id, product_id, qty, last_price, in_stock, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (wishlist_id, product_id) DO UPDATE SET qty = wishlist_items.qty + EXCLUDED.qty`,
+		item.ID, item.WishlistID, item.ProductID, item.Qty, item.LastPrice, item.InStock, item.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) DeleteWishlistItem(tx *sqlx.Tx, wishlistID, productID uuid.UUID) error {
+	_, err := tx.Exec(`DELETE FROM wishlist_items WHERE wishlist_id = $1 AND product_id = $2`, wishlistID, productID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetWishlistWatchers(productID uuid.UUID) ([]WishlistWatcher, error) {
+	var watchers []WishlistWatcher
+	err := r.db.Select(&watchers, `
+	SELECT w.user_id, wi.product_id, wi.last_price, wi.in_stock
+	FROM wishlist_items wi
+	INNER JOIN wishlists w ON wi.wishlist_id = w.id
+	WHERE wi.product_id = $1`, productID)
+	if err != nil {
+		return nil, err
+	}
+	return watchers, nil
+}
+
+// UpdateUserWishlistSnapshot обновляет снимок только в списках одного пользователя, чтобы повтор
+// события после сбоя не уведомлял повторно тех, кто уже получил уведомление
+func (r *Repository) UpdateUserWishlistSnapshot(userID, productID uuid.UUID, price *int64, inStock *bool) error {
+	_, err := r.db.Exec(`UPDATE wishlist_items wi
+		SET last_price = COALESCE($1, wi.last_price), in_stock = COALESCE($2, wi.in_stock)
+		FROM wishlists w
+		WHERE wi.wishlist_id = w.id AND w.user_id = $3 AND wi.product_id = $4`, price, inStock, userID, productID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetOrCreateCart(tx *sqlx.Tx, userID uuid.UUID) (uuid.UUID, error) {
+	var cartID uuid.UUID
+	err := tx.Get(&cartID, `INSERT INTO carts (id, user_id) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET updated_at = carts.updated_at
+		RETURNING id`, uuid.New(), userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return cartID, nil
+}
+
+func (r *Repository) GetCartItem(tx *sqlx.Tx, cartID, productID uuid.UUID) (Product, error) {
+	var item Product
+	err := tx.QueryRowx(`SELECT id, cart_id, product_id, qty FROM cart_items WHERE cart_id = $1 AND product_id = $2`,
+		cartID, productID).Scan(&item.Id, &item.CartId, &item.ProductId, &item.Qty)
+	if err != nil {
+		return Product{}, err
+	}
+	return item, nil
+}
+
+// UpsertCartItem добавляет количество к строке корзины и возвращает итоговое количество
+func (r *Repository) UpsertCartItem(tx *sqlx.Tx, cartID, productID uuid.UUID, qty int64) (int64, error) {
+	var total int64
+	err := tx.QueryRowx(`INSERT INTO cart_items (id, cart_id, product_id, qty) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (cart_id, product_id) DO UPDATE SET qty = cart_items.qty + EXCLUDED.qty, updated_at = NOW()
+		RETURNING qty`,
+		uuid.New(), cartID, productID, qty).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+func (r *Repository) DeleteCartItem(tx *sqlx.Tx, cartID, productID uuid.UUID) error {
+	_, err := tx.Exec(`DELETE FROM cart_items WHERE cart_id = $1 AND product_id = $2`, cartID, productID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) FindAbandonedCarts(idleSince time.Time, limit int) ([]AbandonedCart, error) {
+	var carts []AbandonedCart
+	err := r.db.Select(&carts, `
+	SELECT c.id AS cart_id, c.user_id, SUM(ci.qty) AS items_qty, c.updated_at,
+	       EXISTS (SELECT 1 FROM cart_reminder_opt_outs o WHERE o.user_id = c.user_id) AS opted_out,
+	       COALESCE(c.reminded_at >= c.updated_at, FALSE) AS reminded
+	FROM carts c
+	INNER JOIN cart_items ci ON ci.cart_id = c.id
+	WHERE c.updated_at < $1 AND (c.abandoned_at IS NULL OR c.abandoned_at < c.updated_at)
+	  AND NOT EXISTS (SELECT 1 FROM checkouts ch WHERE ch.cart_id = c.id AND ch.status = $3)
+	GROUP BY c.id, c.user_id, c.updated_at
+	ORDER BY c.updated_at
+	LIMIT $2`, idleSince, limit, CheckoutPending)
+	if err != nil {
+		return nil, err
+	}
+	return carts, nil
+}
+
+func (r *Repository) MarkCartReminded(cartID uuid.UUID, at time.Time) error {
+	_, err := r.db.Exec(`UPDATE carts SET reminded_at = $1 WHERE id = $2`, at, cartID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) MarkCartAbandoned(cartID uuid.UUID, at time.Time) error {
+	_, err := r.db.Exec(`UPDATE carts SET abandoned_at = $1 WHERE id = $2`, at, cartID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) AddReminderOptOut(userID uuid.UUID) error {
+	_, err := r.db.Exec(`INSERT INTO cart_reminder_opt_outs (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING`, userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) DeleteReminderOptOut(userID uuid.UUID) error {
+	_, err := r.db.Exec(`DELETE FROM cart_reminder_opt_outs WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetPurchaseRules(productIDs []uuid.UUID) ([]PurchaseRule, error) {
+	var rules []PurchaseRule
+	q, args, err := sqlx.In(`SELECT product_id, max_qty, min_qty, pack_size, min_age, allowed_regions
+		FROM purchase_rules WHERE product_id IN (?)`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	q = r.db.Rebind(q)
+	err = r.db.Select(&rules, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (r *Repository) UpsertPurchaseRule(rule PurchaseRule) error {
+	_, err := r.db.Exec(`INSERT INTO purchase_rules (product_id, max_qty, min_qty, pack_size, min_age, allowed_regions)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (product_id) DO UPDATE SET max_qty = EXCLUDED.max_qty, min_qty = EXCLUDED.min_qty,
+			pack_size = EXCLUDED.pack_size, min_age = EXCLUDED.min_age, allowed_regions = EXCLUDED.allowed_regions,
+			updated_at = NOW()`,
+		rule.ProductID, rule.MaxQty, rule.MinQty, rule.PackSize, rule.MinAge, rule.AllowedRegions)
+	if err != nil {
+		return err
+	}
+	return nil
+}
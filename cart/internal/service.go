@@ -1,41 +1,89 @@
 package internal
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	_ "github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/madrabit/mini-market/cart/internal/common"
+	"sort"
+	"strings"
+	"time"
 )
 
 type Service struct {
 	repo      Repo
 	validator Validator
+	catalog   Catalog
+	orders    Orders
+	users     Users
+	callbacks CallbackVerifier
 }
 
 type Repo interface {
 	BeginTransaction() (tx *sqlx.Tx, err error)
-	FindItemById(tx *sqlx.Tx, productID uuid.UUID) (bool, error)
+	FindItemById(tx *sqlx.Tx, cartID, productID uuid.UUID) (bool, error)
 	GetCart(userID uuid.UUID) (Cart, error)
-	AddToCart(tx *sqlx.Tx, item AddToCartRequest) error
-	UpdateCart(tx *sqlx.Tx, item UpdateCartItemRequest) error
-	DeleteProduct(id uuid.UUID) error
+	GetCartForUpdate(tx *sqlx.Tx, userID uuid.UUID) (Cart, error)
+	GetOrCreateCart(tx *sqlx.Tx, userID uuid.UUID) (uuid.UUID, error)
+	AddToCart(tx *sqlx.Tx, cartID uuid.UUID, item AddToCartRequest) error
+	UpdateCart(tx *sqlx.Tx, cartID uuid.UUID, item UpdateCartItemRequest) error
+	DeleteProduct(userID, productID uuid.UUID) error
+	CreatePromotion(promo Promotion) error
+	GetPromotionByCode(tx *sqlx.Tx, code string) (Promotion, error)
+	GetPromotions(ids []uuid.UUID) ([]Promotion, error)
+	GetPromotionsForUpdate(tx *sqlx.Tx, ids []uuid.UUID) ([]Promotion, error)
+	GetCartPromotions(cartID uuid.UUID) ([]Promotion, error)
+	GetAppliedPromotions(tx *sqlx.Tx, cartID uuid.UUID) ([]Promotion, error)
+	AddCartPromotion(tx *sqlx.Tx, cartID, promotionID uuid.UUID) error
+	DeleteCartPromotion(cartID, promotionID uuid.UUID) error
+	CountPromotionUsages(tx *sqlx.Tx, promotionID, userID uuid.UUID) (int64, error)
+	AddPromotionUsages(tx *sqlx.Tx, userID, orderID uuid.UUID, promotionIDs []uuid.UUID) error
+	CreateCheckout(tx *sqlx.Tx, checkout Checkout) error
+	GetPendingCheckout(tx *sqlx.Tx, cartID uuid.UUID) (Checkout, error)
+	SetCheckoutOrder(checkoutID, orderID uuid.UUID) error
+	GetCheckoutByOrder(tx *sqlx.Tx, orderID uuid.UUID) (Checkout, error)
+	UpdateCheckoutStatus(tx *sqlx.Tx, checkoutID uuid.UUID, status CheckoutStatus) error
+	ClearCart(tx *sqlx.Tx, cartID uuid.UUID) error
+	AddReminderOptOut(userID uuid.UUID) error
+	DeleteReminderOptOut(userID uuid.UUID) error
+	GetPurchaseRules(productIDs []uuid.UUID) ([]PurchaseRule, error)
+	UpsertPurchaseRule(rule PurchaseRule) error
 }
 
 type Validator interface {
 	Validate(request any) error
 }
 
-func NewService(repo Repo, validator Validator) *Service {
-	return &Service{repo, validator}
+type Catalog interface {
+	GetProducts(req CatalogRequest) (CatalogResponse, error)
 }
 
-func (s *Service) Add(item AddToCartRequest) (err error) {
+type Orders interface {
+	CreateOrder(req CreatOrderRequest, idempotencyKey string) (OrderResponse, error)
+}
+
+type CallbackVerifier interface {
+	Verify(req ConfirmCheckoutPaymentRequest, now time.Time) error
+}
+
+func NewService(repo Repo, validator Validator, catalog Catalog, orders Orders, users Users, callbacks CallbackVerifier) *Service {
+	return &Service{repo, validator, catalog, orders, users, callbacks}
+}
+
+// AddToCart добавляет товар в корзину. Правила покупки проверяются до транзакции:
+// профиль покупателя запрашивается в Users, и держать ради этого транзакцию открытой незачем.
+func (s *Service) AddToCart(item AddToCartRequest) (err error) {
 	if err = s.validator.Validate(item); err != nil {
 		return &common.RequestValidationError{Message: err.Error()}
 	}
+	if err = s.checkPurchaseRules(item.UserID, map[uuid.UUID]int64{item.ProductId: item.Qty}); err != nil {
+		return err
+	}
 	tx, err := s.repo.BeginTransaction()
 	if err != nil {
 		return fmt.Errorf("cart service: add product: error starting transaction")
@@ -56,35 +104,50 @@ func (s *Service) Add(item AddToCartRequest) (err error) {
 			err = fmt.Errorf("cart service: add product: committing transaction failed: %w", commitErr)
 		}
 	}()
-	isExists, err := s.repo.FindItemById(tx, item.ProductId)
+	cartID, err := s.repo.GetOrCreateCart(tx, item.UserID)
+	if err != nil {
+		return fmt.Errorf("cart service: add product: error getting cart: %w", err)
+	}
+	isExists, err := s.repo.FindItemById(tx, cartID, item.ProductId)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return fmt.Errorf("cart service: add product: error checking exists of product")
 	}
 	if isExists {
 		return &common.AlreadyExistsError{Message: fmt.Sprintf("product with id %s already exists", item.ProductId)}
 	}
-	err = s.repo.AddToCart(tx, item)
+	err = s.repo.AddToCart(tx, cartID, item)
 	if err != nil {
 		return fmt.Errorf("cart service: add product: error adding product")
 	}
 	return nil
 }
 
-func (s *Service) GetCart(userID uuid.UUID) (Cart, error) {
+func (s *Service) GetCart(userID uuid.UUID) (PricedCart, error) {
 	if userID == uuid.Nil {
-		return Cart{}, errors.New("cart service: invalid user")
+		return PricedCart{}, errors.New("cart service: invalid user")
 	}
 	cart, err := s.repo.GetCart(userID)
 	if err != nil {
-		return Cart{}, fmt.Errorf("cart service: failed to get cart: %w", err)
+		return PricedCart{}, fmt.Errorf("cart service: failed to get cart: %w", err)
+	}
+	promos, err := s.repo.GetCartPromotions(cart.Id)
+	if err != nil {
+		return PricedCart{}, fmt.Errorf("cart service: failed to get cart promotions: %w", err)
 	}
-	return cart, nil
+	priced, err := s.priceCart(cart, promos)
+	if err != nil {
+		return PricedCart{}, fmt.Errorf("cart service: get cart: %w", err)
+	}
+	return priced, nil
 }
 
-func (s *Service) UpdateCart(item UpdateCartItemRequest) error {
+func (s *Service) UpdateCart(item UpdateCartItemRequest) (err error) {
 	if err := s.validator.Validate(item); err != nil {
 		return &common.RequestValidationError{Message: err.Error()}
 	}
+	if err = s.checkPurchaseRules(item.UserID, map[uuid.UUID]int64{item.ProductId: item.Qty}); err != nil {
+		return err
+	}
 	tx, err := s.repo.BeginTransaction()
 	if err != nil {
 		return fmt.Errorf("cart service: update cart: error starting transaction")
@@ -105,24 +168,544 @@ func (s *Service) UpdateCart(item UpdateCartItemRequest) error {
 			err = fmt.Errorf("cart service: update cart: committing transaction failed: %w", commitErr)
 		}
 	}()
-	isExists, err := s.repo.FindItemById(tx, item.ProductId)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return fmt.Errorf("cart service: add product: error checking exists of product")
+	cartID, err := s.repo.GetOrCreateCart(tx, item.UserID)
+	if err != nil {
+		return fmt.Errorf("cart service: update cart: error getting cart: %w", err)
 	}
-	if isExists {
-		return &common.AlreadyExistsError{Message: fmt.Sprintf("product with id %s already exists", item.ProductId)}
+	err = s.repo.UpdateCart(tx, cartID, item)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &common.NotFoundError{Message: fmt.Sprintf("product with id %s is not in cart", item.ProductId)}
 	}
-	err = s.repo.UpdateCart(tx, item)
 	if err != nil {
-		return fmt.Errorf("cart service: add product: error adding product")
+		return fmt.Errorf("cart service: update cart: error updating product: %w", err)
 	}
 	return nil
 }
 
-func (s *Service) Delete(id uuid.UUID) error {
-	err := s.repo.DeleteProduct(id)
+func (s *Service) DeleteProduct(userID, productID uuid.UUID) error {
+	if userID == uuid.Nil || productID == uuid.Nil {
+		return &common.RequestValidationError{Message: "invalid id"}
+	}
+	err := s.repo.DeleteProduct(userID, productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &common.NotFoundError{Message: fmt.Sprintf("product with id %s is not in cart", productID)}
+	}
 	if err != nil {
-		return fmt.Errorf("cart service: delete: error deleting product with id %d", id)
+		return fmt.Errorf("cart service: delete: error deleting product with id %s: %w", productID, err)
 	}
 	return nil
 }
+
+func (s *Service) CreatePromotion(req CreatePromotionRequest) (Promotion, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return Promotion{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	if req.Type == PercentOff && req.Value > 100 {
+		return Promotion{}, &common.RequestValidationError{Message: "percent off value must be between 0 and 100"}
+	}
+	if req.Type == BuyXGetY && (req.BuyQty == 0 || req.GetQty == 0) {
+		return Promotion{}, &common.RequestValidationError{Message: "buy x get y requires buy_qty and get_qty"}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(req.StartsAt) {
+		return Promotion{}, &common.RequestValidationError{Message: "expires_at must be after starts_at"}
+	}
+	promo := Promotion{
+		ID:         uuid.New(),
+		Code:       strings.ToUpper(req.Code),
+		Type:       req.Type,
+		Value:      req.Value,
+		ProductID:  req.ProductID,
+		BuyQty:     req.BuyQty,
+		GetQty:     req.GetQty,
+		MinBasket:  req.MinBasket,
+		Stackable:  req.Stackable,
+		UsageLimit: req.UsageLimit,
+		StartsAt:   req.StartsAt,
+		ExpiresAt:  req.ExpiresAt,
+		CreatedAt:  time.Now(),
+	}
+	if promo.StartsAt.IsZero() {
+		promo.StartsAt = promo.CreatedAt
+	}
+	if err := s.repo.CreatePromotion(promo); err != nil {
+		return Promotion{}, fmt.Errorf("cart service: create promotion: %w", err)
+	}
+	return promo, nil
+}
+
+// ApplyPromoCode применяет промокод к корзине. Корзина блокируется до конца транзакции,
+// поэтому два параллельных запроса не добавят две несочетаемые акции.
+func (s *Service) ApplyPromoCode(req ApplyPromoCodeRequest) (resp PricedCart, err error) {
+	if err = s.validator.Validate(req); err != nil {
+		return PricedCart{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	tx, err := s.repo.BeginTransaction()
+	if err != nil {
+		return PricedCart{}, fmt.Errorf("cart service: apply promo code: error starting transaction")
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			err = fmt.Errorf("cart service: apply promo code: panic apply promo code: %v", p)
+			return
+		}
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				err = fmt.Errorf("rollback failed: original error: %w", err)
+			}
+			return
+		}
+		if commitErr := tx.Commit(); commitErr != nil {
+			err = fmt.Errorf("cart service: apply promo code: committing transaction failed: %w", commitErr)
+		}
+	}()
+	cart, err := s.repo.GetCartForUpdate(tx, req.UserID)
+	if err != nil {
+		return PricedCart{}, fmt.Errorf("cart service: apply promo code: failed to get cart: %w", err)
+	}
+	applied, err := s.repo.GetAppliedPromotions(tx, cart.Id)
+	if err != nil {
+		return PricedCart{}, fmt.Errorf("cart service: apply promo code: failed to get cart promotions: %w", err)
+	}
+	promo, err := s.repo.GetPromotionByCode(tx, strings.ToUpper(req.Code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return PricedCart{}, &common.NotFoundError{Message: fmt.Sprintf("promo code %s not found", req.Code)}
+	}
+	if err != nil {
+		return PricedCart{}, fmt.Errorf("cart service: apply promo code: failed to get promotion: %w", err)
+	}
+	if !promo.IsActive(time.Now()) {
+		return PricedCart{}, &common.RequestValidationError{Message: fmt.Sprintf("promo code %s is not active", req.Code)}
+	}
+	for _, p := range applied {
+		if p.ID == promo.ID {
+			return PricedCart{}, &common.AlreadyExistsError{Message: fmt.Sprintf("promo code %s already applied", req.Code)}
+		}
+		if !p.Stackable || !promo.Stackable {
+			return PricedCart{}, &common.ConflictError{Message: fmt.Sprintf("promo code %s cannot be combined with %s", req.Code, p.Code)}
+		}
+	}
+	if promo.UsageLimit > 0 {
+		used, err := s.repo.CountPromotionUsages(tx, promo.ID, req.UserID)
+		if err != nil {
+			return PricedCart{}, fmt.Errorf("cart service: apply promo code: failed to count usages: %w", err)
+		}
+		if used >= promo.UsageLimit {
+			return PricedCart{}, &common.ConflictError{Message: fmt.Sprintf("promo code %s usage limit reached", req.Code)}
+		}
+	}
+	if err = s.repo.AddCartPromotion(tx, cart.Id, promo.ID); err != nil {
+		return PricedCart{}, fmt.Errorf("cart service: apply promo code: error adding promotion")
+	}
+	resp, err = s.priceCart(cart, append(applied, promo))
+	if err != nil {
+		return PricedCart{}, fmt.Errorf("cart service: apply promo code: %w", err)
+	}
+	return resp, nil
+}
+
+func (s *Service) RemovePromoCode(req RemovePromoCodeRequest) (PricedCart, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return PricedCart{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	cart, err := s.repo.GetCart(req.UserID)
+	if err != nil {
+		return PricedCart{}, fmt.Errorf("cart service: remove promo code: failed to get cart: %w", err)
+	}
+	applied, err := s.repo.GetCartPromotions(cart.Id)
+	if err != nil {
+		return PricedCart{}, fmt.Errorf("cart service: remove promo code: failed to get cart promotions: %w", err)
+	}
+	code := strings.ToUpper(req.Code)
+	promos := make([]Promotion, 0, len(applied))
+	var removed bool
+	for _, p := range applied {
+		if p.Code == code {
+			if err := s.repo.DeleteCartPromotion(cart.Id, p.ID); err != nil {
+				return PricedCart{}, fmt.Errorf("cart service: remove promo code: error deleting promotion")
+			}
+			removed = true
+			continue
+		}
+		promos = append(promos, p)
+	}
+	if !removed {
+		return PricedCart{}, &common.NotFoundError{Message: fmt.Sprintf("promo code %s is not applied", req.Code)}
+	}
+	priced, err := s.priceCart(cart, promos)
+	if err != nil {
+		return PricedCart{}, fmt.Errorf("cart service: remove promo code: %w", err)
+	}
+	return priced, nil
+}
+
+// QuotePromotions заново проверяет промо-акции заказа и считает скидки по текущим ценам каталога.
+// Неизвестная, неактивная или исчерпавшая лимит акция - ошибка, а не нулевая скидка:
+// покупатель оформлял заказ с ней и должен узнать, что она больше не действует.
+func (s *Service) QuotePromotions(req PromotionQuoteRequest) (quote PromotionQuote, err error) {
+	if err = s.validator.Validate(req); err != nil {
+		return PromotionQuote{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	cart := Cart{Userid: req.UserID, Items: make(map[uuid.UUID]Product, len(req.Items))}
+	for _, item := range req.Items {
+		line := cart.Items[item.ProductID]
+		line.ProductId = item.ProductID
+		line.Qty += item.Qty
+		cart.Items[item.ProductID] = line
+	}
+	var promos []Promotion
+	if len(req.PromotionIDs) > 0 {
+		if promos, err = s.checkQuotedPromotions(req.UserID, req.PromotionIDs); err != nil {
+			return PromotionQuote{}, err
+		}
+	}
+	priced, err := s.priceCart(cart, promos)
+	if err != nil {
+		return PromotionQuote{}, fmt.Errorf("cart service: quote promotions: %w", err)
+	}
+	return PromotionQuote{Subtotal: priced.Subtotal, Total: priced.Total, Promotions: priced.Promotions}, nil
+}
+
+func (s *Service) checkQuotedPromotions(userID uuid.UUID, ids []uuid.UUID) (promos []Promotion, err error) {
+	promos, err = s.repo.GetPromotions(ids)
+	if err != nil {
+		return nil, fmt.Errorf("cart service: quote promotions: failed to get promotions: %w", err)
+	}
+	found := make(map[uuid.UUID]bool, len(promos))
+	for _, p := range promos {
+		found[p.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return nil, &common.NotFoundError{Message: fmt.Sprintf("promotion %s not found", id)}
+		}
+	}
+	now := time.Now()
+	for _, p := range promos {
+		if !p.IsActive(now) {
+			return nil, &common.ConflictError{Message: fmt.Sprintf("promo code %s is not active", p.Code)}
+		}
+	}
+	tx, err := s.repo.BeginTransaction()
+	if err != nil {
+		return nil, fmt.Errorf("cart service: quote promotions: error starting transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	for _, p := range promos {
+		if p.UsageLimit == 0 {
+			continue
+		}
+		used, err := s.repo.CountPromotionUsages(tx, p.ID, userID)
+		if err != nil {
+			return nil, fmt.Errorf("cart service: quote promotions: failed to count usages: %w", err)
+		}
+		if used >= p.UsageLimit {
+			return nil, &common.ConflictError{Message: fmt.Sprintf("promo code %s usage limit reached", p.Code)}
+		}
+	}
+	return promos, nil
+}
+
+func (s *Service) Checkout(req CheckoutRequest) (resp OrderResponse, err error) {
+	if err = s.validator.Validate(req); err != nil {
+		return OrderResponse{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	cart, err := s.repo.GetCart(req.UserID)
+	if err != nil {
+		return OrderResponse{}, fmt.Errorf("cart service: checkout: failed to get cart: %w", err)
+	}
+	if len(cart.Items) == 0 {
+		return OrderResponse{}, &common.RequestValidationError{Message: "cart is empty"}
+	}
+	qty := make(map[uuid.UUID]int64, len(cart.Items))
+	for _, item := range cart.Items {
+		qty[item.ProductId] = item.Qty
+	}
+	if err = s.checkPurchaseRules(req.UserID, qty); err != nil {
+		return OrderResponse{}, err
+	}
+	promos, err := s.repo.GetCartPromotions(cart.Id)
+	if err != nil {
+		return OrderResponse{}, fmt.Errorf("cart service: checkout: failed to get cart promotions: %w", err)
+	}
+	priced, err := s.priceCart(cart, promos)
+	if err != nil {
+		return OrderResponse{}, fmt.Errorf("cart service: checkout: %w", err)
+	}
+	orderReq := CreatOrderRequest{
+		UserID:     cart.Userid,
+		Items:      make([]ItemQty, 0, len(priced.Lines)),
+		Promotions: priced.Promotions,
+	}
+	for _, line := range priced.Lines {
+		if line.UnitPrice <= 0 {
+			return OrderResponse{}, &common.RequestValidationError{Message: fmt.Sprintf("product %s is not available", line.ProductId)}
+		}
+		orderReq.Items = append(orderReq.Items, ItemQty{ID: line.ProductId, Quantity: int(line.Qty)})
+	}
+	checkout, err := s.startCheckout(cart, orderReq, req.IdempotencyKey)
+	if err != nil {
+		return OrderResponse{}, fmt.Errorf("cart service: checkout: %w", err)
+	}
+	order, err := s.orders.CreateOrder(orderReq, checkout.IdempotencyKey)
+	if err != nil {
+		return OrderResponse{}, fmt.Errorf("cart service: checkout: failed to create order: %w", err)
+	}
+	if err = s.repo.SetCheckoutOrder(checkout.ID, order.ID); err != nil {
+		return OrderResponse{}, fmt.Errorf("cart service: checkout: failed to save order id: %w", err)
+	}
+	return order, nil
+}
+
+// startCheckout возвращает незавершенное оформление корзины, чтобы повторный запрос
+// ушел в Order с тем же ключом идемпотентности, либо создает новое. Оформление переиспользуется,
+// только если состав заказа тот же и вызывающий не передал другой ключ. Иначе старое оформление
+// закрывается: его заказ остается ждать оплату в Order, а новый заказ уходит с новым ключом.
+// Лимиты промокодов проверяются под блокировкой акций, акции сохраняются в оформлении.
+func (s *Service) startCheckout(cart Cart, orderReq CreatOrderRequest, idempotencyKey string) (checkout Checkout, err error) {
+	hash := checkoutHash(orderReq)
+	tx, err := s.repo.BeginTransaction()
+	if err != nil {
+		return Checkout{}, fmt.Errorf("error starting transaction")
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			err = fmt.Errorf("panic start checkout: %v", p)
+			return
+		}
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				err = fmt.Errorf("rollback failed: original error: %w", err)
+			}
+			return
+		}
+		if commitErr := tx.Commit(); commitErr != nil {
+			err = fmt.Errorf("committing transaction failed: %w", commitErr)
+		}
+	}()
+	checkout, err = s.repo.GetPendingCheckout(tx, cart.Id)
+	switch {
+	case err == nil:
+		if checkout.ContentHash == hash && (idempotencyKey == "" || idempotencyKey == checkout.IdempotencyKey) {
+			return checkout, nil
+		}
+		if err = s.repo.UpdateCheckoutStatus(tx, checkout.ID, CheckoutSuperseded); err != nil {
+			return Checkout{}, fmt.Errorf("failed to close pending checkout: %w", err)
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return Checkout{}, fmt.Errorf("failed to get pending checkout: %w", err)
+	}
+	promotionIDs := make([]uuid.UUID, 0, len(orderReq.Promotions))
+	for _, p := range orderReq.Promotions {
+		promotionIDs = append(promotionIDs, p.PromotionID)
+	}
+	if err = s.checkPromotionLimits(tx, cart.Userid, promotionIDs); err != nil {
+		return Checkout{}, err
+	}
+	if idempotencyKey == "" {
+		idempotencyKey = uuid.NewString()
+	}
+	checkout = Checkout{
+		ID:             uuid.New(),
+		CartID:         cart.Id,
+		UserID:         cart.Userid,
+		IdempotencyKey: idempotencyKey,
+		ContentHash:    hash,
+		PromotionIDs:   promotionIDs,
+		Status:         CheckoutPending,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	if err = s.repo.CreateCheckout(tx, checkout); err != nil {
+		return Checkout{}, fmt.Errorf("failed to create checkout: %w", err)
+	}
+	return checkout, nil
+}
+
+// checkPromotionLimits блокирует акции и сверяет оплаченные использования пользователя с лимитом.
+// Блокировку держит и ConfirmCheckoutPayment, поэтому списание не проскочит между подсчетом и заказом.
+func (s *Service) checkPromotionLimits(tx *sqlx.Tx, userID uuid.UUID, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	promos, err := s.repo.GetPromotionsForUpdate(tx, ids)
+	if err != nil {
+		return fmt.Errorf("failed to lock promotions: %w", err)
+	}
+	for _, p := range promos {
+		if p.UsageLimit == 0 {
+			continue
+		}
+		used, err := s.repo.CountPromotionUsages(tx, p.ID, userID)
+		if err != nil {
+			return fmt.Errorf("failed to count usages: %w", err)
+		}
+		if used >= p.UsageLimit {
+			return &common.ConflictError{Message: fmt.Sprintf("promo code %s usage limit reached", p.Code)}
+		}
+	}
+	return nil
+}
+
+// checkoutHash хэш состава заказа: строки и акции без учета порядка
+func checkoutHash(req CreatOrderRequest) string {
+	lines := make([]string, 0, len(req.Items)+len(req.Promotions))
+	for _, it := range req.Items {
+		lines = append(lines, fmt.Sprintf("item:%s:%d", it.ID, it.Quantity))
+	}
+	for _, p := range req.Promotions {
+		lines = append(lines, "promotion:"+p.PromotionID.String())
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ConfirmCheckoutPayment вызывается, когда Order подтвердил оплату: корзина очищается,
+// промокоды, с которыми ушел заказ, списываются с лимита пользователя. Запрос подписан Order.
+func (s *Service) ConfirmCheckoutPayment(req ConfirmCheckoutPaymentRequest) (err error) {
+	if err = s.validator.Validate(req); err != nil {
+		return &common.RequestValidationError{Message: err.Error()}
+	}
+	if err = s.callbacks.Verify(req, time.Now()); err != nil {
+		return err
+	}
+	orderID := req.OrderID
+	tx, err := s.repo.BeginTransaction()
+	if err != nil {
+		return fmt.Errorf("cart service: confirm checkout payment: error starting transaction")
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			err = fmt.Errorf("cart service: confirm checkout payment: panic confirm payment: %v", p)
+			return
+		}
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				err = fmt.Errorf("rollback failed: original error: %w", err)
+			}
+			return
+		}
+		if commitErr := tx.Commit(); commitErr != nil {
+			err = fmt.Errorf("cart service: confirm checkout payment: committing transaction failed: %w", commitErr)
+		}
+	}()
+	checkout, err := s.repo.GetCheckoutByOrder(tx, orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &common.NotFoundError{Message: fmt.Sprintf("checkout for order %s not found", orderID)}
+	}
+	if err != nil {
+		return fmt.Errorf("cart service: confirm checkout payment: failed to get checkout: %w", err)
+	}
+	if checkout.Status == CheckoutPaid {
+		return nil
+	}
+	if len(checkout.PromotionIDs) > 0 {
+		if _, err = s.repo.GetPromotionsForUpdate(tx, checkout.PromotionIDs); err != nil {
+			return fmt.Errorf("cart service: confirm checkout payment: failed to lock promotions: %w", err)
+		}
+		if err = s.repo.AddPromotionUsages(tx, checkout.UserID, orderID, checkout.PromotionIDs); err != nil {
+			return fmt.Errorf("cart service: confirm checkout payment: failed to save promotion usages: %w", err)
+		}
+	}
+	// после закрытого оформления корзину меняли: в ней уже не то, что оплачено
+	if checkout.Status == CheckoutPending {
+		if err = s.repo.ClearCart(tx, checkout.CartID); err != nil {
+			return fmt.Errorf("cart service: confirm checkout payment: failed to clear cart: %w", err)
+		}
+	}
+	if err = s.repo.UpdateCheckoutStatus(tx, checkout.ID, CheckoutPaid); err != nil {
+		return fmt.Errorf("cart service: confirm checkout payment: failed to update checkout: %w", err)
+	}
+	return nil
+}
+
+// OptOutReminders отключает напоминания о брошенной корзине
+func (s *Service) OptOutReminders(req ReminderOptOutRequest) error {
+	if err := s.validator.Validate(req); err != nil {
+		return &common.RequestValidationError{Message: err.Error()}
+	}
+	if err := s.repo.AddReminderOptOut(req.UserID); err != nil {
+		return fmt.Errorf("cart service: opt out reminders: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) OptInReminders(userID uuid.UUID) error {
+	if userID == uuid.Nil {
+		return &common.RequestValidationError{Message: "invalid user"}
+	}
+	if err := s.repo.DeleteReminderOptOut(userID); err != nil {
+		return fmt.Errorf("cart service: opt in reminders: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) GetPurchaseRule(productID uuid.UUID) (PurchaseRule, error) {
+	if productID == uuid.Nil {
+		return PurchaseRule{}, &common.RequestValidationError{Message: "invalid product id"}
+	}
+	rules, err := s.repo.GetPurchaseRules([]uuid.UUID{productID})
+	if err != nil {
+		return PurchaseRule{}, fmt.Errorf("cart service: get purchase rule: %w", err)
+	}
+	if len(rules) == 0 {
+		return PurchaseRule{ProductID: productID}, nil
+	}
+	return rules[0], nil
+}
+
+func (s *Service) SetPurchaseRule(productID uuid.UUID, req PurchaseRuleRequest) (PurchaseRule, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return PurchaseRule{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	if productID == uuid.Nil {
+		return PurchaseRule{}, &common.RequestValidationError{Message: "invalid product id"}
+	}
+	if req.MaxQty > 0 && req.MinQty > req.MaxQty {
+		return PurchaseRule{}, &common.RequestValidationError{Message: "min_qty must not exceed max_qty"}
+	}
+	regions := make([]string, 0, len(req.AllowedRegions))
+	for _, region := range req.AllowedRegions {
+		regions = append(regions, strings.TrimSpace(region))
+	}
+	rule := PurchaseRule{
+		ProductID:      productID,
+		MaxQty:         req.MaxQty,
+		MinQty:         req.MinQty,
+		PackSize:       req.PackSize,
+		MinAge:         req.MinAge,
+		AllowedRegions: strings.Join(regions, ","),
+	}
+	if err := s.repo.UpsertPurchaseRule(rule); err != nil {
+		return PurchaseRule{}, fmt.Errorf("cart service: set purchase rule: %w", err)
+	}
+	return rule, nil
+}
+
+func (s *Service) checkPurchaseRules(userID uuid.UUID, qty map[uuid.UUID]int64) error {
+	return checkCartRules(s.repo, s.users, userID, qty, time.Now())
+}
+
+// priceCart запрашивает актуальные цены в Catalog и применяет промокоды корзины.
+func (s *Service) priceCart(cart Cart, promos []Promotion) (PricedCart, error) {
+	ids := make([]uuid.UUID, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		ids = append(ids, item.ProductId)
+	}
+	products := make(map[uuid.UUID]CatalogProduct, len(ids))
+	if len(ids) > 0 {
+		resp, err := s.catalog.GetProducts(CatalogRequest{ProductIds: ids})
+		if err != nil {
+			return PricedCart{}, fmt.Errorf("failed to get prices: %w", err)
+		}
+		for _, p := range resp.Products {
+			products[p.Id] = p
+		}
+	}
+	return PriceCart(cart, products, promos, time.Now()), nil
+}
@@ -0,0 +1,472 @@
+package internal
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/cart/internal/common"
+	"time"
+)
+
+type WishlistService struct {
+	repo      WishlistRepo
+	validator Validator
+	catalog   Catalog
+	notifier  Notifier
+	users     Users
+	inventory Inventory
+}
+
+type WishlistRepo interface {
+	BeginTransaction() (tx *sqlx.Tx, err error)
+	CreateWishlist(tx *sqlx.Tx, wishlist Wishlist) error
+	UpdateWishlist(wishlist Wishlist) error
+	DeleteWishlist(id uuid.UUID) error
+	GetWishlist(tx *sqlx.Tx, id uuid.UUID) (Wishlist, error)
+	GetWishlistByToken(token string) (Wishlist, error)
+	GetSavedForLater(tx *sqlx.Tx, userID uuid.UUID) (Wishlist, error)
+	GetUserWishlists(userID uuid.UUID) ([]Wishlist, error)
+	GetWishlistItems(wishlistIDs []uuid.UUID) ([]WishlistItem, error)
+	GetWishlistItem(tx *sqlx.Tx, wishlistID, productID uuid.UUID) (WishlistItem, error)
+	AddWishlistItem(tx *sqlx.Tx, item WishlistItem) error
+	DeleteWishlistItem(tx *sqlx.Tx, wishlistID, productID uuid.UUID) error
+	GetWishlistWatchers(productID uuid.UUID) ([]WishlistWatcher, error)
+	UpdateUserWishlistSnapshot(userID, productID uuid.UUID, price *int64, inStock *bool) error
+	GetOrCreateCart(tx *sqlx.Tx, userID uuid.UUID) (uuid.UUID, error)
+	GetCartItem(tx *sqlx.Tx, cartID, productID uuid.UUID) (Product, error)
+	UpsertCartItem(tx *sqlx.Tx, cartID, productID uuid.UUID, qty int64) (int64, error)
+	DeleteCartItem(tx *sqlx.Tx, cartID, productID uuid.UUID) error
+	GetPurchaseRules(productIDs []uuid.UUID) ([]PurchaseRule, error)
+}
+
+type Notifier interface {
+	Notify(req NotificationRequest) error
+}
+
+type Inventory interface {
+	GetItems(req InventoryItemsRequest) (InventoryItemsResponse, error)
+}
+
+func NewWishlistService(repo WishlistRepo, validator Validator, catalog Catalog, notifier Notifier, users Users,
+	inventory Inventory) *WishlistService {
+	return &WishlistService{
+		repo:      repo,
+		validator: validator,
+		catalog:   catalog,
+		notifier:  notifier,
+		users:     users,
+		inventory: inventory,
+	}
+}
+
+func (s *WishlistService) CreateWishlist(req CreateWishlistRequest) (wishlist Wishlist, err error) {
+	if err = s.validator.Validate(req); err != nil {
+		return Wishlist{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	wishlist = Wishlist{
+		ID:        uuid.New(),
+		UserID:    req.UserID,
+		Name:      req.Name,
+		Kind:      NamedWishlist,
+		Public:    req.Public,
+		CreatedAt: time.Now(),
+		Items:     []WishlistItem{},
+	}
+	if req.Public {
+		token, err := newShareToken()
+		if err != nil {
+			return Wishlist{}, fmt.Errorf("wishlist service: create wishlist: failed to generate share token: %w", err)
+		}
+		wishlist.ShareToken = &token
+	}
+	err = s.withTx("create wishlist", func(tx *sqlx.Tx) error {
+		return s.repo.CreateWishlist(tx, wishlist)
+	})
+	if err != nil {
+		return Wishlist{}, err
+	}
+	return wishlist, nil
+}
+
+func (s *WishlistService) UpdateWishlist(id uuid.UUID, req UpdateWishlistRequest) (Wishlist, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return Wishlist{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	var wishlist Wishlist
+	err := s.withTx("update wishlist", func(tx *sqlx.Tx) error {
+		var err error
+		wishlist, err = s.getOwnWishlist(tx, id, req.UserID)
+		if err != nil {
+			return err
+		}
+		if wishlist.Kind == SavedForLater {
+			return &common.RequestValidationError{Message: "saved for later list cannot be renamed or shared"}
+		}
+		return nil
+	})
+	if err != nil {
+		return Wishlist{}, err
+	}
+	wishlist.Name = req.Name
+	wishlist.Public = req.Public
+	if req.Public && wishlist.ShareToken == nil {
+		token, err := newShareToken()
+		if err != nil {
+			return Wishlist{}, fmt.Errorf("wishlist service: update wishlist: failed to generate share token: %w", err)
+		}
+		wishlist.ShareToken = &token
+	}
+	if !req.Public {
+		wishlist.ShareToken = nil
+	}
+	if err = s.repo.UpdateWishlist(wishlist); err != nil {
+		return Wishlist{}, fmt.Errorf("wishlist service: update wishlist: %w", err)
+	}
+	return wishlist, nil
+}
+
+func (s *WishlistService) DeleteWishlist(userID, id uuid.UUID) error {
+	err := s.withTx("delete wishlist", func(tx *sqlx.Tx) error {
+		_, err := s.getOwnWishlist(tx, id, userID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err = s.repo.DeleteWishlist(id); err != nil {
+		return fmt.Errorf("wishlist service: delete wishlist: %w", err)
+	}
+	return nil
+}
+
+func (s *WishlistService) GetWishlists(userID uuid.UUID) ([]Wishlist, error) {
+	if userID == uuid.Nil {
+		return nil, errors.New("wishlist service: invalid user")
+	}
+	wishlists, err := s.repo.GetUserWishlists(userID)
+	if err != nil {
+		return nil, fmt.Errorf("wishlist service: failed to get wishlists: %w", err)
+	}
+	if err = s.fillItems(wishlists); err != nil {
+		return nil, fmt.Errorf("wishlist service: failed to get wishlist items: %w", err)
+	}
+	return wishlists, nil
+}
+
+func (s *WishlistService) GetSharedWishlist(token string) (Wishlist, error) {
+	if token == "" {
+		return Wishlist{}, errors.New("wishlist service: invalid token")
+	}
+	wishlist, err := s.repo.GetWishlistByToken(token)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !wishlist.Public) {
+		return Wishlist{}, &common.NotFoundError{Message: "wishlist not found"}
+	}
+	if err != nil {
+		return Wishlist{}, fmt.Errorf("wishlist service: failed to get shared wishlist: %w", err)
+	}
+	wishlists := []Wishlist{wishlist}
+	if err = s.fillItems(wishlists); err != nil {
+		return Wishlist{}, fmt.Errorf("wishlist service: failed to get wishlist items: %w", err)
+	}
+	return wishlists[0], nil
+}
+
+func (s *WishlistService) AddWishlistItem(wishlistID uuid.UUID, req AddWishlistItemRequest) error {
+	if err := s.validator.Validate(req); err != nil {
+		return &common.RequestValidationError{Message: err.Error()}
+	}
+	price, err := s.currentPrice(req.ProductID)
+	if err != nil {
+		return fmt.Errorf("wishlist service: add item: %w", err)
+	}
+	inStock, err := s.inStock(req.ProductID)
+	if err != nil {
+		return fmt.Errorf("wishlist service: add item: %w", err)
+	}
+	return s.withTx("add item", func(tx *sqlx.Tx) error {
+		if _, err := s.getOwnWishlist(tx, wishlistID, req.UserID); err != nil {
+			return err
+		}
+		return s.repo.AddWishlistItem(tx, WishlistItem{
+			ID:         uuid.New(),
+			WishlistID: wishlistID,
+			ProductID:  req.ProductID,
+			Qty:        req.Qty,
+			LastPrice:  price,
+			InStock:    inStock,
+			CreatedAt:  time.Now(),
+		})
+	})
+}
+
+func (s *WishlistService) DeleteWishlistItem(userID, wishlistID, productID uuid.UUID) error {
+	return s.withTx("delete item", func(tx *sqlx.Tx) error {
+		if _, err := s.getOwnWishlist(tx, wishlistID, userID); err != nil {
+			return err
+		}
+		return s.repo.DeleteWishlistItem(tx, wishlistID, productID)
+	})
+}
+
+// SaveForLater переносит строку корзины в список "отложенные"
+func (s *WishlistService) SaveForLater(req MoveItemRequest) error {
+	if err := s.validator.Validate(req); err != nil {
+		return &common.RequestValidationError{Message: err.Error()}
+	}
+	price, err := s.currentPrice(req.ProductID)
+	if err != nil {
+		return fmt.Errorf("wishlist service: save for later: %w", err)
+	}
+	inStock, err := s.inStock(req.ProductID)
+	if err != nil {
+		return fmt.Errorf("wishlist service: save for later: %w", err)
+	}
+	return s.withTx("save for later", func(tx *sqlx.Tx) error {
+		saved, err := s.repo.GetSavedForLater(tx, req.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			saved = Wishlist{
+				ID:        uuid.New(),
+				UserID:    req.UserID,
+				Name:      "Saved for later",
+				Kind:      SavedForLater,
+				CreatedAt: time.Now(),
+			}
+			err = s.repo.CreateWishlist(tx, saved)
+		}
+		if err != nil {
+			return err
+		}
+		return s.moveFromCart(tx, req.UserID, req.ProductID, saved.ID, price, inStock)
+	})
+}
+
+// MoveToWishlist переносит строку корзины в именованный список желаний
+func (s *WishlistService) MoveToWishlist(req MoveItemRequest) error {
+	if err := s.validator.Validate(req); err != nil {
+		return &common.RequestValidationError{Message: err.Error()}
+	}
+	if req.WishlistID == uuid.Nil {
+		return &common.RequestValidationError{Message: "wishlist_id is required"}
+	}
+	price, err := s.currentPrice(req.ProductID)
+	if err != nil {
+		return fmt.Errorf("wishlist service: move to wishlist: %w", err)
+	}
+	inStock, err := s.inStock(req.ProductID)
+	if err != nil {
+		return fmt.Errorf("wishlist service: move to wishlist: %w", err)
+	}
+	return s.withTx("move to wishlist", func(tx *sqlx.Tx) error {
+		if _, err := s.getOwnWishlist(tx, req.WishlistID, req.UserID); err != nil {
+			return err
+		}
+		return s.moveFromCart(tx, req.UserID, req.ProductID, req.WishlistID, price, inStock)
+	})
+}
+
+// MoveToCart возвращает товар из списка (отложенные или список желаний) в корзину
+func (s *WishlistService) MoveToCart(req MoveItemRequest) error {
+	if err := s.validator.Validate(req); err != nil {
+		return &common.RequestValidationError{Message: err.Error()}
+	}
+	if req.WishlistID == uuid.Nil {
+		return &common.RequestValidationError{Message: "wishlist_id is required"}
+	}
+	return s.withTx("move to cart", func(tx *sqlx.Tx) error {
+		if _, err := s.getOwnWishlist(tx, req.WishlistID, req.UserID); err != nil {
+			return err
+		}
+		item, err := s.repo.GetWishlistItem(tx, req.WishlistID, req.ProductID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &common.NotFoundError{Message: fmt.Sprintf("product %s not found in wishlist", req.ProductID)}
+		}
+		if err != nil {
+			return err
+		}
+		cartID, err := s.repo.GetOrCreateCart(tx, req.UserID)
+		if err != nil {
+			return err
+		}
+		qty, err := s.repo.UpsertCartItem(tx, cartID, item.ProductID, item.Qty)
+		if err != nil {
+			return err
+		}
+		// правила проверяются по итоговому количеству в корзине, при нарушении транзакция откатывается
+		if err = checkCartRules(s.repo, s.users, req.UserID, map[uuid.UUID]int64{item.ProductID: qty}, time.Now()); err != nil {
+			return err
+		}
+		return s.repo.DeleteWishlistItem(tx, req.WishlistID, req.ProductID)
+	})
+}
+
+// HandleProductUpdate уведомляет владельцев списков о снижении цены или появлении товара в наличии
+func (s *WishlistService) HandleProductUpdate(req ProductUpdateRequest) error {
+	if err := s.validator.Validate(req); err != nil {
+		return &common.RequestValidationError{Message: err.Error()}
+	}
+	watchers, err := s.repo.GetWishlistWatchers(req.ProductID)
+	if err != nil {
+		return fmt.Errorf("wishlist service: product update: failed to get watchers: %w", err)
+	}
+	// снимок обновляется по каждому пользователю сразу после уведомления: при повторе события
+	// после сбоя уже уведомленные увидят актуальный снимок и не получат уведомление второй раз
+	handled := make(map[uuid.UUID]bool, len(watchers))
+	for _, w := range watchers {
+		if handled[w.UserID] {
+			continue
+		}
+		var text string
+		switch {
+		case req.Available != nil && *req.Available && !w.InStock:
+			text = fmt.Sprintf("Product %s from your wishlist is back in stock", req.ProductID)
+		case req.Price != nil && *req.Price < w.LastPrice:
+			text = fmt.Sprintf("Price of product %s from your wishlist dropped to %d", req.ProductID, *req.Price)
+		}
+		if text != "" {
+			err = s.notifier.Notify(NotificationRequest{
+				UserID:  w.UserID,
+				To:      w.UserID.String(),
+				Type:    "push",
+				Subject: "Wishlist update",
+				Text:    text,
+			})
+			if err != nil {
+				return fmt.Errorf("wishlist service: product update: failed to notify user %s: %w", w.UserID, err)
+			}
+		}
+		if err = s.repo.UpdateUserWishlistSnapshot(w.UserID, req.ProductID, req.Price, req.Available); err != nil {
+			return fmt.Errorf("wishlist service: product update: failed to update snapshot: %w", err)
+		}
+		handled[w.UserID] = true
+	}
+	return nil
+}
+
+func (s *WishlistService) moveFromCart(tx *sqlx.Tx, userID, productID, wishlistID uuid.UUID, price int64, inStock bool) error {
+	cartID, err := s.repo.GetOrCreateCart(tx, userID)
+	if err != nil {
+		return err
+	}
+	line, err := s.repo.GetCartItem(tx, cartID, productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &common.NotFoundError{Message: fmt.Sprintf("product %s not found in cart", productID)}
+	}
+	if err != nil {
+		return err
+	}
+	err = s.repo.AddWishlistItem(tx, WishlistItem{
+		ID:         uuid.New(),
+		WishlistID: wishlistID,
+		ProductID:  productID,
+		Qty:        line.Qty,
+		LastPrice:  price,
+		InStock:    inStock,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return s.repo.DeleteCartItem(tx, cartID, productID)
+}
+
+func (s *WishlistService) getOwnWishlist(tx *sqlx.Tx, id, userID uuid.UUID) (Wishlist, error) {
+	wishlist, err := s.repo.GetWishlist(tx, id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && wishlist.UserID != userID) {
+		return Wishlist{}, &common.NotFoundError{Message: fmt.Sprintf("wishlist %s not found", id)}
+	}
+	if err != nil {
+		return Wishlist{}, err
+	}
+	return wishlist, nil
+}
+
+func (s *WishlistService) currentPrice(productID uuid.UUID) (int64, error) {
+	resp, err := s.catalog.GetProducts(CatalogRequest{ProductIds: []uuid.UUID{productID}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get price: %w", err)
+	}
+	for _, p := range resp.Products {
+		if p.Id == productID {
+			return p.Price, nil
+		}
+	}
+	return 0, &common.NotFoundError{Message: fmt.Sprintf("product %s not found in catalog", productID)}
+}
+
+// inStock наличие товара по данным Inventory. Товара нет в Inventory - значит нет в наличии.
+func (s *WishlistService) inStock(productID uuid.UUID) (bool, error) {
+	resp, err := s.inventory.GetItems(InventoryItemsRequest{IDs: []uuid.UUID{productID}})
+	if err != nil {
+		return false, fmt.Errorf("failed to get stock: %w", err)
+	}
+	for _, item := range resp.Items {
+		if item.ID == productID {
+			return item.Available > 0, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *WishlistService) fillItems(wishlists []Wishlist) error {
+	if len(wishlists) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(wishlists))
+	for _, w := range wishlists {
+		ids = append(ids, w.ID)
+	}
+	items, err := s.repo.GetWishlistItems(ids)
+	if err != nil {
+		return err
+	}
+	byList := make(map[uuid.UUID][]WishlistItem, len(wishlists))
+	for _, item := range items {
+		byList[item.WishlistID] = append(byList[item.WishlistID], item)
+	}
+	for i := range wishlists {
+		wishlists[i].Items = byList[wishlists[i].ID]
+		if wishlists[i].Items == nil {
+			wishlists[i].Items = []WishlistItem{}
+		}
+	}
+	return nil
+}
+
+func (s *WishlistService) withTx(op string, fn func(tx *sqlx.Tx) error) (err error) {
+	tx, err := s.repo.BeginTransaction()
+	if err != nil {
+		return fmt.Errorf("wishlist service: %s: error starting transaction", op)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			err = fmt.Errorf("wishlist service: %s: panic: %v", op, p)
+			return
+		}
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				err = fmt.Errorf("rollback failed: original error: %w", err)
+			}
+			return
+		}
+		if commitErr := tx.Commit(); commitErr != nil {
+			err = fmt.Errorf("wishlist service: %s: committing transaction failed: %w", op, commitErr)
+		}
+	}()
+	if err = fn(tx); err != nil {
+		return fmt.Errorf("wishlist service: %s: %w", op, err)
+	}
+	return nil
+}
+
+func newShareToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
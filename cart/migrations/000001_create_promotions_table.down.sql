@@ -0,0 +1,3 @@
+DROP TABLE promotion_usages;
+DROP TABLE cart_promotions;
+DROP TABLE promotions;
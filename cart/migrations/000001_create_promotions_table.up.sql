@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS promotions
+(
+    id          UUID PRIMARY KEY,
+    code        VARCHAR(32) UNIQUE NOT NULL,
+    type        VARCHAR(20)        NOT NULL,
+    value       BIGINT             NOT NULL DEFAULT 0,
+    product_id  UUID,
+    buy_qty     BIGINT             NOT NULL DEFAULT 0,
+    get_qty     BIGINT             NOT NULL DEFAULT 0,
+    min_basket  BIGINT             NOT NULL DEFAULT 0,
+    stackable   BOOLEAN            NOT NULL DEFAULT FALSE,
+    usage_limit BIGINT             NOT NULL DEFAULT 0,
+    starts_at   TIMESTAMP          NOT NULL DEFAULT NOW(),
+    expires_at  TIMESTAMP,
+    created_at  TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS cart_promotions
+(
+    cart_id      UUID NOT NULL,
+    promotion_id UUID NOT NULL,
+    created_at   TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (cart_id, promotion_id),
+    FOREIGN KEY (promotion_id) REFERENCES promotions (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS promotion_usages
+(
+    promotion_id UUID NOT NULL,
+    user_id      UUID NOT NULL,
+    order_id     UUID NOT NULL,
+    created_at   TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (promotion_id, order_id),
+    FOREIGN KEY (promotion_id) REFERENCES promotions (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_promotion_usages_user ON promotion_usages (promotion_id, user_id);
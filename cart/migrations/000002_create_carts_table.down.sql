@@ -0,0 +1,3 @@
+DROP TABLE checkouts;
+DROP TABLE cart_items;
+DROP TABLE carts;
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS carts
+(
+    id         UUID PRIMARY KEY,
+    user_id    UUID UNIQUE NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS cart_items
+(
+    id         UUID PRIMARY KEY,
+    cart_id    UUID   NOT NULL,
+    product_id UUID   NOT NULL,
+    qty        BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (cart_id, product_id),
+    FOREIGN KEY (cart_id) REFERENCES carts (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS checkouts
+(
+    id              UUID PRIMARY KEY,
+    cart_id         UUID         NOT NULL,
+    user_id         UUID         NOT NULL,
+    order_id        UUID UNIQUE,
+    idempotency_key VARCHAR(100) NOT NULL,
+    status          VARCHAR(20)  NOT NULL,
+    created_at      TIMESTAMP DEFAULT NOW(),
+    updated_at      TIMESTAMP DEFAULT NOW(),
+    FOREIGN KEY (cart_id) REFERENCES carts (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_checkouts_pending ON checkouts (cart_id) WHERE status = 'pending';
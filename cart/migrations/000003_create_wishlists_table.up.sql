@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS wishlists
+(
+    id          UUID PRIMARY KEY,
+    user_id     UUID         NOT NULL,
+    name        VARCHAR(100) NOT NULL,
+    kind        VARCHAR(20)  NOT NULL,
+    public      BOOLEAN      NOT NULL DEFAULT FALSE,
+    share_token VARCHAR(64) UNIQUE,
+    created_at  TIMESTAMP DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_wishlists_saved_for_later ON wishlists (user_id) WHERE kind = 'saved_for_later';
+
+CREATE TABLE IF NOT EXISTS wishlist_items
+(
+    id          UUID PRIMARY KEY,
+    wishlist_id UUID    NOT NULL,
+    product_id  UUID    NOT NULL,
+    qty         BIGINT  NOT NULL DEFAULT 1,
+    last_price  BIGINT  NOT NULL DEFAULT 0,
+    in_stock    BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at  TIMESTAMP DEFAULT NOW(),
+    UNIQUE (wishlist_id, product_id),
+    FOREIGN KEY (wishlist_id) REFERENCES wishlists (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_wishlist_items_product ON wishlist_items (product_id);
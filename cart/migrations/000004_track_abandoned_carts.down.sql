@@ -0,0 +1,5 @@
+DROP TRIGGER cart_items_touch_cart ON cart_items;
+DROP FUNCTION touch_cart();
+DROP TABLE cart_reminder_opt_outs;
+DROP INDEX idx_carts_updated_at;
+ALTER TABLE carts DROP COLUMN abandoned_at;
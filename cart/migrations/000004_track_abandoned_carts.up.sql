@@ -0,0 +1,24 @@
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS abandoned_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_carts_updated_at ON carts (updated_at);
+
+CREATE TABLE IF NOT EXISTS cart_reminder_opt_outs
+(
+    user_id    UUID PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- любое изменение строк корзины обновляет время последнего изменения корзины
+CREATE OR REPLACE FUNCTION touch_cart() RETURNS TRIGGER AS
+$$
+BEGIN
+    UPDATE carts SET updated_at = NOW() WHERE id = COALESCE(NEW.cart_id, OLD.cart_id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER cart_items_touch_cart
+    AFTER INSERT OR UPDATE OR DELETE
+    ON cart_items
+    FOR EACH ROW
+EXECUTE FUNCTION touch_cart();
@@ -0,0 +1 @@
+DROP TABLE purchase_rules;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS purchase_rules
+(
+    product_id      UUID PRIMARY KEY,
+    max_qty         BIGINT       NOT NULL DEFAULT 0,
+    min_qty         BIGINT       NOT NULL DEFAULT 0,
+    pack_size       BIGINT       NOT NULL DEFAULT 0,
+    min_age         BIGINT       NOT NULL DEFAULT 0,
+    allowed_regions VARCHAR(255) NOT NULL DEFAULT '',
+    created_at      TIMESTAMP DEFAULT NOW(),
+    updated_at      TIMESTAMP DEFAULT NOW()
+);
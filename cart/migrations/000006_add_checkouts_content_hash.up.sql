@@ -0,0 +1,2 @@
+-- хэш состава заказа: незавершенное оформление переиспользуется, только пока корзина не менялась
+ALTER TABLE checkouts ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64) NOT NULL DEFAULT '';
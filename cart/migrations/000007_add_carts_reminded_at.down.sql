@@ -0,0 +1 @@
+ALTER TABLE carts DROP COLUMN IF EXISTS reminded_at;
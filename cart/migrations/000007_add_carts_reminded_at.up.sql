@@ -0,0 +1,2 @@
+-- время отправки напоминания: сбой публикации события не должен приводить к повторному письму
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS reminded_at TIMESTAMP;
@@ -0,0 +1 @@
+ALTER TABLE checkouts DROP COLUMN IF EXISTS promotion_ids;
@@ -0,0 +1,2 @@
+-- акции, с которыми ушел заказ: после оплаты списываются именно они, а не то, что сейчас в корзине
+ALTER TABLE checkouts ADD COLUMN IF NOT EXISTS promotion_ids UUID[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type CartClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewCartClient(baseURL string) *CartClient {
+	return &CartClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// ProductUpdated сообщает Cart о новой цене товара, чтобы уведомить владельцев списков желаний
+func (c *CartClient) ProductUpdated(update ProductUpdate) error {
+	if err := postJSON(c.client, c.baseURL+"/api/v1/carts/wishlists/product-updates", update); err != nil {
+		return fmt.Errorf("cart client: product updated %s: %w", update.ProductID, err)
+	}
+	return nil
+}
+
+func postJSON(client *http.Client, url string, body any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"fmt"
 	"github.com/kelseyhightower/envconfig"
 	"os"
 	"strings"
@@ -9,6 +10,7 @@ import (
 type Config struct {
 	DB             DBConfig
 	Server         ServerConfig
+	Services       ServicesConfig
 	LogLevel       string
 	LogDevelopMode bool
 	AllowedOrigins []string
@@ -22,6 +24,10 @@ type DBConfig struct {
 	Database string `envconfig:"DATABASE" required:"true"`
 }
 
+type ServicesConfig struct {
+	CartURL string `envconfig:"CART_URL" required:"true"`
+}
+
 type ServerConfig struct {
 	Address string `envconfig:"ADDRESS" required:"true"`
 	Port    string `envconfig:"PORT" required:"true"`
@@ -43,6 +49,11 @@ func Load() (Config, error) {
 	} else {
 		cfg.Server = server
 	}
+	if services, err := LoadServicesConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Services = services
+	}
 	return cfg, nil
 }
 
@@ -63,3 +74,19 @@ func LoadServerConfig() (ServerConfig, error) {
 	}
 	return cfg, nil
 }
+
+func LoadServicesConfig() (ServicesConfig, error) {
+	var cfg ServicesConfig
+	err := envconfig.Process("SERVICES", &cfg)
+	if err != nil {
+		return ServicesConfig{}, err
+	}
+	return cfg, nil
+}
+
+func (db DBConfig) DSN() string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		db.Server, db.Port, db.User, db.Pass, db.Database,
+	)
+}
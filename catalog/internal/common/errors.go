@@ -23,3 +23,12 @@ type NotFoundError struct {
 func (err *NotFoundError) Error() string {
 	return err.Message
 }
+
+// PreconditionFailedError версия из If-Match не совпала с текущей
+type PreconditionFailedError struct {
+	Message string
+}
+
+func (err *PreconditionFailedError) Error() string {
+	return err.Message
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/madrabit/mini-market/catalog/internal/common"
@@ -30,7 +31,7 @@ func NewController(svc Svc, logger common.Logger) *Controller {
 type Svc interface {
 	GetCatalog(limit int64, cursorID uuid.UUID) (GetCatalogResponse, error)
 	AddProduct(item AddItemRequest) error
-	UpdateProduct(item UpdateItemRequest) error
+	UpdateProduct(item UpdateItemRequest) (Item, error)
 	DeleteProduct(id uuid.UUID) error
 	GetProductById(id uuid.UUID) (Item, error)
 }
@@ -38,12 +39,12 @@ type Svc interface {
 func (c *Controller) Routes() chi.Router {
 	r := chi.NewRouter()
 	//Вернуть весь каталог
-	r.Get("", c.GetCatalog)
+	r.Get("/", c.GetCatalog)
 	//Вернуть товар по id
 	r.Get("/{productID}", c.GetProductById)
 	//добавить в товар каталог
-	r.Post("", c.AddProduct)
-	//обновить товар в каталоге
+	r.Post("/", c.AddProduct)
+	//обновить товар в каталоге, нужен If-Match с ETag из GET
 	r.Patch("/{productID}", c.UpdateProduct)
 	// удалить товар из каталога
 	r.Delete("/{productID}", c.DeleteProduct)
@@ -99,21 +100,37 @@ func (c *Controller) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 			c.logger.Error("failed to close body", zap.Error(err))
 		}
 	}()
+	id, err := uuid.Parse(chi.URLParam(r, "productID"))
+	if err != nil || id == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
 	var item UpdateItemRequest
-	err := json.NewDecoder(r.Body).Decode(&item)
+	err = json.NewDecoder(r.Body).Decode(&item)
 	if err != nil {
 		c.logger.Error("failed update product", zap.Error(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	err = c.svc.UpdateProduct(item)
+	version, hasIfMatch, err := common.ParseIfMatch(r)
 	if err != nil {
-		c.logger.Error("failed to update product", zap.Error(err))
 		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	if !hasIfMatch {
+		common.ErrResponse(w, http.StatusPreconditionRequired, "If-Match header is required")
+		return
+	}
+	item.Id, item.Version = id, version
+	product, err := c.svc.UpdateProduct(item)
+	if err != nil {
+		c.logger.Error("failed to update product", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("ETag", common.ETag(product.Version))
+	common.OkResponse(w, product)
 }
 func (c *Controller) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	productID := r.URL.Query().Get("productID")
@@ -134,8 +151,7 @@ func (c *Controller) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *Controller) GetProductById(w http.ResponseWriter, r *http.Request) {
-	req := r.URL.Query().Get("productID")
-	id, err := uuid.Parse(req)
+	id, err := uuid.Parse(chi.URLParam(r, "productID"))
 	if err != nil || id == uuid.Nil {
 		c.logger.Warn("invalid param")
 		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
@@ -144,8 +160,28 @@ func (c *Controller) GetProductById(w http.ResponseWriter, r *http.Request) {
 	product, err := c.svc.GetProductById(id)
 	if err != nil {
 		c.logger.Error("failed to get product by ID", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
 		return
 	}
+	w.Header().Set("ETag", common.ETag(product.Version))
 	common.OkResponse(w, product)
 }
+
+func errStatus(err error) int {
+	var validationErr *common.RequestValidationError
+	var notFoundErr *common.NotFoundError
+	var existsErr *common.AlreadyExistsError
+	var preconditionErr *common.PreconditionFailedError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.As(err, &notFoundErr):
+		return http.StatusNotFound
+	case errors.As(err, &existsErr):
+		return http.StatusConflict
+	case errors.As(err, &preconditionErr):
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
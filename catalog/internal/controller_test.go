@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"database/sql"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/catalog/internal/common"
+	"github.com/madrabit/mini-market/catalog/internal/validator"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// catalogRepo хранит товары в памяти
+type catalogRepo struct {
+	Repo
+	items map[uuid.UUID]Item
+}
+
+func (r *catalogRepo) BeginTransaction() (*sqlx.Tx, error) { return beginNoopTx() }
+
+func (r *catalogRepo) GetProductById(id uuid.UUID) (Item, error) {
+	item, ok := r.items[id]
+	if !ok {
+		return Item{}, sql.ErrNoRows
+	}
+	return item, nil
+}
+
+func (r *catalogRepo) GetProductForUpdate(_ *sqlx.Tx, id uuid.UUID) (Item, error) {
+	return r.GetProductById(id)
+}
+
+func (r *catalogRepo) UpdateProduct(_ *sqlx.Tx, item UpdateItemRequest) error {
+	current := r.items[item.Id]
+	r.items[item.Id] = Item{Id: item.Id, Name: item.Name, UnitPrice: item.Price, Version: current.Version + 1}
+	return nil
+}
+
+// priceUpdates запоминает изменения цен, отправленные в Cart
+type priceUpdates []ProductUpdate
+
+func (u *priceUpdates) ProductUpdated(update ProductUpdate) error {
+	*u = append(*u, update)
+	return nil
+}
+
+func newCatalogRouter(repo Repo, cart Cart) chi.Router {
+	logger := common.Logger{Logger: zap.NewNop()}
+	router := chi.NewRouter()
+	router.Mount("/catalog", NewController(NewService(repo, validator.New(), cart, &logger), logger).Routes())
+	return router
+}
+
+func TestUpdateProductIfMatch(t *testing.T) {
+	productID := uuid.New()
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+		etag    string
+		price   int64
+	}{
+		{name: "missing If-Match", status: http.StatusPreconditionRequired, price: 100},
+		{name: "malformed If-Match", ifMatch: `"abc"`, status: http.StatusBadRequest, price: 100},
+		{name: "stale version", ifMatch: `"2"`, status: http.StatusPreconditionFailed, price: 100},
+		{name: "current version", ifMatch: `"3"`, status: http.StatusOK, etag: `"4"`, price: 150},
+		{name: "weak current version", ifMatch: `W/"3"`, status: http.StatusOK, etag: `"4"`, price: 150},
+		{name: "wildcard", ifMatch: "*", status: http.StatusOK, etag: `"4"`, price: 150},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &catalogRepo{items: map[uuid.UUID]Item{
+				productID: {Id: productID, Name: "tea", UnitPrice: 100, Version: 3},
+			}}
+			updates := &priceUpdates{}
+			router := newCatalogRouter(repo, updates)
+
+			req := httptest.NewRequest(http.MethodPatch, "/catalog/"+productID.String(),
+				strings.NewReader(`{"Name":"tea","Price":150}`))
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if etag := rec.Header().Get("ETag"); etag != tt.etag {
+				t.Errorf("ETag = %q, want %q", etag, tt.etag)
+			}
+			if price := repo.items[productID].UnitPrice; price != tt.price {
+				t.Errorf("price = %d, want %d", price, tt.price)
+			}
+			// Cart узнает только о примененном изменении цены
+			if changed := tt.price != 100; changed != (len(*updates) == 1) {
+				t.Errorf("price updates = %+v, want one only when the price changed", *updates)
+			}
+		})
+	}
+}
+
+func TestGetProductByIdETag(t *testing.T) {
+	productID := uuid.New()
+	repo := &catalogRepo{items: map[uuid.UUID]Item{productID: {Id: productID, Name: "tea", Version: 7}}}
+	router := newCatalogRouter(repo, &priceUpdates{})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/catalog/"+productID.String(), nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"7"` {
+		t.Fatalf("status %d, ETag %q; want 200, \"7\"", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/catalog/"+uuid.NewString(), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown product status = %d, want 404", rec.Code)
+	}
+}
@@ -2,10 +2,15 @@ package internal
 
 import "github.com/google/uuid"
 
+// DefaultTaxClass налоговая категория товара, если при добавлении она не указана
+const DefaultTaxClass = "standard"
+
 type Item struct {
-	Id        uuid.UUID
-	Name      string
-	UnitPrice int64
+	Id        uuid.UUID `db:"id"`
+	Name      string    `db:"name"`
+	UnitPrice int64     `db:"unit_price"`
+	TaxClass  string    `db:"tax_class"` // standard, reduced, zero - ставку по категории выбирает Order
+	Version   int64     `db:"version"`   // растет при каждом изменении, отдается как ETag
 }
 
 type GetCatalogRequest struct {
@@ -20,15 +25,24 @@ type GetCatalogResponse struct {
 }
 
 type AddItemRequest struct {
-	ItemID uuid.UUID
-	Name   string
-	Price  int64
+	ItemID   uuid.UUID
+	Name     string
+	Price    int64
+	TaxClass string
 }
 
 type UpdateItemRequest struct {
-	Id    uuid.UUID
-	Name  string
-	Price int64
+	Id       uuid.UUID
+	Name     string `validate:"required,max=255"`
+	Price    int64  `validate:"gte=0"`
+	TaxClass string `validate:"max=30"` // пустая - категория не меняется
+	Version  int64  `json:"-"`          // из If-Match
+}
+
+// ProductUpdate изменение цены товара для списков желаний в Cart
+type ProductUpdate struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Price     int64     `json:"price"`
 }
 
 type RemoveItemRequest struct {
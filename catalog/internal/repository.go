@@ -1 +1,88 @@
 package internal
+
+import (
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+func (r *Repository) FindItemById(tx *sqlx.Tx, productID uuid.UUID) (bool, error) {
+	var exists bool
+	err := tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM catalog_items WHERE id = $1)`, productID)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (r *Repository) GetCatalog(limit int64, cursorID uuid.UUID) (GetCatalogResponse, error) {
+	var items []Item
+	err := r.db.Select(&items, `SELECT id, name, unit_price, tax_class, version FROM catalog_items
+		WHERE id > $1 ORDER BY id LIMIT $2`, cursorID, limit+1)
+	if err != nil {
+		return GetCatalogResponse{}, err
+	}
+	resp := GetCatalogResponse{Items: items}
+	if int64(len(items)) > limit {
+		resp.Items, resp.HasMore = items[:limit], true
+	}
+	if len(resp.Items) > 0 {
+		resp.NextCursorID = resp.Items[len(resp.Items)-1].Id
+	}
+	return resp, nil
+}
+
+func (r *Repository) AddProduct(tx *sqlx.Tx, item AddItemRequest) error {
+	_, err := tx.Exec(`INSERT INTO catalog_items (id, name, unit_price, tax_class) VALUES ($1, $2, $3, $4)`,
+		item.ItemID, item.Name, item.Price, item.TaxClass)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) UpdateProduct(tx *sqlx.Tx, item UpdateItemRequest) error {
+	_, err := tx.Exec(`UPDATE catalog_items SET name = $1, unit_price = $2, tax_class = $3, version = version + 1,
+		updated_at = NOW() WHERE id = $4`, item.Name, item.Price, item.TaxClass, item.Id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) DeleteProduct(id uuid.UUID) error {
+	_, err := r.db.Exec(`DELETE FROM catalog_items WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetProductById(id uuid.UUID) (Item, error) {
+	var item Item
+	err := r.db.Get(&item, `SELECT id, name, unit_price, tax_class, version FROM catalog_items WHERE id = $1`, id)
+	if err != nil {
+		return Item{}, err
+	}
+	return item, nil
+}
+
+func (r *Repository) GetProductForUpdate(tx *sqlx.Tx, id uuid.UUID) (Item, error) {
+	var item Item
+	err := tx.Get(&item, `SELECT id, name, unit_price, tax_class, version FROM catalog_items WHERE id = $1 FOR UPDATE`, id)
+	if err != nil {
+		return Item{}, err
+	}
+	return item, nil
+}
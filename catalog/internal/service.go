@@ -7,11 +7,14 @@ import (
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/madrabit/mini-market/catalog/internal/common"
+	"go.uber.org/zap"
 )
 
 type Service struct {
 	repo      Repo
 	validator Validator
+	cart      Cart
+	logger    *common.Logger
 }
 
 type Repo interface {
@@ -20,6 +23,7 @@ type Repo interface {
 	GetCatalog(limit int64, cursorID uuid.UUID) (GetCatalogResponse, error)
 	AddProduct(tx *sqlx.Tx, item AddItemRequest) error
 	UpdateProduct(tx *sqlx.Tx, item UpdateItemRequest) error
+	GetProductForUpdate(tx *sqlx.Tx, id uuid.UUID) (Item, error)
 	DeleteProduct(id uuid.UUID) error
 	GetProductById(id uuid.UUID) (Item, error)
 }
@@ -28,8 +32,12 @@ type Validator interface {
 	Validate(request any) error
 }
 
-func NewService(repo Repo, validator Validator) *Service {
-	return &Service{repo, validator}
+type Cart interface {
+	ProductUpdated(update ProductUpdate) error
+}
+
+func NewService(repo Repo, validator Validator, cart Cart, logger *common.Logger) *Service {
+	return &Service{repo, validator, cart, logger}
 }
 
 func (s *Service) AddProduct(item AddItemRequest) (err error) {
@@ -63,6 +71,9 @@ func (s *Service) AddProduct(item AddItemRequest) (err error) {
 	if isExists {
 		return &common.AlreadyExistsError{Message: fmt.Sprintf("product with id %s already exists", item.ItemID)}
 	}
+	if item.TaxClass == "" {
+		item.TaxClass = DefaultTaxClass
+	}
 	err = s.repo.AddProduct(tx, item)
 	if err != nil {
 		return fmt.Errorf("catalog service: add product: error adding product")
@@ -75,19 +86,40 @@ func (s *Service) GetProductById(productId uuid.UUID) (Item, error) {
 		return Item{}, errors.New("catalog service: invalid product")
 	}
 	product, err := s.repo.GetProductById(productId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Item{}, &common.NotFoundError{Message: fmt.Sprintf("product with id %s not found", productId)}
+	}
 	if err != nil {
 		return Item{}, fmt.Errorf("catalog service: failed to get product by id: %w", err)
 	}
 	return product, nil
 }
 
-func (s *Service) UpdateProduct(item UpdateItemRequest) error {
-	if err := s.validator.Validate(item); err != nil {
-		return &common.RequestValidationError{Message: err.Error()}
+// UpdateProduct обновляет товар, только если версия из If-Match совпадает с текущей.
+// Об изменении цены после коммита сообщает Cart для уведомлений по спискам желаний.
+func (s *Service) UpdateProduct(item UpdateItemRequest) (Item, error) {
+	updated, oldPrice, err := s.updateProduct(item)
+	if err != nil {
+		return Item{}, err
+	}
+	if updated.UnitPrice != oldPrice {
+		// товар уже обновлен, недоступность Cart не должна превращать ответ в ошибку
+		err = s.cart.ProductUpdated(ProductUpdate{ProductID: updated.Id, Price: updated.UnitPrice})
+		if err != nil {
+			s.logger.Warn("failed to send price update to cart", zap.Error(err),
+				zap.String("product_id", updated.Id.String()))
+		}
+	}
+	return updated, nil
+}
+
+func (s *Service) updateProduct(item UpdateItemRequest) (updated Item, oldPrice int64, err error) {
+	if err = s.validator.Validate(item); err != nil {
+		return Item{}, 0, &common.RequestValidationError{Message: err.Error()}
 	}
 	tx, err := s.repo.BeginTransaction()
 	if err != nil {
-		return fmt.Errorf("catalog service: update product: error starting transaction")
+		return Item{}, 0, fmt.Errorf("catalog service: update product: error starting transaction")
 	}
 	defer func() {
 		if p := recover(); p != nil {
@@ -105,18 +137,26 @@ func (s *Service) UpdateProduct(item UpdateItemRequest) error {
 			err = fmt.Errorf("catalog service: update product: committing transaction failed: %w", commitErr)
 		}
 	}()
-	isExists, err := s.repo.FindItemById(tx, item.Id)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return fmt.Errorf("catalog service: update product: error checking exists of product")
+	current, err := s.repo.GetProductForUpdate(tx, item.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Item{}, 0, &common.NotFoundError{Message: fmt.Sprintf("product with id %s not found", item.Id)}
 	}
-	if isExists {
-		return &common.AlreadyExistsError{Message: fmt.Sprintf("product with id %s already exists", item.Id)}
+	if err != nil {
+		return Item{}, 0, fmt.Errorf("catalog service: update product: error checking exists of product")
+	}
+	if item.Version != 0 && current.Version != item.Version {
+		return Item{}, 0, &common.PreconditionFailedError{Message: fmt.Sprintf("product %s was modified: version %d, expected %d",
+			item.Id, current.Version, item.Version)}
+	}
+	if item.TaxClass == "" {
+		item.TaxClass = current.TaxClass
 	}
 	err = s.repo.UpdateProduct(tx, item)
 	if err != nil {
-		return fmt.Errorf("catalog service: update product: error update product")
+		return Item{}, 0, fmt.Errorf("catalog service: update product: error update product")
 	}
-	return nil
+	return Item{Id: item.Id, Name: item.Name, UnitPrice: item.Price, TaxClass: item.TaxClass, Version: current.Version + 1},
+		current.UnitPrice, nil
 }
 
 func (s *Service) DeleteProduct(id uuid.UUID) error {
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS catalog_items
+(
+    id         UUID PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL,
+    unit_price BIGINT       NOT NULL CHECK (unit_price >= 0),
+    version    BIGINT       NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
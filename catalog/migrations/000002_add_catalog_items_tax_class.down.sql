@@ -0,0 +1 @@
+ALTER TABLE catalog_items DROP COLUMN IF EXISTS tax_class;
@@ -0,0 +1,2 @@
+-- налоговая категория товара, по ней Order подбирает ставку налога
+ALTER TABLE catalog_items ADD COLUMN IF NOT EXISTS tax_class VARCHAR(30) NOT NULL DEFAULT 'standard';
@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/inventory/internal/common"
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AvailabilityRepo interface {
+	GetProductsByIds(IDs []uuid.UUID) (ListItemsResponse, error)
+}
+
+// AvailabilityHub раздает изменения остатка подписчикам потока.
+// Хаб живет в памяти одного экземпляра сервиса и видит только изменения, прошедшие через него.
+// Номера событий начинаются заново после рестарта, поэтому id события в потоке - "эпоха-номер":
+// Last-Event-ID из прошлого запуска или с другого экземпляра не совпадет по эпохе, и клиент получит снимок.
+type AvailabilityHub struct {
+	repo    AvailabilityRepo
+	logger  *common.Logger
+	epoch   string
+	mu      sync.Mutex
+	seq     uint64
+	history []AvailabilityEvent // кольцевой буфер последних событий
+	next    int
+	subs    map[*availabilitySub]struct{}
+}
+
+// availabilitySub подписка одного клиента. Медленный клиент не тормозит остальных:
+// события по товару схлопываются до последнего, клиент получает актуальное состояние, когда успеет.
+type availabilitySub struct {
+	products map[uuid.UUID]struct{}
+	mu       sync.Mutex
+	pending  map[uuid.UUID]AvailabilityEvent
+	ready    chan struct{}
+}
+
+func NewAvailabilityHub(repo AvailabilityRepo, logger *common.Logger, cfg common.StreamConfig) *AvailabilityHub {
+	return &AvailabilityHub{
+		repo:    repo,
+		logger:  logger,
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		history: make([]AvailabilityEvent, 0, cfg.History),
+		subs:    make(map[*availabilitySub]struct{}),
+	}
+}
+
+// Check читает актуальный остаток по товарам и рассылает его подписчикам
+func (h *AvailabilityHub) Check(productIDs []uuid.UUID) {
+	items, err := h.repo.GetProductsByIds(productIDs)
+	if err != nil {
+		h.logger.Error("failed to get availability for stream", zap.Error(err))
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, item := range items.Items {
+		h.seq++
+		event := AvailabilityEvent{
+			ID:        h.seq,
+			ProductID: item.ID,
+			Qty:       item.Qty,
+			Reserved:  item.Reserved,
+			Available: item.Available,
+		}
+		h.remember(event)
+		for sub := range h.subs {
+			sub.push(event)
+		}
+	}
+}
+
+// EventID id события для потока
+func (h *AvailabilityHub) EventID(seq uint64) string {
+	return fmt.Sprintf("%s-%d", h.epoch, seq)
+}
+
+// parseEventID номер события из id потока. Id другой эпохи или в неверном формате дает 0.
+func (h *AvailabilityHub) parseEventID(id string) uint64 {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != h.epoch {
+		return 0
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Subscribe регистрирует клиента. Если lastEventID этого запуска и еще в истории, возвращает пропущенные события,
+// иначе replayed=false и клиенту нужен полный снимок.
+func (h *AvailabilityHub) Subscribe(productIDs []uuid.UUID, lastEventID string) (sub *availabilitySub, missed []AvailabilityEvent, replayed bool) {
+	sub = &availabilitySub{
+		products: make(map[uuid.UUID]struct{}, len(productIDs)),
+		pending:  make(map[uuid.UUID]AvailabilityEvent),
+		ready:    make(chan struct{}, 1),
+	}
+	for _, id := range productIDs {
+		sub.products[id] = struct{}{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = struct{}{}
+	lastSeq := h.parseEventID(lastEventID)
+	if lastSeq == 0 {
+		return sub, nil, false
+	}
+	events := h.ordered()
+	if lastSeq > h.seq || len(events) == 0 || events[0].ID > lastSeq+1 {
+		return sub, nil, false
+	}
+	for _, e := range events {
+		if _, ok := sub.products[e.ProductID]; ok && e.ID > lastSeq {
+			missed = append(missed, e)
+		}
+	}
+	return sub, missed, true
+}
+
+func (h *AvailabilityHub) Unsubscribe(sub *availabilitySub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+// Snapshot текущий остаток по товарам подписки и номер последнего события на момент снимка
+func (h *AvailabilityHub) Snapshot(productIDs []uuid.UUID) ([]AvailabilityEvent, uint64, error) {
+	h.mu.Lock()
+	seq := h.seq
+	h.mu.Unlock()
+	items, err := h.repo.GetProductsByIds(productIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	snapshot := make([]AvailabilityEvent, 0, len(items.Items))
+	for _, item := range items.Items {
+		snapshot = append(snapshot, AvailabilityEvent{
+			ID:        seq,
+			ProductID: item.ID,
+			Qty:       item.Qty,
+			Reserved:  item.Reserved,
+			Available: item.Available,
+		})
+	}
+	return snapshot, seq, nil
+}
+
+func (h *AvailabilityHub) remember(event AvailabilityEvent) {
+	if cap(h.history) == 0 {
+		return
+	}
+	if len(h.history) < cap(h.history) {
+		h.history = append(h.history, event)
+		return
+	}
+	h.history[h.next] = event
+	h.next = (h.next + 1) % cap(h.history)
+}
+
+// ordered события истории от старых к новым
+func (h *AvailabilityHub) ordered() []AvailabilityEvent {
+	if len(h.history) < cap(h.history) {
+		return h.history
+	}
+	return append(append([]AvailabilityEvent{}, h.history[h.next:]...), h.history[:h.next]...)
+}
+
+func (s *availabilitySub) push(event AvailabilityEvent) {
+	if _, ok := s.products[event.ProductID]; !ok {
+		return
+	}
+	s.mu.Lock()
+	s.pending[event.ProductID] = event
+	s.mu.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// drain забирает накопленные события, по одному последнему на товар
+func (s *availabilitySub) drain() []AvailabilityEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]AvailabilityEvent, 0, len(s.pending))
+	for id, e := range s.pending {
+		events = append(events, e)
+		delete(s.pending, id)
+	}
+	return events
+}
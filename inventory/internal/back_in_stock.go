@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/inventory/internal/common"
+	"go.uber.org/zap"
+)
+
+type SubscriptionRepo interface {
+	BeginTransaction() (tx *sqlx.Tx, err error)
+	GetProductsByIds(IDs []uuid.UUID) (ListItemsResponse, error)
+	ClaimSubscriptionsAfter(tx *sqlx.Tx, productID, afterID uuid.UUID, limit int) ([]StockSubscription, error)
+	DeleteSubscriptions(tx *sqlx.Tx, ids []uuid.UUID) error
+}
+
+// BackInStock рассылает подписчикам уведомление, когда товар снова появился в наличии.
+// Подписчик, которому письмо ушло, удаляется; при ошибке отправки подписка остается до следующего поступления.
+// Пачка подписок блокируется на время отправки, поэтому параллельные рассылки по одному товару
+// не отправляют одно письмо дважды.
+type BackInStock struct {
+	repo      SubscriptionRepo
+	notifier  Notifier
+	logger    *common.Logger
+	batchSize int
+}
+
+func NewBackInStock(repo SubscriptionRepo, notifier Notifier, logger *common.Logger, cfg common.BackInStockConfig) *BackInStock {
+	return &BackInStock{repo: repo, notifier: notifier, logger: logger, batchSize: cfg.BatchSize}
+}
+
+// Check запускает рассылку в фоне, чтобы не держать запрос, изменивший остаток
+func (b *BackInStock) Check(productIDs []uuid.UUID) {
+	go b.run(productIDs)
+}
+
+func (b *BackInStock) run(productIDs []uuid.UUID) {
+	items, err := b.repo.GetProductsByIds(productIDs)
+	if err != nil {
+		b.logger.Error("failed to get products for back in stock", zap.Error(err))
+		return
+	}
+	for _, item := range items.Items {
+		if item.Available <= 0 {
+			continue
+		}
+		if err = b.notifyProduct(item.ID); err != nil {
+			b.logger.Error("failed to notify back in stock subscribers", zap.Error(err),
+				zap.String("product_id", item.ID.String()))
+		}
+	}
+}
+
+func (b *BackInStock) notifyProduct(productID uuid.UUID) error {
+	after := uuid.Nil
+	for {
+		last, err := b.notifyBatch(productID, after)
+		if err != nil {
+			return err
+		}
+		if last == uuid.Nil {
+			return nil
+		}
+		after = last
+	}
+}
+
+// notifyBatch блокирует пачку подписок, по очереди отправляет уведомления и удаляет подписки,
+// которым письмо ушло. Возвращает id последней подписки пачки или uuid.Nil, если подписок больше нет.
+func (b *BackInStock) notifyBatch(productID, after uuid.UUID) (last uuid.UUID, err error) {
+	tx, err := b.repo.BeginTransaction()
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("back in stock: error starting transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		if commitErr := tx.Commit(); commitErr != nil {
+			err = fmt.Errorf("back in stock: committing transaction failed: %w", commitErr)
+		}
+	}()
+	subs, err := b.repo.ClaimSubscriptionsAfter(tx, productID, after, b.batchSize)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if len(subs) == 0 {
+		return uuid.Nil, nil
+	}
+	notified := make([]uuid.UUID, 0, len(subs))
+	for _, sub := range subs {
+		err := b.notifier.Notify(NotificationRequest{
+			UserID:  sub.UserID,
+			To:      sub.Email,
+			Type:    "email",
+			Subject: "Back in stock",
+			Text:    fmt.Sprintf("Product %s is back in stock", sub.ProductID),
+		})
+		if err != nil {
+			b.logger.Warn("failed to send back in stock notification", zap.Error(err),
+				zap.String("subscription_id", sub.ID.String()))
+			continue
+		}
+		notified = append(notified, sub.ID)
+	}
+	if len(notified) > 0 {
+		if err = b.repo.DeleteSubscriptions(tx, notified); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	return subs[len(subs)-1].ID, nil
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type NotificationClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewNotificationClient(baseURL string) *NotificationClient {
+	return &NotificationClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (c *NotificationClient) Notify(req NotificationRequest) error {
+	if err := postJSON(c.client, c.baseURL+"/api/v1/notifications/notify", req); err != nil {
+		return fmt.Errorf("notification client: notify: %w", err)
+	}
+	return nil
+}
+
+type AnalyticsClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewAnalyticsClient(baseURL string) *AnalyticsClient {
+	return &AnalyticsClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (c *AnalyticsClient) Publish(event Event) error {
+	if err := postJSON(c.client, c.baseURL+"/api/v1/analytics/orders/", event); err != nil {
+		return fmt.Errorf("analytics client: publish %s: %w", event.Type, err)
+	}
+	return nil
+}
+
+type CartClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewCartClient(baseURL string) *CartClient {
+	return &CartClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// ProductUpdated сообщает Cart о наличии товара, чтобы уведомить владельцев списков желаний
+func (c *CartClient) ProductUpdated(update ProductUpdate) error {
+	if err := postJSON(c.client, c.baseURL+"/api/v1/carts/wishlists/product-updates", update); err != nil {
+		return fmt.Errorf("cart client: product updated %s: %w", update.ProductID, err)
+	}
+	return nil
+}
+
+func postJSON(client *http.Client, url string, body any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -1,14 +1,21 @@
 package common
 
 import (
+	"fmt"
 	"github.com/kelseyhightower/envconfig"
 	"os"
 	"strings"
+	"time"
 )
 
 type Config struct {
 	DB             DBConfig
 	Server         ServerConfig
+	Reservation    ReservationConfig
+	Services       ServicesConfig
+	Alerts         AlertsConfig
+	BackInStock    BackInStockConfig
+	Stream         StreamConfig
 	LogLevel       string
 	LogDevelopMode bool
 	AllowedOrigins []string
@@ -27,6 +34,32 @@ type ServerConfig struct {
 	Port    string `envconfig:"PORT" required:"true"`
 }
 
+type ReservationConfig struct {
+	TTL           time.Duration `envconfig:"TTL" default:"15m"`
+	SweepInterval time.Duration `envconfig:"SWEEP_INTERVAL" default:"1m"`
+	SweepBatch    int           `envconfig:"SWEEP_BATCH" default:"100"`
+}
+
+type ServicesConfig struct {
+	NotificationURL string `envconfig:"NOTIFICATION_URL" required:"true"`
+	AnalyticsURL    string `envconfig:"ANALYTICS_URL" required:"true"`
+	CartURL         string `envconfig:"CART_URL" required:"true"`
+}
+
+type AlertsConfig struct {
+	PurchasingEmail string `envconfig:"PURCHASING_EMAIL" required:"true"` // куда слать уведомления о низком остатке
+}
+
+type BackInStockConfig struct {
+	BatchSize int `envconfig:"BATCH_SIZE" default:"100"` // сколько подписчиков уведомлять за один проход
+}
+
+type StreamConfig struct {
+	History     int           `envconfig:"HISTORY" default:"1024"` // сколько последних событий хранить для переподключения
+	Heartbeat   time.Duration `envconfig:"HEARTBEAT" default:"15s"`
+	MaxProducts int           `envconfig:"MAX_PRODUCTS" default:"200"`
+}
+
 func Load() (Config, error) {
 	var cfg Config = Config{
 		LogLevel:       os.Getenv("LOG_LEVEL"),
@@ -43,6 +76,31 @@ func Load() (Config, error) {
 	} else {
 		cfg.Server = server
 	}
+	if reservation, err := LoadReservationConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Reservation = reservation
+	}
+	if services, err := LoadServicesConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Services = services
+	}
+	if alerts, err := LoadAlertsConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Alerts = alerts
+	}
+	if backInStock, err := LoadBackInStockConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.BackInStock = backInStock
+	}
+	if stream, err := LoadStreamConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Stream = stream
+	}
 	return cfg, nil
 }
 
@@ -63,3 +121,55 @@ func LoadServerConfig() (ServerConfig, error) {
 	}
 	return cfg, nil
 }
+
+func LoadReservationConfig() (ReservationConfig, error) {
+	var cfg ReservationConfig
+	err := envconfig.Process("RESERVATION", &cfg)
+	if err != nil {
+		return ReservationConfig{}, err
+	}
+	return cfg, nil
+}
+
+func LoadServicesConfig() (ServicesConfig, error) {
+	var cfg ServicesConfig
+	err := envconfig.Process("SERVICES", &cfg)
+	if err != nil {
+		return ServicesConfig{}, err
+	}
+	return cfg, nil
+}
+
+func LoadAlertsConfig() (AlertsConfig, error) {
+	var cfg AlertsConfig
+	err := envconfig.Process("ALERTS", &cfg)
+	if err != nil {
+		return AlertsConfig{}, err
+	}
+	return cfg, nil
+}
+
+func LoadBackInStockConfig() (BackInStockConfig, error) {
+	var cfg BackInStockConfig
+	err := envconfig.Process("BACK_IN_STOCK", &cfg)
+	if err != nil {
+		return BackInStockConfig{}, err
+	}
+	return cfg, nil
+}
+
+func LoadStreamConfig() (StreamConfig, error) {
+	var cfg StreamConfig
+	err := envconfig.Process("STREAM", &cfg)
+	if err != nil {
+		return StreamConfig{}, err
+	}
+	return cfg, nil
+}
+
+func (db DBConfig) DSN() string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		db.Server, db.Port, db.User, db.Pass, db.Database,
+	)
+}
@@ -23,3 +23,36 @@ type NotFoundError struct {
 func (err *NotFoundError) Error() string {
 	return err.Message
 }
+
+type ConflictError struct {
+	Message string
+}
+
+func (err *ConflictError) Error() string {
+	return err.Message
+}
+
+// PreconditionFailedError версия из If-Match не совпала с текущей
+type PreconditionFailedError struct {
+	Message string
+}
+
+func (err *PreconditionFailedError) Error() string {
+	return err.Message
+}
+
+// StockShortage строка, по которой не хватило остатка
+type StockShortage struct {
+	ProductID string `json:"product_id"`
+	Requested int64  `json:"requested"`
+	Available int64  `json:"available"`
+}
+
+type InsufficientStockError struct {
+	ConflictError
+	Shortages []StockShortage
+}
+
+func (err *InsufficientStockError) Unwrap() error {
+	return &err.ConflictError
+}
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func ETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ParseIfMatch достает версию из заголовка If-Match. ok=false - заголовка нет, "*" - версия 0 (любая).
+func ParseIfMatch(r *http.Request) (version int64, ok bool, err error) {
+	raw := strings.TrimSpace(r.Header.Get("If-Match"))
+	if raw == "" {
+		return 0, false, nil
+	}
+	if raw == "*" {
+		return 0, true, nil
+	}
+	raw = strings.Trim(strings.TrimPrefix(raw, "W/"), `"`)
+	version, err = strconv.ParseInt(raw, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, true, errors.New("invalid If-Match")
+	}
+	return version, true, nil
+}
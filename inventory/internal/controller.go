@@ -2,11 +2,13 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/madrabit/mini-market/inventory/internal/common"
 	"go.uber.org/zap"
 	"net/http"
+	"strconv"
 )
 
 /*
@@ -29,11 +31,33 @@ func NewController(svc Svc, logger common.Logger) *Controller {
 type Svc interface {
 	GetProductsByIds(IDs []uuid.UUID) (ListItemsResponse, error)
 	AddProduct(item AddItemRequest) error
-	UpdateProduct(item UpdateItemRequest) error
+	UpdateProduct(item UpdateItemRequest) (Item, error)
 	DeleteProduct(id uuid.UUID) error
 	GetProductById(id uuid.UUID) (Item, error)
-	ReserveProducts(item ReserveItemRequest) error
+	ReserveProducts(item ReserveItemRequest) ([]Reservation, error)
+	ReserveOrder(req BatchReserveRequest) ([]Reservation, error)
 	ReleaseProducts(item ReliesItemRequest) error
+	CommitReservations(orderID uuid.UUID) error
+	GetReservationsByOrder(orderID uuid.UUID) (OrderReservationsResponse, error)
+	CreateWarehouse(req CreateWarehouseRequest) (Warehouse, error)
+	GetWarehouses() ([]Warehouse, error)
+	SetWarehouseStock(warehouseID, productID uuid.UUID, req SetWarehouseStockRequest) error
+	RecordMovement(req RecordMovementRequest) (StockMovement, error)
+	GetMovements(filter MovementsFilter) ([]StockMovement, error)
+	ReconcileStock(productID uuid.UUID) ([]StockReconciliation, error)
+	GetReorderPoint(productID uuid.UUID) (ReorderPoint, error)
+	SetReorderPoint(productID uuid.UUID, req SetReorderPointRequest) (ReorderPoint, error)
+	Subscribe(productID uuid.UUID, req SubscribeRequest) (StockSubscription, error)
+	Unsubscribe(productID, userID uuid.UUID) error
+	OpenStocktake(req OpenStocktakeRequest) (Stocktake, error)
+	GetStocktake(id uuid.UUID) (Stocktake, error)
+	SubmitCounts(id uuid.UUID, req SubmitCountsRequest) (Stocktake, error)
+	ApproveStocktake(id uuid.UUID, req CloseStocktakeRequest) (Stocktake, error)
+	CancelStocktake(id uuid.UUID, req CloseStocktakeRequest) error
+	CreatePurchaseOrder(req CreatePurchaseOrderRequest) (PurchaseOrder, error)
+	GetPurchaseOrder(id uuid.UUID) (PurchaseOrder, error)
+	ReceiveGoods(id uuid.UUID, req ReceiveGoodsRequest) (PurchaseOrder, error)
+	CancelPurchaseOrder(id uuid.UUID) error
 }
 
 func (c *Controller) Routes() chi.Router {
@@ -43,15 +67,61 @@ func (c *Controller) Routes() chi.Router {
 	// GET /api/v1/inventory/{product_id} - Получить информацию о конкретном товаре
 	r.Get("/{productID}", c.GetProductById)
 	//Добавить товар с количеством
-	r.Post("", c.AddProduct)
-	//Изменить количество
+	r.Post("/", c.AddProduct)
+	//Изменить количество: Qty с If-Match или Delta
 	r.Patch("/{productID}", c.UpdateProduct)
 	// Удалить товар совсем
 	r.Delete("/{productID}", c.DeleteProduct)
 	// POST /api/v1/inventory/reserve - Зарезервировать товары на время оформления заказа
 	r.Post("/reserve", c.ReserveProducts)
+	// POST /api/v1/inventory/reserve/batch - Зарезервировать все строки заказа разом (все или ничего)
+	r.Post("/reserve/batch", c.ReserveOrder)
 	// POST /api/v1/inventory/release - Освободить резерв (если заказ отменен)
 	r.Post("/release", c.ReleaseProducts)
+	// GET /api/v1/inventory/reservations/{orderID} - резервы заказа
+	r.Get("/reservations/{orderID}", c.GetReservationsByOrder)
+	// POST /api/v1/inventory/reservations/{orderID}/commit - списать резерв после оплаты
+	r.Post("/reservations/{orderID}/commit", c.CommitReservations)
+	// POST /api/v1/inventory/reservations/{orderID}/release - снять весь резерв заказа
+	r.Post("/reservations/{orderID}/release", c.ReleaseOrderReservations)
+	// GET /api/v1/inventory/warehouses - список складов
+	r.Get("/warehouses", c.GetWarehouses)
+	// POST /api/v1/inventory/warehouses - добавить склад
+	r.Post("/warehouses", c.CreateWarehouse)
+	// PUT /api/v1/inventory/warehouses/{warehouseID}/stock/{productID} - задать остаток товара на складе (нужен If-Match)
+	r.Put("/warehouses/{warehouseID}/stock/{productID}", c.SetWarehouseStock)
+	// POST /api/v1/inventory/movements - приемка, возврат или корректировка остатка
+	r.Post("/movements", c.RecordMovement)
+	// GET /api/v1/inventory/movements/{productID} - журнал движений товара
+	r.Get("/movements/{productID}", c.GetMovements)
+	// GET /api/v1/inventory/movements/{productID}/reconcile - сверка остатков с журналом
+	r.Get("/movements/{productID}/reconcile", c.ReconcileStock)
+	// GET /api/v1/inventory/reorder-points/{productID} - порог пополнения товара
+	r.Get("/reorder-points/{productID}", c.GetReorderPoint)
+	// PUT /api/v1/inventory/reorder-points/{productID} - задать порог пополнения и страховой запас
+	r.Put("/reorder-points/{productID}", c.SetReorderPoint)
+	// POST /api/v1/inventory/back-in-stock/{productID} - подписаться на поступление товара
+	r.Post("/back-in-stock/{productID}", c.Subscribe)
+	// DELETE /api/v1/inventory/back-in-stock/{productID}?userID= - отписаться
+	r.Delete("/back-in-stock/{productID}", c.Unsubscribe)
+	// POST /api/v1/inventory/stocktakes - открыть пересчет товаров на складе
+	r.Post("/stocktakes", c.OpenStocktake)
+	// GET /api/v1/inventory/stocktakes/{stocktakeID} - пересчет с расхождениями
+	r.Get("/stocktakes/{stocktakeID}", c.GetStocktake)
+	// POST /api/v1/inventory/stocktakes/{stocktakeID}/counts - ввести подсчитанное количество
+	r.Post("/stocktakes/{stocktakeID}/counts", c.SubmitCounts)
+	// POST /api/v1/inventory/stocktakes/{stocktakeID}/approve - провести расхождения
+	r.Post("/stocktakes/{stocktakeID}/approve", c.ApproveStocktake)
+	// POST /api/v1/inventory/stocktakes/{stocktakeID}/cancel - отменить пересчет
+	r.Post("/stocktakes/{stocktakeID}/cancel", c.CancelStocktake)
+	// POST /api/v1/inventory/purchase-orders - создать закупку у поставщика
+	r.Post("/purchase-orders", c.CreatePurchaseOrder)
+	// GET /api/v1/inventory/purchase-orders/{poID} - закупка с принятым количеством
+	r.Get("/purchase-orders/{poID}", c.GetPurchaseOrder)
+	// POST /api/v1/inventory/purchase-orders/{poID}/receipts - принять товар, можно частично
+	r.Post("/purchase-orders/{poID}/receipts", c.ReceiveGoods)
+	// POST /api/v1/inventory/purchase-orders/{poID}/cancel - отменить закупку
+	r.Post("/purchase-orders/{poID}/cancel", c.CancelPurchaseOrder)
 	return r
 }
 
@@ -95,13 +165,14 @@ func (c *Controller) AddProduct(w http.ResponseWriter, r *http.Request) {
 	err = c.svc.AddProduct(item)
 	if err != nil {
 		c.logger.Error("failed add product to inventory", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
 
+// UpdateProduct задать остаток (Qty, нужен If-Match) или изменить его на Delta (If-Match по желанию)
 func (c *Controller) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		err := r.Body.Close()
@@ -109,35 +180,50 @@ func (c *Controller) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 			c.logger.Error("failed to close body", zap.Error(err))
 		}
 	}()
+	id, err := uuid.Parse(chi.URLParam(r, "productID"))
+	if err != nil || id == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
 	var item UpdateItemRequest
-	err := json.NewDecoder(r.Body).Decode(&item)
+	err = json.NewDecoder(r.Body).Decode(&item)
 	if err != nil {
 		c.logger.Error("failed update product", zap.Error(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	err = c.svc.UpdateProduct(item)
+	version, hasIfMatch, err := common.ParseIfMatch(r)
 	if err != nil {
-		c.logger.Error("failed to update product", zap.Error(err))
 		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	if item.Qty != nil && !hasIfMatch {
+		common.ErrResponse(w, http.StatusPreconditionRequired, "If-Match header is required")
+		return
+	}
+	item.Id, item.Version = id, version
+	product, err := c.svc.UpdateProduct(item)
+	if err != nil {
+		c.logger.Error("failed to update product", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("ETag", common.ETag(product.Version))
+	common.OkResponse(w, product)
 }
 
 func (c *Controller) DeleteProduct(w http.ResponseWriter, r *http.Request) {
-	productID := r.URL.Query().Get("productID")
-	id, err := uuid.Parse(productID)
-	if productID == "" {
-		c.logger.Warn("empty params")
-		common.ErrResponse(w, http.StatusBadRequest, "empty param")
+	id, err := uuid.Parse(chi.URLParam(r, "productID"))
+	if err != nil || id == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
 		return
 	}
 	err = c.svc.DeleteProduct(id)
 	if err != nil {
 		c.logger.Error("failed to delete item from inventory", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -145,8 +231,7 @@ func (c *Controller) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *Controller) GetProductById(w http.ResponseWriter, r *http.Request) {
-	req := r.URL.Query().Get("productID")
-	id, err := uuid.Parse(req)
+	id, err := uuid.Parse(chi.URLParam(r, "productID"))
 	if err != nil || id == uuid.Nil {
 		c.logger.Warn("invalid param")
 		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
@@ -155,9 +240,10 @@ func (c *Controller) GetProductById(w http.ResponseWriter, r *http.Request) {
 	product, err := c.svc.GetProductById(id)
 	if err != nil {
 		c.logger.Error("failed to get product by ID", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
 		return
 	}
+	w.Header().Set("ETag", common.ETag(product.Version))
 	common.OkResponse(w, product)
 }
 
@@ -175,14 +261,46 @@ func (c *Controller) ReserveProducts(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	err = c.svc.ReserveProducts(item)
+	reservations, err := c.svc.ReserveProducts(item)
 	if err != nil {
 		c.logger.Error("failed reserve product", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		var stockErr *common.InsufficientStockError
+		if errors.As(err, &stockErr) {
+			common.ErrResponseData(w, http.StatusConflict, stockErr.Error(), stockErr.Shortages)
+			return
+		}
+		common.ErrResponse(w, errStatus(err), error.Error(err))
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	common.OkResponse(w, reservations)
+}
+
+func (c *Controller) ReserveOrder(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req BatchReserveRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed reserve order", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	reservations, err := c.svc.ReserveOrder(req)
+	if err != nil {
+		c.logger.Error("failed reserve order", zap.Error(err))
+		var stockErr *common.InsufficientStockError
+		if errors.As(err, &stockErr) {
+			common.ErrResponseData(w, http.StatusConflict, stockErr.Error(), stockErr.Shortages)
+			return
+		}
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, reservations)
 }
 
 func (c *Controller) ReleaseProducts(w http.ResponseWriter, r *http.Request) {
@@ -202,9 +320,524 @@ func (c *Controller) ReleaseProducts(w http.ResponseWriter, r *http.Request) {
 	err = c.svc.ReleaseProducts(item)
 	if err != nil {
 		c.logger.Error("failed reserve product", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) GetReservationsByOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	reservations, err := c.svc.GetReservationsByOrder(orderID)
+	if err != nil {
+		c.logger.Error("failed to get reservations", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, reservations)
+}
+
+func (c *Controller) CommitReservations(w http.ResponseWriter, r *http.Request) {
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	err = c.svc.CommitReservations(orderID)
+	if err != nil {
+		c.logger.Error("failed to commit reservations", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) ReleaseOrderReservations(w http.ResponseWriter, r *http.Request) {
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	err = c.svc.ReleaseProducts(ReliesItemRequest{OrderID: orderID})
+	if err != nil {
+		c.logger.Error("failed to release reservations", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) GetWarehouses(w http.ResponseWriter, r *http.Request) {
+	warehouses, err := c.svc.GetWarehouses()
+	if err != nil {
+		c.logger.Error("failed to get warehouses", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, warehouses)
+}
+
+func (c *Controller) CreateWarehouse(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req CreateWarehouseRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to create warehouse", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	warehouse, err := c.svc.CreateWarehouse(req)
+	if err != nil {
+		c.logger.Error("failed to create warehouse", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, warehouse)
+}
+
+func (c *Controller) SetWarehouseStock(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	warehouseID, errWarehouse := uuid.Parse(chi.URLParam(r, "warehouseID"))
+	productID, errProduct := uuid.Parse(chi.URLParam(r, "productID"))
+	if errWarehouse != nil || errProduct != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req SetWarehouseStockRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to set warehouse stock", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	version, hasIfMatch, err := common.ParseIfMatch(r)
+	if err != nil {
 		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
 		return
 	}
+	if !hasIfMatch {
+		common.ErrResponse(w, http.StatusPreconditionRequired, "If-Match header is required")
+		return
+	}
+	req.Version = version
+	err = c.svc.SetWarehouseStock(warehouseID, productID, req)
+	if err != nil {
+		c.logger.Error("failed to set warehouse stock", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
+
+func (c *Controller) RecordMovement(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req RecordMovementRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to record movement", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	movement, err := c.svc.RecordMovement(req)
+	if err != nil {
+		c.logger.Error("failed to record movement", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, movement)
+}
+
+func (c *Controller) GetMovements(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(chi.URLParam(r, "productID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	filter := MovementsFilter{ProductID: productID}
+	query := r.URL.Query()
+	if v := query.Get("warehouseID"); v != "" {
+		filter.WarehouseID, err = uuid.Parse(v)
+		if err != nil {
+			c.logger.Warn("invalid param")
+			common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+			return
+		}
+	}
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+	filter.Offset, _ = strconv.Atoi(query.Get("offset"))
+	movements, err := c.svc.GetMovements(filter)
+	if err != nil {
+		c.logger.Error("failed to get movements", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, movements)
+}
+
+func (c *Controller) ReconcileStock(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(chi.URLParam(r, "productID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	result, err := c.svc.ReconcileStock(productID)
+	if err != nil {
+		c.logger.Error("failed to reconcile stock", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, result)
+}
+
+func (c *Controller) GetReorderPoint(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(chi.URLParam(r, "productID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	rp, err := c.svc.GetReorderPoint(productID)
+	if err != nil {
+		c.logger.Error("failed to get reorder point", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, rp)
+}
+
+func (c *Controller) SetReorderPoint(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	productID, err := uuid.Parse(chi.URLParam(r, "productID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req SetReorderPointRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to set reorder point", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rp, err := c.svc.SetReorderPoint(productID, req)
+	if err != nil {
+		c.logger.Error("failed to set reorder point", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, rp)
+}
+
+func (c *Controller) Subscribe(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	productID, err := uuid.Parse(chi.URLParam(r, "productID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req SubscribeRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to subscribe", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sub, err := c.svc.Subscribe(productID, req)
+	if err != nil {
+		c.logger.Error("failed to subscribe", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, sub)
+}
+
+func (c *Controller) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	productID, errProduct := uuid.Parse(chi.URLParam(r, "productID"))
+	userID, errUser := uuid.Parse(r.URL.Query().Get("userID"))
+	if errProduct != nil || errUser != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	err := c.svc.Unsubscribe(productID, userID)
+	if err != nil {
+		c.logger.Error("failed to unsubscribe", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) OpenStocktake(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req OpenStocktakeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to open stocktake", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	st, err := c.svc.OpenStocktake(req)
+	if err != nil {
+		c.logger.Error("failed to open stocktake", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, st)
+}
+
+func (c *Controller) GetStocktake(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "stocktakeID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	st, err := c.svc.GetStocktake(id)
+	if err != nil {
+		c.logger.Error("failed to get stocktake", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, st)
+}
+
+func (c *Controller) SubmitCounts(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	id, err := uuid.Parse(chi.URLParam(r, "stocktakeID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req SubmitCountsRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to submit counts", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	st, err := c.svc.SubmitCounts(id, req)
+	if err != nil {
+		c.logger.Error("failed to submit counts", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, st)
+}
+
+func (c *Controller) ApproveStocktake(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	id, err := uuid.Parse(chi.URLParam(r, "stocktakeID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req CloseStocktakeRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to approve stocktake", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	st, err := c.svc.ApproveStocktake(id, req)
+	if err != nil {
+		c.logger.Error("failed to approve stocktake", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, st)
+}
+
+func (c *Controller) CancelStocktake(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	id, err := uuid.Parse(chi.URLParam(r, "stocktakeID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req CloseStocktakeRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to cancel stocktake", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = c.svc.CancelStocktake(id, req)
+	if err != nil {
+		c.logger.Error("failed to cancel stocktake", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) CreatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req CreatePurchaseOrderRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to create purchase order", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	po, err := c.svc.CreatePurchaseOrder(req)
+	if err != nil {
+		c.logger.Error("failed to create purchase order", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, po)
+}
+
+func (c *Controller) GetPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "poID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	po, err := c.svc.GetPurchaseOrder(id)
+	if err != nil {
+		c.logger.Error("failed to get purchase order", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, po)
+}
+
+func (c *Controller) ReceiveGoods(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	id, err := uuid.Parse(chi.URLParam(r, "poID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req ReceiveGoodsRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to receive goods", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	po, err := c.svc.ReceiveGoods(id, req)
+	if err != nil {
+		c.logger.Error("failed to receive goods", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, po)
+}
+
+func (c *Controller) CancelPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "poID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	err = c.svc.CancelPurchaseOrder(id)
+	if err != nil {
+		c.logger.Error("failed to cancel purchase order", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func errStatus(err error) int {
+	var validationErr *common.RequestValidationError
+	var notFoundErr *common.NotFoundError
+	var existsErr *common.AlreadyExistsError
+	var conflictErr *common.ConflictError
+	var preconditionErr *common.PreconditionFailedError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.As(err, &notFoundErr):
+		return http.StatusNotFound
+	case errors.As(err, &existsErr), errors.As(err, &conflictErr):
+		return http.StatusConflict
+	case errors.As(err, &preconditionErr):
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"database/sql"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/inventory/internal/common"
+	"github.com/madrabit/mini-market/inventory/internal/validator"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stockRepo добавляет к резервам версию товара: в базе ее поднимает триггер на изменение остатка
+type stockRepo struct {
+	*reservationRepo
+}
+
+func (r stockRepo) GetWarehouseById(id uuid.UUID) (Warehouse, error) {
+	if id != warehouseID {
+		return Warehouse{}, sql.ErrNoRows
+	}
+	return Warehouse{ID: id, Name: "main"}, nil
+}
+
+func (r stockRepo) GetItemVersion(_ *sqlx.Tx, id uuid.UUID) (int64, error) {
+	item, ok := r.items[id]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return item.Version, nil
+}
+
+func (r stockRepo) SetWarehouseStock(_ *sqlx.Tx, _, id uuid.UUID, qty int64) error {
+	item := r.items[id]
+	item.Available += qty - item.Qty
+	item.Qty = qty
+	item.Version++
+	return nil
+}
+
+func (r stockRepo) GetProductById(id uuid.UUID) (Item, error) {
+	item, ok := r.items[id]
+	if !ok {
+		return Item{}, sql.ErrNoRows
+	}
+	return *item, nil
+}
+
+func TestUpdateProductIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		ifMatch string
+		status  int
+		etag    string
+		qty     int64
+	}{
+		{name: "set qty without If-Match", body: `"Qty":20`, status: http.StatusPreconditionRequired, qty: 10},
+		{name: "set qty with stale version", body: `"Qty":20`, ifMatch: `"1"`, status: http.StatusPreconditionFailed, qty: 10},
+		{name: "set qty with current version", body: `"Qty":20`, ifMatch: `"2"`, status: http.StatusOK, etag: `"3"`, qty: 20},
+		{name: "set qty below reserved", body: `"Qty":2`, ifMatch: `"2"`, status: http.StatusConflict, qty: 10},
+		{name: "delta without If-Match", body: `"Delta":-4`, status: http.StatusOK, etag: `"3"`, qty: 6},
+		{name: "delta with stale version", body: `"Delta":-4`, ifMatch: `"5"`, status: http.StatusPreconditionFailed, qty: 10},
+		{name: "delta below zero", body: `"Delta":-11`, status: http.StatusConflict, qty: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := stockRepo{newReservationRepo(map[uuid.UUID]int64{productA: 10})}
+			repo.items[productA].Version = 2
+			repo.items[productA].Reserved, repo.items[productA].Available = 3, 7
+			svc := NewService(repo, validator.New(), common.ReservationConfig{TTL: time.Minute})
+			router := chi.NewRouter()
+			router.Mount("/inventories", NewController(svc, common.Logger{Logger: zap.NewNop()}).Routes())
+
+			body := `{"WarehouseID":"` + warehouseID.String() + `","ReasonCode":"recount","Actor":"tester",` + tt.body + `}`
+			req := httptest.NewRequest(http.MethodPatch, "/inventories/"+productA.String(), strings.NewReader(body))
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if etag := rec.Header().Get("ETag"); etag != tt.etag {
+				t.Errorf("ETag = %q, want %q", etag, tt.etag)
+			}
+			if qty := repo.items[productA].Qty; qty != tt.qty {
+				t.Errorf("qty = %d, want %d", qty, tt.qty)
+			}
+			if changed := tt.qty != 10; changed != (repo.countMovements(MovementAdjustment) == 1) {
+				t.Errorf("adjustment movements = %d, stock changed = %v", repo.countMovements(MovementAdjustment), changed)
+			}
+		})
+	}
+}
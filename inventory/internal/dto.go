@@ -1,16 +1,67 @@
 package internal
 
-import "github.com/google/uuid"
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
 
 type Warehouse struct {
-	items map[uuid.UUID]Item
+	ID      uuid.UUID `json:"id" db:"id"`
+	Name    string    `json:"name" db:"name"`
+	Address string    `json:"address" db:"address"`
+	Location
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	items     map[uuid.UUID]Item
+}
+
+type Location struct {
+	Latitude  float64 `json:"latitude" db:"latitude" validate:"gte=-90,lte=90"`
+	Longitude float64 `json:"longitude" db:"longitude" validate:"gte=-180,lte=180"`
 }
 
 type Item struct {
-	ID        uuid.UUID
-	Qty       int64
-	Reserved  int64
-	Available int64
+	ID         uuid.UUID        `db:"id"`
+	Qty        int64            `db:"qty"`
+	Reserved   int64            `db:"reserved"`
+	Available  int64            `db:"available"`
+	Version    int64            `db:"version"` // растет при каждом изменении физического остатка, отдается как ETag
+	Warehouses []WarehouseStock `json:",omitempty" db:"-"`
+	// ожидаемое поступление по открытым закупкам за вычетом предзаказов
+	Inbound       int64      `json:",omitempty" db:"-"`
+	AvailableFrom *time.Time `json:",omitempty" db:"-"`
+}
+
+// WarehouseStock остаток товара на конкретном складе
+type WarehouseStock struct {
+	WarehouseID uuid.UUID `json:"warehouse_id" db:"warehouse_id"`
+	ProductID   uuid.UUID `json:"product_id" db:"product_id"`
+	Qty         int64     `json:"qty" db:"qty"`
+	Reserved    int64     `json:"reserved" db:"reserved"`
+	Available   int64     `json:"available" db:"available"`
+}
+
+// Allocation сколько товара взять с какого склада. Для предзаказа ExpectedAt - когда товар придет.
+type Allocation struct {
+	WarehouseID uuid.UUID
+	ProductID   uuid.UUID
+	Qty         int64
+	ExpectedAt  time.Time
+}
+
+type CreateWarehouseRequest struct {
+	Name     string   `json:"name" validate:"required,max=100"`
+	Address  string   `json:"address" validate:"required,max=255"`
+	Location Location `json:"location"`
+}
+
+// SetWarehouseStockRequest задает остаток по итогам пересчета. Разница с текущим остатком попадает в журнал как корректировка.
+type SetWarehouseStockRequest struct {
+	Qty        int64  `json:"qty" validate:"gte=0"`
+	ReasonCode string `json:"reason_code" validate:"required,max=50"`
+	Actor      string `json:"actor" validate:"required,max=100"`
+	Reference  string `json:"reference" validate:"max=100"`
+	Version    int64  `json:"-"` // из If-Match, 0 - не проверять
 }
 
 type ListItemsRequest struct {
@@ -21,14 +72,27 @@ type ListItemsResponse struct {
 	Items []Item
 }
 
+// AddItemRequest заводит товар с начальным остатком на складе. Пустой WarehouseID - склад по умолчанию.
 type AddItemRequest struct {
-	Id  uuid.UUID
-	Qty int64
+	Id          uuid.UUID `validate:"required"`
+	Qty         int64     `validate:"gte=0"`
+	WarehouseID uuid.UUID
 }
 
+// DefaultWarehouseID склад, на который миграция перенесла остатки, заведенные до появления складов
+var DefaultWarehouseID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+// UpdateItemRequest меняет остаток товара на складе: либо задает Qty, либо прибавляет Delta.
+// Абсолютное значение принимается только с If-Match, иначе можно затереть чужое изменение.
 type UpdateItemRequest struct {
-	Id  uuid.UUID
-	Qty int64
+	Id          uuid.UUID `json:"-"`
+	WarehouseID uuid.UUID `validate:"required"`
+	Qty         *int64    `validate:"required_without=Delta,excluded_with=Delta,omitempty,gte=0"`
+	Delta       *int64    `validate:"required_without=Qty,omitempty,ne=0"`
+	ReasonCode  string    `validate:"required,max=50"`
+	Actor       string    `validate:"required,max=100"`
+	Reference   string    `validate:"max=100"`
+	Version     int64     `json:"-"` // из If-Match, 0 - не проверять
 }
 
 type DeleteItemRequest struct {
@@ -36,12 +100,325 @@ type DeleteItemRequest struct {
 }
 
 type ReserveItemRequest struct {
-	Id      uuid.UUID
-	Qty     int64
-	OrderID uuid.UUID // под какой заказ резерв
+	Id          uuid.UUID `validate:"required"`
+	Qty         int64     `validate:"gte=1"`
+	OrderID     uuid.UUID `validate:"required"` // под какой заказ резерв
+	Destination *Location // адрес доставки, для выбора ближайшего склада
 }
 
+// ReliesItemRequest освободить резерв заказа. Если Id не задан - освобождаются все товары заказа,
+// если не задан Qty - весь резерв товара
 type ReliesItemRequest struct {
-	Id  uuid.UUID
-	Qty int64
+	Id      uuid.UUID
+	Qty     int64     `validate:"gte=0"`
+	OrderID uuid.UUID `validate:"required"`
+}
+
+type ReservationState string
+
+const (
+	Held         ReservationState = "held"          // товар заморожен под заказ
+	Committed    ReservationState = "committed"     // заказ оплачен, товар списан
+	Released     ReservationState = "released"      // резерв снят (заказ отменен)
+	Expired      ReservationState = "expired"       // заказ не оплачен вовремя
+	Preordered   ReservationState = "preordered"    // предзаказ под ожидаемую закупку, остаток еще не пришел
+	PreorderPaid ReservationState = "preorder_paid" // предзаказ оплачен, товар спишется, как только придет
+)
+
+// Live резерв действует: держит или уже списал товар под заказ
+func (s ReservationState) Live() bool {
+	return s == Held || s == Committed || s == Preordered || s == PreorderPaid
+}
+
+type Reservation struct {
+	ID          uuid.UUID        `json:"id" db:"id"`
+	OrderID     uuid.UUID        `json:"order_id" db:"order_id"`
+	ProductID   uuid.UUID        `json:"product_id" db:"product_id"`
+	WarehouseID uuid.UUID        `json:"warehouse_id" db:"warehouse_id"`
+	Qty         int64            `json:"qty" db:"qty"`
+	State       ReservationState `json:"state" db:"state"`
+	ExpiresAt   time.Time        `json:"expires_at" db:"expires_at"`
+	CreatedAt   time.Time        `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at" db:"updated_at"`
+}
+
+type OrderReservationsResponse struct {
+	OrderID      uuid.UUID     `json:"order_id"`
+	Reservations []Reservation `json:"reservations"`
+}
+
+type ReserveLine struct {
+	Id  uuid.UUID `json:"id" validate:"required"`
+	Qty int64     `json:"qty" validate:"gte=1"`
+}
+
+// BatchReserveRequest резерв всех строк заказа одной транзакцией: либо все, либо ничего
+type BatchReserveRequest struct {
+	OrderID     uuid.UUID            `json:"order_id" validate:"required"`
+	Lines       []ReserveLine        `json:"lines" validate:"min=1,dive"`
+	Strategy    SourcingStrategyName `json:"strategy" validate:"omitempty,oneof=nearest fewest_splits"`
+	Destination *Location            `json:"destination"`
+	// то, чего нет на складах, оформить предзаказом под открытые закупки
+	AllowPreorder bool `json:"allow_preorder"`
+}
+
+type MovementType string
+
+const (
+	MovementReceipt    MovementType = "receipt"    // приемка от поставщика
+	MovementAdjustment MovementType = "adjustment" // корректировка после пересчета, списание брака
+	MovementReserve    MovementType = "reserve"    // резерв под заказ
+	MovementRelease    MovementType = "release"    // снятие резерва
+	MovementShipment   MovementType = "shipment"   // отгрузка оплаченного заказа
+	MovementReturn     MovementType = "return"     // возврат от покупателя
+)
+
+// Коды причин для движений, которые сервис пишет сам
+const (
+	ReasonOrderReserved      = "order_reserved"
+	ReasonOrderReleased      = "order_released"
+	ReasonOrderPaid          = "order_paid"
+	ReasonOrderCanceled      = "order_canceled" // отмена оплаченного заказа до отгрузки
+	ReasonReservationExpired = "reservation_expired"
+	ReasonStocktake          = "stocktake"
+	ReasonGoodsReceipt       = "goods_receipt"
+	ReasonPreorderArrived    = "preorder_arrived"
+	ReasonOpeningBalance     = "opening_balance"
+	ReasonProductDeleted     = "product_deleted"
+	SystemActor              = "system"
+)
+
+// StockMovement запись журнала движения товара. QtyDelta меняет физический остаток, ReservedDelta - резерв.
+type StockMovement struct {
+	ID            uuid.UUID    `json:"id" db:"id"`
+	WarehouseID   uuid.UUID    `json:"warehouse_id" db:"warehouse_id"`
+	ProductID     uuid.UUID    `json:"product_id" db:"product_id"`
+	Type          MovementType `json:"type" db:"type"`
+	QtyDelta      int64        `json:"qty_delta" db:"qty_delta"`
+	ReservedDelta int64        `json:"reserved_delta" db:"reserved_delta"`
+	ReasonCode    string       `json:"reason_code" db:"reason_code"`
+	Actor         string       `json:"actor" db:"actor"`
+	Reference     string       `json:"reference" db:"reference"` // id заказа, номер закупки
+	CreatedAt     time.Time    `json:"created_at" db:"created_at"`
+}
+
+// RecordMovementRequest ручное движение: приемка, возврат или корректировка на Qty единиц (может быть отрицательной).
+// Движение с Reference проводится по товару один раз: повтор с той же ссылкой и типом вернет уже проведенное.
+type RecordMovementRequest struct {
+	WarehouseID uuid.UUID    `json:"warehouse_id" validate:"required"`
+	ProductID   uuid.UUID    `json:"product_id" validate:"required"`
+	Type        MovementType `json:"type" validate:"required,oneof=receipt adjustment return"`
+	Qty         int64        `json:"qty" validate:"ne=0"`
+	ReasonCode  string       `json:"reason_code" validate:"required,max=50"`
+	Actor       string       `json:"actor" validate:"required,max=100"`
+	Reference   string       `json:"reference" validate:"max=100"`
+}
+
+type MovementsFilter struct {
+	ProductID   uuid.UUID
+	WarehouseID uuid.UUID // uuid.Nil - все склады
+	Limit       int
+	Offset      int
+}
+
+// StockReconciliation сверка остатка на складе с суммой движений по журналу
+type StockReconciliation struct {
+	WarehouseID    uuid.UUID `json:"warehouse_id"`
+	ProductID      uuid.UUID `json:"product_id"`
+	Qty            int64     `json:"qty"`
+	Reserved       int64     `json:"reserved"`
+	LedgerQty      int64     `json:"ledger_qty"`
+	LedgerReserved int64     `json:"ledger_reserved"`
+	InSync         bool      `json:"in_sync"`
+}
+
+// ReorderPoint настройки пополнения товара. Когда суммарный Available падает ниже ReorderPoint, закупке уходит уведомление.
+type ReorderPoint struct {
+	ProductID    uuid.UUID  `json:"product_id" db:"product_id"`
+	ReorderPoint int64      `json:"reorder_point" db:"reorder_point"`
+	SafetyStock  int64      `json:"safety_stock" db:"safety_stock"`
+	ReorderQty   int64      `json:"reorder_qty" db:"reorder_qty"` // сколько рекомендуется дозаказать
+	AlertedAt    *time.Time `json:"alerted_at,omitempty" db:"alerted_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type SetReorderPointRequest struct {
+	ReorderPoint int64 `json:"reorder_point" validate:"gte=0"`
+	SafetyStock  int64 `json:"safety_stock" validate:"gte=0,ltefield=ReorderPoint"`
+	ReorderQty   int64 `json:"reorder_qty" validate:"gte=0"`
+}
+
+// ReorderStatus текущий остаток товара вместе с его порогом
+type ReorderStatus struct {
+	ProductID    uuid.UUID  `db:"product_id"`
+	Available    int64      `db:"available"`
+	ReorderPoint int64      `db:"reorder_point"`
+	SafetyStock  int64      `db:"safety_stock"`
+	ReorderQty   int64      `db:"reorder_qty"`
+	AlertedAt    *time.Time `db:"alerted_at"`
+}
+
+// ProductUpdate изменение наличия товара для списков желаний в Cart
+type ProductUpdate struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Available bool      `json:"available"`
+}
+
+type NotificationRequest struct {
+	UserID  uuid.UUID
+	To      string
+	Type    string
+	Subject string
+	Text    string
+}
+
+// Event событие для сервиса Analytics
+type Event struct {
+	ID         uuid.UUID
+	Type       string
+	Payload    json.RawMessage
+	OccurredAt time.Time
+}
+
+type StockLowPayload struct {
+	Version      int       `json:"version"`
+	ProductID    uuid.UUID `json:"product_id"`
+	Available    int64     `json:"available"`
+	ReorderPoint int64     `json:"reorder_point"`
+	SafetyStock  int64     `json:"safety_stock"`
+	ReorderQty   int64     `json:"reorder_qty"`
+	BelowSafety  bool      `json:"below_safety"`
+}
+
+// StockSubscription подписка покупателя на поступление товара. Удаляется после отправки уведомления.
+type StockSubscription struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	ProductID uuid.UUID `json:"product_id" db:"product_id"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	Email     string    `json:"email" db:"email"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type SubscribeRequest struct {
+	UserID uuid.UUID `json:"user_id" validate:"required"`
+	Email  string    `json:"email" validate:"required,email"`
+}
+
+type StocktakeStatus string
+
+const (
+	StocktakeOpen      StocktakeStatus = "open"      // идет пересчет
+	StocktakeApproved  StocktakeStatus = "approved"  // расхождения проведены корректировками
+	StocktakeCancelled StocktakeStatus = "cancelled" // пересчет отменен без изменений остатка
+)
+
+// Stocktake сессия пересчета товаров на складе
+type Stocktake struct {
+	ID          uuid.UUID       `json:"id" db:"id"`
+	WarehouseID uuid.UUID       `json:"warehouse_id" db:"warehouse_id"`
+	Status      StocktakeStatus `json:"status" db:"status"`
+	OpenedBy    string          `json:"opened_by" db:"opened_by"`
+	ClosedBy    string          `json:"closed_by,omitempty" db:"closed_by"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	ClosedAt    *time.Time      `json:"closed_at,omitempty" db:"closed_at"`
+	Lines       []StocktakeLine `json:"lines" db:"-"`
+}
+
+// StocktakeLine строка пересчета. SystemQty - остаток по системе в момент ввода подсчета,
+// поэтому отгрузки, прошедшие до подсчета, не попадают в расхождение.
+type StocktakeLine struct {
+	StocktakeID uuid.UUID  `json:"-" db:"stocktake_id"`
+	ProductID   uuid.UUID  `json:"product_id" db:"product_id"`
+	CountedQty  *int64     `json:"counted_qty" db:"counted_qty"`
+	SystemQty   *int64     `json:"system_qty" db:"system_qty"`
+	Variance    *int64     `json:"variance" db:"-"`
+	CountedBy   string     `json:"counted_by,omitempty" db:"counted_by"`
+	CountedAt   *time.Time `json:"counted_at,omitempty" db:"counted_at"`
+}
+
+type OpenStocktakeRequest struct {
+	WarehouseID uuid.UUID   `json:"warehouse_id" validate:"required"`
+	ProductIDs  []uuid.UUID `json:"product_ids" validate:"min=1,dive,required"`
+	Actor       string      `json:"actor" validate:"required,max=100"`
+}
+
+type CountLine struct {
+	ProductID uuid.UUID `json:"product_id" validate:"required"`
+	Qty       int64     `json:"qty" validate:"gte=0"`
+}
+
+type SubmitCountsRequest struct {
+	Actor  string      `json:"actor" validate:"required,max=100"`
+	Counts []CountLine `json:"counts" validate:"min=1,dive"`
+}
+
+type CloseStocktakeRequest struct {
+	Actor string `json:"actor" validate:"required,max=100"`
+}
+
+type PurchaseOrderStatus string
+
+const (
+	PurchaseOrderOpen      PurchaseOrderStatus = "open"
+	PurchaseOrderPartial   PurchaseOrderStatus = "partially_received"
+	PurchaseOrderReceived  PurchaseOrderStatus = "received"
+	PurchaseOrderCancelled PurchaseOrderStatus = "cancelled"
+)
+
+// PurchaseOrder закупка у поставщика на конкретный склад
+type PurchaseOrder struct {
+	ID          uuid.UUID           `json:"id" db:"id"`
+	Number      string              `json:"number" db:"number"`
+	Supplier    string              `json:"supplier" db:"supplier"`
+	WarehouseID uuid.UUID           `json:"warehouse_id" db:"warehouse_id"`
+	Status      PurchaseOrderStatus `json:"status" db:"status"`
+	ExpectedAt  time.Time           `json:"expected_at" db:"expected_at"`
+	CreatedBy   string              `json:"created_by" db:"created_by"`
+	CreatedAt   time.Time           `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at" db:"updated_at"`
+	Lines       []PurchaseOrderLine `json:"lines" db:"-"`
+}
+
+type PurchaseOrderLine struct {
+	PurchaseOrderID uuid.UUID `json:"-" db:"purchase_order_id"`
+	ProductID       uuid.UUID `json:"product_id" db:"product_id"`
+	QtyOrdered      int64     `json:"qty_ordered" db:"qty_ordered"`
+	QtyReceived     int64     `json:"qty_received" db:"qty_received"`
+}
+
+type PurchaseOrderLineRequest struct {
+	ProductID uuid.UUID `json:"product_id" validate:"required"`
+	Qty       int64     `json:"qty" validate:"gte=1"`
+}
+
+type CreatePurchaseOrderRequest struct {
+	Number      string                     `json:"number" validate:"required,max=50"`
+	Supplier    string                     `json:"supplier" validate:"required,max=255"`
+	WarehouseID uuid.UUID                  `json:"warehouse_id" validate:"required"`
+	ExpectedAt  time.Time                  `json:"expected_at" validate:"required"`
+	Actor       string                     `json:"actor" validate:"required,max=100"`
+	Lines       []PurchaseOrderLineRequest `json:"lines" validate:"min=1,dive"`
+}
+
+// ReceiveGoodsRequest приемка товара по закупке, можно частями
+type ReceiveGoodsRequest struct {
+	Actor string                     `json:"actor" validate:"required,max=100"`
+	Lines []PurchaseOrderLineRequest `json:"lines" validate:"min=1,dive"`
+}
+
+// InboundSupply сколько товара еще ждем на склад по открытым закупкам
+type InboundSupply struct {
+	ProductID   uuid.UUID `db:"product_id"`
+	WarehouseID uuid.UUID `db:"warehouse_id"`
+	Qty         int64     `db:"qty"`
+	ExpectedAt  time.Time `db:"expected_at"`
+}
+
+// AvailabilityEvent изменение остатка товара для потока /stream
+type AvailabilityEvent struct {
+	ID        uint64    `json:"-"`
+	ProductID uuid.UUID `json:"product_id"`
+	Qty       int64     `json:"qty"`
+	Reserved  int64     `json:"reserved"`
+	Available int64     `json:"available"`
 }
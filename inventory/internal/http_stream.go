@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/inventory/internal/common"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ControllerStream struct {
+	hub    *AvailabilityHub
+	logger *common.Logger
+	cfg    common.StreamConfig
+}
+
+func NewControllerStream(hub *AvailabilityHub, logger *common.Logger, cfg common.StreamConfig) *ControllerStream {
+	return &ControllerStream{hub: hub, logger: logger, cfg: cfg}
+}
+
+func (c *ControllerStream) Routes() chi.Router {
+	r := chi.NewRouter()
+	// GET /api/v1/inventory/stream?ids=... - Server-Sent Events с изменениями остатка по товарам
+	r.Get("/", c.Stream)
+	return r
+}
+
+// Stream при подключении отдает снимок, при переподключении с Last-Event-ID - пропущенные события,
+// если они еще в истории, дальше - изменения по мере появления.
+func (c *ControllerStream) Stream(w http.ResponseWriter, r *http.Request) {
+	ids, err := parseIDs(r.URL.Query().Get("ids"))
+	if err != nil || len(ids) == 0 || len(ids) > c.cfg.MaxProducts {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		common.ErrResponse(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	sub, missed, replayed := c.hub.Subscribe(ids, lastEventID)
+	defer c.hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if replayed {
+		for _, e := range missed {
+			if err = writeEvent(w, c.hub.EventID(e.ID), "availability", e); err != nil {
+				return
+			}
+		}
+	} else {
+		snapshot, seq, err := c.hub.Snapshot(ids)
+		if err != nil {
+			c.logger.Error("failed to get availability snapshot", zap.Error(err))
+			return
+		}
+		if err = writeEvent(w, c.hub.EventID(seq), "snapshot", snapshot); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(c.cfg.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-sub.ready:
+			events := sub.drain()
+			sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+			for _, e := range events {
+				if err = writeEvent(w, c.hub.EventID(e.ID), "availability", e); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, id string, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, payload)
+	return err
+}
+
+func parseIDs(raw string) ([]uuid.UUID, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	parts := strings.Split(raw, ",")
+	ids := make([]uuid.UUID, 0, len(parts))
+	for _, p := range parts {
+		id, err := uuid.Parse(strings.TrimSpace(p))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return uniqueIDs(ids), nil
+}
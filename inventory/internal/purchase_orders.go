@@ -0,0 +1,321 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/inventory/internal/common"
+	"time"
+)
+
+func (s *Service) CreatePurchaseOrder(req CreatePurchaseOrderRequest) (PurchaseOrder, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return PurchaseOrder{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	_, err := s.repo.GetWarehouseById(req.WarehouseID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PurchaseOrder{}, &common.NotFoundError{Message: fmt.Sprintf("warehouse with id %s not found", req.WarehouseID)}
+	}
+	if err != nil {
+		return PurchaseOrder{}, fmt.Errorf("inventory service: create purchase order: %w", err)
+	}
+	exists, err := s.repo.ExistsPurchaseOrderNumber(req.Number)
+	if err != nil {
+		return PurchaseOrder{}, fmt.Errorf("inventory service: create purchase order: %w", err)
+	}
+	if exists {
+		return PurchaseOrder{}, &common.AlreadyExistsError{Message: fmt.Sprintf("purchase order %s already exists", req.Number)}
+	}
+	now := time.Now()
+	po := PurchaseOrder{
+		ID:          uuid.New(),
+		Number:      req.Number,
+		Supplier:    req.Supplier,
+		WarehouseID: req.WarehouseID,
+		Status:      PurchaseOrderOpen,
+		ExpectedAt:  req.ExpectedAt,
+		CreatedBy:   req.Actor,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	lines := make(map[uuid.UUID]int, len(req.Lines))
+	for _, l := range req.Lines {
+		if i, ok := lines[l.ProductID]; ok {
+			po.Lines[i].QtyOrdered += l.Qty
+			continue
+		}
+		lines[l.ProductID] = len(po.Lines)
+		po.Lines = append(po.Lines, PurchaseOrderLine{PurchaseOrderID: po.ID, ProductID: l.ProductID, QtyOrdered: l.Qty})
+	}
+	err = s.withTx("create purchase order", func(tx *sqlx.Tx) error {
+		return s.repo.CreatePurchaseOrder(tx, po)
+	})
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	return po, nil
+}
+
+func (s *Service) GetPurchaseOrder(id uuid.UUID) (PurchaseOrder, error) {
+	if id == uuid.Nil {
+		return PurchaseOrder{}, &common.RequestValidationError{Message: "invalid purchase order id"}
+	}
+	po, err := s.repo.GetPurchaseOrder(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PurchaseOrder{}, &common.NotFoundError{Message: fmt.Sprintf("purchase order %s not found", id)}
+	}
+	if err != nil {
+		return PurchaseOrder{}, fmt.Errorf("inventory service: get purchase order: %w", err)
+	}
+	return po, nil
+}
+
+// ReceiveGoods оприходует пришедший по закупке товар. Приемка может быть частичной.
+// Поступивший товар в первую очередь закрывает предзаказы в порядке их оформления.
+func (s *Service) ReceiveGoods(id uuid.UUID, req ReceiveGoodsRequest) (PurchaseOrder, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return PurchaseOrder{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	var po PurchaseOrder
+	var received []uuid.UUID
+	err := s.withTx("receive goods", func(tx *sqlx.Tx) (err error) {
+		po, err = s.activePurchaseOrderForUpdate(tx, id)
+		if err != nil {
+			return err
+		}
+		lines := make(map[uuid.UUID]int, len(po.Lines))
+		for i, line := range po.Lines {
+			lines[line.ProductID] = i
+		}
+		incoming := make(map[uuid.UUID]int64, len(req.Lines))
+		for _, l := range req.Lines {
+			i, ok := lines[l.ProductID]
+			if !ok {
+				return &common.RequestValidationError{Message: fmt.Sprintf("product %s is not in purchase order", l.ProductID)}
+			}
+			incoming[l.ProductID] += l.Qty
+			if left := po.Lines[i].QtyOrdered - po.Lines[i].QtyReceived; incoming[l.ProductID] > left {
+				return &common.ConflictError{Message: fmt.Sprintf("product %s: receiving %d, only %d left to receive",
+					l.ProductID, incoming[l.ProductID], left)}
+			}
+		}
+		received = make([]uuid.UUID, 0, len(incoming))
+		for productID := range incoming {
+			received = append(received, productID)
+		}
+		received = uniqueIDs(received)
+		stock, err := s.repo.GetWarehouseStockForUpdate(tx, received)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, productID := range received {
+			qty := incoming[productID]
+			current := findStock(stockOf(stock, productID), po.WarehouseID)
+			if err = s.repo.SetWarehouseStock(tx, po.WarehouseID, productID, current.Qty+qty); err != nil {
+				return err
+			}
+			err = s.recordMovement(tx, StockMovement{
+				WarehouseID: po.WarehouseID,
+				ProductID:   productID,
+				Type:        MovementReceipt,
+				QtyDelta:    qty,
+				ReasonCode:  ReasonGoodsReceipt,
+				Actor:       req.Actor,
+				Reference:   po.Number,
+				CreatedAt:   now,
+			})
+			if err != nil {
+				return err
+			}
+			line := &po.Lines[lines[productID]]
+			line.QtyReceived += qty
+			if err = s.repo.UpdatePurchaseOrderLine(tx, *line); err != nil {
+				return err
+			}
+			err = s.fulfilPreorders(tx, po.WarehouseID, productID, current.Qty+qty-current.Reserved, now)
+			if err != nil {
+				return err
+			}
+		}
+		po.Status = PurchaseOrderReceived
+		for _, line := range po.Lines {
+			if line.QtyReceived < line.QtyOrdered {
+				po.Status = PurchaseOrderPartial
+				break
+			}
+		}
+		po.UpdatedAt = now
+		return s.repo.UpdatePurchaseOrderStatus(tx, po.ID, po.Status)
+	})
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	s.stockChanged(received)
+	return po, nil
+}
+
+// CancelPurchaseOrder отменяет закупку. Уже принятый товар остается на складе.
+func (s *Service) CancelPurchaseOrder(id uuid.UUID) error {
+	return s.withTx("cancel purchase order", func(tx *sqlx.Tx) error {
+		po, err := s.activePurchaseOrderForUpdate(tx, id)
+		if err != nil {
+			return err
+		}
+		return s.repo.UpdatePurchaseOrderStatus(tx, po.ID, PurchaseOrderCancelled)
+	})
+}
+
+func (s *Service) activePurchaseOrderForUpdate(tx *sqlx.Tx, id uuid.UUID) (PurchaseOrder, error) {
+	po, err := s.repo.GetPurchaseOrderForUpdate(tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PurchaseOrder{}, &common.NotFoundError{Message: fmt.Sprintf("purchase order %s not found", id)}
+	}
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	if po.Status != PurchaseOrderOpen && po.Status != PurchaseOrderPartial {
+		return PurchaseOrder{}, &common.ConflictError{Message: fmt.Sprintf("purchase order %s is already %s", id, po.Status)}
+	}
+	return po, nil
+}
+
+// fulfilPreorders переводит предзаказы в обычные резервы, пока хватает доступного остатка.
+// Предзаказ резервируется целиком, очередь не перескакивается. Оплаченный предзаказ сразу
+// списывается: срок резерва ему не нужен, иначе уборщик снял бы товар оплаченного заказа.
+func (s *Service) fulfilPreorders(tx *sqlx.Tx, warehouseID, productID uuid.UUID, available int64, now time.Time) error {
+	preorders, err := s.repo.GetPreordersForUpdate(tx, warehouseID, productID)
+	if err != nil {
+		return err
+	}
+	for _, r := range preorders {
+		if r.Qty > available {
+			return nil
+		}
+		if err = s.repo.ReserveStock(tx, warehouseID, productID, r.Qty); err != nil {
+			return err
+		}
+		err = s.recordMovement(tx, StockMovement{
+			WarehouseID:   warehouseID,
+			ProductID:     productID,
+			Type:          MovementReserve,
+			ReservedDelta: r.Qty,
+			ReasonCode:    ReasonPreorderArrived,
+			Actor:         SystemActor,
+			Reference:     r.OrderID.String(),
+			CreatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+		available -= r.Qty
+		if r.State == Preordered {
+			if err = s.repo.ActivatePreorder(tx, r.ID, now.Add(s.cfg.TTL)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = s.repo.CommitStock(tx, warehouseID, productID, r.Qty); err != nil {
+			return err
+		}
+		if err = s.repo.UpdateReservationState(tx, r.ID, Committed); err != nil {
+			return err
+		}
+		err = s.recordMovement(tx, StockMovement{
+			WarehouseID:   warehouseID,
+			ProductID:     productID,
+			Type:          MovementShipment,
+			QtyDelta:      -r.Qty,
+			ReservedDelta: -r.Qty,
+			ReasonCode:    ReasonOrderPaid,
+			Actor:         SystemActor,
+			Reference:     r.OrderID.String(),
+			CreatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inboundCapacity ожидаемые поступления за вычетом уже оформленных предзаказов, ближайшие первыми
+func (s *Service) inboundCapacity(productIDs []uuid.UUID) ([]InboundSupply, error) {
+	supply, err := s.repo.GetInbound(productIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(supply) == 0 {
+		return nil, nil
+	}
+	preordered, err := s.repo.GetPreorderedQty(productIDs)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[[2]uuid.UUID]int64, len(preordered))
+	for _, p := range preordered {
+		taken[[2]uuid.UUID{p.ProductID, p.WarehouseID}] = p.Reserved
+	}
+	result := make([]InboundSupply, 0, len(supply))
+	for _, in := range supply {
+		in.Qty -= taken[[2]uuid.UUID{in.ProductID, in.WarehouseID}]
+		if in.Qty > 0 {
+			result = append(result, in)
+		}
+	}
+	return result, nil
+}
+
+// inboundByProduct суммарное свободное поступление по товару и дата ближайшего
+func (s *Service) inboundByProduct(productIDs []uuid.UUID) (map[uuid.UUID]InboundSupply, error) {
+	supply, err := s.inboundCapacity(productIDs)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[uuid.UUID]InboundSupply, len(supply))
+	for _, in := range supply {
+		total, ok := result[in.ProductID]
+		if !ok {
+			total = InboundSupply{ProductID: in.ProductID, ExpectedAt: in.ExpectedAt}
+		}
+		total.Qty += in.Qty
+		if in.ExpectedAt.Before(total.ExpectedAt) {
+			total.ExpectedAt = in.ExpectedAt
+		}
+		result[in.ProductID] = total
+	}
+	return result, nil
+}
+
+// allocatePreorders покрывает нехватку ожидаемыми поступлениями, начиная с ближайших
+func (s *Service) allocatePreorders(missing map[uuid.UUID]int64) ([]Allocation, map[uuid.UUID]int64, error) {
+	ids := make([]uuid.UUID, 0, len(missing))
+	for id := range missing {
+		ids = append(ids, id)
+	}
+	supply, err := s.inboundCapacity(uniqueIDs(ids))
+	if err != nil {
+		return nil, nil, err
+	}
+	remaining := copyLines(missing)
+	var allocations []Allocation
+	for _, in := range supply {
+		qty := min(remaining[in.ProductID], in.Qty)
+		if qty <= 0 {
+			continue
+		}
+		allocations = append(allocations, Allocation{
+			WarehouseID: in.WarehouseID,
+			ProductID:   in.ProductID,
+			Qty:         qty,
+			ExpectedAt:  in.ExpectedAt,
+		})
+		remaining[in.ProductID] -= qty
+		if remaining[in.ProductID] == 0 {
+			delete(remaining, in.ProductID)
+		}
+	}
+	return allocations, shortages(remaining), nil
+}
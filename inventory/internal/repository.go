@@ -1 +1,640 @@
 package internal
+
+import (
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+func (r *Repository) GetProductsByIds(ids []uuid.UUID) (ListItemsResponse, error) {
+	var items []Item
+	q, args, err := sqlx.In(`SELECT id, qty, reserved, qty - reserved AS available, version
+		FROM inventory_items WHERE id IN (?) AND deleted_at IS NULL ORDER BY id`, ids)
+	if err != nil {
+		return ListItemsResponse{}, err
+	}
+	q = r.db.Rebind(q)
+	err = r.db.Select(&items, q, args...)
+	if err != nil {
+		return ListItemsResponse{}, err
+	}
+	return ListItemsResponse{Items: items}, nil
+}
+
+func (r *Repository) GetProductById(id uuid.UUID) (Item, error) {
+	var item Item
+	err := r.db.Get(&item, `SELECT id, qty, reserved, qty - reserved AS available, version
+		FROM inventory_items WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// FindItemById учитывает и удаленные товары: id удаленного товара повторно не используется
+func (r *Repository) FindItemById(tx *sqlx.Tx, id uuid.UUID) (bool, error) {
+	var exists bool
+	err := tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM inventory_items WHERE id = $1)`, id)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (r *Repository) AddProduct(tx *sqlx.Tx, item AddItemRequest) error {
+	_, err := tx.Exec(`INSERT INTO inventory_items (id) VALUES ($1)`, item.Id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteProduct помечает товар удаленным. Остатки к этому моменту должны быть списаны через журнал.
+func (r *Repository) DeleteProduct(tx *sqlx.Tx, id uuid.UUID) error {
+	res, err := tx.Exec(`UPDATE inventory_items SET deleted_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *Repository) GetItemVersion(tx *sqlx.Tx, id uuid.UUID) (int64, error) {
+	var version int64
+	err := tx.Get(&version, `SELECT version FROM inventory_items WHERE id = $1`, id)
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func (r *Repository) CreateWarehouse(warehouse Warehouse) error {
+	_, err := r.db.Exec(`INSERT INTO warehouses (id, name, address, latitude, longitude, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		warehouse.ID, warehouse.Name, warehouse.Address, warehouse.Latitude, warehouse.Longitude, warehouse.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetWarehouses() ([]Warehouse, error) {
+	var warehouses []Warehouse
+	err := r.db.Select(&warehouses, `SELECT id, name, address, latitude, longitude, created_at
+		FROM warehouses ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	return warehouses, nil
+}
+
+func (r *Repository) GetWarehouseById(id uuid.UUID) (Warehouse, error) {
+	var warehouse Warehouse
+	err := r.db.Get(&warehouse, `SELECT id, name, address, latitude, longitude, created_at
+		FROM warehouses WHERE id = $1`, id)
+	if err != nil {
+		return Warehouse{}, err
+	}
+	return warehouse, nil
+}
+
+func (r *Repository) GetWarehouseStock(productIDs []uuid.UUID) ([]WarehouseStock, error) {
+	var stock []WarehouseStock
+	q, args, err := sqlx.In(`SELECT warehouse_id, product_id, qty, reserved, qty - reserved AS available
+		FROM warehouse_stock WHERE product_id IN (?) ORDER BY product_id, warehouse_id`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	q = r.db.Rebind(q)
+	err = r.db.Select(&stock, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return stock, nil
+}
+
+// GetWarehouseStockForUpdate блокирует строки в порядке (product_id, warehouse_id),
+// чтобы параллельные резервы не ловили дедлок
+func (r *Repository) GetWarehouseStockForUpdate(tx *sqlx.Tx, productIDs []uuid.UUID) ([]WarehouseStock, error) {
+	var stock []WarehouseStock
+	q, args, err := sqlx.In(`SELECT warehouse_id, product_id, qty, reserved, qty - reserved AS available
+		FROM warehouse_stock WHERE product_id IN (?) ORDER BY product_id, warehouse_id FOR UPDATE`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	q = tx.Rebind(q)
+	err = tx.Select(&stock, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return stock, nil
+}
+
+// SetWarehouseStock задает остаток товара на складе. Суммарный остаток в inventory_items пересчитывает триггер.
+func (r *Repository) SetWarehouseStock(tx *sqlx.Tx, warehouseID, productID uuid.UUID, qty int64) error {
+	_, err := tx.Exec(`INSERT INTO inventory_items (id) VALUES ($1) ON CONFLICT DO NOTHING`, productID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO warehouse_stock (warehouse_id, product_id, qty) VALUES ($1, $2, $3)
+		ON CONFLICT (warehouse_id, product_id) DO UPDATE SET qty = EXCLUDED.qty, updated_at = NOW()`,
+		warehouseID, productID, qty)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) ReserveStock(tx *sqlx.Tx, warehouseID, productID uuid.UUID, qty int64) error {
+	_, err := tx.Exec(`UPDATE warehouse_stock SET reserved = reserved + $1, updated_at = NOW()
+		WHERE warehouse_id = $2 AND product_id = $3`, qty, warehouseID, productID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) ReleaseStock(tx *sqlx.Tx, warehouseID, productID uuid.UUID, qty int64) error {
+	_, err := tx.Exec(`UPDATE warehouse_stock SET reserved = reserved - $1, updated_at = NOW()
+		WHERE warehouse_id = $2 AND product_id = $3`, qty, warehouseID, productID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) CommitStock(tx *sqlx.Tx, warehouseID, productID uuid.UUID, qty int64) error {
+	_, err := tx.Exec(`UPDATE warehouse_stock SET qty = qty - $1, reserved = reserved - $1, updated_at = NOW()
+		WHERE warehouse_id = $2 AND product_id = $3`, qty, warehouseID, productID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) ReturnStock(tx *sqlx.Tx, warehouseID, productID uuid.UUID, qty int64) error {
+	_, err := tx.Exec(`UPDATE warehouse_stock SET qty = qty + $1, updated_at = NOW()
+		WHERE warehouse_id = $2 AND product_id = $3`, qty, warehouseID, productID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) CreateReservation(tx *sqlx.Tx, reservation Reservation) error {
+	_, err := tx.Exec(`INSERT INTO reservations (id, order_id, product_id, warehouse_id, qty, state, expires_at,
+		created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		reservation.ID, reservation.OrderID, reservation.ProductID, reservation.WarehouseID, reservation.Qty, reservation.State,
+		reservation.ExpiresAt, reservation.CreatedAt, reservation.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetReservationsByOrder(tx *sqlx.Tx, orderID uuid.UUID) ([]Reservation, error) {
+	var reservations []Reservation
+	err := tx.Select(&reservations, `SELECT id, order_id, product_id, warehouse_id, qty, state, expires_at, created_at, updated_at
+		FROM reservations WHERE order_id = $1 ORDER BY product_id, warehouse_id FOR UPDATE`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// SplitReservation отделяет qty от резерва в новую запись в том же состоянии и возвращает ее
+func (r *Repository) SplitReservation(tx *sqlx.Tx, reservation Reservation, qty int64) (Reservation, error) {
+	_, err := tx.Exec(`UPDATE reservations SET qty = qty - $1, updated_at = NOW() WHERE id = $2`, qty, reservation.ID)
+	if err != nil {
+		return Reservation{}, err
+	}
+	part := reservation
+	part.ID = uuid.New()
+	part.Qty = qty
+	part.UpdatedAt = time.Now()
+	if err = r.CreateReservation(tx, part); err != nil {
+		return Reservation{}, err
+	}
+	return part, nil
+}
+
+func (r *Repository) GetOrderReservations(orderID uuid.UUID) ([]Reservation, error) {
+	var reservations []Reservation
+	err := r.db.Select(&reservations, `SELECT id, order_id, product_id, warehouse_id, qty, state, expires_at, created_at, updated_at
+		FROM reservations WHERE order_id = $1 ORDER BY created_at`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+func (r *Repository) UpdateReservationState(tx *sqlx.Tx, id uuid.UUID, state ReservationState) error {
+	_, err := tx.Exec(`UPDATE reservations SET state = $1, updated_at = NOW() WHERE id = $2`, state, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) FindExpiredReservations(tx *sqlx.Tx, now time.Time, limit int) ([]Reservation, error) {
+	var reservations []Reservation
+	err := tx.Select(&reservations, `SELECT id, order_id, product_id, warehouse_id, qty, state, expires_at, created_at, updated_at
+		FROM reservations WHERE state = $1 AND expires_at < $2
+		ORDER BY expires_at LIMIT $3 FOR UPDATE SKIP LOCKED`, Held, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+func (r *Repository) AddMovement(tx *sqlx.Tx, m StockMovement) error {
+	_, err := tx.Exec(`INSERT INTO stock_movements (id, warehouse_id, product_id, type, qty_delta, reserved_delta,
+		reason_code, actor, reference, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		m.ID, m.WarehouseID, m.ProductID, m.Type, m.QtyDelta, m.ReservedDelta, m.ReasonCode, m.Actor, m.Reference, m.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetMovements(filter MovementsFilter) ([]StockMovement, error) {
+	var movements []StockMovement
+	err := r.db.Select(&movements, `SELECT id, warehouse_id, product_id, type, qty_delta, reserved_delta,
+		reason_code, actor, reference, created_at
+		FROM stock_movements
+		WHERE product_id = $1
+		  AND ($2::uuid = '00000000-0000-0000-0000-000000000000'::uuid OR warehouse_id = $2::uuid)
+		ORDER BY created_at DESC, id LIMIT $3 OFFSET $4`,
+		filter.ProductID, filter.WarehouseID, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+// FindMovement движение по товару с той же ссылкой и типом, если оно уже проведено
+func (r *Repository) FindMovement(tx *sqlx.Tx, reference string, productID uuid.UUID, movementType MovementType) (StockMovement, error) {
+	var movement StockMovement
+	err := tx.Get(&movement, `SELECT id, warehouse_id, product_id, type, qty_delta, reserved_delta,
+		reason_code, actor, reference, created_at
+		FROM stock_movements WHERE reference = $1 AND product_id = $2 AND type = $3
+		ORDER BY created_at LIMIT 1`, reference, productID, movementType)
+	if err != nil {
+		return StockMovement{}, err
+	}
+	return movement, nil
+}
+
+// GetLedgerBalances остаток по складам, посчитанный по журналу движений
+func (r *Repository) GetLedgerBalances(productID uuid.UUID) ([]WarehouseStock, error) {
+	var balances []WarehouseStock
+	err := r.db.Select(&balances, `SELECT warehouse_id, product_id,
+		SUM(qty_delta) AS qty, SUM(reserved_delta) AS reserved, SUM(qty_delta) - SUM(reserved_delta) AS available
+		FROM stock_movements WHERE product_id = $1
+		GROUP BY warehouse_id, product_id ORDER BY warehouse_id`, productID)
+	if err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+func (r *Repository) SetReorderPoint(rp ReorderPoint) error {
+	_, err := r.db.Exec(`INSERT INTO reorder_points (product_id, reorder_point, safety_stock, reorder_qty, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (product_id) DO UPDATE SET reorder_point = EXCLUDED.reorder_point,
+			safety_stock = EXCLUDED.safety_stock, reorder_qty = EXCLUDED.reorder_qty, updated_at = EXCLUDED.updated_at`,
+		rp.ProductID, rp.ReorderPoint, rp.SafetyStock, rp.ReorderQty, rp.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetReorderPoint(productID uuid.UUID) (ReorderPoint, error) {
+	var rp ReorderPoint
+	err := r.db.Get(&rp, `SELECT product_id, reorder_point, safety_stock, reorder_qty, alerted_at, updated_at
+		FROM reorder_points WHERE product_id = $1`, productID)
+	if err != nil {
+		return ReorderPoint{}, err
+	}
+	return rp, nil
+}
+
+func (r *Repository) GetReorderStatus(productIDs []uuid.UUID) ([]ReorderStatus, error) {
+	var statuses []ReorderStatus
+	q, args, err := sqlx.In(`SELECT rp.product_id, i.qty - i.reserved AS available,
+		rp.reorder_point, rp.safety_stock, rp.reorder_qty, rp.alerted_at
+		FROM reorder_points rp JOIN inventory_items i ON i.id = rp.product_id
+		WHERE rp.product_id IN (?)`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	q = r.db.Rebind(q)
+	err = r.db.Select(&statuses, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// MarkLowStockAlerted отмечает, что по товару ушло уведомление. false - отметка уже стояла.
+func (r *Repository) MarkLowStockAlerted(productID uuid.UUID, at time.Time) (bool, error) {
+	res, err := r.db.Exec(`UPDATE reorder_points SET alerted_at = $1 WHERE product_id = $2 AND alerted_at IS NULL`,
+		at, productID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *Repository) ClearLowStockAlert(productID uuid.UUID) error {
+	_, err := r.db.Exec(`UPDATE reorder_points SET alerted_at = NULL WHERE product_id = $1`, productID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) CreateSubscription(sub StockSubscription) error {
+	_, err := r.db.Exec(`INSERT INTO stock_subscriptions (id, product_id, user_id, email, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (product_id, user_id) DO UPDATE SET email = EXCLUDED.email`,
+		sub.ID, sub.ProductID, sub.UserID, sub.Email, sub.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) DeleteSubscription(productID, userID uuid.UUID) (bool, error) {
+	res, err := r.db.Exec(`DELETE FROM stock_subscriptions WHERE product_id = $1 AND user_id = $2`, productID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ClaimSubscriptionsAfter блокирует следующую пачку подписок на товар после afterID (uuid.Nil - с начала).
+// Подписки, которые уже обрабатывает другая рассылка, пропускаются.
+func (r *Repository) ClaimSubscriptionsAfter(tx *sqlx.Tx, productID, afterID uuid.UUID, limit int) ([]StockSubscription, error) {
+	var subs []StockSubscription
+	err := tx.Select(&subs, `SELECT id, product_id, user_id, email, created_at
+		FROM stock_subscriptions WHERE product_id = $1 AND id > $2 ORDER BY id LIMIT $3
+		FOR UPDATE SKIP LOCKED`, productID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+func (r *Repository) DeleteSubscriptions(tx *sqlx.Tx, ids []uuid.UUID) error {
+	q, args, err := sqlx.In(`DELETE FROM stock_subscriptions WHERE id IN (?)`, ids)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(tx.Rebind(q), args...)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) CreateStocktake(tx *sqlx.Tx, st Stocktake) error {
+	_, err := tx.Exec(`INSERT INTO stocktakes (id, warehouse_id, status, opened_by, created_at)
+		VALUES ($1, $2, $3, $4, $5)`, st.ID, st.WarehouseID, st.Status, st.OpenedBy, st.CreatedAt)
+	if err != nil {
+		return err
+	}
+	for _, line := range st.Lines {
+		_, err = tx.Exec(`INSERT INTO stocktake_lines (stocktake_id, product_id) VALUES ($1, $2)`, st.ID, line.ProductID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) GetStocktake(id uuid.UUID) (Stocktake, error) {
+	var st Stocktake
+	err := r.db.Get(&st, `SELECT id, warehouse_id, status, opened_by, closed_by, created_at, closed_at
+		FROM stocktakes WHERE id = $1`, id)
+	if err != nil {
+		return Stocktake{}, err
+	}
+	err = r.db.Select(&st.Lines, `SELECT stocktake_id, product_id, counted_qty, system_qty, counted_by, counted_at
+		FROM stocktake_lines WHERE stocktake_id = $1 ORDER BY product_id`, id)
+	if err != nil {
+		return Stocktake{}, err
+	}
+	return st, nil
+}
+
+func (r *Repository) GetStocktakeForUpdate(tx *sqlx.Tx, id uuid.UUID) (Stocktake, error) {
+	var st Stocktake
+	err := tx.Get(&st, `SELECT id, warehouse_id, status, opened_by, closed_by, created_at, closed_at
+		FROM stocktakes WHERE id = $1 FOR UPDATE`, id)
+	if err != nil {
+		return Stocktake{}, err
+	}
+	err = tx.Select(&st.Lines, `SELECT stocktake_id, product_id, counted_qty, system_qty, counted_by, counted_at
+		FROM stocktake_lines WHERE stocktake_id = $1 ORDER BY product_id FOR UPDATE`, id)
+	if err != nil {
+		return Stocktake{}, err
+	}
+	return st, nil
+}
+
+// GetOpenStocktakeProducts товары из списка, которые уже пересчитываются на складе в другой открытой сессии
+func (r *Repository) GetOpenStocktakeProducts(tx *sqlx.Tx, warehouseID uuid.UUID, productIDs []uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	q, args, err := sqlx.In(`SELECT l.product_id FROM stocktake_lines l JOIN stocktakes s ON s.id = l.stocktake_id
+		WHERE s.warehouse_id = ? AND s.status = ? AND l.product_id IN (?)`, warehouseID, StocktakeOpen, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	q = tx.Rebind(q)
+	err = tx.Select(&ids, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *Repository) UpdateStocktakeLine(tx *sqlx.Tx, line StocktakeLine) error {
+	_, err := tx.Exec(`UPDATE stocktake_lines SET counted_qty = $1, system_qty = $2, counted_by = $3, counted_at = $4
+		WHERE stocktake_id = $5 AND product_id = $6`,
+		line.CountedQty, line.SystemQty, line.CountedBy, line.CountedAt, line.StocktakeID, line.ProductID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) CloseStocktake(tx *sqlx.Tx, id uuid.UUID, status StocktakeStatus, actor string, at time.Time) error {
+	_, err := tx.Exec(`UPDATE stocktakes SET status = $1, closed_by = $2, closed_at = $3 WHERE id = $4`,
+		status, actor, at, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) CreatePurchaseOrder(tx *sqlx.Tx, po PurchaseOrder) error {
+	_, err := tx.Exec(`INSERT INTO purchase_orders (id, number, supplier, warehouse_id, status, expected_at,
+		created_by, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		po.ID, po.Number, po.Supplier, po.WarehouseID, po.Status, po.ExpectedAt, po.CreatedBy, po.CreatedAt, po.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	for _, line := range po.Lines {
+		_, err = tx.Exec(`INSERT INTO purchase_order_lines (purchase_order_id, product_id, qty_ordered)
+			VALUES ($1, $2, $3)`, po.ID, line.ProductID, line.QtyOrdered)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) ExistsPurchaseOrderNumber(number string) (bool, error) {
+	var exists bool
+	err := r.db.Get(&exists, `SELECT EXISTS(SELECT 1 FROM purchase_orders WHERE number = $1)`, number)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (r *Repository) GetPurchaseOrder(id uuid.UUID) (PurchaseOrder, error) {
+	var po PurchaseOrder
+	err := r.db.Get(&po, `SELECT id, number, supplier, warehouse_id, status, expected_at, created_by, created_at, updated_at
+		FROM purchase_orders WHERE id = $1`, id)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	err = r.db.Select(&po.Lines, `SELECT purchase_order_id, product_id, qty_ordered, qty_received
+		FROM purchase_order_lines WHERE purchase_order_id = $1 ORDER BY product_id`, id)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	return po, nil
+}
+
+func (r *Repository) GetPurchaseOrderForUpdate(tx *sqlx.Tx, id uuid.UUID) (PurchaseOrder, error) {
+	var po PurchaseOrder
+	err := tx.Get(&po, `SELECT id, number, supplier, warehouse_id, status, expected_at, created_by, created_at, updated_at
+		FROM purchase_orders WHERE id = $1 FOR UPDATE`, id)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	err = tx.Select(&po.Lines, `SELECT purchase_order_id, product_id, qty_ordered, qty_received
+		FROM purchase_order_lines WHERE purchase_order_id = $1 ORDER BY product_id FOR UPDATE`, id)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	return po, nil
+}
+
+func (r *Repository) UpdatePurchaseOrderLine(tx *sqlx.Tx, line PurchaseOrderLine) error {
+	_, err := tx.Exec(`UPDATE purchase_order_lines SET qty_received = $1 WHERE purchase_order_id = $2 AND product_id = $3`,
+		line.QtyReceived, line.PurchaseOrderID, line.ProductID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) UpdatePurchaseOrderStatus(tx *sqlx.Tx, id uuid.UUID, status PurchaseOrderStatus) error {
+	_, err := tx.Exec(`UPDATE purchase_orders SET status = $1, updated_at = NOW() WHERE id = $2`, status, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetInbound ожидаемые поступления по открытым закупкам, ближайшие первыми
+func (r *Repository) GetInbound(productIDs []uuid.UUID) ([]InboundSupply, error) {
+	var supply []InboundSupply
+	q, args, err := sqlx.In(`SELECT l.product_id, p.warehouse_id, SUM(l.qty_ordered - l.qty_received) AS qty,
+		MIN(p.expected_at) AS expected_at
+		FROM purchase_order_lines l JOIN purchase_orders p ON p.id = l.purchase_order_id
+		WHERE p.status IN (?) AND l.qty_ordered > l.qty_received AND l.product_id IN (?)
+		GROUP BY l.product_id, p.warehouse_id ORDER BY expected_at, p.warehouse_id`,
+		[]PurchaseOrderStatus{PurchaseOrderOpen, PurchaseOrderPartial}, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	q = r.db.Rebind(q)
+	err = r.db.Select(&supply, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return supply, nil
+}
+
+// GetPreorderedQty сколько уже предзаказано по товарам, по складам
+func (r *Repository) GetPreorderedQty(productIDs []uuid.UUID) ([]WarehouseStock, error) {
+	var preordered []WarehouseStock
+	q, args, err := sqlx.In(`SELECT warehouse_id, product_id, 0 AS qty, SUM(qty) AS reserved, 0 AS available
+		FROM reservations WHERE state IN (?) AND product_id IN (?)
+		GROUP BY warehouse_id, product_id`, []ReservationState{Preordered, PreorderPaid}, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	q = r.db.Rebind(q)
+	err = r.db.Select(&preordered, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return preordered, nil
+}
+
+// GetPreordersForUpdate предзаказы товара на складе, оплаченные и нет, в порядке оформления
+func (r *Repository) GetPreordersForUpdate(tx *sqlx.Tx, warehouseID, productID uuid.UUID) ([]Reservation, error) {
+	var reservations []Reservation
+	err := tx.Select(&reservations, `SELECT id, order_id, product_id, warehouse_id, qty, state, expires_at, created_at, updated_at
+		FROM reservations WHERE state IN ($1, $2) AND warehouse_id = $3 AND product_id = $4
+		ORDER BY created_at FOR UPDATE`, Preordered, PreorderPaid, warehouseID, productID)
+	if err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// ActivatePreorder переводит предзаказ в обычный резерв после поступления товара
+func (r *Repository) ActivatePreorder(tx *sqlx.Tx, id uuid.UUID, expiresAt time.Time) error {
+	_, err := tx.Exec(`UPDATE reservations SET state = $1, expires_at = $2, updated_at = NOW() WHERE id = $3`,
+		Held, expiresAt, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
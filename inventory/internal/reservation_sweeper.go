@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"context"
+	"github.com/madrabit/mini-market/inventory/internal/common"
+	"go.uber.org/zap"
+	"time"
+)
+
+type ReservationExpirer interface {
+	ExpireReservations(now time.Time) (int, error)
+}
+
+// ReservationSweeper периодически снимает неоплаченные резервы, у которых истек TTL
+type ReservationSweeper struct {
+	svc      ReservationExpirer
+	logger   *common.Logger
+	interval time.Duration
+}
+
+func NewReservationSweeper(svc ReservationExpirer, logger *common.Logger, cfg common.ReservationConfig) *ReservationSweeper {
+	return &ReservationSweeper{svc: svc, logger: logger, interval: cfg.SweepInterval}
+}
+
+func (s *ReservationSweeper) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := s.svc.ExpireReservations(time.Now())
+			if err != nil {
+				s.logger.Error("failed to expire reservations", zap.Error(err))
+				continue
+			}
+			if expired > 0 {
+				s.logger.Info("reservations expired", zap.Int("count", expired))
+			}
+		}
+	}
+}
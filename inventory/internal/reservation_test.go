@@ -0,0 +1,308 @@
+package internal
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/inventory/internal/common"
+	"testing"
+	"time"
+)
+
+type okValidator struct{}
+
+func (okValidator) Validate(any) error { return nil }
+
+// stockChecks запоминает товары, по которым запрашивали проверку низкого остатка
+type stockChecks []uuid.UUID
+
+func (c *stockChecks) Check(productIDs []uuid.UUID) { *c = append(*c, productIDs...) }
+
+// reservationRepo хранит остатки и резервы в памяти
+type reservationRepo struct {
+	Repo
+	items        map[uuid.UUID]*Item
+	reservations []Reservation
+	movements    []StockMovement
+}
+
+func newReservationRepo(stock map[uuid.UUID]int64) *reservationRepo {
+	r := &reservationRepo{items: map[uuid.UUID]*Item{}}
+	for id, qty := range stock {
+		r.items[id] = &Item{ID: id, Qty: qty, Available: qty}
+	}
+	return r
+}
+
+func (r *reservationRepo) BeginTransaction() (*sqlx.Tx, error) { return beginNoopTx() }
+
+func (r *reservationRepo) GetWarehouses() ([]Warehouse, error) {
+	return []Warehouse{{ID: warehouseID, Name: "main"}}, nil
+}
+
+func (r *reservationRepo) GetWarehouseStockForUpdate(_ *sqlx.Tx, productIDs []uuid.UUID) ([]WarehouseStock, error) {
+	var stock []WarehouseStock
+	for _, id := range productIDs {
+		if item, ok := r.items[id]; ok {
+			stock = append(stock, WarehouseStock{WarehouseID: warehouseID, ProductID: id,
+				Qty: item.Qty, Reserved: item.Reserved, Available: item.Available})
+		}
+	}
+	return stock, nil
+}
+
+func (r *reservationRepo) ReserveStock(_ *sqlx.Tx, _, id uuid.UUID, qty int64) error {
+	r.items[id].Reserved += qty
+	r.items[id].Available -= qty
+	return nil
+}
+
+func (r *reservationRepo) ReleaseStock(_ *sqlx.Tx, _, id uuid.UUID, qty int64) error {
+	r.items[id].Reserved -= qty
+	r.items[id].Available += qty
+	return nil
+}
+
+func (r *reservationRepo) CommitStock(_ *sqlx.Tx, _, id uuid.UUID, qty int64) error {
+	r.items[id].Reserved -= qty
+	r.items[id].Qty -= qty
+	return nil
+}
+
+func (r *reservationRepo) ReturnStock(_ *sqlx.Tx, _, id uuid.UUID, qty int64) error {
+	r.items[id].Qty += qty
+	r.items[id].Available += qty
+	return nil
+}
+
+func (r *reservationRepo) CreateReservation(_ *sqlx.Tx, reservation Reservation) error {
+	r.reservations = append(r.reservations, reservation)
+	return nil
+}
+
+func (r *reservationRepo) GetReservationsByOrder(_ *sqlx.Tx, orderID uuid.UUID) ([]Reservation, error) {
+	var res []Reservation
+	for _, rv := range r.reservations {
+		if rv.OrderID == orderID {
+			res = append(res, rv)
+		}
+	}
+	return res, nil
+}
+
+func (r *reservationRepo) GetOrderReservations(orderID uuid.UUID) ([]Reservation, error) {
+	return r.GetReservationsByOrder(nil, orderID)
+}
+
+func (r *reservationRepo) UpdateReservationState(_ *sqlx.Tx, id uuid.UUID, state ReservationState) error {
+	for i := range r.reservations {
+		if r.reservations[i].ID == id {
+			r.reservations[i].State = state
+		}
+	}
+	return nil
+}
+
+func (r *reservationRepo) FindExpiredReservations(_ *sqlx.Tx, now time.Time, limit int) ([]Reservation, error) {
+	var res []Reservation
+	for _, rv := range r.reservations {
+		if rv.State == Held && rv.ExpiresAt.Before(now) && len(res) < limit {
+			res = append(res, rv)
+		}
+	}
+	return res, nil
+}
+
+func (r *reservationRepo) AddMovement(_ *sqlx.Tx, movement StockMovement) error {
+	r.movements = append(r.movements, movement)
+	return nil
+}
+
+func (r *reservationRepo) countMovements(t MovementType) int {
+	n := 0
+	for _, m := range r.movements {
+		if m.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *reservationRepo) states(orderID uuid.UUID) map[uuid.UUID]ReservationState {
+	states := map[uuid.UUID]ReservationState{}
+	for _, rv := range r.reservations {
+		if rv.OrderID == orderID {
+			states[rv.ProductID] = rv.State
+		}
+	}
+	return states
+}
+
+var (
+	warehouseID = uuid.MustParse("00000000-0000-0000-0000-0000000000f0")
+	productA    = uuid.MustParse("00000000-0000-0000-0000-00000000000a")
+	productB    = uuid.MustParse("00000000-0000-0000-0000-00000000000b")
+)
+
+func newReservationService(t *testing.T) (*Service, *reservationRepo, uuid.UUID) {
+	t.Helper()
+	repo := newReservationRepo(map[uuid.UUID]int64{productA: 10, productB: 5})
+	svc := NewService(repo, okValidator{}, common.ReservationConfig{TTL: 15 * time.Minute, SweepBatch: 100}, &stockChecks{})
+	orderID := uuid.New()
+	for _, req := range []ReserveItemRequest{{Id: productA, Qty: 3, OrderID: orderID}, {Id: productB, Qty: 2, OrderID: orderID}} {
+		if _, err := svc.ReserveProducts(req); err != nil {
+			t.Fatalf("reserve %s: %v", req.Id, err)
+		}
+	}
+	return svc, repo, orderID
+}
+
+func TestReserveProducts(t *testing.T) {
+	svc, repo, _ := newReservationService(t)
+	if a := repo.items[productA]; a.Reserved != 3 || a.Available != 7 {
+		t.Errorf("product A reserved/available = %d/%d, want 3/7", a.Reserved, a.Available)
+	}
+	for _, r := range repo.reservations {
+		if r.State != Held {
+			t.Errorf("reservation %s state = %s, want held", r.ProductID, r.State)
+		}
+		if ttl := r.ExpiresAt.Sub(r.CreatedAt); ttl != 15*time.Minute {
+			t.Errorf("reservation %s ttl = %s, want 15m", r.ProductID, ttl)
+		}
+	}
+
+	_, err := svc.ReserveProducts(ReserveItemRequest{Id: productB, Qty: 4, OrderID: uuid.New()})
+	var conflict *common.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("reserve above available: err = %v, want ConflictError", err)
+	}
+	if b := repo.items[productB]; b.Available != 3 {
+		t.Errorf("product B available = %d, want 3", b.Available)
+	}
+}
+
+func TestReserveOrderIsIdempotent(t *testing.T) {
+	svc, repo, orderID := newReservationService(t)
+	req := BatchReserveRequest{OrderID: orderID, Lines: []ReserveLine{{Id: productA, Qty: 3}, {Id: productB, Qty: 2}}}
+	reservations, err := svc.ReserveOrder(req)
+	if err != nil {
+		t.Fatalf("repeat reserve: %v", err)
+	}
+	if len(reservations) != 2 || len(repo.reservations) != 2 {
+		t.Errorf("reservations returned/stored = %d/%d, want 2/2", len(reservations), len(repo.reservations))
+	}
+	if a := repo.items[productA]; a.Reserved != 3 {
+		t.Errorf("product A reserved = %d, want 3", a.Reserved)
+	}
+
+	// снятый резерв не мешает зарезервировать товар заново
+	if err = svc.ReleaseProducts(ReliesItemRequest{Id: productB, OrderID: orderID}); err != nil {
+		t.Fatalf("release product B: %v", err)
+	}
+	if _, err = svc.ReserveOrder(req); err != nil {
+		t.Fatalf("reserve after release: %v", err)
+	}
+	if b := repo.items[productB]; b.Reserved != 2 || len(repo.reservations) != 3 {
+		t.Errorf("product B reserved = %d, reservations %d; want 2, 3", b.Reserved, len(repo.reservations))
+	}
+}
+
+func TestCommitReservations(t *testing.T) {
+	svc, repo, orderID := newReservationService(t)
+	if err := svc.CommitReservations(orderID); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	for product, state := range repo.states(orderID) {
+		if state != Committed {
+			t.Errorf("product %s state = %s, want committed", product, state)
+		}
+	}
+	if a := repo.items[productA]; a.Qty != 7 || a.Reserved != 0 || a.Available != 7 {
+		t.Errorf("product A qty/reserved/available = %d/%d/%d, want 7/0/7", a.Qty, a.Reserved, a.Available)
+	}
+	if n := repo.countMovements(MovementShipment); n != 2 {
+		t.Errorf("shipment movements = %d, want 2", n)
+	}
+
+	// повторное подтверждение ничего не списывает
+	if err := svc.CommitReservations(orderID); err != nil {
+		t.Fatalf("repeated commit: %v", err)
+	}
+	if a := repo.items[productA]; a.Qty != 7 || repo.countMovements(MovementShipment) != 2 {
+		t.Errorf("repeated commit changed stock: qty %d, shipments %d", a.Qty, repo.countMovements(MovementShipment))
+	}
+
+	// отмена оплаченного, но не отгруженного заказа возвращает списанный товар
+	if err := svc.ReleaseProducts(ReliesItemRequest{OrderID: orderID}); err != nil {
+		t.Fatalf("release committed: %v", err)
+	}
+	if a := repo.items[productA]; a.Qty != 10 || a.Available != 10 || repo.countMovements(MovementReturn) != 2 {
+		t.Errorf("product A qty/available = %d/%d, returns %d; want 10/10, 2", a.Qty, a.Available, repo.countMovements(MovementReturn))
+	}
+	if state := repo.states(orderID)[productA]; state != Released {
+		t.Errorf("state after return = %s, want released", state)
+	}
+
+	// отмененные строки при оплате пропускаются
+	if err := svc.CommitReservations(orderID); err != nil {
+		t.Fatalf("commit released: %v", err)
+	}
+	if state := repo.states(orderID)[productA]; state != Released || repo.countMovements(MovementShipment) != 2 {
+		t.Errorf("state %s, shipments %d; want released, 2", state, repo.countMovements(MovementShipment))
+	}
+}
+
+func TestReleaseProducts(t *testing.T) {
+	svc, repo, orderID := newReservationService(t)
+	if err := svc.ReleaseProducts(ReliesItemRequest{Id: productB, OrderID: orderID}); err != nil {
+		t.Fatalf("release product B: %v", err)
+	}
+	states := repo.states(orderID)
+	if states[productA] != Held || states[productB] != Released {
+		t.Errorf("states A/B = %s/%s, want held/released", states[productA], states[productB])
+	}
+	if b := repo.items[productB]; b.Reserved != 0 || b.Available != 5 {
+		t.Errorf("product B reserved/available = %d/%d, want 0/5", b.Reserved, b.Available)
+	}
+
+	var notFound *common.NotFoundError
+	if err := svc.ReleaseProducts(ReliesItemRequest{OrderID: uuid.New()}); !errors.As(err, &notFound) {
+		t.Errorf("release unknown order: err = %v, want NotFoundError", err)
+	}
+}
+
+func TestExpireReservations(t *testing.T) {
+	svc, repo, orderID := newReservationService(t)
+	if err := svc.CommitReservations(orderID); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	stale := uuid.New()
+	if _, err := svc.ReserveProducts(ReserveItemRequest{Id: productA, Qty: 4, OrderID: stale}); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+
+	expired, err := svc.ExpireReservations(time.Now())
+	if err != nil || expired != 0 {
+		t.Fatalf("expire before ttl = %d, %v; want 0, nil", expired, err)
+	}
+	expired, err = svc.ExpireReservations(time.Now().Add(time.Hour))
+	if err != nil || expired != 1 {
+		t.Fatalf("expire after ttl = %d, %v; want 1, nil", expired, err)
+	}
+	if state := repo.states(stale)[productA]; state != Expired {
+		t.Errorf("stale reservation state = %s, want expired", state)
+	}
+	if state := repo.states(orderID)[productA]; state != Committed {
+		t.Errorf("paid reservation state = %s, want committed", state)
+	}
+	if a := repo.items[productA]; a.Reserved != 0 || a.Available != 7 {
+		t.Errorf("product A reserved/available = %d/%d, want 0/7", a.Reserved, a.Available)
+	}
+
+	// оплата после истечения резерва не списывает товар
+	err = svc.CommitReservations(stale)
+	var conflict *common.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("commit expired: err = %v, want ConflictError", err)
+	}
+}
@@ -1,17 +1,22 @@
 package internal
 
 import (
+	"bytes"
 	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/madrabit/mini-market/inventory/internal/common"
+	"sort"
+	"time"
 )
 
 type Service struct {
 	repo      Repo
 	validator Validator
+	cfg       common.ReservationConfig
+	watchers  []StockWatcher
 }
 
 type Repo interface {
@@ -19,56 +24,99 @@ type Repo interface {
 	FindItemById(tx *sqlx.Tx, productID uuid.UUID) (bool, error)
 	GetProductsByIds(IDs []uuid.UUID) (ListItemsResponse, error)
 	AddProduct(tx *sqlx.Tx, item AddItemRequest) error
-	UpdateProduct(tx *sqlx.Tx, item UpdateItemRequest) error
-	DeleteProduct(id uuid.UUID) error
+	DeleteProduct(tx *sqlx.Tx, id uuid.UUID) error
 	GetProductById(id uuid.UUID) (Item, error)
-	ReserveProducts(tx *sqlx.Tx, item ReserveItemRequest) error
-	ReleaseProducts(tx *sqlx.Tx, item ReliesItemRequest) error
+	GetItemVersion(tx *sqlx.Tx, id uuid.UUID) (int64, error)
+	CreateWarehouse(warehouse Warehouse) error
+	GetWarehouses() ([]Warehouse, error)
+	GetWarehouseById(id uuid.UUID) (Warehouse, error)
+	GetWarehouseStock(productIDs []uuid.UUID) ([]WarehouseStock, error)
+	GetWarehouseStockForUpdate(tx *sqlx.Tx, productIDs []uuid.UUID) ([]WarehouseStock, error)
+	FindMovement(tx *sqlx.Tx, reference string, productID uuid.UUID, movementType MovementType) (StockMovement, error)
+	SetWarehouseStock(tx *sqlx.Tx, warehouseID, productID uuid.UUID, qty int64) error
+	ReserveStock(tx *sqlx.Tx, warehouseID, productID uuid.UUID, qty int64) error
+	ReleaseStock(tx *sqlx.Tx, warehouseID, productID uuid.UUID, qty int64) error
+	CommitStock(tx *sqlx.Tx, warehouseID, productID uuid.UUID, qty int64) error
+	ReturnStock(tx *sqlx.Tx, warehouseID, productID uuid.UUID, qty int64) error
+	AddMovement(tx *sqlx.Tx, movement StockMovement) error
+	GetMovements(filter MovementsFilter) ([]StockMovement, error)
+	GetLedgerBalances(productID uuid.UUID) ([]WarehouseStock, error)
+	CreateReservation(tx *sqlx.Tx, reservation Reservation) error
+	GetReservationsByOrder(tx *sqlx.Tx, orderID uuid.UUID) ([]Reservation, error)
+	SplitReservation(tx *sqlx.Tx, reservation Reservation, qty int64) (Reservation, error)
+	GetOrderReservations(orderID uuid.UUID) ([]Reservation, error)
+	UpdateReservationState(tx *sqlx.Tx, id uuid.UUID, state ReservationState) error
+	FindExpiredReservations(tx *sqlx.Tx, now time.Time, limit int) ([]Reservation, error)
+	SetReorderPoint(rp ReorderPoint) error
+	GetReorderPoint(productID uuid.UUID) (ReorderPoint, error)
+	CreateSubscription(sub StockSubscription) error
+	DeleteSubscription(productID, userID uuid.UUID) (bool, error)
+	CreateStocktake(tx *sqlx.Tx, st Stocktake) error
+	GetStocktake(id uuid.UUID) (Stocktake, error)
+	GetStocktakeForUpdate(tx *sqlx.Tx, id uuid.UUID) (Stocktake, error)
+	GetOpenStocktakeProducts(tx *sqlx.Tx, warehouseID uuid.UUID, productIDs []uuid.UUID) ([]uuid.UUID, error)
+	UpdateStocktakeLine(tx *sqlx.Tx, line StocktakeLine) error
+	CloseStocktake(tx *sqlx.Tx, id uuid.UUID, status StocktakeStatus, actor string, at time.Time) error
+	CreatePurchaseOrder(tx *sqlx.Tx, po PurchaseOrder) error
+	ExistsPurchaseOrderNumber(number string) (bool, error)
+	GetPurchaseOrder(id uuid.UUID) (PurchaseOrder, error)
+	GetPurchaseOrderForUpdate(tx *sqlx.Tx, id uuid.UUID) (PurchaseOrder, error)
+	UpdatePurchaseOrderLine(tx *sqlx.Tx, line PurchaseOrderLine) error
+	UpdatePurchaseOrderStatus(tx *sqlx.Tx, id uuid.UUID, status PurchaseOrderStatus) error
+	GetInbound(productIDs []uuid.UUID) ([]InboundSupply, error)
+	GetPreorderedQty(productIDs []uuid.UUID) ([]WarehouseStock, error)
+	GetPreordersForUpdate(tx *sqlx.Tx, warehouseID, productID uuid.UUID) ([]Reservation, error)
+	ActivatePreorder(tx *sqlx.Tx, id uuid.UUID, expiresAt time.Time) error
+}
+
+// StockWatcher вызывается после операций, меняющих доступный остаток: низкий остаток, поступление товара
+type StockWatcher interface {
+	Check(productIDs []uuid.UUID)
 }
 
 type Validator interface {
 	Validate(request any) error
 }
 
-func NewService(repo Repo, validator Validator) *Service {
-	return &Service{repo, validator}
+func NewService(repo Repo, validator Validator, cfg common.ReservationConfig, watchers ...StockWatcher) *Service {
+	return &Service{repo, validator, cfg, watchers}
 }
 
-func (s *Service) AddProduct(item AddItemRequest) (err error) {
-	if err = s.validator.Validate(item); err != nil {
+func (s *Service) AddProduct(item AddItemRequest) error {
+	if err := s.validator.Validate(item); err != nil {
 		return &common.RequestValidationError{Message: err.Error()}
 	}
-	tx, err := s.repo.BeginTransaction()
+	warehouseID := item.WarehouseID
+	if warehouseID == uuid.Nil {
+		warehouseID = DefaultWarehouseID
+	}
+	_, err := s.repo.GetWarehouseById(warehouseID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &common.NotFoundError{Message: fmt.Sprintf("warehouse with id %s not found", warehouseID)}
+	}
 	if err != nil {
-		return fmt.Errorf("inventory service: add product: error starting transaction")
+		return fmt.Errorf("inventory service: add product: %w", err)
 	}
-	defer func() {
-		if p := recover(); p != nil {
-			_ = tx.Rollback()
-			err = fmt.Errorf("inventory service: add product:  panic add product: %v", p)
-			return
+	err = s.withTx("add product", func(tx *sqlx.Tx) error {
+		isExists, err := s.repo.FindItemById(tx, item.Id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("error checking exists of product")
 		}
-		if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				err = fmt.Errorf("rollback failed: original error: %w", err)
-			}
-			return
+		if isExists {
+			return &common.AlreadyExistsError{Message: fmt.Sprintf("product with id %s already exists", item.Id)}
 		}
-		if commitErr := tx.Commit(); commitErr != nil {
-			err = fmt.Errorf("inventory service: add product: committing transaction failed: %w", commitErr)
+		if err = s.repo.AddProduct(tx, item); err != nil {
+			return fmt.Errorf("error adding product")
 		}
-	}()
-	isExists, err := s.repo.FindItemById(tx, item.Id)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return fmt.Errorf("inventory service: add product: error checking exists of product")
-	}
-	if isExists {
-		return &common.AlreadyExistsError{Message: fmt.Sprintf("product with id %s already exists", item.Id)}
-	}
-	err = s.repo.AddProduct(tx, item)
+		// начальный остаток проводится через журнал, чтобы баланс по движениям сходился с warehouse_stock
+		return s.changeStock(tx, warehouseID, item.Id, 0,
+			func(WarehouseStock) int64 { return item.Qty },
+			StockMovement{ReasonCode: ReasonOpeningBalance, Actor: SystemActor})
+	})
 	if err != nil {
-		return fmt.Errorf("inventory service: add product: error adding product")
+		return err
 	}
+	s.stockChanged([]uuid.UUID{item.Id})
 	return nil
 }
 
@@ -80,116 +128,851 @@ func (s *Service) GetProductsByIds(IDs []uuid.UUID) (ListItemsResponse, error) {
 	if err != nil {
 		return ListItemsResponse{}, fmt.Errorf("inventory service: failed to get product by ids: %w", err)
 	}
+	stock, err := s.repo.GetWarehouseStock(IDs)
+	if err != nil {
+		return ListItemsResponse{}, fmt.Errorf("inventory service: failed to get warehouse stock: %w", err)
+	}
+	byProduct := make(map[uuid.UUID][]WarehouseStock, len(product.Items))
+	for _, ws := range stock {
+		byProduct[ws.ProductID] = append(byProduct[ws.ProductID], ws)
+	}
+	inbound, err := s.inboundByProduct(IDs)
+	if err != nil {
+		return ListItemsResponse{}, fmt.Errorf("inventory service: failed to get inbound: %w", err)
+	}
+	for i := range product.Items {
+		item := &product.Items[i]
+		item.Warehouses = byProduct[item.ID]
+		if in, ok := inbound[item.ID]; ok {
+			item.Inbound, item.AvailableFrom = in.Qty, &in.ExpectedAt
+		}
+	}
 	return product, nil
 }
 
+func (s *Service) CreateWarehouse(req CreateWarehouseRequest) (Warehouse, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return Warehouse{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	warehouse := Warehouse{
+		ID:        uuid.New(),
+		Name:      req.Name,
+		Address:   req.Address,
+		Location:  req.Location,
+		CreatedAt: time.Now(),
+	}
+	if err := s.repo.CreateWarehouse(warehouse); err != nil {
+		return Warehouse{}, fmt.Errorf("inventory service: create warehouse: %w", err)
+	}
+	return warehouse, nil
+}
+
+func (s *Service) GetWarehouses() ([]Warehouse, error) {
+	warehouses, err := s.repo.GetWarehouses()
+	if err != nil {
+		return nil, fmt.Errorf("inventory service: get warehouses: %w", err)
+	}
+	return warehouses, nil
+}
+
+// SetWarehouseStock задает остаток товара на складе. Нельзя опустить остаток ниже уже зарезервированного.
+func (s *Service) SetWarehouseStock(warehouseID, productID uuid.UUID, req SetWarehouseStockRequest) error {
+	if err := s.validator.Validate(req); err != nil {
+		return &common.RequestValidationError{Message: err.Error()}
+	}
+	if warehouseID == uuid.Nil || productID == uuid.Nil {
+		return &common.RequestValidationError{Message: "invalid id"}
+	}
+	_, err := s.repo.GetWarehouseById(warehouseID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &common.NotFoundError{Message: fmt.Sprintf("warehouse with id %s not found", warehouseID)}
+	}
+	if err != nil {
+		return fmt.Errorf("inventory service: set warehouse stock: %w", err)
+	}
+	err = s.withTx("set warehouse stock", func(tx *sqlx.Tx) error {
+		return s.changeStock(tx, warehouseID, productID, req.Version,
+			func(WarehouseStock) int64 { return req.Qty },
+			StockMovement{ReasonCode: req.ReasonCode, Actor: req.Actor, Reference: req.Reference})
+	})
+	if err != nil {
+		return err
+	}
+	s.stockChanged([]uuid.UUID{productID})
+	return nil
+}
+
+func (s *Service) GetReorderPoint(productID uuid.UUID) (ReorderPoint, error) {
+	if productID == uuid.Nil {
+		return ReorderPoint{}, &common.RequestValidationError{Message: "invalid product id"}
+	}
+	rp, err := s.repo.GetReorderPoint(productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ReorderPoint{}, &common.NotFoundError{Message: fmt.Sprintf("reorder point for product %s not found", productID)}
+	}
+	if err != nil {
+		return ReorderPoint{}, fmt.Errorf("inventory service: get reorder point: %w", err)
+	}
+	return rp, nil
+}
+
+// SetReorderPoint задает порог пополнения и сразу проверяет текущий остаток по новому порогу
+func (s *Service) SetReorderPoint(productID uuid.UUID, req SetReorderPointRequest) (ReorderPoint, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return ReorderPoint{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	if productID == uuid.Nil {
+		return ReorderPoint{}, &common.RequestValidationError{Message: "invalid product id"}
+	}
+	rp := ReorderPoint{
+		ProductID:    productID,
+		ReorderPoint: req.ReorderPoint,
+		SafetyStock:  req.SafetyStock,
+		ReorderQty:   req.ReorderQty,
+		UpdatedAt:    time.Now(),
+	}
+	if err := s.repo.SetReorderPoint(rp); err != nil {
+		return ReorderPoint{}, fmt.Errorf("inventory service: set reorder point: %w", err)
+	}
+	s.stockChanged([]uuid.UUID{productID})
+	return rp, nil
+}
+
+// Subscribe подписывает покупателя на поступление товара. Подписаться можно только на товар, которого нет в наличии.
+func (s *Service) Subscribe(productID uuid.UUID, req SubscribeRequest) (StockSubscription, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return StockSubscription{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	if productID == uuid.Nil {
+		return StockSubscription{}, &common.RequestValidationError{Message: "invalid product id"}
+	}
+	items, err := s.repo.GetProductsByIds([]uuid.UUID{productID})
+	if err != nil {
+		return StockSubscription{}, fmt.Errorf("inventory service: subscribe: %w", err)
+	}
+	for _, item := range items.Items {
+		if item.Available > 0 {
+			return StockSubscription{}, &common.ConflictError{Message: fmt.Sprintf("product %s is in stock", productID)}
+		}
+	}
+	sub := StockSubscription{
+		ID:        uuid.New(),
+		ProductID: productID,
+		UserID:    req.UserID,
+		Email:     req.Email,
+		CreatedAt: time.Now(),
+	}
+	if err = s.repo.CreateSubscription(sub); err != nil {
+		return StockSubscription{}, fmt.Errorf("inventory service: subscribe: %w", err)
+	}
+	return sub, nil
+}
+
+func (s *Service) Unsubscribe(productID, userID uuid.UUID) error {
+	if productID == uuid.Nil || userID == uuid.Nil {
+		return &common.RequestValidationError{Message: "invalid id"}
+	}
+	deleted, err := s.repo.DeleteSubscription(productID, userID)
+	if err != nil {
+		return fmt.Errorf("inventory service: unsubscribe: %w", err)
+	}
+	if !deleted {
+		return &common.NotFoundError{Message: fmt.Sprintf("subscription to product %s not found", productID)}
+	}
+	return nil
+}
+
+// RecordMovement проводит приемку, возврат или корректировку и пишет движение в журнал
+func (s *Service) RecordMovement(req RecordMovementRequest) (StockMovement, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return StockMovement{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	if req.Type != MovementAdjustment && req.Qty < 0 {
+		return StockMovement{}, &common.RequestValidationError{Message: fmt.Sprintf("%s qty must be positive", req.Type)}
+	}
+	_, err := s.repo.GetWarehouseById(req.WarehouseID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return StockMovement{}, &common.NotFoundError{Message: fmt.Sprintf("warehouse with id %s not found", req.WarehouseID)}
+	}
+	if err != nil {
+		return StockMovement{}, fmt.Errorf("inventory service: record movement: %w", err)
+	}
+	movement := StockMovement{
+		WarehouseID: req.WarehouseID,
+		ProductID:   req.ProductID,
+		Type:        req.Type,
+		QtyDelta:    req.Qty,
+		ReasonCode:  req.ReasonCode,
+		Actor:       req.Actor,
+		Reference:   req.Reference,
+		ID:          uuid.New(),
+		CreatedAt:   time.Now(),
+	}
+	var replay bool
+	err = s.withTx("record movement", func(tx *sqlx.Tx) error {
+		stock, err := s.repo.GetWarehouseStockForUpdate(tx, []uuid.UUID{req.ProductID})
+		if err != nil {
+			return err
+		}
+		if req.Reference != "" {
+			// остаток товара заблокирован, так что два одинаковых запроса не проведут движение дважды
+			done, err := s.repo.FindMovement(tx, req.Reference, req.ProductID, req.Type)
+			if err == nil {
+				movement, replay = done, true
+				return nil
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+		current := findStock(stock, req.WarehouseID)
+		qty := current.Qty + req.Qty
+		if qty < current.Reserved {
+			return &common.ConflictError{Message: fmt.Sprintf("qty %d is below reserved %d", qty, current.Reserved)}
+		}
+		if err = s.repo.SetWarehouseStock(tx, req.WarehouseID, req.ProductID, qty); err != nil {
+			return err
+		}
+		return s.recordMovement(tx, movement)
+	})
+	if err != nil {
+		return StockMovement{}, err
+	}
+	if !replay {
+		s.stockChanged([]uuid.UUID{req.ProductID})
+	}
+	return movement, nil
+}
+
+func (s *Service) GetMovements(filter MovementsFilter) ([]StockMovement, error) {
+	if filter.ProductID == uuid.Nil {
+		return nil, &common.RequestValidationError{Message: "invalid product id"}
+	}
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	movements, err := s.repo.GetMovements(filter)
+	if err != nil {
+		return nil, fmt.Errorf("inventory service: get movements: %w", err)
+	}
+	return movements, nil
+}
+
+// ReconcileStock сверяет остатки товара по складам с суммой движений в журнале
+func (s *Service) ReconcileStock(productID uuid.UUID) ([]StockReconciliation, error) {
+	if productID == uuid.Nil {
+		return nil, &common.RequestValidationError{Message: "invalid product id"}
+	}
+	stock, err := s.repo.GetWarehouseStock([]uuid.UUID{productID})
+	if err != nil {
+		return nil, fmt.Errorf("inventory service: reconcile stock: %w", err)
+	}
+	balances, err := s.repo.GetLedgerBalances(productID)
+	if err != nil {
+		return nil, fmt.Errorf("inventory service: reconcile stock: %w", err)
+	}
+	result := make([]StockReconciliation, 0, len(stock))
+	seen := make(map[uuid.UUID]bool, len(stock))
+	for _, ws := range stock {
+		ledger := findStock(balances, ws.WarehouseID)
+		seen[ws.WarehouseID] = true
+		result = append(result, reconciliation(ws.WarehouseID, productID, ws, ledger))
+	}
+	// движения по складу, где строки остатка уже нет
+	for _, ledger := range balances {
+		if !seen[ledger.WarehouseID] {
+			result = append(result, reconciliation(ledger.WarehouseID, productID, WarehouseStock{}, ledger))
+		}
+	}
+	return result, nil
+}
+
+func reconciliation(warehouseID, productID uuid.UUID, actual, ledger WarehouseStock) StockReconciliation {
+	return StockReconciliation{
+		WarehouseID:    warehouseID,
+		ProductID:      productID,
+		Qty:            actual.Qty,
+		Reserved:       actual.Reserved,
+		LedgerQty:      ledger.Qty,
+		LedgerReserved: ledger.Reserved,
+		InSync:         actual.Qty == ledger.Qty && actual.Reserved == ledger.Reserved,
+	}
+}
+
+func findStock(stock []WarehouseStock, warehouseID uuid.UUID) WarehouseStock {
+	for _, ws := range stock {
+		if ws.WarehouseID == warehouseID {
+			return ws
+		}
+	}
+	return WarehouseStock{WarehouseID: warehouseID}
+}
+
+func (s *Service) recordMovement(tx *sqlx.Tx, movement StockMovement) error {
+	if movement.ID == uuid.Nil {
+		movement.ID = uuid.New()
+	}
+	if movement.CreatedAt.IsZero() {
+		movement.CreatedAt = time.Now()
+	}
+	return s.repo.AddMovement(tx, movement)
+}
+
 func (s *Service) GetProductById(productId uuid.UUID) (Item, error) {
 	if productId == uuid.Nil {
 		return Item{}, errors.New("inventory service: invalid id")
 	}
 	product, err := s.repo.GetProductById(productId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Item{}, &common.NotFoundError{Message: fmt.Sprintf("product with id %s not found", productId)}
+	}
 	if err != nil {
 		return Item{}, fmt.Errorf("inventory service: failed to get product by id: %w", err)
 	}
 	return product, nil
 }
 
-func (s *Service) UpdateProduct(item UpdateItemRequest) error {
+// UpdateProduct задает остаток товара на складе или меняет его на Delta.
+// Если передана версия, изменение пройдет только при совпадении с текущей.
+func (s *Service) UpdateProduct(item UpdateItemRequest) (Item, error) {
 	if err := s.validator.Validate(item); err != nil {
-		return &common.RequestValidationError{Message: err.Error()}
+		return Item{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	_, err := s.repo.GetWarehouseById(item.WarehouseID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Item{}, &common.NotFoundError{Message: fmt.Sprintf("warehouse with id %s not found", item.WarehouseID)}
 	}
-	tx, err := s.repo.BeginTransaction()
 	if err != nil {
-		return fmt.Errorf("inventory service: update product: error starting transaction")
+		return Item{}, fmt.Errorf("inventory service: update product: %w", err)
 	}
-	defer func() {
-		if p := recover(); p != nil {
-			_ = tx.Rollback()
-			err = fmt.Errorf("inventory service: update product: panic update produdct: %v", p)
-			return
+	err = s.withTx("update product", func(tx *sqlx.Tx) error {
+		return s.changeStock(tx, item.WarehouseID, item.Id, item.Version,
+			func(current WarehouseStock) int64 {
+				if item.Qty != nil {
+					return *item.Qty
+				}
+				return current.Qty + *item.Delta
+			},
+			StockMovement{ReasonCode: item.ReasonCode, Actor: item.Actor, Reference: item.Reference})
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	s.stockChanged([]uuid.UUID{item.Id})
+	return s.GetProductById(item.Id)
+}
+
+// changeStock меняет физический остаток на складе и пишет корректировку в журнал.
+// Сначала блокируются строки остатка, затем сверяется версия: тот же порядок, что у триггера пересчета.
+func (s *Service) changeStock(tx *sqlx.Tx, warehouseID, productID uuid.UUID, version int64,
+	target func(current WarehouseStock) int64, movement StockMovement) error {
+	stock, err := s.repo.GetWarehouseStockForUpdate(tx, []uuid.UUID{productID})
+	if err != nil {
+		return err
+	}
+	if version != 0 {
+		current, err := s.repo.GetItemVersion(tx, productID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &common.NotFoundError{Message: fmt.Sprintf("product with id %s not found", productID)}
 		}
 		if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				err = fmt.Errorf("rollback failed: original error: %w", err)
-			}
-			return
+			return err
 		}
-		if commitErr := tx.Commit(); commitErr != nil {
-			err = fmt.Errorf("inventory service: update product: committing transaction failed: %w", commitErr)
+		if current != version {
+			return &common.PreconditionFailedError{Message: fmt.Sprintf("product %s was modified: version %d, expected %d",
+				productID, current, version)}
 		}
-	}()
-	isExists, err := s.repo.FindItemById(tx, item.Id)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return fmt.Errorf("catalog service: update product: error checking exists of product")
 	}
-	if isExists {
-		return &common.AlreadyExistsError{Message: fmt.Sprintf("product with id %s already exists", item.Id)}
+	current := findStock(stock, warehouseID)
+	qty := target(current)
+	if qty < 0 {
+		return &common.ConflictError{Message: fmt.Sprintf("qty %d is negative", qty)}
 	}
-	err = s.repo.UpdateProduct(tx, item)
-	if err != nil {
-		return fmt.Errorf("inventory service: update product: error update product")
+	if qty < current.Reserved {
+		return &common.ConflictError{Message: fmt.Sprintf("qty %d is below reserved %d", qty, current.Reserved)}
 	}
-	return nil
+	if err = s.repo.SetWarehouseStock(tx, warehouseID, productID, qty); err != nil {
+		return err
+	}
+	if qty == current.Qty {
+		return nil
+	}
+	movement.WarehouseID, movement.ProductID = warehouseID, productID
+	movement.Type, movement.QtyDelta = MovementAdjustment, qty-current.Qty
+	return s.recordMovement(tx, movement)
 }
 
+// DeleteProduct снимает товар с учета. Журнал движений только дополняется, поэтому товар не удаляется,
+// а помечается удаленным: остаток на складах списывается корректировками, история остается.
+// Товар с резервом удалить нельзя - сначала нужно завершить или снять заказы.
 func (s *Service) DeleteProduct(id uuid.UUID) error {
-	err := s.repo.DeleteProduct(id)
+	if id == uuid.Nil {
+		return &common.RequestValidationError{Message: "invalid id"}
+	}
+	err := s.withTx("delete product", func(tx *sqlx.Tx) error {
+		stock, err := s.repo.GetWarehouseStockForUpdate(tx, []uuid.UUID{id})
+		if err != nil {
+			return err
+		}
+		for _, ws := range stock {
+			if ws.Reserved > 0 {
+				return &common.ConflictError{Message: fmt.Sprintf("product %s has %d reserved in warehouse %s",
+					id, ws.Reserved, ws.WarehouseID)}
+			}
+		}
+		for _, ws := range stock {
+			err = s.changeStock(tx, ws.WarehouseID, id, 0,
+				func(WarehouseStock) int64 { return 0 },
+				StockMovement{ReasonCode: ReasonProductDeleted, Actor: SystemActor})
+			if err != nil {
+				return err
+			}
+		}
+		err = s.repo.DeleteProduct(tx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &common.NotFoundError{Message: fmt.Sprintf("product with id %s not found", id)}
+		}
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("inventory service: delete: error deleting product with id %d", id)
+		return err
 	}
+	s.stockChanged([]uuid.UUID{id})
 	return nil
 }
 
-func (s *Service) ReserveProducts(item ReserveItemRequest) error {
+// ReserveProducts резервирует один товар. Если одного склада не хватает, резерв делится между складами.
+func (s *Service) ReserveProducts(item ReserveItemRequest) ([]Reservation, error) {
+	if err := s.validator.Validate(item); err != nil {
+		return nil, &common.RequestValidationError{Message: err.Error()}
+	}
+	return s.ReserveOrder(BatchReserveRequest{
+		OrderID:     item.OrderID,
+		Lines:       []ReserveLine{{Id: item.Id, Qty: item.Qty}},
+		Destination: item.Destination,
+	})
+}
+
+// ReserveOrder резервирует все строки заказа в одной транзакции.
+// Склады для каждой строки выбирает стратегия sourcing, по умолчанию - как можно меньше отправлений.
+// Остатки блокируются в порядке id, чтобы параллельные заказы не ловили дедлок.
+// Повтор для заказа идемпотентен: товары, по которым у заказа уже есть действующий резерв,
+// не резервируются снова, возвращается существующий резерв. Истекшие и снятые резервы не учитываются,
+// поэтому заказ может зарезервировать товар заново.
+func (s *Service) ReserveOrder(req BatchReserveRequest) (reservations []Reservation, err error) {
+	if err = s.validator.Validate(req); err != nil {
+		return nil, &common.RequestValidationError{Message: err.Error()}
+	}
+	requested := make(map[uuid.UUID]int64, len(req.Lines))
+	ids := make([]uuid.UUID, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		if _, ok := requested[line.Id]; !ok {
+			ids = append(ids, line.Id)
+		}
+		requested[line.Id] += line.Qty
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+	err = s.withTx("reserve order", func(tx *sqlx.Tx) error {
+		stock, err := s.repo.GetWarehouseStockForUpdate(tx, ids)
+		if err != nil {
+			return err
+		}
+		// блокировка остатков выстраивает повторы одного заказа в очередь, второй увидит резерв первого
+		existing, err := s.repo.GetOrderReservations(req.OrderID)
+		if err != nil {
+			return err
+		}
+		live := make(map[uuid.UUID]bool)
+		for _, r := range existing {
+			if _, ok := requested[r.ProductID]; ok && r.State.Live() {
+				live[r.ProductID] = true
+				reservations = append(reservations, r)
+			}
+		}
+		for id := range live {
+			delete(requested, id)
+		}
+		if len(requested) == 0 {
+			return nil
+		}
+		warehouses, err := s.repo.GetWarehouses()
+		if err != nil {
+			return err
+		}
+		byID := make(map[uuid.UUID]int, len(warehouses))
+		for i := range warehouses {
+			warehouses[i].items = make(map[uuid.UUID]Item)
+			byID[warehouses[i].ID] = i
+		}
+		available := make(map[uuid.UUID]int64, len(ids))
+		for _, ws := range stock {
+			i, ok := byID[ws.WarehouseID]
+			if !ok {
+				continue
+			}
+			warehouses[i].items[ws.ProductID] = Item{ID: ws.ProductID, Qty: ws.Qty, Reserved: ws.Reserved, Available: ws.Available}
+			available[ws.ProductID] += ws.Available
+		}
+		allocations, missing := NewSourcingStrategy(req.Strategy).Allocate(requested, warehouses, req.Destination)
+		var preorders []Allocation
+		if req.AllowPreorder && len(missing) > 0 {
+			preorders, missing, err = s.allocatePreorders(missing)
+			if err != nil {
+				return err
+			}
+		}
+		if len(missing) > 0 {
+			var shortages []common.StockShortage
+			for _, id := range ids {
+				if _, ok := missing[id]; ok {
+					shortages = append(shortages, common.StockShortage{
+						ProductID: id.String(),
+						Requested: requested[id],
+						Available: available[id],
+					})
+				}
+			}
+			return &common.InsufficientStockError{
+				ConflictError: common.ConflictError{Message: fmt.Sprintf("not enough stock for %d line(s)", len(shortages))},
+				Shortages:     shortages,
+			}
+		}
+		now := time.Now()
+		for _, a := range allocations {
+			if err = s.repo.ReserveStock(tx, a.WarehouseID, a.ProductID, a.Qty); err != nil {
+				return err
+			}
+			reservation := Reservation{
+				ID:          uuid.New(),
+				OrderID:     req.OrderID,
+				ProductID:   a.ProductID,
+				WarehouseID: a.WarehouseID,
+				Qty:         a.Qty,
+				State:       Held,
+				ExpiresAt:   now.Add(s.cfg.TTL),
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}
+			if err = s.repo.CreateReservation(tx, reservation); err != nil {
+				return err
+			}
+			err = s.recordMovement(tx, StockMovement{
+				WarehouseID:   a.WarehouseID,
+				ProductID:     a.ProductID,
+				Type:          MovementReserve,
+				ReservedDelta: a.Qty,
+				ReasonCode:    ReasonOrderReserved,
+				Actor:         SystemActor,
+				Reference:     req.OrderID.String(),
+				CreatedAt:     now,
+			})
+			if err != nil {
+				return err
+			}
+			reservations = append(reservations, reservation)
+		}
+		for _, p := range preorders {
+			reservation := Reservation{
+				ID:          uuid.New(),
+				OrderID:     req.OrderID,
+				ProductID:   p.ProductID,
+				WarehouseID: p.WarehouseID,
+				Qty:         p.Qty,
+				State:       Preordered,
+				ExpiresAt:   p.ExpectedAt,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}
+			if err = s.repo.CreateReservation(tx, reservation); err != nil {
+				return err
+			}
+			reservations = append(reservations, reservation)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.stockChanged(ids)
+	return reservations, nil
+}
+
+// ReleaseProducts снимает резерв заказа и возвращает товар в доступный остаток
+func (s *Service) ReleaseProducts(item ReliesItemRequest) error {
 	if err := s.validator.Validate(item); err != nil {
 		return &common.RequestValidationError{Message: err.Error()}
 	}
-	tx, err := s.repo.BeginTransaction()
+	if item.Qty > 0 && item.Id == uuid.Nil {
+		return &common.RequestValidationError{Message: "qty requires product id"}
+	}
+	var released []uuid.UUID
+	err := s.withTx("release product", func(tx *sqlx.Tx) (err error) {
+		if item.Qty > 0 {
+			released, err = s.releaseQty(tx, item.OrderID, item.Id, item.Qty)
+			return err
+		}
+		released, err = s.finishReservations(tx, item.OrderID, item.Id, Released)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("inventory service: reserve product: error starting transaction")
+		return err
 	}
-	defer func() {
-		if p := recover(); p != nil {
-			_ = tx.Rollback()
-			err = fmt.Errorf("inventory service: reserve product:  panic reserve product: %v", p)
-			return
+	s.stockChanged(released)
+	return nil
+}
+
+// CommitReservations списывает зарезервированный товар после оплаты заказа
+func (s *Service) CommitReservations(orderID uuid.UUID) error {
+	if orderID == uuid.Nil {
+		return &common.RequestValidationError{Message: "invalid order id"}
+	}
+	return s.withTx("commit reservations", func(tx *sqlx.Tx) error {
+		_, err := s.finishReservations(tx, orderID, uuid.Nil, Committed)
+		return err
+	})
+}
+
+func (s *Service) GetReservationsByOrder(orderID uuid.UUID) (OrderReservationsResponse, error) {
+	if orderID == uuid.Nil {
+		return OrderReservationsResponse{}, &common.RequestValidationError{Message: "invalid order id"}
+	}
+	reservations, err := s.repo.GetOrderReservations(orderID)
+	if err != nil {
+		return OrderReservationsResponse{}, fmt.Errorf("inventory service: get reservations: %w", err)
+	}
+	if len(reservations) == 0 {
+		return OrderReservationsResponse{}, &common.NotFoundError{Message: fmt.Sprintf("reservations for order %s not found", orderID)}
+	}
+	return OrderReservationsResponse{OrderID: orderID, Reservations: reservations}, nil
+}
+
+// ExpireReservations переводит просроченные неоплаченные резервы в expired и возвращает товар в остаток
+func (s *Service) ExpireReservations(now time.Time) (expired int, err error) {
+	var released []uuid.UUID
+	err = s.withTx("expire reservations", func(tx *sqlx.Tx) error {
+		reservations, err := s.repo.FindExpiredReservations(tx, now, s.cfg.SweepBatch)
+		if err != nil {
+			return err
+		}
+		for _, r := range reservations {
+			if err = s.repo.ReleaseStock(tx, r.WarehouseID, r.ProductID, r.Qty); err != nil {
+				return err
+			}
+			if err = s.repo.UpdateReservationState(tx, r.ID, Expired); err != nil {
+				return err
+			}
+			err = s.recordMovement(tx, releaseMovement(r, ReasonReservationExpired))
+			if err != nil {
+				return err
+			}
+		}
+		expired = len(reservations)
+		for _, r := range reservations {
+			released = append(released, r.ProductID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.stockChanged(released)
+	return expired, nil
+}
+
+// finishReservations переводит удерживаемые резервы заказа в конечное состояние.
+// Повторный вызов для уже завершенного резерва ничего не делает. Возвращает товары, по которым изменился остаток.
+func (s *Service) finishReservations(tx *sqlx.Tx, orderID, productID uuid.UUID, state ReservationState) ([]uuid.UUID, error) {
+	reservations, err := s.repo.GetReservationsByOrder(tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if len(reservations) == 0 {
+		return nil, &common.NotFoundError{Message: fmt.Sprintf("reservations for order %s not found", orderID)}
+	}
+	// товары, которые заказ зарезервировал заново после истечения прошлого резерва
+	renewed := make(map[uuid.UUID]bool)
+	for _, r := range reservations {
+		if r.State.Live() {
+			renewed[r.ProductID] = true
+		}
+	}
+	var products []uuid.UUID
+	found := false
+	for _, r := range reservations {
+		if productID != uuid.Nil && r.ProductID != productID {
+			continue
 		}
+		found = true
+		changed, err := s.finishReservation(tx, r, state, renewed[r.ProductID])
 		if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				err = fmt.Errorf("rollback failed: original error: %w", err)
+			return nil, err
+		}
+		if changed {
+			products = append(products, r.ProductID)
+		}
+	}
+	if !found {
+		return nil, &common.NotFoundError{Message: fmt.Sprintf("product %s is not reserved for order %s", productID, orderID)}
+	}
+	return products, nil
+}
+
+// releaseQty снимает часть резерва строки заказа. Резерв, который снимается не целиком,
+// сначала делится на две записи, чтобы в истории осталось, что и сколько вернулось в остаток.
+func (s *Service) releaseQty(tx *sqlx.Tx, orderID, productID uuid.UUID, qty int64) ([]uuid.UUID, error) {
+	reservations, err := s.repo.GetReservationsByOrder(tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	var live []Reservation
+	var reserved int64
+	found := false
+	for _, r := range reservations {
+		if r.ProductID != productID {
+			continue
+		}
+		found = true
+		if r.State.Live() {
+			live = append(live, r)
+			reserved += r.Qty
+		}
+	}
+	if !found {
+		return nil, &common.NotFoundError{Message: fmt.Sprintf("product %s is not reserved for order %s", productID, orderID)}
+	}
+	if qty > reserved {
+		return nil, &common.ConflictError{Message: fmt.Sprintf("cannot release %d of product %s, only %d reserved", qty, productID, reserved)}
+	}
+	var products []uuid.UUID
+	for _, r := range live {
+		if qty == 0 {
+			break
+		}
+		part := r
+		if qty < r.Qty {
+			if part, err = s.repo.SplitReservation(tx, r, qty); err != nil {
+				return nil, err
 			}
-			return
 		}
-		if commitErr := tx.Commit(); commitErr != nil {
-			err = fmt.Errorf("inventory service: reserve product: committing transaction failed: %w", commitErr)
+		changed, err := s.finishReservation(tx, part, Released, true)
+		if err != nil {
+			return nil, err
 		}
-	}()
-	isExists, err := s.repo.FindItemById(tx, item.Id)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return fmt.Errorf("inventory service: reserve product: error checking exists of product")
+		if changed {
+			products = append(products, part.ProductID)
+		}
+		qty -= part.Qty
+	}
+	return products, nil
+}
+
+// finishReservation переводит один резерв в конечное состояние. renewed - по товару есть действующий резерв.
+// Возвращает true, если изменился остаток.
+func (s *Service) finishReservation(tx *sqlx.Tx, r Reservation, state ReservationState, renewed bool) (bool, error) {
+	if r.State == state {
+		return false, nil
+	}
+	if r.State == Expired && (state == Released || renewed) {
+		// истекший резерв уже вернул товар в остаток, а при оплате его заменил новый
+		return false, nil
+	}
+	if r.State == Released && state == Committed {
+		// строку отменили до оплаты, списывать нечего
+		return false, nil
+	}
+	if r.State == PreorderPaid && state == Committed {
+		// предзаказ уже оплачен, спишется при поступлении
+		return false, nil
+	}
+	if r.State == Preordered && state == Committed {
+		// товар еще не пришел: предзаказ помечается оплаченным и спишется при поступлении, см. fulfilPreorders
+		return false, s.repo.UpdateReservationState(tx, r.ID, PreorderPaid)
+	}
+	if (r.State == Preordered || r.State == PreorderPaid) && state == Released {
+		// товар еще не пришел, остаток не трогаем
+		return false, s.repo.UpdateReservationState(tx, r.ID, state)
 	}
-	if isExists {
-		return &common.AlreadyExistsError{Message: fmt.Sprintf("product with id %s already exists", item.Id)}
+	var movement StockMovement
+	var err error
+	switch {
+	case r.State == Committed && state == Released:
+		// заказ отменили после оплаты, но до отгрузки: списанный товар возвращается в остаток
+		err = s.repo.ReturnStock(tx, r.WarehouseID, r.ProductID, r.Qty)
+		movement = StockMovement{
+			WarehouseID: r.WarehouseID,
+			ProductID:   r.ProductID,
+			Type:        MovementReturn,
+			QtyDelta:    r.Qty,
+			ReasonCode:  ReasonOrderCanceled,
+			Actor:       SystemActor,
+			Reference:   r.OrderID.String(),
+		}
+	case r.State != Held:
+		return false, &common.ConflictError{Message: fmt.Sprintf("reservation %s is already %s", r.ID, r.State)}
+	case state == Committed:
+		err = s.repo.CommitStock(tx, r.WarehouseID, r.ProductID, r.Qty)
+		movement = StockMovement{
+			WarehouseID:   r.WarehouseID,
+			ProductID:     r.ProductID,
+			Type:          MovementShipment,
+			QtyDelta:      -r.Qty,
+			ReservedDelta: -r.Qty,
+			ReasonCode:    ReasonOrderPaid,
+			Actor:         SystemActor,
+			Reference:     r.OrderID.String(),
+		}
+	default:
+		err = s.repo.ReleaseStock(tx, r.WarehouseID, r.ProductID, r.Qty)
+		movement = releaseMovement(r, ReasonOrderReleased)
 	}
-	err = s.repo.ReserveProducts(tx, item)
 	if err != nil {
-		return fmt.Errorf("inventory service: reserve product: error adding product")
+		return false, err
 	}
-	return nil
+	if err = s.repo.UpdateReservationState(tx, r.ID, state); err != nil {
+		return false, err
+	}
+	if err = s.recordMovement(tx, movement); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func (s *Service) ReleaseProducts(item ReliesItemRequest) error {
-	if err := s.validator.Validate(item); err != nil {
-		return &common.RequestValidationError{Message: err.Error()}
+func releaseMovement(r Reservation, reason string) StockMovement {
+	return StockMovement{
+		WarehouseID:   r.WarehouseID,
+		ProductID:     r.ProductID,
+		Type:          MovementRelease,
+		ReservedDelta: -r.Qty,
+		ReasonCode:    reason,
+		Actor:         SystemActor,
+		Reference:     r.OrderID.String(),
 	}
+}
+
+// stockChanged сообщает наблюдателям о товарах, у которых изменился остаток. Вызывается после коммита.
+func (s *Service) stockChanged(productIDs []uuid.UUID) {
+	if len(productIDs) == 0 {
+		return
+	}
+	for _, w := range s.watchers {
+		w.Check(productIDs)
+	}
+}
+
+func (s *Service) withTx(op string, fn func(tx *sqlx.Tx) error) (err error) {
 	tx, err := s.repo.BeginTransaction()
 	if err != nil {
-		return fmt.Errorf("inventory service: release product: error starting transaction")
+		return fmt.Errorf("inventory service: %s: error starting transaction", op)
 	}
 	defer func() {
 		if p := recover(); p != nil {
 			_ = tx.Rollback()
-			err = fmt.Errorf("inventory service: release product:  panic add product: %v", p)
+			err = fmt.Errorf("inventory service: %s: panic: %v", op, p)
 			return
 		}
 		if err != nil {
@@ -199,19 +982,11 @@ func (s *Service) ReleaseProducts(item ReliesItemRequest) error {
 			return
 		}
 		if commitErr := tx.Commit(); commitErr != nil {
-			err = fmt.Errorf("inventory service: release product: committing transaction failed: %w", commitErr)
+			err = fmt.Errorf("inventory service: %s: committing transaction failed: %w", op, commitErr)
 		}
 	}()
-	isExists, err := s.repo.FindItemById(tx, item.Id)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return fmt.Errorf("inventory service: release product: error checking exists of product")
-	}
-	if isExists {
-		return &common.AlreadyExistsError{Message: fmt.Sprintf("product with id %s already exists", item.Id)}
-	}
-	err = s.repo.ReleaseProducts(tx, item)
-	if err != nil {
-		return fmt.Errorf("inventory service: release product: error adding product")
+	if err = fn(tx); err != nil {
+		return fmt.Errorf("inventory service: %s: %w", op, err)
 	}
 	return nil
 }
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"math"
+	"sort"
+)
+
+type SourcingStrategyName string
+
+const (
+	NearestWarehouse SourcingStrategyName = "nearest"       // ближайший к адресу доставки склад
+	FewestSplits     SourcingStrategyName = "fewest_splits" // как можно меньше отправлений
+)
+
+// SourcingStrategy распределяет строки заказа (productID -> qty) по складам с учетом их доступного остатка.
+// Возвращает распределение и строки, которые не удалось покрыть (productID -> сколько не хватило).
+type SourcingStrategy interface {
+	Allocate(lines map[uuid.UUID]int64, warehouses []Warehouse, dest *Location) ([]Allocation, map[uuid.UUID]int64)
+}
+
+func NewSourcingStrategy(name SourcingStrategyName) SourcingStrategy {
+	if name == NearestWarehouse {
+		return nearestStrategy{}
+	}
+	return fewestSplitsStrategy{}
+}
+
+type nearestStrategy struct{}
+
+// Allocate набирает товар со складов по возрастанию расстояния до адреса доставки
+func (nearestStrategy) Allocate(lines map[uuid.UUID]int64, warehouses []Warehouse, dest *Location) ([]Allocation, map[uuid.UUID]int64) {
+	ordered := sortByDistance(warehouses, dest)
+	remaining := copyLines(lines)
+	var allocations []Allocation
+	for _, w := range ordered {
+		allocations = append(allocations, take(w, remaining)...)
+	}
+	return allocations, shortages(remaining)
+}
+
+type fewestSplitsStrategy struct{}
+
+// Allocate жадно выбирает склад, который покрывает больше всего оставшегося количества.
+// При равенстве берется ближайший.
+func (fewestSplitsStrategy) Allocate(lines map[uuid.UUID]int64, warehouses []Warehouse, dest *Location) ([]Allocation, map[uuid.UUID]int64) {
+	candidates := sortByDistance(warehouses, dest)
+	remaining := copyLines(lines)
+	var allocations []Allocation
+	for len(candidates) > 0 {
+		best, bestCover := -1, int64(0)
+		for i, w := range candidates {
+			if cover := coverage(w, remaining); cover > bestCover {
+				best, bestCover = i, cover
+			}
+		}
+		if best < 0 {
+			break
+		}
+		allocations = append(allocations, take(candidates[best], remaining)...)
+		candidates = append(candidates[:best], candidates[best+1:]...)
+	}
+	return allocations, shortages(remaining)
+}
+
+func coverage(w Warehouse, remaining map[uuid.UUID]int64) int64 {
+	var cover int64
+	for productID, qty := range remaining {
+		cover += min(qty, w.items[productID].Available)
+	}
+	return cover
+}
+
+func take(w Warehouse, remaining map[uuid.UUID]int64) []Allocation {
+	ids := make([]uuid.UUID, 0, len(remaining))
+	for id := range remaining {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+	var allocations []Allocation
+	for _, productID := range ids {
+		qty := min(remaining[productID], w.items[productID].Available)
+		if qty <= 0 {
+			continue
+		}
+		allocations = append(allocations, Allocation{WarehouseID: w.ID, ProductID: productID, Qty: qty})
+		remaining[productID] -= qty
+		if remaining[productID] == 0 {
+			delete(remaining, productID)
+		}
+	}
+	return allocations
+}
+
+func sortByDistance(warehouses []Warehouse, dest *Location) []Warehouse {
+	ordered := make([]Warehouse, len(warehouses))
+	copy(ordered, warehouses)
+	if dest == nil {
+		return ordered
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return distanceKm(ordered[i].Location, *dest) < distanceKm(ordered[j].Location, *dest)
+	})
+	return ordered
+}
+
+func copyLines(lines map[uuid.UUID]int64) map[uuid.UUID]int64 {
+	remaining := make(map[uuid.UUID]int64, len(lines))
+	for id, qty := range lines {
+		remaining[id] = qty
+	}
+	return remaining
+}
+
+func shortages(remaining map[uuid.UUID]int64) map[uuid.UUID]int64 {
+	if len(remaining) == 0 {
+		return nil
+	}
+	return remaining
+}
+
+// distanceKm расстояние по формуле гаверсинуса
+func distanceKm(a, b Location) float64 {
+	const earthRadiusKm = 6371
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
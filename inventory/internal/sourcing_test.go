@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"github.com/google/uuid"
+	"reflect"
+	"testing"
+)
+
+func TestSourcingStrategies(t *testing.T) {
+	productP := uuid.MustParse("00000000-0000-0000-0000-0000000000a1")
+	productQ := uuid.MustParse("00000000-0000-0000-0000-0000000000a2")
+	moscowID := uuid.MustParse("00000000-0000-0000-0000-000000000101")
+	spbID := uuid.MustParse("00000000-0000-0000-0000-000000000102")
+	kazanID := uuid.MustParse("00000000-0000-0000-0000-000000000103")
+	warehouse := func(id uuid.UUID, lat, lon float64, stock map[uuid.UUID]int64) Warehouse {
+		w := Warehouse{ID: id, Location: Location{Latitude: lat, Longitude: lon}, items: map[uuid.UUID]Item{}}
+		for productID, available := range stock {
+			w.items[productID] = Item{ID: productID, Available: available}
+		}
+		return w
+	}
+	// от адреса доставки в Москве: Москва, затем Санкт-Петербург (~630 км), затем Казань (~720 км)
+	dest := &Location{Latitude: 55.70, Longitude: 37.60}
+	split := []Warehouse{
+		warehouse(kazanID, 55.79, 49.12, map[uuid.UUID]int64{productP: 10}),
+		warehouse(spbID, 59.93, 30.34, map[uuid.UUID]int64{productP: 10}),
+		warehouse(moscowID, 55.75, 37.62, map[uuid.UUID]int64{productP: 2}),
+	}
+	twoProducts := []Warehouse{
+		warehouse(moscowID, 55.75, 37.62, map[uuid.UUID]int64{productP: 3}),
+		warehouse(spbID, 59.93, 30.34, map[uuid.UUID]int64{productQ: 3}),
+		warehouse(kazanID, 55.79, 49.12, map[uuid.UUID]int64{productP: 3, productQ: 3}),
+	}
+
+	tests := []struct {
+		name        string
+		strategy    SourcingStrategyName
+		lines       map[uuid.UUID]int64
+		warehouses  []Warehouse
+		dest        *Location
+		allocations []Allocation
+		shortages   map[uuid.UUID]int64
+	}{
+		{
+			name:       "nearest takes closest first",
+			strategy:   NearestWarehouse,
+			lines:      map[uuid.UUID]int64{productP: 5},
+			warehouses: split,
+			dest:       dest,
+			allocations: []Allocation{
+				{WarehouseID: moscowID, ProductID: productP, Qty: 2},
+				{WarehouseID: spbID, ProductID: productP, Qty: 3},
+			},
+		},
+		{
+			name:        "fewest splits prefers one warehouse, tie goes to the closer one",
+			strategy:    FewestSplits,
+			lines:       map[uuid.UUID]int64{productP: 5},
+			warehouses:  split,
+			dest:        dest,
+			allocations: []Allocation{{WarehouseID: spbID, ProductID: productP, Qty: 5}},
+		},
+		{
+			name:       "nearest splits products across warehouses",
+			strategy:   NearestWarehouse,
+			lines:      map[uuid.UUID]int64{productP: 3, productQ: 3},
+			warehouses: twoProducts,
+			dest:       dest,
+			allocations: []Allocation{
+				{WarehouseID: moscowID, ProductID: productP, Qty: 3},
+				{WarehouseID: spbID, ProductID: productQ, Qty: 3},
+			},
+		},
+		{
+			name:       "fewest splits ships everything from one warehouse",
+			strategy:   FewestSplits,
+			lines:      map[uuid.UUID]int64{productP: 3, productQ: 3},
+			warehouses: twoProducts,
+			dest:       dest,
+			allocations: []Allocation{
+				{WarehouseID: kazanID, ProductID: productP, Qty: 3},
+				{WarehouseID: kazanID, ProductID: productQ, Qty: 3},
+			},
+		},
+		{
+			name:       "without destination keeps warehouse order",
+			strategy:   NearestWarehouse,
+			lines:      map[uuid.UUID]int64{productP: 12},
+			warehouses: split,
+			allocations: []Allocation{
+				{WarehouseID: kazanID, ProductID: productP, Qty: 10},
+				{WarehouseID: spbID, ProductID: productP, Qty: 2},
+			},
+		},
+		{
+			name:       "nearest reports shortage",
+			strategy:   NearestWarehouse,
+			lines:      map[uuid.UUID]int64{productP: 30},
+			warehouses: split,
+			dest:       dest,
+			allocations: []Allocation{
+				{WarehouseID: moscowID, ProductID: productP, Qty: 2},
+				{WarehouseID: spbID, ProductID: productP, Qty: 10},
+				{WarehouseID: kazanID, ProductID: productP, Qty: 10},
+			},
+			shortages: map[uuid.UUID]int64{productP: 8},
+		},
+		{
+			name:       "fewest splits reports shortage",
+			strategy:   FewestSplits,
+			lines:      map[uuid.UUID]int64{productP: 30},
+			warehouses: split,
+			dest:       dest,
+			allocations: []Allocation{
+				{WarehouseID: spbID, ProductID: productP, Qty: 10},
+				{WarehouseID: kazanID, ProductID: productP, Qty: 10},
+				{WarehouseID: moscowID, ProductID: productP, Qty: 2},
+			},
+			shortages: map[uuid.UUID]int64{productP: 8},
+		},
+		{
+			name:       "product not stocked anywhere",
+			strategy:   FewestSplits,
+			lines:      map[uuid.UUID]int64{productQ: 1},
+			warehouses: split,
+			dest:       dest,
+			shortages:  map[uuid.UUID]int64{productQ: 1},
+		},
+		{
+			name:       "no lines",
+			strategy:   NearestWarehouse,
+			lines:      map[uuid.UUID]int64{},
+			warehouses: split,
+			dest:       dest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := copyLines(tt.lines)
+			allocations, shortages := NewSourcingStrategy(tt.strategy).Allocate(tt.lines, tt.warehouses, tt.dest)
+			if !reflect.DeepEqual(allocations, tt.allocations) {
+				t.Errorf("allocations = %+v, want %+v", allocations, tt.allocations)
+			}
+			if !reflect.DeepEqual(shortages, tt.shortages) {
+				t.Errorf("shortages = %v, want %v", shortages, tt.shortages)
+			}
+			if !reflect.DeepEqual(tt.lines, lines) {
+				t.Errorf("lines were modified: %v, want %v", tt.lines, lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/inventory/internal/common"
+	"go.uber.org/zap"
+	"time"
+)
+
+const StockLowEvent = "stock.low"
+
+type AlertRepo interface {
+	GetReorderStatus(productIDs []uuid.UUID) ([]ReorderStatus, error)
+	MarkLowStockAlerted(productID uuid.UUID, at time.Time) (bool, error)
+	ClearLowStockAlert(productID uuid.UUID) error
+}
+
+type Notifier interface {
+	Notify(req NotificationRequest) error
+}
+
+type EventPublisher interface {
+	Publish(event Event) error
+}
+
+// StockAlerts проверяет остаток после резерва или корректировки и сообщает закупке о низком остатке.
+// Повторно по тому же товару уведомление уйдет, только когда остаток поднимется выше порога и снова упадет.
+type StockAlerts struct {
+	repo         AlertRepo
+	notifier     Notifier
+	events       EventPublisher
+	logger       *common.Logger
+	purchasingTo string
+}
+
+func NewStockAlerts(repo AlertRepo, notifier Notifier, events EventPublisher,
+	logger *common.Logger, cfg common.AlertsConfig) *StockAlerts {
+	return &StockAlerts{
+		repo:         repo,
+		notifier:     notifier,
+		events:       events,
+		logger:       logger,
+		purchasingTo: cfg.PurchasingEmail,
+	}
+}
+
+// Check не возвращает ошибку: остаток уже изменен, сбой уведомления не должен откатывать операцию
+func (a *StockAlerts) Check(productIDs []uuid.UUID) {
+	statuses, err := a.repo.GetReorderStatus(productIDs)
+	if err != nil {
+		a.logger.Error("failed to get reorder status", zap.Error(err))
+		return
+	}
+	now := time.Now()
+	for _, st := range statuses {
+		if st.Available >= st.ReorderPoint {
+			if st.AlertedAt != nil {
+				if err = a.repo.ClearLowStockAlert(st.ProductID); err != nil {
+					a.logger.Error("failed to clear low stock alert", zap.Error(err),
+						zap.String("product_id", st.ProductID.String()))
+				}
+			}
+			continue
+		}
+		claimed, err := a.repo.MarkLowStockAlerted(st.ProductID, now)
+		if err != nil {
+			a.logger.Error("failed to mark low stock alert", zap.Error(err),
+				zap.String("product_id", st.ProductID.String()))
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if err = a.notify(st); err != nil {
+			a.logger.Error("failed to send low stock alert", zap.Error(err),
+				zap.String("product_id", st.ProductID.String()))
+			// снимаем отметку, чтобы следующая проверка попробовала снова
+			if err = a.repo.ClearLowStockAlert(st.ProductID); err != nil {
+				a.logger.Error("failed to clear low stock alert", zap.Error(err),
+					zap.String("product_id", st.ProductID.String()))
+			}
+			continue
+		}
+		if err = a.publish(st, now); err != nil {
+			a.logger.Error("failed to publish stock.low event", zap.Error(err),
+				zap.String("product_id", st.ProductID.String()))
+		}
+	}
+}
+
+func (a *StockAlerts) notify(st ReorderStatus) error {
+	subject := fmt.Sprintf("Low stock: %s", st.ProductID)
+	if st.Available < st.SafetyStock {
+		subject = fmt.Sprintf("Below safety stock: %s", st.ProductID)
+	}
+	return a.notifier.Notify(NotificationRequest{
+		To:      a.purchasingTo,
+		Type:    "email",
+		Subject: subject,
+		Text: fmt.Sprintf("Product %s has %d available, reorder point %d, safety stock %d. Suggested reorder qty: %d",
+			st.ProductID, st.Available, st.ReorderPoint, st.SafetyStock, st.ReorderQty),
+	})
+}
+
+func (a *StockAlerts) publish(st ReorderStatus, now time.Time) error {
+	payload, err := json.Marshal(StockLowPayload{
+		Version:      1,
+		ProductID:    st.ProductID,
+		Available:    st.Available,
+		ReorderPoint: st.ReorderPoint,
+		SafetyStock:  st.SafetyStock,
+		ReorderQty:   st.ReorderQty,
+		BelowSafety:  st.Available < st.SafetyStock,
+	})
+	if err != nil {
+		return err
+	}
+	return a.events.Publish(Event{
+		ID:         uuid.New(),
+		Type:       StockLowEvent,
+		Payload:    payload,
+		OccurredAt: now,
+	})
+}
@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/inventory/internal/common"
+	"sort"
+	"time"
+)
+
+// Пересчет не замораживает склад: резервы и отгрузки идут как обычно.
+// Для каждой строки запоминается системный остаток в момент ввода подсчета,
+// а при утверждении к текущему остатку применяется только расхождение.
+
+// OpenStocktake открывает пересчет товаров на складе. Один товар не может пересчитываться в двух сессиях сразу.
+func (s *Service) OpenStocktake(req OpenStocktakeRequest) (Stocktake, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return Stocktake{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	_, err := s.repo.GetWarehouseById(req.WarehouseID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Stocktake{}, &common.NotFoundError{Message: fmt.Sprintf("warehouse with id %s not found", req.WarehouseID)}
+	}
+	if err != nil {
+		return Stocktake{}, fmt.Errorf("inventory service: open stocktake: %w", err)
+	}
+	ids := uniqueIDs(req.ProductIDs)
+	st := Stocktake{
+		ID:          uuid.New(),
+		WarehouseID: req.WarehouseID,
+		Status:      StocktakeOpen,
+		OpenedBy:    req.Actor,
+		CreatedAt:   time.Now(),
+		Lines:       make([]StocktakeLine, 0, len(ids)),
+	}
+	for _, id := range ids {
+		st.Lines = append(st.Lines, StocktakeLine{StocktakeID: st.ID, ProductID: id})
+	}
+	err = s.withTx("open stocktake", func(tx *sqlx.Tx) error {
+		busy, err := s.repo.GetOpenStocktakeProducts(tx, req.WarehouseID, ids)
+		if err != nil {
+			return err
+		}
+		if len(busy) > 0 {
+			return &common.ConflictError{Message: fmt.Sprintf("product %s is already being counted", busy[0])}
+		}
+		return s.repo.CreateStocktake(tx, st)
+	})
+	if err != nil {
+		return Stocktake{}, err
+	}
+	return st, nil
+}
+
+func (s *Service) GetStocktake(id uuid.UUID) (Stocktake, error) {
+	if id == uuid.Nil {
+		return Stocktake{}, &common.RequestValidationError{Message: "invalid stocktake id"}
+	}
+	st, err := s.repo.GetStocktake(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Stocktake{}, &common.NotFoundError{Message: fmt.Sprintf("stocktake %s not found", id)}
+	}
+	if err != nil {
+		return Stocktake{}, fmt.Errorf("inventory service: get stocktake: %w", err)
+	}
+	fillVariance(st.Lines)
+	return st, nil
+}
+
+// SubmitCounts сохраняет подсчитанное количество. Повторный ввод по строке заменяет прежний подсчет.
+func (s *Service) SubmitCounts(id uuid.UUID, req SubmitCountsRequest) (Stocktake, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return Stocktake{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	var st Stocktake
+	err := s.withTx("submit counts", func(tx *sqlx.Tx) (err error) {
+		st, err = s.openStocktakeForUpdate(tx, id)
+		if err != nil {
+			return err
+		}
+		lines := make(map[uuid.UUID]int, len(st.Lines))
+		for i, line := range st.Lines {
+			lines[line.ProductID] = i
+		}
+		ids := make([]uuid.UUID, 0, len(req.Counts))
+		for _, c := range req.Counts {
+			if _, ok := lines[c.ProductID]; !ok {
+				return &common.RequestValidationError{Message: fmt.Sprintf("product %s is not in stocktake", c.ProductID)}
+			}
+			ids = append(ids, c.ProductID)
+		}
+		stock, err := s.repo.GetWarehouseStockForUpdate(tx, uniqueIDs(ids))
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, c := range req.Counts {
+			line := &st.Lines[lines[c.ProductID]]
+			counted, system := c.Qty, findStock(stockOf(stock, c.ProductID), st.WarehouseID).Qty
+			line.CountedQty, line.SystemQty = &counted, &system
+			line.CountedBy, line.CountedAt = req.Actor, &now
+			if err = s.repo.UpdateStocktakeLine(tx, *line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Stocktake{}, err
+	}
+	fillVariance(st.Lines)
+	return st, nil
+}
+
+// ApproveStocktake проводит расхождения корректировками в журнале. Все строки должны быть подсчитаны.
+func (s *Service) ApproveStocktake(id uuid.UUID, req CloseStocktakeRequest) (Stocktake, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return Stocktake{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	var st Stocktake
+	var changed []uuid.UUID
+	err := s.withTx("approve stocktake", func(tx *sqlx.Tx) (err error) {
+		st, err = s.openStocktakeForUpdate(tx, id)
+		if err != nil {
+			return err
+		}
+		ids := make([]uuid.UUID, 0, len(st.Lines))
+		for _, line := range st.Lines {
+			if line.CountedQty == nil {
+				return &common.ConflictError{Message: fmt.Sprintf("product %s is not counted yet", line.ProductID)}
+			}
+			ids = append(ids, line.ProductID)
+		}
+		stock, err := s.repo.GetWarehouseStockForUpdate(tx, ids)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, line := range st.Lines {
+			variance := *line.CountedQty - *line.SystemQty
+			if variance == 0 {
+				continue
+			}
+			current := findStock(stockOf(stock, line.ProductID), st.WarehouseID)
+			qty := current.Qty + variance
+			if qty < current.Reserved {
+				return &common.ConflictError{Message: fmt.Sprintf("product %s: qty %d is below reserved %d",
+					line.ProductID, qty, current.Reserved)}
+			}
+			if err = s.repo.SetWarehouseStock(tx, st.WarehouseID, line.ProductID, qty); err != nil {
+				return err
+			}
+			err = s.recordMovement(tx, StockMovement{
+				WarehouseID: st.WarehouseID,
+				ProductID:   line.ProductID,
+				Type:        MovementAdjustment,
+				QtyDelta:    variance,
+				ReasonCode:  ReasonStocktake,
+				Actor:       req.Actor,
+				Reference:   st.ID.String(),
+				CreatedAt:   now,
+			})
+			if err != nil {
+				return err
+			}
+			changed = append(changed, line.ProductID)
+		}
+		st.Status, st.ClosedBy, st.ClosedAt = StocktakeApproved, req.Actor, &now
+		return s.repo.CloseStocktake(tx, st.ID, st.Status, st.ClosedBy, now)
+	})
+	if err != nil {
+		return Stocktake{}, err
+	}
+	s.stockChanged(changed)
+	fillVariance(st.Lines)
+	return st, nil
+}
+
+func (s *Service) CancelStocktake(id uuid.UUID, req CloseStocktakeRequest) error {
+	if err := s.validator.Validate(req); err != nil {
+		return &common.RequestValidationError{Message: err.Error()}
+	}
+	return s.withTx("cancel stocktake", func(tx *sqlx.Tx) error {
+		st, err := s.openStocktakeForUpdate(tx, id)
+		if err != nil {
+			return err
+		}
+		return s.repo.CloseStocktake(tx, st.ID, StocktakeCancelled, req.Actor, time.Now())
+	})
+}
+
+func (s *Service) openStocktakeForUpdate(tx *sqlx.Tx, id uuid.UUID) (Stocktake, error) {
+	st, err := s.repo.GetStocktakeForUpdate(tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Stocktake{}, &common.NotFoundError{Message: fmt.Sprintf("stocktake %s not found", id)}
+	}
+	if err != nil {
+		return Stocktake{}, err
+	}
+	if st.Status != StocktakeOpen {
+		return Stocktake{}, &common.ConflictError{Message: fmt.Sprintf("stocktake %s is already %s", id, st.Status)}
+	}
+	return st, nil
+}
+
+func fillVariance(lines []StocktakeLine) {
+	for i := range lines {
+		if lines[i].CountedQty == nil || lines[i].SystemQty == nil {
+			continue
+		}
+		variance := *lines[i].CountedQty - *lines[i].SystemQty
+		lines[i].Variance = &variance
+	}
+}
+
+func stockOf(stock []WarehouseStock, productID uuid.UUID) []WarehouseStock {
+	var result []WarehouseStock
+	for _, ws := range stock {
+		if ws.ProductID == productID {
+			result = append(result, ws)
+		}
+	}
+	return result
+}
+
+// uniqueIDs убирает повторы и сортирует id, чтобы строки блокировались в одном порядке
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	result := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i][:], result[j][:]) < 0
+	})
+	return result
+}
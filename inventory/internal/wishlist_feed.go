@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/inventory/internal/common"
+	"go.uber.org/zap"
+)
+
+type CartUpdates interface {
+	ProductUpdated(update ProductUpdate) error
+}
+
+// WishlistFeed передает в Cart наличие товаров после изменения остатка. Cart сам сравнивает его
+// с тем, что видел владелец списка, и уведомляет только о появлении товара в наличии.
+type WishlistFeed struct {
+	repo   AvailabilityRepo
+	cart   CartUpdates
+	logger *common.Logger
+}
+
+func NewWishlistFeed(repo AvailabilityRepo, cart CartUpdates, logger *common.Logger) *WishlistFeed {
+	return &WishlistFeed{repo: repo, cart: cart, logger: logger}
+}
+
+// Check не возвращает ошибку: остаток уже изменен, сбой уведомления не должен откатывать операцию
+func (f *WishlistFeed) Check(productIDs []uuid.UUID) {
+	items, err := f.repo.GetProductsByIds(productIDs)
+	if err != nil {
+		f.logger.Error("failed to get products for wishlist feed", zap.Error(err))
+		return
+	}
+	for _, item := range items.Items {
+		err = f.cart.ProductUpdated(ProductUpdate{ProductID: item.ID, Available: item.Available > 0})
+		if err != nil {
+			f.logger.Warn("failed to send product update to cart", zap.Error(err),
+				zap.String("product_id", item.ID.String()))
+		}
+	}
+}
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS inventory_items
+(
+    id         UUID PRIMARY KEY,
+    qty        BIGINT NOT NULL DEFAULT 0 CHECK (qty >= 0),
+    reserved   BIGINT NOT NULL DEFAULT 0 CHECK (reserved >= 0),
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    CHECK (reserved <= qty)
+);
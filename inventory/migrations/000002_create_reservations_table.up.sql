@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS reservations
+(
+    id         UUID PRIMARY KEY,
+    order_id   UUID        NOT NULL,
+    product_id UUID        NOT NULL,
+    qty        BIGINT      NOT NULL CHECK (qty > 0),
+    state      VARCHAR(20) NOT NULL,
+    expires_at TIMESTAMP   NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    FOREIGN KEY (product_id) REFERENCES inventory_items (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_reservations_order ON reservations (order_id);
+CREATE INDEX IF NOT EXISTS idx_reservations_held_expires ON reservations (expires_at) WHERE state = 'held';
@@ -0,0 +1,6 @@
+ALTER TABLE reservations
+    DROP COLUMN IF EXISTS warehouse_id;
+DROP TRIGGER IF EXISTS warehouse_stock_sync ON warehouse_stock;
+DROP FUNCTION IF EXISTS sync_inventory_item();
+DROP TABLE IF EXISTS warehouse_stock;
+DROP TABLE IF EXISTS warehouses;
@@ -0,0 +1,70 @@
+CREATE TABLE IF NOT EXISTS warehouses
+(
+    id         UUID PRIMARY KEY,
+    name       VARCHAR(100)     NOT NULL,
+    address    VARCHAR(255)     NOT NULL,
+    latitude   DOUBLE PRECISION NOT NULL DEFAULT 0,
+    longitude  DOUBLE PRECISION NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS warehouse_stock
+(
+    warehouse_id UUID   NOT NULL,
+    product_id   UUID   NOT NULL,
+    qty          BIGINT NOT NULL DEFAULT 0 CHECK (qty >= 0),
+    reserved     BIGINT NOT NULL DEFAULT 0 CHECK (reserved >= 0),
+    updated_at   TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (warehouse_id, product_id),
+    CHECK (reserved <= qty),
+    FOREIGN KEY (warehouse_id) REFERENCES warehouses (id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES inventory_items (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_warehouse_stock_product ON warehouse_stock (product_id);
+
+-- остаток, который был до складов, переносим на склад по умолчанию
+INSERT INTO warehouses (id, name, address)
+VALUES ('00000000-0000-0000-0000-000000000001', 'Default', '')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO warehouse_stock (warehouse_id, product_id, qty, reserved)
+SELECT '00000000-0000-0000-0000-000000000001', id, qty, reserved
+FROM inventory_items
+ON CONFLICT DO NOTHING;
+
+-- inventory_items хранит суммарный остаток по всем складам
+CREATE OR REPLACE FUNCTION sync_inventory_item() RETURNS TRIGGER AS
+$$
+DECLARE
+    pid UUID;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        pid := OLD.product_id;
+    ELSE
+        pid := NEW.product_id;
+    END IF;
+    UPDATE inventory_items
+    SET qty        = (SELECT COALESCE(SUM(qty), 0) FROM warehouse_stock WHERE product_id = pid),
+        reserved   = (SELECT COALESCE(SUM(reserved), 0) FROM warehouse_stock WHERE product_id = pid),
+        updated_at = NOW()
+    WHERE id = pid;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER warehouse_stock_sync
+    AFTER INSERT OR UPDATE OR DELETE
+    ON warehouse_stock
+    FOR EACH ROW
+EXECUTE FUNCTION sync_inventory_item();
+
+ALTER TABLE reservations
+    ADD COLUMN IF NOT EXISTS warehouse_id UUID REFERENCES warehouses (id);
+
+UPDATE reservations
+SET warehouse_id = '00000000-0000-0000-0000-000000000001'
+WHERE warehouse_id IS NULL;
+
+ALTER TABLE reservations
+    ALTER COLUMN warehouse_id SET NOT NULL;
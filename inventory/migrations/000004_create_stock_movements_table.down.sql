@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS stock_movements_no_change ON stock_movements;
+DROP FUNCTION IF EXISTS stock_movements_append_only();
+DROP TABLE IF EXISTS stock_movements;
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS stock_movements
+(
+    id             UUID PRIMARY KEY,
+    warehouse_id   UUID         NOT NULL,
+    product_id     UUID         NOT NULL,
+    type           VARCHAR(20)  NOT NULL,
+    qty_delta      BIGINT       NOT NULL DEFAULT 0,
+    reserved_delta BIGINT       NOT NULL DEFAULT 0,
+    reason_code    VARCHAR(50)  NOT NULL,
+    actor          VARCHAR(100) NOT NULL,
+    reference      VARCHAR(100) NOT NULL DEFAULT '',
+    created_at     TIMESTAMP    NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (warehouse_id) REFERENCES warehouses (id),
+    FOREIGN KEY (product_id) REFERENCES inventory_items (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_movements_product ON stock_movements (product_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_stock_movements_reference ON stock_movements (reference) WHERE reference <> '';
+
+-- журнал только дополняется
+CREATE OR REPLACE FUNCTION stock_movements_append_only() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'stock_movements is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stock_movements_no_change
+    BEFORE UPDATE OR DELETE
+    ON stock_movements
+    FOR EACH ROW
+EXECUTE FUNCTION stock_movements_append_only();
+
+-- начальные остатки, чтобы журнал сходился с warehouse_stock
+INSERT INTO stock_movements (id, warehouse_id, product_id, type, qty_delta, reserved_delta, reason_code, actor)
+SELECT gen_random_uuid(), warehouse_id, product_id, 'adjustment', qty, reserved, 'opening_balance', 'system'
+FROM warehouse_stock;
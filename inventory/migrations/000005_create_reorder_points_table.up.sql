@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS reorder_points
+(
+    product_id    UUID PRIMARY KEY,
+    reorder_point BIGINT NOT NULL DEFAULT 0 CHECK (reorder_point >= 0),
+    safety_stock  BIGINT NOT NULL DEFAULT 0 CHECK (safety_stock >= 0),
+    reorder_qty   BIGINT NOT NULL DEFAULT 0 CHECK (reorder_qty >= 0),
+    alerted_at    TIMESTAMP,
+    updated_at    TIMESTAMP DEFAULT NOW(),
+    CHECK (safety_stock <= reorder_point),
+    FOREIGN KEY (product_id) REFERENCES inventory_items (id) ON DELETE CASCADE
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS stock_subscriptions
+(
+    id         UUID PRIMARY KEY,
+    product_id UUID         NOT NULL,
+    user_id    UUID         NOT NULL,
+    email      VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (product_id, user_id)
+);
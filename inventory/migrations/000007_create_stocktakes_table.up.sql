@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS stocktakes
+(
+    id           UUID PRIMARY KEY,
+    warehouse_id UUID         NOT NULL,
+    status       VARCHAR(20)  NOT NULL,
+    opened_by    VARCHAR(100) NOT NULL,
+    closed_by    VARCHAR(100) NOT NULL DEFAULT '',
+    created_at   TIMESTAMP DEFAULT NOW(),
+    closed_at    TIMESTAMP,
+    FOREIGN KEY (warehouse_id) REFERENCES warehouses (id)
+);
+
+CREATE TABLE IF NOT EXISTS stocktake_lines
+(
+    stocktake_id UUID         NOT NULL,
+    product_id   UUID         NOT NULL,
+    counted_qty  BIGINT CHECK (counted_qty >= 0),
+    system_qty   BIGINT,
+    counted_by   VARCHAR(100) NOT NULL DEFAULT '',
+    counted_at   TIMESTAMP,
+    PRIMARY KEY (stocktake_id, product_id),
+    FOREIGN KEY (stocktake_id) REFERENCES stocktakes (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_stocktakes_open ON stocktakes (warehouse_id) WHERE status = 'open';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_reservations_preordered;
+DROP TABLE IF EXISTS purchase_order_lines;
+DROP TABLE IF EXISTS purchase_orders;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS purchase_orders
+(
+    id           UUID PRIMARY KEY,
+    number       VARCHAR(50)  NOT NULL UNIQUE,
+    supplier     VARCHAR(255) NOT NULL,
+    warehouse_id UUID         NOT NULL,
+    status       VARCHAR(20)  NOT NULL,
+    expected_at  TIMESTAMP    NOT NULL,
+    created_by   VARCHAR(100) NOT NULL,
+    created_at   TIMESTAMP DEFAULT NOW(),
+    updated_at   TIMESTAMP DEFAULT NOW(),
+    FOREIGN KEY (warehouse_id) REFERENCES warehouses (id)
+);
+
+CREATE TABLE IF NOT EXISTS purchase_order_lines
+(
+    purchase_order_id UUID   NOT NULL,
+    product_id        UUID   NOT NULL,
+    qty_ordered       BIGINT NOT NULL CHECK (qty_ordered > 0),
+    qty_received      BIGINT NOT NULL DEFAULT 0 CHECK (qty_received >= 0),
+    PRIMARY KEY (purchase_order_id, product_id),
+    CHECK (qty_received <= qty_ordered),
+    FOREIGN KEY (purchase_order_id) REFERENCES purchase_orders (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_purchase_order_lines_product ON purchase_order_lines (product_id);
+CREATE INDEX IF NOT EXISTS idx_reservations_preordered ON reservations (product_id, warehouse_id) WHERE state = 'preordered';
@@ -0,0 +1,21 @@
+CREATE OR REPLACE FUNCTION sync_inventory_item() RETURNS TRIGGER AS
+$$
+DECLARE
+    pid UUID;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        pid := OLD.product_id;
+    ELSE
+        pid := NEW.product_id;
+    END IF;
+    UPDATE inventory_items
+    SET qty        = (SELECT COALESCE(SUM(qty), 0) FROM warehouse_stock WHERE product_id = pid),
+        reserved   = (SELECT COALESCE(SUM(reserved), 0) FROM warehouse_stock WHERE product_id = pid),
+        updated_at = NOW()
+    WHERE id = pid;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE inventory_items
+    DROP COLUMN IF EXISTS version;
@@ -0,0 +1,25 @@
+ALTER TABLE inventory_items
+    ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+
+-- версия растет только при изменении физического остатка, резервы ее не трогают
+CREATE OR REPLACE FUNCTION sync_inventory_item() RETURNS TRIGGER AS
+$$
+DECLARE
+    pid       UUID;
+    total_qty BIGINT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        pid := OLD.product_id;
+    ELSE
+        pid := NEW.product_id;
+    END IF;
+    SELECT COALESCE(SUM(qty), 0) INTO total_qty FROM warehouse_stock WHERE product_id = pid;
+    UPDATE inventory_items
+    SET version    = version + CASE WHEN qty <> total_qty THEN 1 ELSE 0 END,
+        qty        = total_qty,
+        reserved   = (SELECT COALESCE(SUM(reserved), 0) FROM warehouse_stock WHERE product_id = pid),
+        updated_at = NOW()
+    WHERE id = pid;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
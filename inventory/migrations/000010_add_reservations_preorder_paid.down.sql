@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_reservations_preordered;
+CREATE INDEX IF NOT EXISTS idx_reservations_preordered ON reservations (product_id, warehouse_id) WHERE state = 'preordered';
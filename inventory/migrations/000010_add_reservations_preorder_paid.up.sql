@@ -0,0 +1,4 @@
+-- оплаченный предзаказ (preorder_paid) стоит в той же очереди на поступление, что и неоплаченный
+DROP INDEX IF EXISTS idx_reservations_preordered;
+CREATE INDEX IF NOT EXISTS idx_reservations_preordered ON reservations (product_id, warehouse_id)
+    WHERE state IN ('preordered', 'preorder_paid');
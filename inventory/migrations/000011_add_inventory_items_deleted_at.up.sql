@@ -0,0 +1,3 @@
+-- товар с историей движений не удаляется физически: журнал ссылается на него и только дополняется
+ALTER TABLE inventory_items
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
@@ -1,21 +1,77 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/madrabit/mini-market/order/internal"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"github.com/madrabit/mini-market/order/internal/validator"
 	"github.com/madrabit/mini-market/order/internal/web"
+	"go.uber.org/zap"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	controller := internal.NewController()
+	cfg, err := common.Load()
+	if err != nil {
+		log.Fatalf("config load error: %v", err)
+	}
+	logger := common.NewLogger(cfg)
+	db := sqlx.MustConnect("postgres", cfg.DB.DSN())
+	defer func() {
+		err := db.Close()
+		if err != nil {
+			logger.Error("failed to close db")
+		}
+	}()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := build(ctx, db, logger, cfg)
+	httpServer := &http.Server{Addr: cfg.Server.Address + ":" + cfg.Server.Port, Handler: server.Router}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("failed to shutdown server", zap.Error(err))
+		}
+	}()
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Fatal("server failed", zap.Error(err))
+	}
+}
+
+// build собирает зависимости и запускает фоновые задачи, которые живут до отмены ctx
+func build(ctx context.Context, db *sqlx.DB, logger *common.Logger, cfg common.Config) *web.Server {
 	server := web.NewServer()
+	vld := validator.New()
+	repository := internal.NewRepository(db)
+	service := internal.NewService(repository, vld, cfg.Saga, cfg.Invoice,
+		internal.NewTableTaxCalculator(repository, cfg.Tax),
+		internal.NewInventoryClient(cfg.Services.InventoryURL),
+		internal.NewCatalogClient(cfg.Services.CatalogURL),
+		internal.NewCartClient(cfg.Services.CartURL),
+		internal.NewPaymentClient(cfg.Services.PaymentURL),
+		internal.NewPaymentSigner(cfg.Callback),
+		internal.NewCustomerNotifications(internal.NewNotificationClient(cfg.Services.NotificationURL), logger, cfg.Invoice),
+	)
+	go internal.NewSagaRecovery(service, logger, cfg.Saga).Start(ctx)
+	go internal.NewEventRelay(repository, internal.NewWebhookClient(), logger, cfg.Outbox).Start(ctx)
+	controller := internal.NewController(service, *logger)
 	server.Router.Route("/api", func(r chi.Router) {
-		r.Route("v1", func(r chi.Router) {
+		r.Route("/v1", func(r chi.Router) {
 			r.Mount("/orders", controller.Routes())
+			r.Mount("/users", controller.UserRoutes())
+			r.Mount("/events", controller.EventRoutes())
 		})
 	})
-	err := http.ListenAndServe("8083", server.Router)
-	if err != nil {
-		return
-	}
+	return server
 }
@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Отмена идет в три шага. Сначала под блокировкой строки заказа заказ переводится в canceling
+// и запоминает, какие строки отменяются: пока отмена не закончена, заказ нельзя отгрузить или
+// отменить другими строками. Затем снимаются резервы и возвращаются деньги. Оба внешних вызова
+// идемпотентны (повторное снятие резерва ничего не делает, возврат идет с ключом), поэтому
+// упавшую на середине отмену можно повторить тем же запросом. В конце строки фиксируются,
+// а заказ переходит в canceled или, если отменена только часть строк, в прежний статус.
+
+// CancelOrder отменяет заказ целиком, пока он не отгружен
+func (s *Service) CancelOrder(orderID uuid.UUID, req CancelOrderRequest) (OrderResponse, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return OrderResponse{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	return s.cancel(orderID, nil, req.Actor, req.Reason)
+}
+
+// CancelLines отменяет отдельные строки заказа. Отмена последних строк отменяет весь заказ.
+func (s *Service) CancelLines(orderID uuid.UUID, req CancelLinesRequest) (OrderResponse, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return OrderResponse{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	return s.cancel(orderID, req.ProductIDs, req.Actor, req.Reason)
+}
+
+// cancellation отмена, которую начали, но еще не зафиксировали
+type cancellation struct {
+	order      OrderRow
+	items      []ItemRow
+	productIDs []uuid.UUID
+	whole      bool // отменяются все оставшиеся строки
+	totals     OrderTotals
+	refund     int64
+}
+
+// cancel отменяет строки productIDs, nil - все оставшиеся
+func (s *Service) cancel(orderID uuid.UUID, productIDs []uuid.UUID, actor, reason string) (OrderResponse, error) {
+	c, err := s.startCancellation(orderID, productIDs, actor, reason)
+	if err != nil {
+		return OrderResponse{}, err
+	}
+	if c.whole {
+		err = s.inventory.ReleaseOrder(orderID)
+	} else {
+		for _, id := range c.productIDs {
+			// строка без резерва (404) не мешает снять остальные
+			if err = s.inventory.ReleaseLine(orderID, id); err != nil && !hasStatus(err, http.StatusNotFound) {
+				break
+			}
+			err = nil
+		}
+	}
+	// резерва уже нет: истек или снят прошлой попыткой
+	if err != nil && !hasStatus(err, http.StatusNotFound) {
+		return OrderResponse{}, fmt.Errorf("order service: cancel order: %w", err)
+	}
+	if c.refund > 0 {
+		_, err = s.payments.Refund(orderID, RefundRequest{Amount: c.refund, Key: cancelKey(c.productIDs), Reason: reason})
+		if err != nil {
+			return OrderResponse{}, fmt.Errorf("order service: cancel order: %w", err)
+		}
+	}
+	creditNote, err := s.finishCancellation(c, actor, reason)
+	if err != nil {
+		return OrderResponse{}, err
+	}
+	if creditNote != nil {
+		s.invoiceIssued(*creditNote)
+	}
+	return s.getOrder(orderID)
+}
+
+// startCancellation переводит заказ в canceling. Заказ, который уже отменяется теми же строками,
+// отдается как есть: это повтор упавшей отмены.
+func (s *Service) startCancellation(orderID uuid.UUID, productIDs []uuid.UUID, actor, reason string) (cancellation, error) {
+	var c cancellation
+	err := s.withTx("cancel order", func(tx *sqlx.Tx) error {
+		order, err := s.repo.GetOrderForUpdate(tx, orderID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &common.NotFoundError{Message: fmt.Sprintf("order %s not found", orderID)}
+		}
+		if err != nil {
+			return err
+		}
+		items, err := s.repo.GetOrderItems(orderID)
+		if err != nil {
+			return err
+		}
+		if c, err = planCancellation(order, items, productIDs); err != nil {
+			return err
+		}
+		key := cancelKey(c.productIDs)
+		if order.Status == Canceling {
+			if order.CancelKey != key {
+				return &common.ConflictError{Message: fmt.Sprintf("order %s is being canceled by another request", orderID)}
+			}
+			return nil
+		}
+		if err = checkCancellable(order); err != nil {
+			return err
+		}
+		if err = s.repo.SetCancellation(tx, orderID, key, order.Status); err != nil {
+			return err
+		}
+		c.order.CancelKey, c.order.CancelFrom = key, order.Status
+		_, err = s.transition(tx, orderID, Canceling, actor, reason)
+		return err
+	})
+	if err != nil {
+		return cancellation{}, err
+	}
+	return c, nil
+}
+
+// planCancellation проверяет строки и считает итоги и сумму возврата после отмены
+func planCancellation(order OrderRow, items []ItemRow, productIDs []uuid.UUID) (cancellation, error) {
+	lines := make(map[uuid.UUID]int, len(items))
+	active := 0
+	for i, it := range items {
+		lines[it.ID] = i
+		if it.CanceledAt == nil {
+			active++
+		}
+	}
+	if productIDs == nil {
+		for _, it := range items {
+			if it.CanceledAt == nil {
+				productIDs = append(productIDs, it.ID)
+			}
+		}
+	}
+	productIDs = uniqueIDs(productIDs)
+	now := time.Now()
+	rest := append([]ItemRow(nil), items...)
+	for _, id := range productIDs {
+		i, ok := lines[id]
+		if !ok {
+			return cancellation{}, &common.RequestValidationError{Message: fmt.Sprintf("product %s is not in order", id)}
+		}
+		if rest[i].CanceledAt != nil {
+			return cancellation{}, &common.ConflictError{Message: fmt.Sprintf("line %s is already canceled", id)}
+		}
+		rest[i].CanceledAt = &now
+	}
+	canceled := make([]ItemRow, 0, len(productIDs))
+	for _, id := range productIDs {
+		canceled = append(canceled, items[lines[id]])
+	}
+	totals := orderTotals(rest)
+	return cancellation{
+		order:      order,
+		items:      canceled,
+		productIDs: productIDs,
+		whole:      len(productIDs) == active,
+		totals:     totals,
+		refund:     order.GrandTotal - totals.Gross,
+	}, nil
+}
+
+// finishCancellation фиксирует отмененные строки, выписывает корректировочный счет
+// и выводит заказ из canceling
+func (s *Service) finishCancellation(c cancellation, actor, reason string) (*Invoice, error) {
+	var creditNote *Invoice
+	err := s.withTx("cancel order", func(tx *sqlx.Tx) error {
+		current, err := s.repo.GetOrderForUpdate(tx, c.order.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status != Canceling || current.CancelKey != c.order.CancelKey {
+			return &common.ConflictError{Message: fmt.Sprintf("order %s changed status to %s during cancellation",
+				c.order.ID, current.Status)}
+		}
+		now := time.Now()
+		if err = s.repo.CancelOrderItems(tx, c.order.ID, c.productIDs, now); err != nil {
+			return err
+		}
+		if err = s.repo.UpdateOrderTotals(tx, c.order.ID, c.totals); err != nil {
+			return err
+		}
+		if c.refund > 0 {
+			if creditNote, err = s.issueCreditNote(tx, current, c.items, c.refund, reason, now); err != nil {
+				return err
+			}
+		}
+		if err = s.repo.SetCancellation(tx, c.order.ID, "", ""); err != nil {
+			return err
+		}
+		if c.whole {
+			_, err = s.transition(tx, c.order.ID, Canceled, actor, reason)
+			return err
+		}
+		return s.restoreStatus(tx, current, actor, reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return creditNote, nil
+}
+
+// restoreStatus возвращает заказ в статус до отмены части строк. Это не переход по transitions:
+// оплата заказа уже опубликована, а другие переходы из canceling запрещены.
+func (s *Service) restoreStatus(tx *sqlx.Tx, order OrderRow, actor, reason string) error {
+	if err := s.repo.UpdateOrderStatus(tx, order.ID, order.CancelFrom); err != nil {
+		return err
+	}
+	return s.repo.AddStatusChange(tx, StatusChange{
+		ID:        uuid.New(),
+		OrderID:   order.ID,
+		From:      Canceling,
+		To:        order.CancelFrom,
+		Actor:     actor,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+}
+
+func checkCancellable(order OrderRow) error {
+	switch order.Status {
+	case PendingPayment, Paid:
+		return nil
+	case New:
+		return &common.ConflictError{Message: fmt.Sprintf("order %s is still being placed", order.ID)}
+	case Shipped, Delivered:
+		return &common.ConflictError{Message: fmt.Sprintf("order %s is already %s and can't be canceled", order.ID, order.Status)}
+	default:
+		return &common.ConflictError{Message: fmt.Sprintf("order %s is already %s", order.ID, order.Status)}
+	}
+}
+
+// cancelKey ключ возврата: одна и та же отмена дает один и тот же ключ
+func cancelKey(productIDs []uuid.UUID) string {
+	ids := make([]string, 0, len(productIDs))
+	for _, id := range productIDs {
+		ids = append(ids, id.String())
+	}
+	return "cancel:" + strings.Join(ids, ",")
+}
+
+// uniqueIDs убирает повторы и сортирует id
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	result := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i][:], result[j][:]) < 0
+	})
+	return result
+}
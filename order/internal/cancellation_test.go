@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"net/http"
+	"testing"
+)
+
+var (
+	lineA = uuid.MustParse("00000000-0000-0000-0000-00000000000a")
+	lineB = uuid.MustParse("00000000-0000-0000-0000-00000000000b")
+)
+
+// placedOrder оформляет заказ на 2*250 + 250 - 100 = 650 и сбрасывает журнал вызовов.
+// Скидка делится по строкам пропорционально: A 500 - 67 = 433, B 250 - 33 = 217.
+func placedOrder(t *testing.T) (*Service, *sagaRepo, *sagaDeps, uuid.UUID) {
+	t.Helper()
+	deps := &sagaDeps{}
+	svc, repo := newSagaService(deps)
+	order, err := svc.CreateOrder(CreatOrderRequest{
+		UserID:     uuid.New(),
+		Items:      []ItemQty{{ID: lineA, Quantity: 2}, {ID: lineB, Quantity: 1}},
+		Promotions: sagaOrder.Promotions,
+	}, "")
+	if err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	if order.GrandTotal != 650 {
+		t.Fatalf("grand total = %d, want 650", order.GrandTotal)
+	}
+	deps.calls = nil
+	return svc, repo, deps, order.ID
+}
+
+func TestCancelOrder(t *testing.T) {
+	svc, repo, deps, orderID := placedOrder(t)
+	order, err := svc.CancelOrder(orderID, CancelOrderRequest{Actor: "user", Reason: "changed my mind"})
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	assertCalls(t, deps.calls, "release", "refund")
+	if len(deps.refunds) != 1 || deps.refunds[0].Amount != 650 {
+		t.Errorf("refunds = %+v, want one of 650", deps.refunds)
+	}
+	if order.Status != Canceled || order.GrandTotal != 0 {
+		t.Errorf("status/total = %s/%d, want canceled/0", order.Status, order.GrandTotal)
+	}
+	for _, it := range repo.items[orderID] {
+		if it.CanceledAt == nil {
+			t.Errorf("line %s is not canceled", it.ID)
+		}
+	}
+	if h := repo.history[orderID]; h[len(h)-1].To != Canceled || h[len(h)-1].Actor != "user" {
+		t.Errorf("last status change = %+v, want canceled by user", h[len(h)-1])
+	}
+
+	_, err = svc.CancelOrder(orderID, CancelOrderRequest{Actor: "user"})
+	var conflict *common.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Errorf("repeated cancel: err = %v, want ConflictError", err)
+	}
+}
+
+func TestCancelLines(t *testing.T) {
+	svc, repo, deps, orderID := placedOrder(t)
+	order, err := svc.CancelLines(orderID, CancelLinesRequest{ProductIDs: []uuid.UUID{lineB, lineB}, Actor: "user"})
+	if err != nil {
+		t.Fatalf("cancel line B: %v", err)
+	}
+	assertCalls(t, deps.calls, "release "+lineB.String(), "refund")
+	if order.Status != PendingPayment || order.GrandTotal != 433 {
+		t.Errorf("status/total = %s/%d, want pending_payment/433", order.Status, order.GrandTotal)
+	}
+	if deps.refunds[0].Amount != 217 || deps.refunds[0].Key != "cancel:"+lineB.String() {
+		t.Errorf("refund = %+v, want 217 with key for line B", deps.refunds[0])
+	}
+
+	var conflict *common.ConflictError
+	if _, err = svc.CancelLines(orderID, CancelLinesRequest{ProductIDs: []uuid.UUID{lineB}, Actor: "user"}); !errors.As(err, &conflict) {
+		t.Errorf("cancel canceled line: err = %v, want ConflictError", err)
+	}
+	var invalid *common.RequestValidationError
+	if _, err = svc.CancelLines(orderID, CancelLinesRequest{ProductIDs: []uuid.UUID{uuid.New()}, Actor: "user"}); !errors.As(err, &invalid) {
+		t.Errorf("cancel unknown line: err = %v, want RequestValidationError", err)
+	}
+
+	// отмена последней строки отменяет заказ целиком
+	deps.calls = nil
+	order, err = svc.CancelLines(orderID, CancelLinesRequest{ProductIDs: []uuid.UUID{lineA}, Actor: "user"})
+	if err != nil {
+		t.Fatalf("cancel line A: %v", err)
+	}
+	assertCalls(t, deps.calls, "release", "refund")
+	if order.Status != Canceled || order.GrandTotal != 0 {
+		t.Errorf("status/total = %s/%d, want canceled/0", order.Status, order.GrandTotal)
+	}
+	if len(repo.items[orderID]) != 2 {
+		t.Errorf("order lines = %d, want 2", len(repo.items[orderID]))
+	}
+}
+
+func TestCancelOrderWithoutReservation(t *testing.T) {
+	svc, _, deps, orderID := placedOrder(t)
+	// резерв истек: Inventory отвечает 404, отмена все равно проходит
+	delete(deps.reserved, orderID)
+	order, err := svc.CancelOrder(orderID, CancelOrderRequest{Actor: "system"})
+	if err != nil || order.Status != Canceled {
+		t.Fatalf("cancel = %s, %v; want canceled", order.Status, err)
+	}
+}
+
+func TestCancelOrderRetriesAfterInventoryFailure(t *testing.T) {
+	svc, repo, deps, orderID := placedOrder(t)
+	deps.releaseErrs = []error{&StatusError{Code: http.StatusServiceUnavailable}}
+	if _, err := svc.CancelOrder(orderID, CancelOrderRequest{Actor: "user"}); err == nil {
+		t.Fatal("cancel: want error while inventory is down")
+	}
+	// заказ остается в canceling, пока отмену не доведут тем же запросом
+	if status := repo.orders[orderID].Status; status != Canceling || len(deps.refunds) != 0 {
+		t.Fatalf("status %s, refunds %d; want canceling, no refunds", status, len(deps.refunds))
+	}
+	var conflict *common.ConflictError
+	if _, err := svc.CancelLines(orderID, CancelLinesRequest{ProductIDs: []uuid.UUID{lineB}, Actor: "user"}); !errors.As(err, &conflict) {
+		t.Fatalf("cancel other lines during cancellation: err = %v, want ConflictError", err)
+	}
+	if _, err := svc.CancelOrder(orderID, CancelOrderRequest{Actor: "user"}); err != nil {
+		t.Fatalf("retry cancel: %v", err)
+	}
+	if status := repo.orders[orderID].Status; status != Canceled {
+		t.Errorf("status = %s, want canceled", status)
+	}
+}
+
+func TestCancelOrderNotCancellable(t *testing.T) {
+	for _, status := range []Status{New, Shipped, Delivered} {
+		t.Run(string(status), func(t *testing.T) {
+			svc, repo, deps, orderID := placedOrder(t)
+			order := repo.orders[orderID]
+			order.Status = status
+			repo.orders[orderID] = order
+			_, err := svc.CancelOrder(orderID, CancelOrderRequest{Actor: "user"})
+			var conflict *common.ConflictError
+			if !errors.As(err, &conflict) {
+				t.Fatalf("err = %v, want ConflictError", err)
+			}
+			if len(deps.calls) != 0 {
+				t.Errorf("calls = %v, want none", deps.calls)
+			}
+		})
+	}
+
+	svc, _, _, _ := placedOrder(t)
+	var notFound *common.NotFoundError
+	if _, err := svc.CancelOrder(uuid.New(), CancelOrderRequest{Actor: "user"}); !errors.As(err, &notFound) {
+		t.Errorf("unknown order: err = %v, want NotFoundError", err)
+	}
+}
@@ -0,0 +1,255 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"net/http"
+	"time"
+)
+
+// StatusError сервис ответил кодом, отличным от 200
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (err *StatusError) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("unexpected status %d", err.Code)
+	}
+	return fmt.Sprintf("unexpected status %d: %s", err.Code, err.Message)
+}
+
+// isPermanent ошибка бизнес-уровня (4xx), повтор ничего не изменит
+func isPermanent(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Code < http.StatusInternalServerError
+}
+
+func hasStatus(err error, codes ...int) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	for _, code := range codes {
+		if statusErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+type InventoryClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewInventoryClient(baseURL string) *InventoryClient {
+	return &InventoryClient{baseURL: baseURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// ReserveOrder резервирует все строки заказа разом, при нехватке не резервирует ничего
+func (c *InventoryClient) ReserveOrder(req ReserveOrderRequest) error {
+	url := c.baseURL + "/api/v1/inventories/reserve/batch"
+	if err := doJSON(c.client, http.MethodPost, url, nil, req, nil); err != nil {
+		return fmt.Errorf("inventory client: reserve order: %w", err)
+	}
+	return nil
+}
+
+func (c *InventoryClient) GetReservations(orderID uuid.UUID) ([]Reservation, error) {
+	var resp common.Response[OrderReservationsResponse]
+	url := c.baseURL + "/api/v1/inventories/reservations/" + orderID.String()
+	if err := doJSON(c.client, http.MethodGet, url, nil, nil, &resp); err != nil {
+		return nil, fmt.Errorf("inventory client: get reservations: %w", err)
+	}
+	return resp.Data.Reservations, nil
+}
+
+func (c *InventoryClient) ReleaseOrder(orderID uuid.UUID) error {
+	url := c.baseURL + "/api/v1/inventories/reservations/" + orderID.String() + "/release"
+	if err := doJSON(c.client, http.MethodPost, url, nil, nil, nil); err != nil {
+		return fmt.Errorf("inventory client: release order: %w", err)
+	}
+	return nil
+}
+
+// CommitOrder списывает зарезервированный товар оплаченного заказа
+func (c *InventoryClient) CommitOrder(orderID uuid.UUID) error {
+	url := c.baseURL + "/api/v1/inventories/reservations/" + orderID.String() + "/commit"
+	if err := doJSON(c.client, http.MethodPost, url, nil, nil, nil); err != nil {
+		return fmt.Errorf("inventory client: commit order: %w", err)
+	}
+	return nil
+}
+
+// ReleaseLine снимает резерв одной строки заказа
+func (c *InventoryClient) ReleaseLine(orderID, productID uuid.UUID) error {
+	req := struct {
+		Id      uuid.UUID
+		OrderID uuid.UUID
+	}{Id: productID, OrderID: orderID}
+	if err := doJSON(c.client, http.MethodPost, c.baseURL+"/api/v1/inventories/release", nil, req, nil); err != nil {
+		return fmt.Errorf("inventory client: release line: %w", err)
+	}
+	return nil
+}
+
+// RecordMovement проводит движение по журналу склада, например возврат товара от покупателя
+func (c *InventoryClient) RecordMovement(req MovementRequest) error {
+	if err := doJSON(c.client, http.MethodPost, c.baseURL+"/api/v1/inventories/movements", nil, req, nil); err != nil {
+		return fmt.Errorf("inventory client: record movement: %w", err)
+	}
+	return nil
+}
+
+type CatalogClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewCatalogClient(baseURL string) *CatalogClient {
+	return &CatalogClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (c *CatalogClient) GetProduct(id uuid.UUID) (CatalogItem, error) {
+	var resp common.Response[CatalogItem]
+	url := c.baseURL + "/api/v1/catalogs/" + id.String()
+	if err := doJSON(c.client, http.MethodGet, url, nil, nil, &resp); err != nil {
+		return CatalogItem{}, fmt.Errorf("catalog client: get product %s: %w", id, err)
+	}
+	return resp.Data, nil
+}
+
+type CartClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewCartClient(baseURL string) *CartClient {
+	return &CartClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// QuotePromotions проверяет акции заказа и считает скидки. Недействующая акция - 404 или 409.
+func (c *CartClient) QuotePromotions(req PromotionQuoteRequest) (PromotionQuote, error) {
+	var resp common.Response[PromotionQuote]
+	url := c.baseURL + "/api/v1/carts/promotions/quote"
+	if err := doJSON(c.client, http.MethodPost, url, nil, req, &resp); err != nil {
+		return PromotionQuote{}, fmt.Errorf("cart client: quote promotions: %w", err)
+	}
+	return resp.Data, nil
+}
+
+type PaymentClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewPaymentClient(baseURL string) *PaymentClient {
+	return &PaymentClient{baseURL: baseURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// CreatePayment создает платеж по заказу. Payment держит один платеж на заказ,
+// так что повтор после потерянного ответа вернет уже созданный платеж, а не второй.
+func (c *PaymentClient) CreatePayment(req PaymentRequest) (PaymentResponse, error) {
+	var resp common.Response[PaymentResponse]
+	if err := doJSON(c.client, http.MethodPost, c.baseURL+"/api/v1/payments/", nil, req, &resp); err != nil {
+		return PaymentResponse{}, fmt.Errorf("payment client: create payment: %w", err)
+	}
+	return resp.Data, nil
+}
+
+func (c *PaymentClient) CancelPayment(orderID uuid.UUID) error {
+	url := c.baseURL + "/api/v1/payments/" + orderID.String() + "/cancel"
+	if err := doJSON(c.client, http.MethodPost, url, nil, nil, nil); err != nil {
+		return fmt.Errorf("payment client: cancel payment: %w", err)
+	}
+	return nil
+}
+
+// Refund возвращает деньги: Payment сам решает, снять блокировку или оформить возврат
+func (c *PaymentClient) Refund(orderID uuid.UUID, req RefundRequest) (Refund, error) {
+	var resp common.Response[Refund]
+	url := c.baseURL + "/api/v1/payments/" + orderID.String() + "/refunds"
+	if err := doJSON(c.client, http.MethodPost, url, nil, req, &resp); err != nil {
+		return Refund{}, fmt.Errorf("payment client: refund: %w", err)
+	}
+	return resp.Data, nil
+}
+
+type NotificationClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewNotificationClient(baseURL string) *NotificationClient {
+	return &NotificationClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (c *NotificationClient) Notify(req NotificationRequest) error {
+	if err := doJSON(c.client, http.MethodPost, c.baseURL+"/api/v1/notifications/notify", nil, req, nil); err != nil {
+		return fmt.Errorf("notification client: notify: %w", err)
+	}
+	return nil
+}
+
+// WebhookClient доставляет события заказа на url подписчиков
+type WebhookClient struct {
+	client *http.Client
+}
+
+func NewWebhookClient() *WebhookClient {
+	return &WebhookClient{client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (c *WebhookClient) SendEvent(url string, event EventEnvelope) error {
+	header := http.Header{}
+	header.Set("X-Event-Id", event.ID.String())
+	header.Set("X-Event-Type", string(event.Type))
+	if err := doJSON(c.client, http.MethodPost, url, header, event, nil); err != nil {
+		return fmt.Errorf("webhook client: send %s: %w", event.Type, err)
+	}
+	return nil
+}
+
+func doJSON(client *http.Client, method, url string, header http.Header, body any, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	// подписчики на события могут ответить 202 или 204
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		statusErr := &StatusError{Code: resp.StatusCode}
+		var errResp common.Response[any]
+		if json.NewDecoder(resp.Body).Decode(&errResp) == nil && errResp.Message != nil {
+			statusErr.Message = *errResp.Message
+		}
+		return statusErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
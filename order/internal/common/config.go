@@ -1,14 +1,22 @@
 package common
 
 import (
+	"fmt"
 	"github.com/kelseyhightower/envconfig"
 	"os"
 	"strings"
+	"time"
 )
 
 type Config struct {
 	DB             DBConfig
 	Server         ServerConfig
+	Services       ServicesConfig
+	Saga           SagaConfig
+	Outbox         OutboxConfig
+	Callback       CallbackConfig
+	Invoice        InvoiceConfig
+	Tax            TaxConfig
 	LogLevel       string
 	LogDevelopMode bool
 	AllowedOrigins []string
@@ -27,6 +35,52 @@ type ServerConfig struct {
 	Port    string `envconfig:"PORT" required:"true"`
 }
 
+type ServicesConfig struct {
+	InventoryURL    string `envconfig:"INVENTORY_URL" required:"true"`
+	CatalogURL      string `envconfig:"CATALOG_URL" required:"true"`
+	CartURL         string `envconfig:"CART_URL" required:"true"`
+	PaymentURL      string `envconfig:"PAYMENT_URL" required:"true"`
+	NotificationURL string `envconfig:"NOTIFICATION_URL" required:"true"`
+}
+
+type SagaConfig struct {
+	MaxAttempts      int           `envconfig:"MAX_ATTEMPTS" default:"5"` // сколько раз повторять шаг при временных ошибках
+	RecoveryInterval time.Duration `envconfig:"RECOVERY_INTERVAL" default:"30s"`
+	StaleAfter       time.Duration `envconfig:"STALE_AFTER" default:"2m"` // сага без движения дольше считается брошенной
+	Lease            time.Duration `envconfig:"LEASE" default:"1m"`       // аренда исполнителя, продлевается каждым шагом
+	BatchSize        int           `envconfig:"BATCH_SIZE" default:"50"`
+	Currency         string        `envconfig:"CURRENCY" default:"RUB"`
+}
+
+type OutboxConfig struct {
+	RelayInterval time.Duration `envconfig:"RELAY_INTERVAL" default:"5s"`
+	BatchSize     int           `envconfig:"BATCH_SIZE" default:"100"`
+	MaxAttempts   int           `envconfig:"MAX_ATTEMPTS" default:"10"`   // после стольких неудач доставка уходит в dead letter
+	RetryBackoff  time.Duration `envconfig:"RETRY_BACKOFF" default:"10s"` // пауза после первой неудачи, дальше удваивается
+	MaxBackoff    time.Duration `envconfig:"MAX_BACKOFF" default:"30m"`
+	DeliveryLease time.Duration `envconfig:"DELIVERY_LEASE" default:"1m"` // на это время взятая доставка скрыта от других экземпляров
+}
+
+// CallbackConfig проверка callback об оплате от Payment
+type CallbackConfig struct {
+	PaymentSecret string        `envconfig:"PAYMENT_SECRET" required:"true"` // общий с Payment ключ подписи
+	MaxSkew       time.Duration `envconfig:"MAX_SKEW" default:"5m"`          // насколько старую подпись еще принимаем
+}
+
+// InvoiceConfig реквизиты продавца для счетов
+type InvoiceConfig struct {
+	SellerName    string `envconfig:"SELLER_NAME" default:"Mini Market"`
+	SellerAddress string `envconfig:"SELLER_ADDRESS"`
+	SellerTaxID   string `envconfig:"SELLER_TAX_ID"`
+	PublicURL     string `envconfig:"PUBLIC_URL"` // адрес сервиса для ссылки на PDF в письме
+}
+
+// TaxConfig расчет налога по таблице ставок
+type TaxConfig struct {
+	Mode          string `envconfig:"MODE" default:"inclusive"`    // inclusive - налог в цене каталога, exclusive - сверху
+	DefaultRegion string `envconfig:"DEFAULT_REGION" default:"RU"` // для заказов без региона доставки
+}
+
 func Load() (Config, error) {
 	var cfg Config = Config{
 		LogLevel:       os.Getenv("LOG_LEVEL"),
@@ -43,6 +97,36 @@ func Load() (Config, error) {
 	} else {
 		cfg.Server = server
 	}
+	if services, err := LoadServicesConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Services = services
+	}
+	if saga, err := LoadSagaConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Saga = saga
+	}
+	if outbox, err := LoadOutboxConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Outbox = outbox
+	}
+	if callback, err := LoadCallbackConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Callback = callback
+	}
+	if invoice, err := LoadInvoiceConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Invoice = invoice
+	}
+	if tax, err := LoadTaxConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Tax = tax
+	}
 	return cfg, nil
 }
 
@@ -63,3 +147,64 @@ func LoadServerConfig() (ServerConfig, error) {
 	}
 	return cfg, nil
 }
+
+func LoadServicesConfig() (ServicesConfig, error) {
+	var cfg ServicesConfig
+	err := envconfig.Process("SERVICES", &cfg)
+	if err != nil {
+		return ServicesConfig{}, err
+	}
+	return cfg, nil
+}
+
+func LoadSagaConfig() (SagaConfig, error) {
+	var cfg SagaConfig
+	err := envconfig.Process("SAGA", &cfg)
+	if err != nil {
+		return SagaConfig{}, err
+	}
+	return cfg, nil
+}
+
+func LoadOutboxConfig() (OutboxConfig, error) {
+	var cfg OutboxConfig
+	err := envconfig.Process("OUTBOX", &cfg)
+	if err != nil {
+		return OutboxConfig{}, err
+	}
+	return cfg, nil
+}
+
+func LoadCallbackConfig() (CallbackConfig, error) {
+	var cfg CallbackConfig
+	err := envconfig.Process("CALLBACK", &cfg)
+	if err != nil {
+		return CallbackConfig{}, err
+	}
+	return cfg, nil
+}
+
+func LoadInvoiceConfig() (InvoiceConfig, error) {
+	var cfg InvoiceConfig
+	err := envconfig.Process("INVOICE", &cfg)
+	if err != nil {
+		return InvoiceConfig{}, err
+	}
+	return cfg, nil
+}
+
+func LoadTaxConfig() (TaxConfig, error) {
+	var cfg TaxConfig
+	err := envconfig.Process("TAX", &cfg)
+	if err != nil {
+		return TaxConfig{}, err
+	}
+	return cfg, nil
+}
+
+func (db DBConfig) DSN() string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		db.Server, db.Port, db.User, db.Pass, db.Database,
+	)
+}
@@ -23,3 +23,19 @@ type NotFoundError struct {
 func (err *NotFoundError) Error() string {
 	return err.Message
 }
+
+type ConflictError struct {
+	Message string
+}
+
+func (err *ConflictError) Error() string {
+	return err.Message
+}
+
+type UnauthorizedError struct {
+	Message string
+}
+
+func (err *UnauthorizedError) Error() string {
+	return err.Message
+}
@@ -2,11 +2,15 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/madrabit/mini-market/order/internal/common"
 	"go.uber.org/zap"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 /*
@@ -37,19 +41,79 @@ func NewController(svc Svc, logger common.Logger) *Controller {
 }
 
 type Svc interface {
-	CreateOrder(req CreatOrderRequest) (OrderResponse, error)
+	CreateOrder(req CreatOrderRequest, idempotencyKey string) (OrderResponse, error)
 	GetStatus(user, order uuid.UUID) (StatusResponse, error)
 	UpdatePaymentStatus(req UpdatePaymentStatusRequest) error
+	ChangeStatus(orderID uuid.UUID, req ChangeStatusRequest) (StatusResponse, error)
+	GetOrder(id uuid.UUID) (OrderResponse, error)
+	GetUserOrders(filter OrdersFilter) (OrderListResponse, error)
+	CancelOrder(orderID uuid.UUID, req CancelOrderRequest) (OrderResponse, error)
+	CancelLines(orderID uuid.UUID, req CancelLinesRequest) (OrderResponse, error)
+	RequestReturn(orderID uuid.UUID, req CreateReturnRequest) (Return, error)
+	GetReturns(orderID uuid.UUID) ([]Return, error)
+	ApproveReturn(orderID, returnID uuid.UUID, req ReviewReturnRequest) (Return, error)
+	RejectReturn(orderID, returnID uuid.UUID, req ReviewReturnRequest) (Return, error)
+	ReceiveReturn(orderID, returnID uuid.UUID, req ReviewReturnRequest) (Return, error)
+	InspectReturn(orderID, returnID uuid.UUID, req InspectReturnRequest) (Return, error)
+	Subscribe(req SubscribeRequest) (Subscription, error)
+	GetSubscriptions() ([]Subscription, error)
+	Unsubscribe(id uuid.UUID) error
+	GetDeadLetters(limit, offset int) ([]Delivery, error)
+	ReplayDeadLetter(id uuid.UUID) (Delivery, error)
+	GetInvoices(orderID uuid.UUID) ([]Invoice, error)
+	GetInvoice(orderID, invoiceID uuid.UUID) (Invoice, error)
+	GetInvoicePDF(orderID, invoiceID uuid.UUID) (Invoice, []byte, error)
 }
 
 func (c *Controller) Routes() chi.Router {
 	r := chi.NewRouter()
-	//создать заказ
+	//создать заказ, повтор с тем же заголовком Idempotency-Key вернет уже созданный заказ
 	r.Post("/", c.CreateOrder)
-	//получает от сервиса payment что заказ оплачен
+	//получает от сервиса payment что заказ оплачен, запрос подписан общим ключом (см. PaymentSigner)
 	r.Post("/{orderID}/payment-status", c.UpdatePaymentStatus)
+	// Получить заказ: строки, итог, история статусов
+	r.Get("/{orderID}", c.GetOrder)
 	// Получить статус заказа
-	r.Get("{/orderID}", c.GetStatus)
+	r.Get("/{orderID}/status", c.GetStatus)
+	// Перевести заказ по доставке (shipped, delivered), недопустимый переход вернет 409
+	r.Post("/{orderID}/status", c.ChangeStatus)
+	// Отменить заказ целиком до отгрузки: резерв снимается, деньги возвращаются
+	r.Post("/{orderID}/cancel", c.CancelOrder)
+	// Отменить отдельные строки заказа до отгрузки
+	r.Post("/{orderID}/lines/cancel", c.CancelLines)
+	// Возвраты по доставленному заказу
+	r.Post("/{orderID}/returns", c.RequestReturn)
+	r.Get("/{orderID}/returns", c.GetReturns)
+	// Рассмотрение возврата сотрудником: одобрить, отказать, принять товар, закрыть проверку
+	r.Post("/{orderID}/returns/{returnID}/approve", c.ApproveReturn)
+	r.Post("/{orderID}/returns/{returnID}/reject", c.RejectReturn)
+	r.Post("/{orderID}/returns/{returnID}/receive", c.ReceiveReturn)
+	r.Post("/{orderID}/returns/{returnID}/inspect", c.InspectReturn)
+	// Счет и кредит-ноты заказа, документ в JSON или PDF
+	r.Get("/{orderID}/invoices", c.GetInvoices)
+	r.Get("/{orderID}/invoices/{invoiceID}", c.GetInvoice)
+	r.Get("/{orderID}/invoices/{invoiceID}/pdf", c.GetInvoicePDF)
+	return r
+}
+
+// UserRoutes заказы пользователя, монтируется в /users
+func (c *Controller) UserRoutes() chi.Router {
+	r := chi.NewRouter()
+	// Заказы пользователя с фильтром по статусу и дате создания, постранично
+	r.Get("/{userID}/orders", c.GetUserOrders)
+	return r
+}
+
+// EventRoutes подписки на события заказа и dead letter, монтируется в /events
+func (c *Controller) EventRoutes() chi.Router {
+	r := chi.NewRouter()
+	// Подписать url на order.created или order.paid, повторная подписка вернет существующую
+	r.Post("/subscriptions", c.Subscribe)
+	r.Get("/subscriptions", c.GetSubscriptions)
+	r.Delete("/subscriptions/{subscriptionID}", c.Unsubscribe)
+	// Доставки, от которых relay отказался, и их ручной повтор
+	r.Get("/dead-letters", c.GetDeadLetters)
+	r.Post("/dead-letters/{deliveryID}/replay", c.ReplayDeadLetter)
 	return r
 }
 
@@ -67,10 +131,10 @@ func (c *Controller) CreateOrder(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	resp, err := c.svc.CreateOrder(req)
+	resp, err := c.svc.CreateOrder(req, r.Header.Get("Idempotency-Key"))
 	if err != nil {
 		c.logger.Error("failed to create order", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
 		return
 	}
 	common.OkResponse(w, resp)
@@ -83,17 +147,26 @@ func (c *Controller) UpdatePaymentStatus(w http.ResponseWriter, r *http.Request)
 			c.logger.Error("failed to update payment status", zap.Error(err))
 		}
 	}()
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
 	var req UpdatePaymentStatusRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		c.logger.Error("failed to update payment status", zap.Error(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	req.OrderID = orderID
+	req.Timestamp = r.Header.Get("X-Payment-Timestamp")
+	req.Signature = r.Header.Get("X-Payment-Signature")
 	err = c.svc.UpdatePaymentStatus(req)
 	if err != nil {
 		c.logger.Error("failed to update payment status", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -101,10 +174,13 @@ func (c *Controller) UpdatePaymentStatus(w http.ResponseWriter, r *http.Request)
 }
 
 func (c *Controller) GetStatus(w http.ResponseWriter, r *http.Request) {
-	orderId := r.URL.Query().Get("orderID")
-	userID := r.URL.Query().Get("userID")
-	user, err := uuid.Parse(userID)
-	order, err := uuid.Parse(orderId)
+	order, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	user, err := uuid.Parse(r.URL.Query().Get("userID"))
 	if err != nil || user == uuid.Nil || order == uuid.Nil {
 		c.logger.Warn("invalid param")
 		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
@@ -113,8 +189,420 @@ func (c *Controller) GetStatus(w http.ResponseWriter, r *http.Request) {
 	status, err := c.svc.GetStatus(user, order)
 	if err != nil {
 		c.logger.Error("failed to get order status", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, status)
+}
+
+func (c *Controller) GetOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	order, err := c.svc.GetOrder(orderID)
+	if err != nil {
+		c.logger.Error("failed to get order", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, order)
+}
+
+func (c *Controller) GetUserOrders(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil || userID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	query := r.URL.Query()
+	filter := OrdersFilter{UserID: userID, Status: Status(query.Get("status"))}
+	for param, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := query.Get(param); v != "" {
+			if *dst, err = time.Parse(time.RFC3339, v); err != nil {
+				c.logger.Warn("invalid param")
+				common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+				return
+			}
+		}
+	}
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+	filter.Offset, _ = strconv.Atoi(query.Get("offset"))
+	orders, err := c.svc.GetUserOrders(filter)
+	if err != nil {
+		c.logger.Error("failed to get user orders", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, orders)
+}
+
+func (c *Controller) ChangeStatus(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req ChangeStatusRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to change status", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	status, err := c.svc.ChangeStatus(orderID, req)
+	if err != nil {
+		c.logger.Error("failed to change status", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
 		return
 	}
 	common.OkResponse(w, status)
 }
+
+func (c *Controller) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req CancelOrderRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to cancel order", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	order, err := c.svc.CancelOrder(orderID, req)
+	if err != nil {
+		c.logger.Error("failed to cancel order", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, order)
+}
+
+func (c *Controller) CancelLines(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req CancelLinesRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to cancel order lines", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	order, err := c.svc.CancelLines(orderID, req)
+	if err != nil {
+		c.logger.Error("failed to cancel order lines", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, order)
+}
+
+func (c *Controller) RequestReturn(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req CreateReturnRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to request return", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ret, err := c.svc.RequestReturn(orderID, req)
+	if err != nil {
+		c.logger.Error("failed to request return", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, ret)
+}
+
+func (c *Controller) GetReturns(w http.ResponseWriter, r *http.Request) {
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	returns, err := c.svc.GetReturns(orderID)
+	if err != nil {
+		c.logger.Error("failed to get returns", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, returns)
+}
+
+func (c *Controller) ApproveReturn(w http.ResponseWriter, r *http.Request) {
+	c.reviewReturn(w, r, c.svc.ApproveReturn)
+}
+
+func (c *Controller) RejectReturn(w http.ResponseWriter, r *http.Request) {
+	c.reviewReturn(w, r, c.svc.RejectReturn)
+}
+
+func (c *Controller) ReceiveReturn(w http.ResponseWriter, r *http.Request) {
+	c.reviewReturn(w, r, c.svc.ReceiveReturn)
+}
+
+func (c *Controller) reviewReturn(w http.ResponseWriter, r *http.Request,
+	review func(orderID, returnID uuid.UUID, req ReviewReturnRequest) (Return, error)) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	orderID, returnID, ok := c.returnParams(w, r)
+	if !ok {
+		return
+	}
+	var req ReviewReturnRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to update return", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ret, err := review(orderID, returnID, req)
+	if err != nil {
+		c.logger.Error("failed to update return", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, ret)
+}
+
+func (c *Controller) InspectReturn(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	orderID, returnID, ok := c.returnParams(w, r)
+	if !ok {
+		return
+	}
+	var req InspectReturnRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to inspect return", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ret, err := c.svc.InspectReturn(orderID, returnID, req)
+	if err != nil {
+		c.logger.Error("failed to inspect return", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, ret)
+}
+
+func (c *Controller) returnParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	orderID, errOrder := uuid.Parse(chi.URLParam(r, "orderID"))
+	returnID, errReturn := uuid.Parse(chi.URLParam(r, "returnID"))
+	if errOrder != nil || errReturn != nil || orderID == uuid.Nil || returnID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return uuid.Nil, uuid.Nil, false
+	}
+	return orderID, returnID, true
+}
+
+func (c *Controller) Subscribe(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	var req SubscribeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to subscribe", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sub, err := c.svc.Subscribe(req)
+	if err != nil {
+		c.logger.Error("failed to subscribe", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, sub)
+}
+
+func (c *Controller) GetSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subs, err := c.svc.GetSubscriptions()
+	if err != nil {
+		c.logger.Error("failed to get subscriptions", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, subs)
+}
+
+func (c *Controller) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "subscriptionID"))
+	if err != nil || id == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	if err = c.svc.Unsubscribe(id); err != nil {
+		c.logger.Error("failed to unsubscribe", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, id)
+}
+
+func (c *Controller) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	deliveries, err := c.svc.GetDeadLetters(limit, offset)
+	if err != nil {
+		c.logger.Error("failed to get dead letters", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, deliveries)
+}
+
+func (c *Controller) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "deliveryID"))
+	if err != nil || id == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	delivery, err := c.svc.ReplayDeadLetter(id)
+	if err != nil {
+		c.logger.Error("failed to replay dead letter", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, delivery)
+}
+
+func (c *Controller) GetInvoices(w http.ResponseWriter, r *http.Request) {
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	invoices, err := c.svc.GetInvoices(orderID)
+	if err != nil {
+		c.logger.Error("failed to get invoices", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, invoices)
+}
+
+func (c *Controller) GetInvoice(w http.ResponseWriter, r *http.Request) {
+	orderID, invoiceID, ok := c.invoiceParams(w, r)
+	if !ok {
+		return
+	}
+	invoice, err := c.svc.GetInvoice(orderID, invoiceID)
+	if err != nil {
+		c.logger.Error("failed to get invoice", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, invoice)
+}
+
+func (c *Controller) GetInvoicePDF(w http.ResponseWriter, r *http.Request) {
+	orderID, invoiceID, ok := c.invoiceParams(w, r)
+	if !ok {
+		return
+	}
+	invoice, pdf, err := c.svc.GetInvoicePDF(orderID, invoiceID)
+	if err != nil {
+		c.logger.Error("failed to get invoice pdf", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, invoice.Number))
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(pdf); err != nil {
+		c.logger.Error("failed to write invoice pdf", zap.Error(err))
+	}
+}
+
+func (c *Controller) invoiceParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	orderID, errOrder := uuid.Parse(chi.URLParam(r, "orderID"))
+	invoiceID, errInvoice := uuid.Parse(chi.URLParam(r, "invoiceID"))
+	if errOrder != nil || errInvoice != nil || orderID == uuid.Nil || invoiceID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return uuid.Nil, uuid.Nil, false
+	}
+	return orderID, invoiceID, true
+}
+
+func errStatus(err error) int {
+	var validationErr *common.RequestValidationError
+	var notFoundErr *common.NotFoundError
+	var existsErr *common.AlreadyExistsError
+	var conflictErr *common.ConflictError
+	var unauthorizedErr *common.UnauthorizedError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.As(err, &unauthorizedErr):
+		return http.StatusUnauthorized
+	case errors.As(err, &notFoundErr):
+		return http.StatusNotFound
+	case errors.As(err, &existsErr), errors.As(err, &conflictErr):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
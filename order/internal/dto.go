@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"encoding/json"
 	"github.com/google/uuid"
 	"time"
 )
@@ -8,27 +9,51 @@ import (
 type Status string
 
 const (
-	New       Status = "new"
-	Shipped   Status = "shipped"
-	Delivered Status = "delivered"
-	Paid      Status = "paid"
-	Canceled  Status = "canceled"
+	New            Status = "new"
+	PendingPayment Status = "pending_payment" // товар зарезервирован, платеж создан, ждем оплату
+	Shipped        Status = "shipped"
+	Delivered      Status = "delivered"
+	Paid           Status = "paid"
+	Canceled       Status = "canceled"
+	Canceling      Status = "canceling" // идет отмена: снимается резерв, возвращаются деньги
+	// после доставки
+	ReturnRequested   Status = "return_requested"   // есть незакрытый возврат
+	PartiallyReturned Status = "partially_returned" // часть товаров возвращена
+	Returned          Status = "returned"           // возвращено все
 )
 
+// SystemActor инициатор изменений, которые делает сам сервис
+const SystemActor = "system"
+
+// ItemRow строка заказа. Name и UnitPrice снапшот из каталога на момент оформления
 type ItemRow struct {
-	ID        uuid.UUID `db:"id"`
-	Name      string    `db:"name"`
-	Quantity  int64     `db:"quantity"`
-	OrderID   uuid.UUID `db:"order_id"`
-	UnitPrice int64     `db:"unit_price"`
+	ID         uuid.UUID  `db:"id"` // id товара
+	Name       string     `db:"name"`
+	Quantity   int64      `db:"quantity"`
+	OrderID    uuid.UUID  `db:"order_id"`
+	UnitPrice  int64      `db:"unit_price"`
+	CanceledAt *time.Time `db:"canceled_at"` // строка отменена до отгрузки
+	TaxClass   string     `db:"tax_class"`
+	TaxRate    int        `db:"tax_rate"` // в сотых долях процента
+	Net        int64      `db:"net"`      // net, tax, gross - после распределения скидок заказа
+	Tax        int64      `db:"tax"`
+	Gross      int64      `db:"gross"`
 }
 
 type OrderRow struct {
-	ID         uuid.UUID `db:"id"`
-	UserID     uuid.UUID `db:"user_id"`
-	CreatedAt  time.Time `db:"created_at"`
-	Status     Status    `db:"status"`
-	GrandTotal int64     `db:"grand_total"`
+	ID         uuid.UUID  `db:"id"`
+	UserID     uuid.UUID  `db:"user_id"`
+	CreatedAt  time.Time  `db:"created_at"`
+	Status     Status     `db:"status"`
+	GrandTotal int64      `db:"grand_total"`
+	PaymentID  *uuid.UUID `db:"payment_id"` // платеж, которым оплачен заказ
+	PaidAt     *time.Time `db:"paid_at"`
+	Region     string     `db:"region"`   // регион доставки, по нему выбирались ставки налога
+	TaxMode    TaxMode    `db:"tax_mode"` // налог в цене каталога или сверху
+	NetTotal   int64      `db:"net_total"`
+	TaxTotal   int64      `db:"tax_total"`
+	CancelKey  string     `db:"cancel_key"`  // строки, которые сейчас отменяются, см. cancelKey
+	CancelFrom Status     `db:"cancel_from"` // статус до начала отмены
 }
 
 type ItemQty struct {
@@ -37,29 +62,128 @@ type ItemQty struct {
 }
 
 type CreatOrderRequest struct {
-	UserID uuid.UUID `json:"user_id" validate:"required"`
-	Items  []ItemQty `json:"items" validate:"min=1,dive"`
+	UserID     uuid.UUID          `json:"user_id" validate:"required"`
+	Region     string             `json:"region" validate:"max=10"` // регион доставки, пустой - регион по умолчанию
+	Items      []ItemQty          `json:"items" validate:"min=1,dive"`
+	Promotions []AppliedPromotion `json:"promotions" validate:"dive"`
+}
+
+// AppliedPromotion снапшот промо-акции, примененной в корзине на момент оформления.
+// Discount из запроса на создание заказа не используется: на шаге price_lines Cart
+// заново проверяет акции и считает скидку по ценам каталога.
+type AppliedPromotion struct {
+	PromotionID uuid.UUID `json:"promotion_id" db:"promotion_id" validate:"required"`
+	Code        string    `json:"code" db:"code" validate:"required"`
+	Type        string    `json:"type" db:"type" validate:"required"`
+	Discount    int64     `json:"discount" db:"discount" validate:"gte=0"`
 }
 
+// UpdatePaymentStatusRequest callback от Payment об успешной оплате. Заказ берется из пути,
+// подпись и время подписи - из заголовков X-Payment-Signature и X-Payment-Timestamp.
 type UpdatePaymentStatusRequest struct {
-	UserID  uuid.UUID `json:"user_id" validate:"required"`
-	OrderID uuid.UUID
+	OrderID   uuid.UUID `json:"-"`
+	PaymentID uuid.UUID `json:"payment_id" validate:"required"`
+	UserID    uuid.UUID `json:"user_id" validate:"required"`
+	Amount    int64     `json:"amount" validate:"gt=0"`
+	Currency  string    `json:"currency" validate:"required,len=3"`
+	Timestamp string    `json:"-" validate:"required"`
+	Signature string    `json:"-" validate:"required"`
+}
+
+// PromotionQuoteRequest запрос в Cart на проверку акций и пересчет скидок
+type PromotionQuoteRequest struct {
+	UserID       uuid.UUID   `json:"user_id"`
+	Items        []QuoteItem `json:"items"`
+	PromotionIDs []uuid.UUID `json:"promotion_ids"`
+}
+
+type QuoteItem struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Qty       int64     `json:"qty"`
+}
+
+type PromotionQuote struct {
+	Subtotal   int64              `json:"subtotal"`
+	Total      int64              `json:"total"`
+	Promotions []AppliedPromotion `json:"promotions"`
 }
 
 type ItemResponse struct {
-	ID        uuid.UUID `json:"id" validate:"required"`
-	Name      string    `json:"name"`
-	Quantity  int       `json:"quantity"`
-	UnitPrice int64     `json:"unit_price"`
+	ID         uuid.UUID  `json:"id" validate:"required"`
+	Name       string     `json:"name"`
+	Quantity   int        `json:"quantity"`
+	UnitPrice  int64      `json:"unit_price"`
+	TaxRate    int        `json:"tax_rate"`
+	Net        int64      `json:"net"`
+	Tax        int64      `json:"tax"`
+	Gross      int64      `json:"gross"`
+	CanceledAt *time.Time `json:"canceled_at,omitempty"`
 }
 
 type OrderResponse struct {
-	ID         uuid.UUID      `json:"id"`
-	UserId     uuid.UUID      `json:"user_id"`
-	Status     Status         `json:"status"`
-	GrandTotal int64          `json:"grand_total"`
-	Created    time.Time      `json:"created"`
-	Items      []ItemResponse `json:"items"`
+	ID         uuid.UUID          `json:"id"`
+	UserId     uuid.UUID          `json:"user_id"`
+	Status     Status             `json:"status"`
+	Region     string             `json:"region"`
+	TaxMode    TaxMode            `json:"tax_mode"`
+	NetTotal   int64              `json:"net_total"`
+	TaxTotal   int64              `json:"tax_total"`
+	GrandTotal int64              `json:"grand_total"`
+	Created    time.Time          `json:"created"`
+	Items      []ItemResponse     `json:"items"`
+	Promotions []AppliedPromotion `json:"promotions"`
+	History    []StatusChange     `json:"history"`
+}
+
+// StatusChange запись истории статусов заказа
+type StatusChange struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	OrderID   uuid.UUID `json:"-" db:"order_id"`
+	From      Status    `json:"from,omitempty" db:"from_status"`
+	To        Status    `json:"to" db:"to_status"`
+	Actor     string    `json:"actor" db:"actor"`
+	Reason    string    `json:"reason" db:"reason"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type ChangeStatusRequest struct {
+	Status Status `json:"status" validate:"required,oneof=shipped delivered"`
+	Actor  string `json:"actor" validate:"required,max=64"`
+	Reason string `json:"reason" validate:"max=255"`
+}
+
+// OrdersFilter выборка заказов пользователя. Пустой Status и нулевые даты - без фильтра
+type OrdersFilter struct {
+	UserID uuid.UUID
+	Status Status `validate:"omitempty,oneof=new pending_payment paid shipped delivered canceling canceled return_requested partially_returned returned"`
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+type OrderSummary struct {
+	ID         uuid.UUID `json:"id" db:"id"`
+	Status     Status    `json:"status" db:"status"`
+	GrandTotal int64     `json:"grand_total" db:"grand_total"`
+	ItemsQty   int64     `json:"items_qty" db:"items_qty"`
+	Created    time.Time `json:"created" db:"created_at"`
+}
+
+type OrderListResponse struct {
+	Orders  []OrderSummary `json:"orders"`
+	HasMore bool           `json:"has_more"`
+}
+
+type CancelOrderRequest struct {
+	Actor  string `json:"actor" validate:"required,max=64"`
+	Reason string `json:"reason" validate:"max=255"`
+}
+
+type CancelLinesRequest struct {
+	ProductIDs []uuid.UUID `json:"product_ids" validate:"min=1,dive,required"`
+	Actor      string      `json:"actor" validate:"required,max=64"`
+	Reason     string      `json:"reason" validate:"max=255"`
 }
 
 type StatusResponse struct {
@@ -67,3 +191,379 @@ type StatusResponse struct {
 	UserId uuid.UUID `json:"user_id"`
 	Status Status    `json:"status"`
 }
+
+type SagaStep string
+
+const (
+	StepReserveStock SagaStep = "reserve_stock" // резерв товара в Inventory
+	StepPriceLines   SagaStep = "price_lines"   // цены и названия из Catalog, снапшот строк
+	StepInitPayment  SagaStep = "init_payment"  // создание платежа в Payment
+	StepDone         SagaStep = "done"
+)
+
+type SagaState string
+
+const (
+	SagaRunning      SagaState = "running"
+	SagaCompensating SagaState = "compensating" // шаг не удался, откатываем выполненные
+	SagaCompleted    SagaState = "completed"
+	SagaCompensated  SagaState = "compensated" // все откатили, заказ отменен
+)
+
+// Saga состояние оформления заказа. Step - шаг, который выполняется сейчас,
+// при компенсации - шаг, который откатывается.
+type Saga struct {
+	OrderID     uuid.UUID  `db:"order_id"`
+	Step        SagaStep   `db:"step"`
+	State       SagaState  `db:"state"`
+	PaymentID   *uuid.UUID `db:"payment_id"`
+	Attempts    int        `db:"attempts"`
+	LastError   string     `db:"last_error"`
+	FailReason  string     `db:"fail_reason"` // почему заказ не удалось оформить
+	CreatedAt   time.Time  `db:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at"`
+	LeaseID     *uuid.UUID `db:"lease_id"` // аренда исполнителя, nil - сагу никто не выполняет
+	LeasedUntil *time.Time `db:"leased_until"`
+}
+
+// IdempotencyKey запрос на создание заказа с заголовком Idempotency-Key.
+// Повтор с тем же ключом получает сохраненный ответ, с другим телом - 409.
+type IdempotencyKey struct {
+	UserID      uuid.UUID  `db:"user_id"`
+	Key         string     `db:"idempotency_key"`
+	RequestHash string     `db:"request_hash"`
+	OrderID     uuid.UUID  `db:"order_id"`
+	Response    []byte     `db:"response"` // nil, пока оформление не дошло до итогового ответа
+	Error       string     `db:"error"`    // заказ не оформлен, повтор вернет ту же ошибку
+	CreatedAt   time.Time  `db:"created_at"`
+	CompletedAt *time.Time `db:"completed_at"`
+}
+
+// ReserveLine строка резерва в Inventory
+type ReserveLine struct {
+	Id  uuid.UUID `json:"id"`
+	Qty int64     `json:"qty"`
+}
+
+type ReserveOrderRequest struct {
+	OrderID uuid.UUID     `json:"order_id"`
+	Lines   []ReserveLine `json:"lines"`
+}
+
+type Reservation struct {
+	ID          uuid.UUID `json:"id"`
+	ProductID   uuid.UUID `json:"product_id"`
+	WarehouseID uuid.UUID `json:"warehouse_id"`
+	Qty         int64     `json:"qty"`
+	State       string    `json:"state"`
+}
+
+type OrderReservationsResponse struct {
+	OrderID      uuid.UUID     `json:"order_id"`
+	Reservations []Reservation `json:"reservations"`
+}
+
+// CatalogItem товар из Catalog
+type CatalogItem struct {
+	Id        uuid.UUID
+	Name      string
+	UnitPrice int64
+	TaxClass  string
+}
+
+// PaymentRequest запрос на создание платежа в Payment
+type PaymentRequest struct {
+	UserID   uuid.UUID
+	OrderID  string `json:"orderId"`
+	Amount   int64
+	Currency string
+}
+
+type PaymentResponse struct {
+	PaymentID uuid.UUID
+	Status    string
+	Amount    int64
+	Currency  string
+}
+
+// RefundRequest возврат денег в Payment. Key делает повтор безопасным
+type RefundRequest struct {
+	Amount int64  `json:"amount"`
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+type Refund struct {
+	ID     uuid.UUID `json:"id"`
+	Action string    `json:"action"` // void или refund
+	Amount int64     `json:"amount"`
+}
+
+type ReturnStatus string
+
+const (
+	RMARequested  ReturnStatus = "requested"  // покупатель оформил возврат
+	RMAApproved   ReturnStatus = "approved"   // сотрудник одобрил, ждем товар
+	RMAReceived   ReturnStatus = "received"   // товар пришел на склад, ждет проверки
+	RMAInspecting ReturnStatus = "inspecting" // проверка пройдена, товар возвращается на склад, деньги покупателю
+	RMARefunded   ReturnStatus = "refunded"   // проверка пройдена, товар на складе, деньги возвращены
+	RMARejected   ReturnStatus = "rejected"   // отказ при рассмотрении или после проверки
+)
+
+// Return заявка на возврат (RMA) по доставленному заказу
+type Return struct {
+	ID           uuid.UUID    `json:"id" db:"id"`
+	OrderID      uuid.UUID    `json:"order_id" db:"order_id"`
+	UserID       uuid.UUID    `json:"user_id" db:"user_id"`
+	Status       ReturnStatus `json:"status" db:"status"`
+	RefundAmount int64        `json:"refund_amount" db:"refund_amount"`
+	Note         string       `json:"note" db:"note"` // комментарий сотрудника
+	UpdatedBy    string       `json:"updated_by" db:"updated_by"`
+	CreatedAt    time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at" db:"updated_at"`
+	Lines        []ReturnLine `json:"lines" db:"-"`
+}
+
+type ReturnLine struct {
+	ReturnID    uuid.UUID  `json:"-" db:"return_id"`
+	ProductID   uuid.UUID  `json:"product_id" db:"product_id"`
+	Qty         int64      `json:"qty" db:"qty"`
+	Reason      string     `json:"reason" db:"reason"`
+	RestockedAt *time.Time `json:"restocked_at,omitempty" db:"restocked_at"`
+}
+
+type ReturnLineRequest struct {
+	ProductID uuid.UUID `json:"product_id" validate:"required"`
+	Qty       int64     `json:"qty" validate:"gte=1"`
+	Reason    string    `json:"reason" validate:"required,max=255"`
+}
+
+type CreateReturnRequest struct {
+	UserID uuid.UUID           `json:"user_id" validate:"required"`
+	Lines  []ReturnLineRequest `json:"lines" validate:"min=1,dive"`
+}
+
+type ReviewReturnRequest struct {
+	Actor string `json:"actor" validate:"required,max=64"`
+	Note  string `json:"note" validate:"max=255"`
+}
+
+type InspectReturnRequest struct {
+	Actor    string `json:"actor" validate:"required,max=64"`
+	Accepted bool   `json:"accepted"`
+	Note     string `json:"note" validate:"max=255"`
+}
+
+// MovementRequest движение в журнале Inventory
+type MovementRequest struct {
+	WarehouseID uuid.UUID `json:"warehouse_id"`
+	ProductID   uuid.UUID `json:"product_id"`
+	Type        string    `json:"type"`
+	Qty         int64     `json:"qty"`
+	ReasonCode  string    `json:"reason_code"`
+	Actor       string    `json:"actor"`
+	Reference   string    `json:"reference"`
+}
+
+type NotificationRequest struct {
+	UserID  uuid.UUID
+	To      string
+	Type    string
+	Subject string
+	Text    string
+}
+
+type EventType string
+
+const (
+	EventOrderCreated EventType = "order.created"
+	EventOrderPaid    EventType = "order.paid"
+)
+
+// Версии схем payload. Несовместимое изменение payload - новая версия и новая структура,
+// подписчики разбирают data по паре type + version.
+const (
+	OrderCreatedVersion = 1
+	OrderPaidVersion    = 1
+)
+
+// OutboxEvent событие заказа, записанное в одной транзакции с изменением заказа
+type OutboxEvent struct {
+	ID           uuid.UUID  `db:"id"`
+	Type         EventType  `db:"type"`
+	Version      int        `db:"version"`
+	OrderID      uuid.UUID  `db:"order_id"`
+	Payload      []byte     `db:"payload"`
+	CreatedAt    time.Time  `db:"created_at"`
+	DispatchedAt *time.Time `db:"dispatched_at"`
+}
+
+// EventEnvelope тело, которое получает подписчик. По id подписчик отсеивает повторные доставки.
+type EventEnvelope struct {
+	ID         uuid.UUID       `json:"id"`
+	Type       EventType       `json:"type"`
+	Version    int             `json:"version"`
+	OrderID    uuid.UUID       `json:"order_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+type EventLine struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Qty       int64     `json:"qty"`
+	UnitPrice int64     `json:"unit_price,omitempty"`
+}
+
+// OrderCreatedV1 заказ принят, цены еще не зафиксированы
+type OrderCreatedV1 struct {
+	OrderID    uuid.UUID   `json:"order_id"`
+	UserID     uuid.UUID   `json:"user_id"`
+	Items      []EventLine `json:"items"`
+	Promotions []string    `json:"promotions"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// OrderPaidV1 заказ оплачен: корзину можно очищать, доставку - начинать
+type OrderPaidV1 struct {
+	OrderID    uuid.UUID   `json:"order_id"`
+	UserID     uuid.UUID   `json:"user_id"`
+	GrandTotal int64       `json:"grand_total"`
+	Currency   string      `json:"currency"`
+	Items      []EventLine `json:"items"`
+	PaidAt     time.Time   `json:"paid_at"`
+}
+
+type Subscription struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	EventType EventType `json:"event_type" db:"event_type"`
+	URL       string    `json:"url" db:"url"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type SubscribeRequest struct {
+	EventType EventType `json:"event_type" validate:"required,oneof=order.created order.paid"`
+	URL       string    `json:"url" validate:"required,url,max=500"`
+}
+
+type DeliveryState string
+
+const (
+	DeliveryPending   DeliveryState = "pending"
+	DeliveryDelivered DeliveryState = "delivered"
+	DeliveryDead      DeliveryState = "dead" // dead letter, повтор только вручную
+)
+
+// Delivery доставка события одному подписчику
+type Delivery struct {
+	ID             uuid.UUID     `json:"id" db:"id"`
+	EventID        uuid.UUID     `json:"event_id" db:"event_id"`
+	SubscriptionID uuid.UUID     `json:"subscription_id" db:"subscription_id"`
+	URL            string        `json:"url" db:"url"`
+	State          DeliveryState `json:"state" db:"state"`
+	Attempts       int           `json:"attempts" db:"attempts"`
+	LastError      string        `json:"last_error" db:"last_error"`
+	NextAttemptAt  time.Time     `json:"next_attempt_at" db:"next_attempt_at"`
+	DeliveredAt    *time.Time    `json:"delivered_at,omitempty" db:"delivered_at"`
+	UpdatedAt      time.Time     `json:"updated_at" db:"updated_at"`
+}
+
+// DeliveryJob доставка вместе с событием, которое нужно отправить
+type DeliveryJob struct {
+	Delivery
+	Event OutboxEvent `db:"event"`
+}
+
+type InvoiceKind string
+
+const (
+	KindInvoice    InvoiceKind = "invoice"
+	KindCreditNote InvoiceKind = "credit_note" // возврат денег по оплаченному заказу
+)
+
+// Invoice счет или кредит-нота. Суммы кредит-ноты положительные, вид документа задает Kind.
+type Invoice struct {
+	ID        uuid.UUID     `json:"id" db:"id"`
+	Number    string        `json:"number" db:"number"`
+	Kind      InvoiceKind   `json:"kind" db:"kind"`
+	OrderID   uuid.UUID     `json:"order_id" db:"order_id"`
+	UserID    uuid.UUID     `json:"user_id" db:"user_id"`
+	InvoiceID *uuid.UUID    `json:"invoice_id,omitempty" db:"invoice_id"` // счет, к которому выпущена кредит-нота
+	Reason    string        `json:"reason,omitempty" db:"reason"`
+	Currency  string        `json:"currency" db:"currency"`
+	Net       int64         `json:"net" db:"net"`
+	Tax       int64         `json:"tax" db:"tax"`
+	Gross     int64         `json:"gross" db:"gross"`
+	IssuedAt  time.Time     `json:"issued_at" db:"issued_at"`
+	Lines     []InvoiceLine `json:"lines" db:"-"`
+	TaxLines  []TaxLine     `json:"tax_lines" db:"-"`
+}
+
+type InvoiceLine struct {
+	InvoiceID uuid.UUID `json:"-" db:"invoice_id"`
+	LineNo    int       `json:"line_no" db:"line_no"`
+	ProductID uuid.UUID `json:"product_id" db:"product_id"`
+	Name      string    `json:"name" db:"name"`
+	Qty       int64     `json:"qty" db:"qty"`
+	UnitPrice int64     `json:"unit_price" db:"unit_price"`
+	TaxRate   int       `json:"tax_rate" db:"tax_rate"` // в сотых долях процента
+	Net       int64     `json:"net" db:"net"`
+	Tax       int64     `json:"tax" db:"tax"`
+	Gross     int64     `json:"gross" db:"gross"` // после распределения скидок заказа
+}
+
+// TaxLine итог документа по одной ставке налога
+// TaxRate ставка из таблицы order_tax_rates
+type TaxRate struct {
+	TaxClass string `db:"tax_class"`
+	Region   string `db:"region"` // код региона или '*' для всех
+	Rate     int    `db:"rate"`   // в сотых долях процента
+}
+
+type TaxLine struct {
+	Rate  int   `json:"rate"`
+	Net   int64 `json:"net"`
+	Tax   int64 `json:"tax"`
+	Gross int64 `json:"gross"`
+}
+
+type TaxMode string
+
+const (
+	TaxInclusive TaxMode = "inclusive" // цена каталога уже включает налог
+	TaxExclusive TaxMode = "exclusive" // налог начисляется сверху цены
+)
+
+// TaxRequest строки заказа для расчета налога. Amount - стоимость строки после скидок.
+type TaxRequest struct {
+	Region   string
+	Currency string
+	Lines    []TaxableLine
+}
+
+type TaxableLine struct {
+	ProductID uuid.UUID
+	TaxClass  string
+	Amount    int64
+}
+
+// TaxResult налог по строкам в том же порядке, что и в запросе
+type TaxResult struct {
+	Region string
+	Mode   TaxMode
+	Lines  []TaxedLine
+}
+
+type TaxedLine struct {
+	ProductID uuid.UUID
+	Rate      int // в сотых долях процента
+	Net       int64
+	Tax       int64
+	Gross     int64
+}
+
+// OrderTotals итоги заказа по неотмененным строкам
+type OrderTotals struct {
+	Net   int64
+	Tax   int64
+	Gross int64
+}
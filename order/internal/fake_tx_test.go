@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/jmoiron/sqlx"
+)
+
+// noopDriver - драйвер без базы: транзакция открывается, коммитится и откатывается вхолостую.
+// Нужен, чтобы гонять сервисный слой с фейковым репозиторием.
+type noopDriver struct{}
+
+func (noopDriver) Open(string) (driver.Conn, error) { return noopConn{}, nil }
+
+type noopConnector struct{}
+
+func (noopConnector) Connect(context.Context) (driver.Conn, error) { return noopConn{}, nil }
+func (noopConnector) Driver() driver.Driver                        { return noopDriver{} }
+
+type noopConn struct{}
+
+func (noopConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("noop driver: no statements")
+}
+func (noopConn) Close() error              { return nil }
+func (noopConn) Begin() (driver.Tx, error) { return noopTx{}, nil }
+
+type noopTx struct{}
+
+func (noopTx) Commit() error   { return nil }
+func (noopTx) Rollback() error { return nil }
+
+func beginNoopTx() (*sqlx.Tx, error) {
+	return sqlx.NewDb(sql.OpenDB(noopConnector{}), "postgres").Beginx()
+}
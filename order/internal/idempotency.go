@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"time"
+)
+
+const maxIdempotencyKeyLen = 100
+
+// requestHash отпечаток тела запроса: по нему повтор отличается от переиспользованного ключа
+func requestHash(req CreatOrderRequest) string {
+	payload, _ := json.Marshal(req)
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// claimIdempotencyKey занимает ключ за новым заказом. Если ключ уже занят тем же запросом,
+// возвращает сохраненную запись для повтора ответа.
+func (s *Service) claimIdempotencyKey(tx *sqlx.Tx, key IdempotencyKey) (*IdempotencyKey, error) {
+	inserted, err := s.repo.SaveIdempotencyKey(tx, key)
+	if err != nil || inserted {
+		return nil, err
+	}
+	stored, err := s.repo.GetIdempotencyKey(tx, key.UserID, key.Key)
+	if err != nil {
+		return nil, err
+	}
+	if stored.RequestHash != key.RequestHash {
+		return nil, &common.ConflictError{Message: "idempotency key was already used with a different request"}
+	}
+	return &stored, nil
+}
+
+// replayOrder повторяет ответ, который получил первый запрос с этим ключом.
+// Пока оформление не закончено, отдается текущее состояние заказа.
+func (s *Service) replayOrder(stored IdempotencyKey) (OrderResponse, error) {
+	if stored.Error != "" {
+		return OrderResponse{}, &common.ConflictError{Message: stored.Error}
+	}
+	if stored.Response == nil {
+		return s.getOrder(stored.OrderID)
+	}
+	var resp OrderResponse
+	if err := json.Unmarshal(stored.Response, &resp); err != nil {
+		return OrderResponse{}, fmt.Errorf("order service: replay order %s: %w", stored.OrderID, err)
+	}
+	return resp, nil
+}
+
+// completeIdempotencyKey сохраняет итоговый ответ: оформленный заказ или отказ после компенсации.
+// Прочие ошибки не сохраняются, повтор вернет текущее состояние заказа.
+func (s *Service) completeIdempotencyKey(key IdempotencyKey, resp OrderResponse, err error) {
+	var conflictErr *common.ConflictError
+	switch {
+	case errors.As(err, &conflictErr):
+		key.Error = conflictErr.Message
+	case err == nil:
+		if key.Response, err = json.Marshal(resp); err != nil {
+			return
+		}
+	default:
+		return
+	}
+	now := time.Now()
+	key.CompletedAt = &now
+	// заказ уже создан, поэтому сбой записи не ломает ответ: повтор вернет заказ из getOrder
+	_ = s.repo.CompleteIdempotencyKey(key)
+}
@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Минимальный PDF без внешних библиотек: страницы A4, встроенный моноширинный шрифт,
+// поэтому колонки выравниваются пробелами. Шрифт подключается как Type0 с кодировкой Identity-H:
+// текст пишется номерами глифов, а таблица ToUnicode позволяет копировать и искать текст,
+// в том числе кириллицу. Символы, которых нет в шрифте, выводятся глифом .notdef.
+
+const (
+	pdfPageWidth    = 595
+	pdfPageHeight   = 842
+	pdfMargin       = 40
+	pdfFontSize     = 9
+	pdfLeading      = 12
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLeading
+	pdfNameWidth    = 30
+)
+
+func renderInvoicePDF(inv Invoice, seller common.InvoiceConfig) []byte {
+	lines := invoiceText(inv, seller)
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages, lines = append(pages, lines[:pdfLinesPerPage]), lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	font := invoiceFont
+	used := make(map[uint16]rune)
+	for _, page := range pages {
+		for _, line := range page {
+			for _, r := range line {
+				if gid := font.glyph(r); gid != 0 {
+					if _, ok := used[gid]; !ok {
+						used[gid] = r
+					}
+				}
+			}
+		}
+	}
+
+	// 1 - каталог, 2 - дерево страниц, 3-7 - шрифт, дальше по паре объектов на страницу
+	const pdfFirstPageObj = 8
+	objects := make([]string, pdfFirstPageObj-1, pdfFirstPageObj-1+2*len(pages))
+	kids := make([]string, 0, len(pages))
+	for i, page := range pages {
+		pageObj, contentObj := pdfFirstPageObj+2*i, pdfFirstPageObj+1+2*i
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObj))
+		content := pdfContent(font, page)
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, contentObj),
+			pdfStream("", content))
+	}
+	objects[0] = "<< /Type /Catalog /Pages 2 0 R >>"
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+	objects[2] = fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [4 0 R] /ToUnicode 7 0 R >>",
+		invoiceFontName)
+	objects[3] = fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s "+
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> "+
+		"/FontDescriptor 5 0 R /CIDToGIDMap /Identity /DW %d /W [%s] >>",
+		invoiceFontName, font.advance(0), pdfWidths(font, used))
+	// флаги 1 (FixedPitch) + 32 (Nonsymbolic)
+	objects[4] = fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 33 /FontBBox [%d %d %d %d] "+
+		"/ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 6 0 R >>",
+		invoiceFontName, font.bbox[0], font.bbox[1], font.bbox[2], font.bbox[3], font.ascent, font.descent, font.ascent)
+	objects[5] = pdfStream(fmt.Sprintf("/Length1 %d", len(font.data)), string(font.data))
+	objects[6] = pdfStream("", pdfToUnicode(used))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		_, _ = fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	_, _ = fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		_, _ = fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	_, _ = fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func pdfStream(dict string, data string) string {
+	if dict != "" {
+		dict = " " + dict
+	}
+	return fmt.Sprintf("<< /Length %d%s >>\nstream\n%s\nendstream", len(data), dict, data)
+}
+
+func pdfContent(font *trueTypeFont, lines []string) string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLeading, pdfMargin, pdfPageHeight-pdfMargin)
+	for _, line := range lines {
+		b.WriteByte('<')
+		for _, r := range line {
+			_, _ = fmt.Fprintf(&b, "%04X", font.glyph(r))
+		}
+		b.WriteString("> Tj T*\n")
+	}
+	b.WriteString("ET")
+	return b.String()
+}
+
+// pdfWidths массив /W с ширинами использованных глифов
+func pdfWidths(font *trueTypeFont, used map[uint16]rune) string {
+	gids := make([]int, 0, len(used))
+	for gid := range used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+	parts := make([]string, 0, len(gids))
+	for _, gid := range gids {
+		parts = append(parts, fmt.Sprintf("%d [%d]", gid, font.advance(uint16(gid))))
+	}
+	return strings.Join(parts, " ")
+}
+
+// pdfToUnicode CMap из номеров глифов обратно в Unicode, чтобы текст PDF можно было скопировать
+func pdfToUnicode(used map[uint16]rune) string {
+	gids := make([]int, 0, len(used))
+	for gid := range used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+	var b strings.Builder
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	// в одном блоке bfchar не больше 100 записей
+	for len(gids) > 0 {
+		n := min(len(gids), 100)
+		_, _ = fmt.Fprintf(&b, "%d beginbfchar\n", n)
+		for _, gid := range gids[:n] {
+			_, _ = fmt.Fprintf(&b, "<%04X> <%04X>\n", gid, used[uint16(gid)])
+		}
+		b.WriteString("endbfchar\n")
+		gids = gids[n:]
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend")
+	return b.String()
+}
+
+func invoiceText(inv Invoice, seller common.InvoiceConfig) []string {
+	title := "INVOICE"
+	if inv.Kind == KindCreditNote {
+		title = "CREDIT NOTE"
+	}
+	lines := []string{seller.SellerName}
+	if seller.SellerAddress != "" {
+		lines = append(lines, seller.SellerAddress)
+	}
+	if seller.SellerTaxID != "" {
+		lines = append(lines, "Tax ID: "+seller.SellerTaxID)
+	}
+	lines = append(lines, "",
+		fmt.Sprintf("%s %s", title, inv.Number),
+		"Date:     "+inv.IssuedAt.Format("2006-01-02"),
+		"Order:    "+inv.OrderID.String(),
+		"Customer: "+inv.UserID.String())
+	if inv.Reason != "" {
+		lines = append(lines, "Reason:   "+inv.Reason)
+	}
+	lines = append(lines, "",
+		fmt.Sprintf("%3s  %-*s %5s %11s %6s %11s %11s", "#", pdfNameWidth, "Item", "Qty", "Price", "Tax%", "Tax", "Total"),
+		strings.Repeat("-", 3+2+pdfNameWidth+6+12+7+12+12))
+	for _, l := range inv.Lines {
+		lines = append(lines, fmt.Sprintf("%3d  %-*s %5d %11s %6s %11s %11s", l.LineNo, pdfNameWidth, truncate(l.Name, pdfNameWidth),
+			l.Qty, money(l.UnitPrice, inv.Currency), percent(l.TaxRate), money(l.Tax, inv.Currency), money(l.Gross, inv.Currency)))
+	}
+	lines = append(lines, "", fmt.Sprintf("%-10s %12s %12s %12s", "Tax rate", "Net", "Tax", "Gross"))
+	for _, t := range inv.TaxLines {
+		lines = append(lines, fmt.Sprintf("%-10s %12s %12s %12s", percent(t.Rate), money(t.Net, inv.Currency), money(t.Tax, inv.Currency), money(t.Gross, inv.Currency)))
+	}
+	lines = append(lines, "",
+		fmt.Sprintf("Total net:   %12s %s", money(inv.Net, inv.Currency), inv.Currency),
+		fmt.Sprintf("Total tax:   %12s %s", money(inv.Tax, inv.Currency), inv.Currency),
+		fmt.Sprintf("Total:       %12s %s", money(inv.Gross, inv.Currency), inv.Currency))
+	return lines
+}
+
+// currencyDecimals число знаков после запятой для валют, у которых их не два (ISO 4217)
+var currencyDecimals = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// money сумма в минимальных единицах валюты: 1234.56 USD, 1234 JPY, 1.234 KWD
+func money(v int64, currency string) string {
+	decimals, ok := currencyDecimals[strings.ToUpper(currency)]
+	if !ok {
+		decimals = 2
+	}
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	if decimals == 0 {
+		return fmt.Sprintf("%s%d", sign, v)
+	}
+	unit := int64(1)
+	for i := 0; i < decimals; i++ {
+		unit *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, v/unit, decimals, v%unit)
+}
+
+// percent ставка в сотых долях процента как 20%
+func percent(rate int) string {
+	if rate%100 == 0 {
+		return fmt.Sprintf("%d%%", rate/100)
+	}
+	return fmt.Sprintf("%d.%02d%%", rate/100, rate%100)
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "~"
+}
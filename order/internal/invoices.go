@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"sort"
+	"time"
+)
+
+// Счет выставляется в момент оплаты на итог заказа, кредит-нота - на каждый возврат денег
+// по уже выставленному счету (отмена после оплаты, принятый возврат товара).
+// Строки документа берутся из снапшота налогов заказа: ставка, net, tax и gross уже учитывают
+// скидки. Сумма документа распределяется по gross строк, поэтому сумма строк всегда равна
+// итогу заказа или сумме возврата.
+
+var invoicePrefixes = map[InvoiceKind]string{
+	KindInvoice:    "INV",
+	KindCreditNote: "CN",
+}
+
+// issueInvoice выставляет счет на оплаченный заказ по неотмененным строкам
+func (s *Service) issueInvoice(tx *sqlx.Tx, order OrderRow, items []ItemRow, at time.Time) (Invoice, error) {
+	active := make([]ItemRow, 0, len(items))
+	for _, it := range items {
+		if it.CanceledAt == nil {
+			active = append(active, it)
+		}
+	}
+	return s.issueDocument(tx, KindInvoice, order, nil, "", active, order.GrandTotal, at)
+}
+
+// issueCreditNote выпускает кредит-ноту на amount по строкам items. Если счета еще нет
+// (заказ не был оплачен, деньги только разблокированы), документ не нужен и возвращается nil.
+func (s *Service) issueCreditNote(tx *sqlx.Tx, order OrderRow, items []ItemRow, amount int64, reason string,
+	at time.Time) (*Invoice, error) {
+	invoice, err := s.repo.GetOrderInvoice(tx, order.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	note, err := s.issueDocument(tx, KindCreditNote, order, &invoice.ID, reason, items, amount, at)
+	if err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+func (s *Service) issueDocument(tx *sqlx.Tx, kind InvoiceKind, order OrderRow, invoiceID *uuid.UUID, reason string,
+	items []ItemRow, total int64, at time.Time) (Invoice, error) {
+	number, err := s.repo.NextInvoiceNumber(tx, kind)
+	if err != nil {
+		return Invoice{}, err
+	}
+	inv := Invoice{
+		ID:        uuid.New(),
+		Number:    fmt.Sprintf("%s-%06d", invoicePrefixes[kind], number),
+		Kind:      kind,
+		OrderID:   order.ID,
+		UserID:    order.UserID,
+		InvoiceID: invoiceID,
+		Reason:    reason,
+		Currency:  s.cfg.Currency,
+		IssuedAt:  at,
+		Lines:     make([]InvoiceLine, 0, len(items)),
+	}
+	weights := make([]int64, 0, len(items))
+	for _, it := range items {
+		weights = append(weights, it.Gross)
+	}
+	for i, gross := range allocate(total, weights) {
+		it := items[i]
+		var tax int64
+		if it.Gross > 0 {
+			tax = (2*gross*it.Tax + it.Gross) / (2 * it.Gross)
+		}
+		inv.Lines = append(inv.Lines, InvoiceLine{
+			InvoiceID: inv.ID,
+			LineNo:    i + 1,
+			ProductID: it.ID,
+			Name:      it.Name,
+			Qty:       it.Quantity,
+			UnitPrice: it.UnitPrice,
+			TaxRate:   it.TaxRate,
+			Net:       gross - tax,
+			Tax:       tax,
+			Gross:     gross,
+		})
+		inv.Net, inv.Tax, inv.Gross = inv.Net+gross-tax, inv.Tax+tax, inv.Gross+gross
+	}
+	inv.TaxLines = taxLines(inv.Lines)
+	if err = s.repo.CreateInvoice(tx, inv); err != nil {
+		return Invoice{}, err
+	}
+	return inv, nil
+}
+
+// GetInvoices счет и кредит-ноты заказа
+func (s *Service) GetInvoices(orderID uuid.UUID) ([]Invoice, error) {
+	invoices, err := s.repo.GetInvoices(orderID)
+	if err != nil {
+		return nil, fmt.Errorf("order service: get invoices: %w", err)
+	}
+	for i := range invoices {
+		invoices[i].TaxLines = taxLines(invoices[i].Lines)
+	}
+	return invoices, nil
+}
+
+func (s *Service) GetInvoice(orderID, invoiceID uuid.UUID) (Invoice, error) {
+	inv, err := s.repo.GetInvoice(invoiceID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && inv.OrderID != orderID {
+		return Invoice{}, &common.NotFoundError{Message: fmt.Sprintf("invoice %s not found", invoiceID)}
+	}
+	if err != nil {
+		return Invoice{}, fmt.Errorf("order service: get invoice: %w", err)
+	}
+	inv.TaxLines = taxLines(inv.Lines)
+	return inv, nil
+}
+
+// GetInvoicePDF счет или кредит-нота в PDF
+func (s *Service) GetInvoicePDF(orderID, invoiceID uuid.UUID) (Invoice, []byte, error) {
+	inv, err := s.GetInvoice(orderID, invoiceID)
+	if err != nil {
+		return Invoice{}, nil, err
+	}
+	return inv, renderInvoicePDF(inv, s.invoicing), nil
+}
+
+func (s *Service) invoiceIssued(inv Invoice) {
+	for _, w := range s.watchers {
+		w.InvoiceIssued(inv)
+	}
+}
+
+// allocate делит total пропорционально weights так, что сумма частей равна total.
+// Копейки, потерянные на округлении вниз, достаются строкам с наибольшим остатком.
+func allocate(total int64, weights []int64) []int64 {
+	parts := make([]int64, len(weights))
+	if len(weights) == 0 {
+		return parts
+	}
+	var sum int64
+	for _, w := range weights {
+		sum += w
+	}
+	if sum <= 0 {
+		parts[0] = total
+		return parts
+	}
+	remainders := make([]int64, len(weights))
+	left := total
+	for i, w := range weights {
+		parts[i] = total * w / sum
+		remainders[i] = total * w % sum
+		left -= parts[i]
+	}
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for i := 0; left > 0; i = (i + 1) % len(order) {
+		parts[order[i]]++
+		left--
+	}
+	return parts
+}
+
+func taxLines(lines []InvoiceLine) []TaxLine {
+	result := []TaxLine{}
+	byRate := make(map[int]int)
+	for _, l := range lines {
+		i, ok := byRate[l.TaxRate]
+		if !ok {
+			i, byRate[l.TaxRate] = len(result), len(result)
+			result = append(result, TaxLine{Rate: l.TaxRate})
+		}
+		result[i].Net += l.Net
+		result[i].Tax += l.Tax
+		result[i].Gross += l.Gross
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].Rate > result[b].Rate
+	})
+	return result
+}
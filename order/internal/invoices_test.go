@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   int64
+		weights []int64
+		want    []int64
+	}{
+		{name: "no weights", total: 7, weights: []int64{}, want: []int64{}},
+		{name: "single weight", total: 1000, weights: []int64{1}, want: []int64{1000}},
+		{name: "zero total", total: 0, weights: []int64{5, 5}, want: []int64{0, 0}},
+		{name: "zero weights go to the first part", total: 7, weights: []int64{0, 0}, want: []int64{7, 0}},
+		{name: "equal remainders keep line order", total: 100, weights: []int64{1, 1, 1}, want: []int64{34, 33, 33}},
+		{name: "kopeck goes to the largest remainder", total: 10, weights: []int64{1, 2}, want: []int64{3, 7}},
+		{name: "zero weight gets nothing", total: 99, weights: []int64{3, 0, 1}, want: []int64{74, 0, 25}},
+		{name: "total smaller than number of parts", total: 1, weights: []int64{1, 1, 1, 1}, want: []int64{1, 0, 0, 0}},
+		{name: "several kopecks by remainder", total: 250, weights: []int64{199, 299, 499}, want: []int64{50, 75, 125}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := allocate(tt.total, tt.weights)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("allocate(%d, %v) = %v, want %v", tt.total, tt.weights, got, tt.want)
+			}
+			if len(got) == 0 {
+				return
+			}
+			var sum int64
+			for _, p := range got {
+				sum += p
+			}
+			if sum != tt.total {
+				t.Errorf("sum of parts = %d, want %d", sum, tt.total)
+			}
+		})
+	}
+}
+
+// payOrder проводит подписанный callback об оплате заказа
+func payOrder(t *testing.T, svc *Service, repo *sagaRepo, orderID uuid.UUID) {
+	t.Helper()
+	order := repo.orders[orderID]
+	req := UpdatePaymentStatusRequest{
+		OrderID:   orderID,
+		PaymentID: *repo.sagas[orderID].PaymentID,
+		UserID:    order.UserID,
+		Amount:    order.GrandTotal,
+		Currency:  "RUB",
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	req.Signature = NewPaymentSigner(common.CallbackConfig{PaymentSecret: callbackSecret}).Sign(req)
+	if err := svc.UpdatePaymentStatus(req); err != nil {
+		t.Fatalf("pay order: %v", err)
+	}
+}
+
+func TestInvoiceAndCreditNote(t *testing.T) {
+	svc, repo, _, orderID := placedOrder(t)
+	if len(repo.invoices) != 0 {
+		t.Fatalf("invoices before payment = %d, want 0", len(repo.invoices))
+	}
+	payOrder(t, svc, repo, orderID)
+	if len(repo.invoices) != 1 {
+		t.Fatalf("invoices after payment = %d, want 1", len(repo.invoices))
+	}
+	invoice := repo.invoices[0]
+	if invoice.Kind != KindInvoice || invoice.Gross != 650 || invoice.Net+invoice.Tax != invoice.Gross {
+		t.Errorf("invoice kind %s, net %d + tax %d = gross %d; want invoice with gross 650",
+			invoice.Kind, invoice.Net, invoice.Tax, invoice.Gross)
+	}
+
+	if _, err := svc.CancelLines(orderID, CancelLinesRequest{ProductIDs: []uuid.UUID{lineB}, Actor: "user"}); err != nil {
+		t.Fatalf("cancel line B: %v", err)
+	}
+	if len(repo.invoices) != 2 {
+		t.Fatalf("documents after cancel = %d, want 2", len(repo.invoices))
+	}
+	note := repo.invoices[1]
+	if note.Kind != KindCreditNote || note.Gross != 217 || note.InvoiceID == nil || *note.InvoiceID != invoice.ID {
+		t.Errorf("credit note kind %s, gross %d, invoice %v; want credit note of 217 for %s",
+			note.Kind, note.Gross, note.InvoiceID, invoice.ID)
+	}
+}
+
+func TestCancelUnpaidOrderIssuesNoCreditNote(t *testing.T) {
+	svc, repo, _, orderID := placedOrder(t)
+	if _, err := svc.CancelOrder(orderID, CancelOrderRequest{Actor: "user"}); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if len(repo.invoices) != 0 {
+		t.Errorf("documents = %d, want none for an unpaid order", len(repo.invoices))
+	}
+}
@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// Watcher получает события заказа после коммита транзакции
+type Watcher interface {
+	ReturnChanged(ret Return)
+	InvoiceIssued(inv Invoice)
+}
+
+type Notifier interface {
+	Notify(req NotificationRequest) error
+}
+
+// CustomerNotifications сообщает покупателю о каждом шаге возврата и присылает счета.
+// Отправка асинхронная: сбой уведомления не должен откатывать сам возврат или оплату.
+type CustomerNotifications struct {
+	notifier  Notifier
+	logger    *common.Logger
+	publicURL string // адрес сервиса для ссылки на PDF счета
+}
+
+func NewCustomerNotifications(notifier Notifier, logger *common.Logger, cfg common.InvoiceConfig) *CustomerNotifications {
+	return &CustomerNotifications{notifier: notifier, logger: logger, publicURL: strings.TrimRight(cfg.PublicURL, "/")}
+}
+
+func (n *CustomerNotifications) ReturnChanged(ret Return) {
+	if ret.Status == RMAInspecting {
+		// промежуточный шаг проверки, покупатель узнает о ее итоге
+		return
+	}
+	subject, text := returnMessage(ret)
+	go func() {
+		err := n.notifier.Notify(NotificationRequest{
+			UserID:  ret.UserID,
+			To:      ret.UserID.String(),
+			Type:    "email",
+			Subject: subject,
+			Text:    text,
+		})
+		if err != nil {
+			n.logger.Error("failed to send return notification", zap.Error(err),
+				zap.String("return_id", ret.ID.String()), zap.String("status", string(ret.Status)))
+		}
+	}()
+}
+
+func (n *CustomerNotifications) InvoiceIssued(inv Invoice) {
+	subject, text := n.invoiceMessage(inv)
+	go func() {
+		err := n.notifier.Notify(NotificationRequest{
+			UserID:  inv.UserID,
+			To:      inv.UserID.String(),
+			Type:    "email",
+			Subject: subject,
+			Text:    text,
+		})
+		if err != nil {
+			n.logger.Error("failed to send invoice", zap.Error(err),
+				zap.String("invoice_id", inv.ID.String()), zap.String("number", inv.Number))
+		}
+	}()
+}
+
+// invoiceMessage письмо со счетом: Notification не умеет вложения, поэтому в письме
+// итоги документа и ссылка на PDF
+func (n *CustomerNotifications) invoiceMessage(inv Invoice) (string, string) {
+	subject := fmt.Sprintf("Invoice %s for order %s", inv.Number, inv.OrderID)
+	if inv.Kind == KindCreditNote {
+		subject = fmt.Sprintf("Credit note %s for order %s", inv.Number, inv.OrderID)
+	}
+	var b strings.Builder
+	for _, l := range inv.Lines {
+		_, _ = fmt.Fprintf(&b, "%s x%d: %s %s\n", l.Name, l.Qty, money(l.Gross, inv.Currency), inv.Currency)
+	}
+	for _, t := range inv.TaxLines {
+		_, _ = fmt.Fprintf(&b, "Tax %s: %s %s\n", percent(t.Rate), money(t.Tax, inv.Currency), inv.Currency)
+	}
+	_, _ = fmt.Fprintf(&b, "Total: %s %s", money(inv.Gross, inv.Currency), inv.Currency)
+	if n.publicURL != "" {
+		_, _ = fmt.Fprintf(&b, "\nPDF: %s/api/v1/orders/%s/invoices/%s/pdf", n.publicURL, inv.OrderID, inv.ID)
+	}
+	return subject, b.String()
+}
+
+func returnMessage(ret Return) (string, string) {
+	order := ret.OrderID.String()
+	switch ret.Status {
+	case RMARequested:
+		return "Return request received", fmt.Sprintf("We received your return request for order %s and will review it shortly", order)
+	case RMAApproved:
+		return "Return approved", fmt.Sprintf("Your return for order %s is approved, please send the items back", order)
+	case RMAReceived:
+		return "Returned items received", fmt.Sprintf("We received the items returned from order %s and are inspecting them", order)
+	case RMARefunded:
+		return "Refund issued", fmt.Sprintf("Your return for order %s is accepted, %d will be refunded", order, ret.RefundAmount)
+	default:
+		text := fmt.Sprintf("Your return for order %s was rejected", order)
+		if ret.Note != "" {
+			text += ": " + ret.Note
+		}
+		return "Return rejected", text
+	}
+}
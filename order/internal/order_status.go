@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"time"
+)
+
+// transitions допустимые переходы статуса заказа. Отмененный и полностью возвращенный заказ дальше не двигаются.
+var transitions = map[Status][]Status{
+	New:            {PendingPayment, Canceled},
+	PendingPayment: {Paid, Canceling, Canceled},
+	Paid:           {Shipped, Canceling},
+	// после отмены части строк заказ возвращается в прежний статус, см. finishCancellation
+	Canceling: {Canceled},
+	Shipped:   {Delivered},
+	// возвраты: статус следует за заявками на возврат, см. syncReturnStatus
+	Delivered:         {ReturnRequested},
+	ReturnRequested:   {Delivered, PartiallyReturned, Returned},
+	PartiallyReturned: {ReturnRequested},
+}
+
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// transition переводит заказ в новый статус и пишет переход в историю, оплата публикует order.paid.
+// Строка заказа блокируется, поэтому два конкурентных перехода не проскочат оба.
+func (s *Service) transition(tx *sqlx.Tx, orderID uuid.UUID, to Status, actor, reason string) (OrderRow, error) {
+	order, err := s.repo.GetOrderForUpdate(tx, orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return OrderRow{}, &common.NotFoundError{Message: fmt.Sprintf("order %s not found", orderID)}
+	}
+	if err != nil {
+		return OrderRow{}, err
+	}
+	if !order.Status.CanTransitionTo(to) {
+		return OrderRow{}, &common.ConflictError{Message: fmt.Sprintf("order %s: transition %s -> %s is not allowed",
+			orderID, order.Status, to)}
+	}
+	if err = s.repo.UpdateOrderStatus(tx, orderID, to); err != nil {
+		return OrderRow{}, err
+	}
+	now := time.Now()
+	err = s.repo.AddStatusChange(tx, StatusChange{
+		ID:        uuid.New(),
+		OrderID:   orderID,
+		From:      order.Status,
+		To:        to,
+		Actor:     actor,
+		Reason:    reason,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return OrderRow{}, err
+	}
+	order.Status = to
+	if to == Paid {
+		if err = s.publishOrderPaid(tx, order, now); err != nil {
+			return OrderRow{}, err
+		}
+	}
+	return order, nil
+}
+
+// ChangeStatus ручной перевод заказа по доставке: shipped, delivered
+func (s *Service) ChangeStatus(orderID uuid.UUID, req ChangeStatusRequest) (StatusResponse, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return StatusResponse{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	var order OrderRow
+	err := s.withTx("change status", func(tx *sqlx.Tx) (err error) {
+		order, err = s.transition(tx, orderID, req.Status, req.Actor, req.Reason)
+		return err
+	})
+	if err != nil {
+		return StatusResponse{}, err
+	}
+	return StatusResponse{ID: order.ID, UserId: order.UserID, Status: order.Status}, nil
+}
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"time"
+)
+
+// Доменные события заказа пишутся в outbox той же транзакцией, что и сам заказ,
+// поэтому событие не теряется при падении и не уходит по откаченному изменению.
+// Доставку подписчикам выполняет EventRelay.
+
+func (s *Service) publish(tx *sqlx.Tx, orderID uuid.UUID, eventType EventType, version int, data any, at time.Time) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", eventType, err)
+	}
+	return s.repo.AddOutboxEvent(tx, OutboxEvent{
+		ID:        uuid.New(),
+		Type:      eventType,
+		Version:   version,
+		OrderID:   orderID,
+		Payload:   payload,
+		CreatedAt: at,
+	})
+}
+
+func (s *Service) publishOrderCreated(tx *sqlx.Tx, order OrderRow, items []ItemRow, promotions []AppliedPromotion) error {
+	event := OrderCreatedV1{
+		OrderID:    order.ID,
+		UserID:     order.UserID,
+		Items:      make([]EventLine, 0, len(items)),
+		Promotions: make([]string, 0, len(promotions)),
+		CreatedAt:  order.CreatedAt,
+	}
+	for _, it := range items {
+		event.Items = append(event.Items, EventLine{ProductID: it.ID, Qty: it.Quantity})
+	}
+	for _, p := range promotions {
+		event.Promotions = append(event.Promotions, p.Code)
+	}
+	return s.publish(tx, order.ID, EventOrderCreated, OrderCreatedVersion, event, order.CreatedAt)
+}
+
+func (s *Service) publishOrderPaid(tx *sqlx.Tx, order OrderRow, at time.Time) error {
+	items, err := s.repo.GetOrderItems(order.ID)
+	if err != nil {
+		return err
+	}
+	event := OrderPaidV1{
+		OrderID:    order.ID,
+		UserID:     order.UserID,
+		GrandTotal: order.GrandTotal,
+		Currency:   s.cfg.Currency,
+		Items:      make([]EventLine, 0, len(items)),
+		PaidAt:     at,
+	}
+	for _, it := range items {
+		if it.CanceledAt == nil {
+			event.Items = append(event.Items, EventLine{ProductID: it.ID, Qty: it.Quantity, UnitPrice: it.UnitPrice})
+		}
+	}
+	return s.publish(tx, order.ID, EventOrderPaid, OrderPaidVersion, event, at)
+}
+
+// Subscribe подписывает url на событие заказа
+func (s *Service) Subscribe(req SubscribeRequest) (Subscription, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return Subscription{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	sub, err := s.repo.CreateSubscription(Subscription{
+		ID:        uuid.New(),
+		EventType: req.EventType,
+		URL:       req.URL,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return Subscription{}, fmt.Errorf("order service: subscribe: %w", err)
+	}
+	return sub, nil
+}
+
+func (s *Service) GetSubscriptions() ([]Subscription, error) {
+	subs, err := s.repo.GetSubscriptions()
+	if err != nil {
+		return nil, fmt.Errorf("order service: get subscriptions: %w", err)
+	}
+	return subs, nil
+}
+
+// Unsubscribe удаляет подписку вместе с недоставленными ей событиями
+func (s *Service) Unsubscribe(id uuid.UUID) error {
+	deleted, err := s.repo.DeleteSubscription(id)
+	if err != nil {
+		return fmt.Errorf("order service: unsubscribe: %w", err)
+	}
+	if !deleted {
+		return &common.NotFoundError{Message: fmt.Sprintf("subscription %s not found", id)}
+	}
+	return nil
+}
+
+func (s *Service) GetDeadLetters(limit, offset int) ([]Delivery, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	deliveries, err := s.repo.GetDeadLetters(limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("order service: get dead letters: %w", err)
+	}
+	return deliveries, nil
+}
+
+// ReplayDeadLetter возвращает доставку из dead letter в очередь relay с обнуленным счетчиком попыток
+func (s *Service) ReplayDeadLetter(id uuid.UUID) (Delivery, error) {
+	delivery, err := s.repo.GetDelivery(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Delivery{}, &common.NotFoundError{Message: fmt.Sprintf("delivery %s not found", id)}
+	}
+	if err != nil {
+		return Delivery{}, fmt.Errorf("order service: replay dead letter: %w", err)
+	}
+	if delivery.State != DeliveryDead {
+		return Delivery{}, &common.ConflictError{Message: fmt.Sprintf("delivery %s is %s, only dead letters can be replayed",
+			id, delivery.State)}
+	}
+	now := time.Now()
+	delivery.State, delivery.Attempts, delivery.LastError = DeliveryPending, 0, ""
+	delivery.NextAttemptAt, delivery.UpdatedAt = now, now
+	if err = s.repo.SaveDelivery(delivery); err != nil {
+		return Delivery{}, fmt.Errorf("order service: replay dead letter: %w", err)
+	}
+	return delivery, nil
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+type EventStore interface {
+	DispatchOutboxEvents(limit int) (int, error)
+	ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]DeliveryJob, error)
+	SaveDelivery(delivery Delivery) error
+}
+
+type EventSender interface {
+	SendEvent(url string, event EventEnvelope) error
+}
+
+// EventRelay разносит события из outbox подписчикам. Доставка at-least-once: после сбоя
+// событие может прийти повторно, подписчик отсеивает дубли по id события.
+// Неудачная доставка повторяется с растущей паузой, после MaxAttempts или ответа 4xx
+// уходит в dead letter и ждет ручного повтора.
+type EventRelay struct {
+	store  EventStore
+	sender EventSender
+	logger *common.Logger
+	cfg    common.OutboxConfig
+}
+
+func NewEventRelay(store EventStore, sender EventSender, logger *common.Logger, cfg common.OutboxConfig) *EventRelay {
+	return &EventRelay{store: store, sender: sender, logger: logger, cfg: cfg}
+}
+
+func (r *EventRelay) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.RelayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Relay(time.Now()); err != nil {
+				r.logger.Error("failed to relay order events", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (r *EventRelay) Relay(now time.Time) error {
+	if _, err := r.store.DispatchOutboxEvents(r.cfg.BatchSize); err != nil {
+		return fmt.Errorf("event relay: dispatch events: %w", err)
+	}
+	jobs, err := r.store.ClaimDeliveries(now, r.cfg.DeliveryLease, r.cfg.BatchSize)
+	if err != nil {
+		return fmt.Errorf("event relay: claim deliveries: %w", err)
+	}
+	var errs []error
+	for _, job := range jobs {
+		if err = r.store.SaveDelivery(r.deliver(job)); err != nil {
+			errs = append(errs, fmt.Errorf("event relay: save delivery %s: %w", job.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *EventRelay) deliver(job DeliveryJob) Delivery {
+	delivery := job.Delivery
+	delivery.Attempts++
+	err := r.sender.SendEvent(delivery.URL, EventEnvelope{
+		ID:         job.Event.ID,
+		Type:       job.Event.Type,
+		Version:    job.Event.Version,
+		OrderID:    job.Event.OrderID,
+		OccurredAt: job.Event.CreatedAt,
+		Data:       job.Event.Payload,
+	})
+	now := time.Now()
+	delivery.UpdatedAt = now
+	if err == nil {
+		delivery.State, delivery.LastError, delivery.DeliveredAt = DeliveryDelivered, "", &now
+		return delivery
+	}
+	delivery.LastError = err.Error()
+	// 408 и 429 - подписчик просит подождать, остальные 4xx повтором не исправить
+	permanent := isPermanent(err) && !hasStatus(err, http.StatusRequestTimeout, http.StatusTooManyRequests)
+	if permanent || delivery.Attempts >= r.cfg.MaxAttempts {
+		delivery.State = DeliveryDead
+		r.logger.Warn("order event moved to dead letters", zap.String("delivery", delivery.ID.String()),
+			zap.String("event", string(job.Event.Type)), zap.String("url", delivery.URL), zap.Error(err))
+		return delivery
+	}
+	delivery.NextAttemptAt = now.Add(r.backoff(delivery.Attempts))
+	return delivery
+}
+
+// backoff пауза перед следующей попыткой: RetryBackoff, дальше вдвое больше, но не больше MaxBackoff
+func (r *EventRelay) backoff(attempts int) time.Duration {
+	delay := r.cfg.RetryBackoff
+	for i := 1; i < attempts && delay < r.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, r.cfg.MaxBackoff)
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PaymentSigner проверяет подпись callback об оплате. Payment подписывает общим ключом строку
+// "<timestamp>.<order_id>.<user_id>.<payment_id>.<amount>.<currency>", timestamp - unix-время в секундах,
+// подпись - HMAC-SHA256 в hex.
+type PaymentSigner struct {
+	secret  []byte
+	maxSkew time.Duration
+}
+
+func NewPaymentSigner(cfg common.CallbackConfig) *PaymentSigner {
+	return &PaymentSigner{secret: []byte(cfg.PaymentSecret), maxSkew: cfg.MaxSkew}
+}
+
+func (p *PaymentSigner) Sign(req UpdatePaymentStatusRequest) string {
+	mac := hmac.New(sha256.New, p.secret)
+	_, _ = fmt.Fprintf(mac, "%s.%s.%s.%s.%d.%s", req.Timestamp, req.OrderID, req.UserID, req.PaymentID, req.Amount, req.Currency)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify отклоняет чужую подпись и слишком старую, чтобы перехваченный callback нельзя было проиграть позже
+func (p *PaymentSigner) Verify(req UpdatePaymentStatusRequest, now time.Time) error {
+	sec, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	if err != nil {
+		return &common.UnauthorizedError{Message: "invalid callback timestamp"}
+	}
+	if skew := now.Sub(time.Unix(sec, 0)).Abs(); skew > p.maxSkew {
+		return &common.UnauthorizedError{Message: "callback signature expired"}
+	}
+	if !hmac.Equal([]byte(p.Sign(req)), []byte(req.Signature)) {
+		return &common.UnauthorizedError{Message: "invalid callback signature"}
+	}
+	return nil
+}
+
+// UpdatePaymentStatus callback от Payment: заказ переходит в paid, выставляется счет, товар списывается со склада.
+// Платеж должен быть тем, что создала сага, на всю сумму заказа. Повторный callback по тому же
+// платежу ничего не меняет, только повторяет списание, если в прошлый раз оно не прошло.
+// Деньги, списанные за уже отмененный заказ, возвращаются. Если резерв истек до оплаты, товар
+// резервируется заново, а когда его уже нет, заказ отменяется с возвратом денег.
+func (s *Service) UpdatePaymentStatus(req UpdatePaymentStatusRequest) error {
+	if err := s.validator.Validate(req); err != nil {
+		return &common.RequestValidationError{Message: err.Error()}
+	}
+	if err := s.callbacks.Verify(req, time.Now()); err != nil {
+		return err
+	}
+	var order OrderRow
+	var invoice *Invoice
+	var refundCaptured bool
+	err := s.withTx("update payment status", func(tx *sqlx.Tx) (err error) {
+		order, err = s.repo.GetOrderForUpdate(tx, req.OrderID)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && order.UserID != req.UserID {
+			return &common.NotFoundError{Message: fmt.Sprintf("order %s not found", req.OrderID)}
+		}
+		if err != nil {
+			return err
+		}
+		if order.PaymentID != nil {
+			if *order.PaymentID != req.PaymentID {
+				return &common.ConflictError{Message: fmt.Sprintf("order %s is already paid by payment %s",
+					order.ID, *order.PaymentID)}
+			}
+			return nil
+		}
+		saga, err := s.repo.GetSaga(tx, order.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if saga.PaymentID == nil || *saga.PaymentID != req.PaymentID {
+			return &common.ConflictError{Message: fmt.Sprintf("payment %s was not created for order %s",
+				req.PaymentID, order.ID)}
+		}
+		switch order.Status {
+		case Canceling:
+			// чем кончится отмена, еще неизвестно: Payment повторит callback
+			return &common.ConflictError{Message: fmt.Sprintf("order %s is being canceled, retry later", order.ID)}
+		case Canceled:
+			refundCaptured = true
+			return nil
+		}
+		if req.Amount != order.GrandTotal || req.Currency != s.cfg.Currency {
+			return &common.ConflictError{Message: fmt.Sprintf("order %s: paid %d %s, expected %d %s",
+				order.ID, req.Amount, req.Currency, order.GrandTotal, s.cfg.Currency)}
+		}
+		now := time.Now()
+		if err = s.repo.SetOrderPayment(tx, order.ID, req.PaymentID, now); err != nil {
+			return err
+		}
+		order, err = s.transition(tx, order.ID, Paid, "payment", fmt.Sprintf("payment %s confirmed", req.PaymentID))
+		if err != nil {
+			return err
+		}
+		items, err := s.repo.GetOrderItems(order.ID)
+		if err != nil {
+			return err
+		}
+		issued, err := s.issueInvoice(tx, order, items, now)
+		invoice = &issued
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if invoice != nil {
+		s.invoiceIssued(*invoice)
+	}
+	if refundCaptured {
+		// заказ отменили, пока шла оплата: ключ по платежу, повтор callback не вернет деньги дважды
+		refund := RefundRequest{Amount: req.Amount, Key: "canceled:" + req.PaymentID.String(), Reason: "order canceled before payment"}
+		if _, err = s.payments.Refund(order.ID, refund); err != nil {
+			return fmt.Errorf("order service: update payment status: %w", err)
+		}
+		return nil
+	}
+	if err = s.commitStock(order.ID); err != nil {
+		return fmt.Errorf("order service: update payment status: %w", err)
+	}
+	return nil
+}
+
+// commitStock списывает оплаченный товар. Резерв, истекший до оплаты, ставится заново;
+// если товара уже нет, заказ отменяется и деньги возвращаются.
+func (s *Service) commitStock(orderID uuid.UUID) error {
+	err := s.inventory.CommitOrder(orderID)
+	if hasStatus(err, http.StatusConflict) {
+		if err = s.reserveStock(orderID); err == nil {
+			err = s.inventory.CommitOrder(orderID)
+		}
+	}
+	if hasStatus(err, http.StatusConflict) {
+		_, err = s.cancel(orderID, nil, SystemActor, "out of stock after payment")
+		return err
+	}
+	if err != nil && !hasStatus(err, http.StatusNotFound) {
+		return err
+	}
+	return nil
+}
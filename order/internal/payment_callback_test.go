@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const callbackSecret = "callback-secret"
+
+func TestPaymentCallback(t *testing.T) {
+	signer := NewPaymentSigner(common.CallbackConfig{PaymentSecret: callbackSecret})
+	otherSigner := NewPaymentSigner(common.CallbackConfig{PaymentSecret: "other-secret"})
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name   string
+		edit   func(req *UpdatePaymentStatusRequest) // правка до подписи
+		tamper func(req *UpdatePaymentStatusRequest) // правка после подписи
+		sign   func(req UpdatePaymentStatusRequest) string
+		status int
+		paid   bool
+	}{
+		{name: "valid signature", status: http.StatusOK, paid: true},
+		{
+			name:   "signed with another key",
+			sign:   otherSigner.Sign,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "amount changed after signing",
+			tamper: func(req *UpdatePaymentStatusRequest) { req.Amount = 1 },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "user changed after signing",
+			tamper: func(req *UpdatePaymentStatusRequest) { req.UserID = uuid.New() },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "expired timestamp",
+			edit:   func(req *UpdatePaymentStatusRequest) { req.Timestamp = stale },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "missing signature",
+			sign:   func(UpdatePaymentStatusRequest) string { return "" },
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "signed but amount differs from order",
+			edit:   func(req *UpdatePaymentStatusRequest) { req.Amount = 1 },
+			status: http.StatusConflict,
+		},
+		{
+			name:   "signed but payment was not created by the saga",
+			edit:   func(req *UpdatePaymentStatusRequest) { req.PaymentID = uuid.New() },
+			status: http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &sagaDeps{}
+			svc, repo := newSagaService(deps)
+			order, err := svc.CreateOrder(sagaOrder, "")
+			if err != nil {
+				t.Fatalf("create order: %v", err)
+			}
+			deps.calls = nil
+			req := UpdatePaymentStatusRequest{
+				OrderID:   order.ID,
+				PaymentID: *repo.sagas[order.ID].PaymentID,
+				UserID:    order.UserId,
+				Amount:    order.GrandTotal,
+				Currency:  "RUB",
+				Timestamp: now,
+			}
+			if tt.edit != nil {
+				tt.edit(&req)
+			}
+			sign := signer.Sign
+			if tt.sign != nil {
+				sign = tt.sign
+			}
+			req.Signature = sign(req)
+			if tt.tamper != nil {
+				tt.tamper(&req)
+			}
+
+			rec := postPaymentCallback(svc, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			paid := repo.orders[order.ID].Status == Paid
+			if paid != tt.paid {
+				t.Errorf("order status = %s, paid %v, want paid %v", repo.orders[order.ID].Status, paid, tt.paid)
+			}
+			if !tt.paid {
+				assertCalls(t, deps.calls)
+				return
+			}
+			assertCalls(t, deps.calls, "commit")
+			if p := repo.orders[order.ID].PaymentID; p == nil || *p != req.PaymentID {
+				t.Errorf("order payment = %v, want %s", p, req.PaymentID)
+			}
+
+			// повторный callback не меняет заказ
+			history := len(repo.history[order.ID])
+			if rec = postPaymentCallback(svc, req); rec.Code != http.StatusOK {
+				t.Fatalf("repeated callback status = %d: %s", rec.Code, rec.Body.String())
+			}
+			if len(repo.history[order.ID]) != history {
+				t.Errorf("repeated callback changed status history")
+			}
+		})
+	}
+}
+
+func postPaymentCallback(svc *Service, req UpdatePaymentStatusRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	httpReq := httptest.NewRequest(http.MethodPost, "/orders/"+req.OrderID.String()+"/payment-status", strings.NewReader(string(body)))
+	httpReq.Header.Set("X-Payment-Timestamp", req.Timestamp)
+	httpReq.Header.Set("X-Payment-Signature", req.Signature)
+	router := chi.NewRouter()
+	router.Mount("/orders", NewController(svc, common.Logger{Logger: zap.NewNop()}).Routes())
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httpReq)
+	return rec
+}
@@ -1 +1,656 @@
 package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"strings"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+func (r *Repository) CreateOrder(tx *sqlx.Tx, order OrderRow) error {
+	_, err := tx.NamedExec(`INSERT INTO orders (id, user_id, status, grand_total, created_at, region)
+		VALUES (:id, :user_id, :status, :grand_total, :created_at, :region)`, order)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetOrder(id uuid.UUID) (OrderRow, error) {
+	var order OrderRow
+	err := r.db.Get(&order, `SELECT id, user_id, status, grand_total, created_at, payment_id, paid_at, region, tax_mode, net_total, tax_total,
+       cancel_key, cancel_from FROM orders WHERE id = $1`, id)
+	if err != nil {
+		return OrderRow{}, err
+	}
+	return order, nil
+}
+
+func (r *Repository) GetOrderForUpdate(tx *sqlx.Tx, id uuid.UUID) (OrderRow, error) {
+	var order OrderRow
+	err := tx.Get(&order, `SELECT id, user_id, status, grand_total, created_at, payment_id, paid_at, region, tax_mode, net_total, tax_total,
+       cancel_key, cancel_from FROM orders WHERE id = $1 FOR UPDATE`, id)
+	if err != nil {
+		return OrderRow{}, err
+	}
+	return order, nil
+}
+
+func (r *Repository) UpdateOrderStatus(tx *sqlx.Tx, id uuid.UUID, status Status) error {
+	_, err := tx.Exec(`UPDATE orders SET status = $1, updated_at = NOW() WHERE id = $2`, status, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetCancellation помечает, какие строки отменяются и из какого статуса, пустой key снимает пометку
+func (r *Repository) SetCancellation(tx *sqlx.Tx, id uuid.UUID, key string, from Status) error {
+	_, err := tx.Exec(`UPDATE orders SET cancel_key = $1, cancel_from = $2, updated_at = NOW() WHERE id = $3`, key, from, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) SetOrderPayment(tx *sqlx.Tx, id, paymentID uuid.UUID, paidAt time.Time) error {
+	_, err := tx.Exec(`UPDATE orders SET payment_id = $1, paid_at = $2, updated_at = NOW() WHERE id = $3`, paymentID, paidAt, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) UpdateOrderTotals(tx *sqlx.Tx, id uuid.UUID, totals OrderTotals) error {
+	_, err := tx.Exec(`UPDATE orders SET net_total = $1, tax_total = $2, grand_total = $3, updated_at = NOW()
+		WHERE id = $4`, totals.Net, totals.Tax, totals.Gross, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateOrderPricing фиксирует регион и режим налога, по которым посчитан заказ, вместе с итогами
+func (r *Repository) UpdateOrderPricing(tx *sqlx.Tx, id uuid.UUID, region string, mode TaxMode, totals OrderTotals) error {
+	_, err := tx.Exec(`UPDATE orders SET region = $1, tax_mode = $2, net_total = $3, tax_total = $4, grand_total = $5,
+		updated_at = NOW() WHERE id = $6`, region, mode, totals.Net, totals.Tax, totals.Gross, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) AddOrderItems(tx *sqlx.Tx, items []ItemRow) error {
+	_, err := tx.NamedExec(`INSERT INTO order_items (id, order_id, name, quantity, unit_price)
+		VALUES (:id, :order_id, :name, :quantity, :unit_price)`, items)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetOrderItems(orderID uuid.UUID) ([]ItemRow, error) {
+	var items []ItemRow
+	err := r.db.Select(&items, `SELECT id, order_id, name, quantity, unit_price, canceled_at,
+		tax_class, tax_rate, net, tax, gross FROM order_items
+		WHERE order_id = $1 ORDER BY created_at, id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *Repository) UpdateOrderItem(tx *sqlx.Tx, item ItemRow) error {
+	_, err := tx.Exec(`UPDATE order_items SET name = $1, unit_price = $2, quantity = $3,
+		tax_class = $4, tax_rate = $5, net = $6, tax = $7, gross = $8
+		WHERE order_id = $9 AND id = $10`, item.Name, item.UnitPrice, item.Quantity,
+		item.TaxClass, item.TaxRate, item.Net, item.Tax, item.Gross, item.OrderID, item.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) CancelOrderItems(tx *sqlx.Tx, orderID uuid.UUID, productIDs []uuid.UUID, at time.Time) error {
+	query, args, err := sqlx.In(`UPDATE order_items SET canceled_at = ?
+		WHERE order_id = ? AND id IN (?) AND canceled_at IS NULL`, at, orderID, productIDs)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(tx.Rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetTaxRates ставки налога для перечисленных регионов
+func (r *Repository) GetTaxRates(regions []string) ([]TaxRate, error) {
+	query, args, err := sqlx.In(`SELECT tax_class, region, rate FROM order_tax_rates WHERE region IN (?)`, regions)
+	if err != nil {
+		return nil, err
+	}
+	var rates []TaxRate
+	if err = r.db.Select(&rates, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+func (r *Repository) GetOrderPromotions(orderID uuid.UUID) ([]AppliedPromotion, error) {
+	var promos []AppliedPromotion
+	err := r.db.Select(&promos, `SELECT promotion_id, code, type, discount FROM order_promotions
+		WHERE order_id = $1 ORDER BY created_at`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return promos, nil
+}
+
+// SaveIdempotencyKey сохраняет ключ, если его еще нет. Параллельный запрос с тем же ключом
+// ждет коммита первого и получает false.
+func (r *Repository) SaveIdempotencyKey(tx *sqlx.Tx, key IdempotencyKey) (bool, error) {
+	res, err := tx.NamedExec(`INSERT INTO order_idempotency_keys (user_id, idempotency_key, request_hash, order_id, created_at)
+		VALUES (:user_id, :idempotency_key, :request_hash, :order_id, :created_at)
+		ON CONFLICT (user_id, idempotency_key) DO NOTHING`, key)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *Repository) GetIdempotencyKey(tx *sqlx.Tx, userID uuid.UUID, key string) (IdempotencyKey, error) {
+	var stored IdempotencyKey
+	err := tx.Get(&stored, `SELECT user_id, idempotency_key, request_hash, order_id, response, error, created_at, completed_at
+		FROM order_idempotency_keys WHERE user_id = $1 AND idempotency_key = $2`, userID, key)
+	if err != nil {
+		return IdempotencyKey{}, err
+	}
+	return stored, nil
+}
+
+func (r *Repository) CompleteIdempotencyKey(key IdempotencyKey) error {
+	_, err := r.db.NamedExec(`UPDATE order_idempotency_keys SET response = :response, error = :error, completed_at = :completed_at
+		WHERE user_id = :user_id AND idempotency_key = :idempotency_key`, key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) CreateSaga(tx *sqlx.Tx, saga Saga) error {
+	_, err := tx.NamedExec(`INSERT INTO order_sagas (order_id, step, state, payment_id, attempts, last_error, fail_reason,
+                         created_at, updated_at, lease_id, leased_until)
+		VALUES (:order_id, :step, :state, :payment_id, :attempts, :last_error, :fail_reason, :created_at, :updated_at,
+		        :lease_id, :leased_until)`, saga)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetSaga(tx *sqlx.Tx, orderID uuid.UUID) (Saga, error) {
+	var saga Saga
+	err := tx.Get(&saga, `SELECT order_id, step, state, payment_id, attempts, last_error, fail_reason, created_at, updated_at,
+       lease_id, leased_until FROM order_sagas WHERE order_id = $1`, orderID)
+	if err != nil {
+		return Saga{}, err
+	}
+	return saga, nil
+}
+
+// SaveSaga сохраняет сагу, только если аренда все еще у этого исполнителя
+func (r *Repository) SaveSaga(tx *sqlx.Tx, saga Saga) error {
+	res, err := tx.NamedExec(`UPDATE order_sagas SET step = :step, state = :state, payment_id = :payment_id,
+		attempts = :attempts, last_error = :last_error, fail_reason = :fail_reason, updated_at = :updated_at,
+		leased_until = :leased_until
+		WHERE order_id = :order_id AND lease_id = :lease_id`, saga)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errSagaLeaseLost
+	}
+	return nil
+}
+
+// ClaimStaleSagas забирает незавершенные саги, которые никто не выполняет, и выдает на них аренду,
+// чтобы другой экземпляр или CreateOrder не двигали их одновременно
+func (r *Repository) ClaimStaleSagas(staleBefore time.Time, leaseID uuid.UUID, leasedUntil time.Time, limit int) ([]Saga, error) {
+	var sagas []Saga
+	err := r.db.Select(&sagas, `UPDATE order_sagas SET updated_at = NOW(), lease_id = $3, leased_until = $4
+		WHERE order_id IN (
+			SELECT order_id FROM order_sagas
+			WHERE state IN ('running', 'compensating') AND updated_at < $1
+			  AND (leased_until IS NULL OR leased_until < NOW())
+			ORDER BY updated_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED)
+		RETURNING order_id, step, state, payment_id, attempts, last_error, fail_reason, created_at, updated_at,
+		    lease_id, leased_until`,
+		staleBefore, limit, leaseID, leasedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return sagas, nil
+}
+
+// ReleaseSaga снимает аренду, сага снова доступна SagaRecovery
+func (r *Repository) ReleaseSaga(orderID, leaseID uuid.UUID) error {
+	_, err := r.db.Exec(`UPDATE order_sagas SET lease_id = NULL, leased_until = NULL
+		WHERE order_id = $1 AND lease_id = $2`, orderID, leaseID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetStatus(user, order uuid.UUID) (StatusResponse, error) {
+	var status StatusResponse
+	err := r.db.QueryRowx(`SELECT id, user_id, status FROM orders WHERE id = $1 AND user_id = $2`, order, user).
+		Scan(&status.ID, &status.UserId, &status.Status)
+	if err != nil {
+		return StatusResponse{}, err
+	}
+	return status, nil
+}
+
+// ReplaceOrderPromotions заменяет акции заказа пересчитанными в Cart
+func (r *Repository) ReplaceOrderPromotions(tx *sqlx.Tx, orderID uuid.UUID, promos []AppliedPromotion) error {
+	if _, err := tx.Exec(`DELETE FROM order_promotions WHERE order_id = $1`, orderID); err != nil {
+		return err
+	}
+	if len(promos) == 0 {
+		return nil
+	}
+	return r.AddOrderPromotions(tx, orderID, promos)
+}
+
+func (r *Repository) AddOrderPromotions(tx *sqlx.Tx, orderID uuid.UUID, promos []AppliedPromotion) error {
+	values := make([]string, 0, len(promos))
+	args := make([]interface{}, 0, 1+len(promos)*4)
+	args = append(args, orderID)
+	for i, p := range promos {
+		n := i*4 + 2
+		values = append(values, fmt.Sprintf("($1, $%d, $%d, $%d, $%d)", n, n+1, n+2, n+3))
+		args = append(args, p.PromotionID, p.Code, p.Type, p.Discount)
+	}
+	query := fmt.Sprintf(`INSERT INTO order_promotions (order_id, promotion_id, code, type, discount)
+		VALUES %s ON CONFLICT (order_id, promotion_id) DO NOTHING`, strings.Join(values, ","))
+	_, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) AddStatusChange(tx *sqlx.Tx, change StatusChange) error {
+	_, err := tx.NamedExec(`INSERT INTO order_status_history (id, order_id, from_status, to_status, actor, reason, created_at)
+		VALUES (:id, :order_id, :from_status, :to_status, :actor, :reason, :created_at)`, change)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetStatusHistory(orderID uuid.UUID) ([]StatusChange, error) {
+	history := []StatusChange{}
+	err := r.db.Select(&history, `SELECT id, order_id, from_status, to_status, actor, reason, created_at
+		FROM order_status_history WHERE order_id = $1 ORDER BY created_at, id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (r *Repository) GetUserOrders(filter OrdersFilter) ([]OrderSummary, error) {
+	orders := []OrderSummary{}
+	err := r.db.Select(&orders, `SELECT o.id, o.status, o.grand_total, o.created_at,
+			COALESCE((SELECT SUM(quantity) FROM order_items i
+				WHERE i.order_id = o.id AND i.canceled_at IS NULL), 0) AS items_qty
+		FROM orders o
+		WHERE o.user_id = $1
+		  AND ($2 = '' OR o.status = $2)
+		  AND ($3::timestamp IS NULL OR o.created_at >= $3)
+		  AND ($4::timestamp IS NULL OR o.created_at < $4)
+		ORDER BY o.created_at DESC, o.id
+		LIMIT $5 OFFSET $6`,
+		filter.UserID, filter.Status, nullTime(filter.From), nullTime(filter.To), filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (r *Repository) CreateReturn(tx *sqlx.Tx, ret Return) error {
+	_, err := tx.NamedExec(`INSERT INTO order_returns (id, order_id, user_id, status, refund_amount, note, updated_by,
+		created_at, updated_at) VALUES (:id, :order_id, :user_id, :status, :refund_amount, :note, :updated_by,
+		:created_at, :updated_at)`, ret)
+	if err != nil {
+		return err
+	}
+	_, err = tx.NamedExec(`INSERT INTO order_return_lines (return_id, product_id, qty, reason)
+		VALUES (:return_id, :product_id, :qty, :reason)`, ret.Lines)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetReturns(orderID uuid.UUID) ([]Return, error) {
+	return selectReturns(r.db, `SELECT id, order_id, user_id, status, refund_amount, note, updated_by, created_at, updated_at
+		FROM order_returns WHERE order_id = $1 ORDER BY created_at, id`, orderID)
+}
+
+// GetOrderReturns возвраты по заказу внутри транзакции, видит еще не закоммиченные изменения
+func (r *Repository) GetOrderReturns(tx *sqlx.Tx, orderID uuid.UUID) ([]Return, error) {
+	return selectReturns(tx, `SELECT id, order_id, user_id, status, refund_amount, note, updated_by, created_at, updated_at
+		FROM order_returns WHERE order_id = $1 ORDER BY created_at, id`, orderID)
+}
+
+func (r *Repository) GetReturnForUpdate(tx *sqlx.Tx, id uuid.UUID) (Return, error) {
+	returns, err := selectReturns(tx, `SELECT id, order_id, user_id, status, refund_amount, note, updated_by, created_at, updated_at
+		FROM order_returns WHERE id = $1 FOR UPDATE`, id)
+	if err != nil {
+		return Return{}, err
+	}
+	if len(returns) == 0 {
+		return Return{}, sql.ErrNoRows
+	}
+	return returns[0], nil
+}
+
+func (r *Repository) UpdateReturn(tx *sqlx.Tx, ret Return) error {
+	_, err := tx.NamedExec(`UPDATE order_returns SET status = :status, refund_amount = :refund_amount, note = :note,
+		updated_by = :updated_by, updated_at = :updated_at WHERE id = :id`, ret)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) MarkReturnLineRestocked(tx *sqlx.Tx, returnID, productID uuid.UUID, at time.Time) error {
+	_, err := tx.Exec(`UPDATE order_return_lines SET restocked_at = $1
+		WHERE return_id = $2 AND product_id = $3 AND restocked_at IS NULL`, at, returnID, productID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func selectReturns(q sqlx.Queryer, query string, args ...any) ([]Return, error) {
+	returns := []Return{}
+	if err := sqlx.Select(q, &returns, query, args...); err != nil {
+		return nil, err
+	}
+	if len(returns) == 0 {
+		return returns, nil
+	}
+	ids := make([]uuid.UUID, 0, len(returns))
+	byID := make(map[uuid.UUID]int, len(returns))
+	for i, ret := range returns {
+		ids = append(ids, ret.ID)
+		byID[ret.ID] = i
+	}
+	linesQuery, linesArgs, err := sqlx.In(`SELECT return_id, product_id, qty, reason, restocked_at
+		FROM order_return_lines WHERE return_id IN (?) ORDER BY product_id`, ids)
+	if err != nil {
+		return nil, err
+	}
+	var lines []ReturnLine
+	if err = sqlx.Select(q, &lines, sqlx.Rebind(sqlx.DOLLAR, linesQuery), linesArgs...); err != nil {
+		return nil, err
+	}
+	for _, l := range lines {
+		i := byID[l.ReturnID]
+		returns[i].Lines = append(returns[i].Lines, l)
+	}
+	return returns, nil
+}
+
+func (r *Repository) AddOutboxEvent(tx *sqlx.Tx, event OutboxEvent) error {
+	_, err := tx.NamedExec(`INSERT INTO order_outbox (id, type, version, order_id, payload, created_at)
+		VALUES (:id, :type, :version, :order_id, :payload, :created_at)`, event)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DispatchOutboxEvents раскладывает новые события по доставкам текущим подписчикам.
+// Событие без подписчиков просто помечается разобранным.
+func (r *Repository) DispatchOutboxEvents(limit int) (int, error) {
+	res, err := r.db.Exec(`WITH events AS (
+			SELECT id, type FROM order_outbox
+			WHERE dispatched_at IS NULL
+			ORDER BY created_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED),
+		fanout AS (
+			INSERT INTO order_event_deliveries (id, event_id, subscription_id, state, next_attempt_at, updated_at)
+			SELECT gen_random_uuid(), e.id, s.id, $2, NOW(), NOW()
+			FROM events e JOIN order_event_subscriptions s ON s.event_type = e.type
+			ON CONFLICT (event_id, subscription_id) DO NOTHING)
+		UPDATE order_outbox SET dispatched_at = NOW() WHERE id IN (SELECT id FROM events)`,
+		limit, DeliveryPending)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// ClaimDeliveries забирает доставки, которым пора уходить, сдвигая next_attempt_at на lease,
+// чтобы другой экземпляр не отправил их же
+func (r *Repository) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]DeliveryJob, error) {
+	var jobs []DeliveryJob
+	err := r.db.Select(&jobs, `UPDATE order_event_deliveries d SET next_attempt_at = $2
+		FROM order_outbox e, order_event_subscriptions s
+		WHERE d.id IN (
+			SELECT id FROM order_event_deliveries
+			WHERE state = $3 AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED)
+		  AND e.id = d.event_id AND s.id = d.subscription_id
+		RETURNING d.id, d.event_id, d.subscription_id, s.url, d.state, d.attempts, d.last_error,
+			d.next_attempt_at, d.delivered_at, d.updated_at,
+			e.id AS "event.id", e.type AS "event.type", e.version AS "event.version", e.order_id AS "event.order_id",
+			e.payload AS "event.payload", e.created_at AS "event.created_at", e.dispatched_at AS "event.dispatched_at"`,
+		now, now.Add(lease), DeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (r *Repository) SaveDelivery(delivery Delivery) error {
+	_, err := r.db.NamedExec(`UPDATE order_event_deliveries SET state = :state, attempts = :attempts,
+		last_error = :last_error, next_attempt_at = :next_attempt_at, delivered_at = :delivered_at, updated_at = :updated_at
+		WHERE id = :id`, delivery)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetDelivery(id uuid.UUID) (Delivery, error) {
+	var delivery Delivery
+	err := r.db.Get(&delivery, `SELECT d.id, d.event_id, d.subscription_id, s.url, d.state, d.attempts, d.last_error,
+			d.next_attempt_at, d.delivered_at, d.updated_at
+		FROM order_event_deliveries d JOIN order_event_subscriptions s ON s.id = d.subscription_id
+		WHERE d.id = $1`, id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	return delivery, nil
+}
+
+// GetDeadLetters доставки, от которых relay отказался, последние первыми
+func (r *Repository) GetDeadLetters(limit, offset int) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	err := r.db.Select(&deliveries, `SELECT d.id, d.event_id, d.subscription_id, s.url, d.state, d.attempts, d.last_error,
+			d.next_attempt_at, d.delivered_at, d.updated_at
+		FROM order_event_deliveries d JOIN order_event_subscriptions s ON s.id = d.subscription_id
+		WHERE d.state = $1
+		ORDER BY d.updated_at DESC, d.id
+		LIMIT $2 OFFSET $3`, DeliveryDead, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// CreateSubscription подписывает url на событие. Повторная подписка возвращает существующую.
+func (r *Repository) CreateSubscription(sub Subscription) (Subscription, error) {
+	var created Subscription
+	err := r.db.Get(&created, `INSERT INTO order_event_subscriptions (id, event_type, url, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (event_type, url) DO UPDATE SET url = EXCLUDED.url
+		RETURNING id, event_type, url, created_at`, sub.ID, sub.EventType, sub.URL, sub.CreatedAt)
+	if err != nil {
+		return Subscription{}, err
+	}
+	return created, nil
+}
+
+func (r *Repository) GetSubscriptions() ([]Subscription, error) {
+	subs := []Subscription{}
+	err := r.db.Select(&subs, `SELECT id, event_type, url, created_at FROM order_event_subscriptions
+		ORDER BY event_type, created_at`)
+	if err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+func (r *Repository) DeleteSubscription(id uuid.UUID) (bool, error) {
+	res, err := r.db.Exec(`DELETE FROM order_event_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// NextInvoiceNumber берет следующий номер документа. Строка счетчика остается заблокированной
+// до конца транзакции, поэтому номера не пропускаются и не повторяются.
+func (r *Repository) NextInvoiceNumber(tx *sqlx.Tx, kind InvoiceKind) (int64, error) {
+	var number int64
+	err := tx.Get(&number, `UPDATE order_invoice_counters SET last_number = last_number + 1
+		WHERE kind = $1 RETURNING last_number`, kind)
+	if err != nil {
+		return 0, err
+	}
+	return number, nil
+}
+
+func (r *Repository) CreateInvoice(tx *sqlx.Tx, inv Invoice) error {
+	_, err := tx.NamedExec(`INSERT INTO order_invoices (id, number, kind, order_id, user_id, invoice_id, reason, currency,
+		net, tax, gross, issued_at) VALUES (:id, :number, :kind, :order_id, :user_id, :invoice_id, :reason, :currency,
+		:net, :tax, :gross, :issued_at)`, inv)
+	if err != nil {
+		return err
+	}
+	_, err = tx.NamedExec(`INSERT INTO order_invoice_lines (invoice_id, line_no, product_id, name, qty, unit_price,
+		tax_rate, net, tax, gross) VALUES (:invoice_id, :line_no, :product_id, :name, :qty, :unit_price,
+		:tax_rate, :net, :tax, :gross)`, inv.Lines)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetOrderInvoice счет заказа внутри транзакции, без строк
+func (r *Repository) GetOrderInvoice(tx *sqlx.Tx, orderID uuid.UUID) (Invoice, error) {
+	var inv Invoice
+	err := tx.Get(&inv, `SELECT id, number, kind, order_id, user_id, invoice_id, reason, currency, net, tax, gross, issued_at
+		FROM order_invoices WHERE order_id = $1 AND kind = $2`, orderID, KindInvoice)
+	if err != nil {
+		return Invoice{}, err
+	}
+	return inv, nil
+}
+
+// GetInvoices счет и кредит-ноты заказа в порядке выпуска
+func (r *Repository) GetInvoices(orderID uuid.UUID) ([]Invoice, error) {
+	return selectInvoices(r.db, `SELECT id, number, kind, order_id, user_id, invoice_id, reason, currency, net, tax, gross,
+		issued_at FROM order_invoices WHERE order_id = $1 ORDER BY issued_at, number`, orderID)
+}
+
+func (r *Repository) GetInvoice(id uuid.UUID) (Invoice, error) {
+	invoices, err := selectInvoices(r.db, `SELECT id, number, kind, order_id, user_id, invoice_id, reason, currency, net, tax,
+		gross, issued_at FROM order_invoices WHERE id = $1`, id)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if len(invoices) == 0 {
+		return Invoice{}, sql.ErrNoRows
+	}
+	return invoices[0], nil
+}
+
+func selectInvoices(q sqlx.Queryer, query string, args ...any) ([]Invoice, error) {
+	invoices := []Invoice{}
+	if err := sqlx.Select(q, &invoices, query, args...); err != nil {
+		return nil, err
+	}
+	if len(invoices) == 0 {
+		return invoices, nil
+	}
+	ids := make([]uuid.UUID, 0, len(invoices))
+	byID := make(map[uuid.UUID]int, len(invoices))
+	for i, inv := range invoices {
+		ids = append(ids, inv.ID)
+		byID[inv.ID] = i
+	}
+	linesQuery, linesArgs, err := sqlx.In(`SELECT invoice_id, line_no, product_id, name, qty, unit_price, tax_rate,
+		net, tax, gross FROM order_invoice_lines WHERE invoice_id IN (?) ORDER BY line_no`, ids)
+	if err != nil {
+		return nil, err
+	}
+	var lines []InvoiceLine
+	if err = sqlx.Select(q, &lines, sqlx.Rebind(sqlx.DOLLAR, linesQuery), linesArgs...); err != nil {
+		return nil, err
+	}
+	for _, l := range lines {
+		i := byID[l.InvoiceID]
+		invoices[i].Lines = append(invoices[i].Lines, l)
+	}
+	return invoices, nil
+}
@@ -0,0 +1,373 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"time"
+)
+
+// Возврат: requested -> approved -> received -> inspecting -> refunded, на рассмотрении и после проверки
+// возможен отказ (rejected). Статус заказа следует за заявками, см. syncReturnStatus.
+
+// ReturnReasonCode код причины движения в журнале склада
+const ReturnReasonCode = "customer_return"
+
+// RequestReturn оформляет возврат по доставленному заказу. Вернуть можно не больше, чем куплено,
+// с учетом уже оформленных возвратов.
+func (s *Service) RequestReturn(orderID uuid.UUID, req CreateReturnRequest) (Return, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return Return{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	items, err := s.repo.GetOrderItems(orderID)
+	if err != nil {
+		return Return{}, fmt.Errorf("order service: request return: %w", err)
+	}
+	now := time.Now()
+	ret := Return{
+		ID:        uuid.New(),
+		OrderID:   orderID,
+		UserID:    req.UserID,
+		Status:    RMARequested,
+		UpdatedBy: req.UserID.String(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = s.withTx("request return", func(tx *sqlx.Tx) error {
+		order, err := s.repo.GetOrderForUpdate(tx, orderID)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && order.UserID != req.UserID {
+			return &common.NotFoundError{Message: fmt.Sprintf("order %s not found", orderID)}
+		}
+		if err != nil {
+			return err
+		}
+		if order.Status != Delivered && order.Status != PartiallyReturned && order.Status != ReturnRequested {
+			return &common.ConflictError{Message: fmt.Sprintf("order %s is %s, only delivered orders can be returned", orderID, order.Status)}
+		}
+		returns, err := s.repo.GetOrderReturns(tx, orderID)
+		if err != nil {
+			return err
+		}
+		left := returnableQty(items, returns)
+		lines := make(map[uuid.UUID]int, len(req.Lines))
+		for _, l := range req.Lines {
+			i, ok := lines[l.ProductID]
+			if !ok {
+				i, lines[l.ProductID] = len(ret.Lines), len(ret.Lines)
+				ret.Lines = append(ret.Lines, ReturnLine{ReturnID: ret.ID, ProductID: l.ProductID, Reason: l.Reason})
+			}
+			ret.Lines[i].Qty += l.Qty
+			if ret.Lines[i].Qty > left[l.ProductID] {
+				return &common.ConflictError{Message: fmt.Sprintf("product %s: returning %d, only %d can be returned",
+					l.ProductID, ret.Lines[i].Qty, left[l.ProductID])}
+			}
+		}
+		if err = s.repo.CreateReturn(tx, ret); err != nil {
+			return err
+		}
+		return s.syncReturnStatus(tx, orderID, items, append(returns, ret), req.UserID.String())
+	})
+	if err != nil {
+		return Return{}, err
+	}
+	s.returnChanged(ret)
+	return ret, nil
+}
+
+func (s *Service) GetReturns(orderID uuid.UUID) ([]Return, error) {
+	if orderID == uuid.Nil {
+		return nil, &common.RequestValidationError{Message: "invalid order id"}
+	}
+	returns, err := s.repo.GetReturns(orderID)
+	if err != nil {
+		return nil, fmt.Errorf("order service: get returns: %w", err)
+	}
+	return returns, nil
+}
+
+func (s *Service) ApproveReturn(orderID, returnID uuid.UUID, req ReviewReturnRequest) (Return, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return Return{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	return s.moveReturn(orderID, returnID, RMARequested, RMAApproved, req.Actor, req.Note, 0)
+}
+
+func (s *Service) RejectReturn(orderID, returnID uuid.UUID, req ReviewReturnRequest) (Return, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return Return{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	return s.moveReturn(orderID, returnID, RMARequested, RMARejected, req.Actor, req.Note, 0)
+}
+
+func (s *Service) ReceiveReturn(orderID, returnID uuid.UUID, req ReviewReturnRequest) (Return, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return Return{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	return s.moveReturn(orderID, returnID, RMAApproved, RMAReceived, req.Actor, req.Note, 0)
+}
+
+// InspectReturn закрывает проверку пришедшего товара. Если товар принят, заявка сначала переходит
+// в inspecting, чтобы вторая проверка того же возврата получила конфликт, затем товар возвращается
+// на склад движением в журнале (склад проводит движение по ссылке на возврат один раз), а покупателю
+// возвращаются деньги. Упавшую проверку можно повторить: она продолжится из inspecting.
+func (s *Service) InspectReturn(orderID, returnID uuid.UUID, req InspectReturnRequest) (Return, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return Return{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	if !req.Accepted {
+		return s.moveReturn(orderID, returnID, RMAReceived, RMARejected, req.Actor, req.Note, 0)
+	}
+	ret, err := s.findReturn(orderID, returnID)
+	if err != nil {
+		return Return{}, err
+	}
+	if ret.Status == RMAReceived {
+		ret, err = s.moveReturn(orderID, returnID, RMAReceived, RMAInspecting, req.Actor, req.Note, 0)
+		if err != nil {
+			return Return{}, err
+		}
+	}
+	if ret.Status != RMAInspecting {
+		return Return{}, &common.ConflictError{Message: fmt.Sprintf("return %s is %s, expected %s", returnID, ret.Status, RMAReceived)}
+	}
+	reservations, err := s.inventory.GetReservations(orderID)
+	if err != nil {
+		return Return{}, fmt.Errorf("order service: inspect return: %w", err)
+	}
+	for _, line := range ret.Lines {
+		if line.RestockedAt != nil {
+			continue
+		}
+		warehouseID := shippedFrom(reservations, line.ProductID)
+		if warehouseID == uuid.Nil {
+			return Return{}, &common.ConflictError{Message: fmt.Sprintf("product %s: warehouse of shipment not found", line.ProductID)}
+		}
+		err = s.inventory.RecordMovement(MovementRequest{
+			WarehouseID: warehouseID,
+			ProductID:   line.ProductID,
+			Type:        "return",
+			Qty:         line.Qty,
+			ReasonCode:  ReturnReasonCode,
+			Actor:       req.Actor,
+			Reference:   ret.ID.String(),
+		})
+		if err != nil {
+			return Return{}, fmt.Errorf("order service: inspect return: %w", err)
+		}
+		err = s.withTx("restock return", func(tx *sqlx.Tx) error {
+			return s.repo.MarkReturnLineRestocked(tx, ret.ID, line.ProductID, time.Now())
+		})
+		if err != nil {
+			return Return{}, err
+		}
+	}
+	order, err := s.repo.GetOrder(orderID)
+	if err != nil {
+		return Return{}, fmt.Errorf("order service: inspect return: %w", err)
+	}
+	items, err := s.repo.GetOrderItems(orderID)
+	if err != nil {
+		return Return{}, fmt.Errorf("order service: inspect return: %w", err)
+	}
+	returns, err := s.repo.GetReturns(orderID)
+	if err != nil {
+		return Return{}, fmt.Errorf("order service: inspect return: %w", err)
+	}
+	refund := returnRefund(order, items, returns, ret)
+	if refund > 0 {
+		_, err = s.payments.Refund(orderID, RefundRequest{Amount: refund, Key: "return:" + ret.ID.String(), Reason: ReturnReasonCode})
+		if err != nil {
+			return Return{}, fmt.Errorf("order service: inspect return: %w", err)
+		}
+	}
+	return s.moveReturn(orderID, returnID, RMAInspecting, RMARefunded, req.Actor, req.Note, refund)
+}
+
+// moveReturn переводит возврат из статуса from в to и пересчитывает статус заказа
+func (s *Service) moveReturn(orderID, returnID uuid.UUID, from, to ReturnStatus, actor, note string, refund int64) (Return, error) {
+	items, err := s.repo.GetOrderItems(orderID)
+	if err != nil {
+		return Return{}, fmt.Errorf("order service: update return: %w", err)
+	}
+	var ret Return
+	var creditNote *Invoice
+	err = s.withTx("update return", func(tx *sqlx.Tx) (err error) {
+		order, err := s.repo.GetOrderForUpdate(tx, orderID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &common.NotFoundError{Message: fmt.Sprintf("order %s not found", orderID)}
+		}
+		if err != nil {
+			return err
+		}
+		ret, err = s.repo.GetReturnForUpdate(tx, returnID)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && ret.OrderID != orderID {
+			return &common.NotFoundError{Message: fmt.Sprintf("return %s not found", returnID)}
+		}
+		if err != nil {
+			return err
+		}
+		if ret.Status != from {
+			return &common.ConflictError{Message: fmt.Sprintf("return %s is %s, expected %s", returnID, ret.Status, from)}
+		}
+		ret.Status, ret.Note, ret.UpdatedBy, ret.UpdatedAt = to, note, actor, time.Now()
+		ret.RefundAmount = refund
+		if err = s.repo.UpdateReturn(tx, ret); err != nil {
+			return err
+		}
+		if to == RMARefunded && refund > 0 {
+			creditNote, err = s.issueCreditNote(tx, order, returnedItems(items, ret), refund,
+				fmt.Sprintf("return %s", ret.ID), ret.UpdatedAt)
+			if err != nil {
+				return err
+			}
+		}
+		returns, err := s.repo.GetOrderReturns(tx, orderID)
+		if err != nil {
+			return err
+		}
+		return s.syncReturnStatus(tx, orderID, items, returns, actor)
+	})
+	if err != nil {
+		return Return{}, err
+	}
+	s.returnChanged(ret)
+	if creditNote != nil {
+		s.invoiceIssued(*creditNote)
+	}
+	return ret, nil
+}
+
+func (s *Service) findReturn(orderID, returnID uuid.UUID) (Return, error) {
+	returns, err := s.repo.GetReturns(orderID)
+	if err != nil {
+		return Return{}, fmt.Errorf("order service: get return: %w", err)
+	}
+	for _, ret := range returns {
+		if ret.ID == returnID {
+			return ret, nil
+		}
+	}
+	return Return{}, &common.NotFoundError{Message: fmt.Sprintf("return %s not found", returnID)}
+}
+
+// syncReturnStatus выставляет статус заказа по заявкам на возврат: пока есть открытая заявка -
+// return_requested, иначе returned, partially_returned или снова delivered
+func (s *Service) syncReturnStatus(tx *sqlx.Tx, orderID uuid.UUID, items []ItemRow, returns []Return, actor string) error {
+	order, err := s.repo.GetOrderForUpdate(tx, orderID)
+	if err != nil {
+		return err
+	}
+	var bought, returned int64
+	for _, it := range items {
+		if it.CanceledAt == nil {
+			bought += it.Quantity
+		}
+	}
+	target := Delivered
+	for _, ret := range returns {
+		switch ret.Status {
+		case RMARequested, RMAApproved, RMAReceived, RMAInspecting:
+			target = ReturnRequested
+		case RMARefunded:
+			for _, l := range ret.Lines {
+				returned += l.Qty
+			}
+		}
+	}
+	if target != ReturnRequested && returned > 0 {
+		target = PartiallyReturned
+		if returned >= bought {
+			target = Returned
+		}
+	}
+	if order.Status == target {
+		return nil
+	}
+	_, err = s.transition(tx, orderID, target, actor, "return status changed")
+	return err
+}
+
+func (s *Service) returnChanged(ret Return) {
+	for _, w := range s.watchers {
+		w.ReturnChanged(ret)
+	}
+}
+
+// returnableQty сколько каждого товара еще можно вернуть: отказанные заявки не считаются
+func returnableQty(items []ItemRow, returns []Return) map[uuid.UUID]int64 {
+	left := make(map[uuid.UUID]int64, len(items))
+	for _, it := range items {
+		if it.CanceledAt == nil {
+			left[it.ID] += it.Quantity
+		}
+	}
+	for _, ret := range returns {
+		if ret.Status == RMARejected {
+			continue
+		}
+		for _, l := range ret.Lines {
+			left[l.ProductID] -= l.Qty
+		}
+	}
+	return left
+}
+
+// returnRefund доля итога заказа, приходящаяся на возвращаемые товары, по gross строк,
+// в котором уже учтены скидки. Сумма всех возвратов не превышает итог заказа.
+func returnRefund(order OrderRow, items []ItemRow, returns []Return, ret Return) int64 {
+	lines := make(map[uuid.UUID]ItemRow, len(items))
+	var itemsValue, value int64
+	for _, it := range items {
+		if it.CanceledAt == nil && it.Quantity > 0 {
+			lines[it.ID] = it
+			itemsValue += it.Gross
+		}
+	}
+	if itemsValue == 0 {
+		return 0
+	}
+	for _, l := range ret.Lines {
+		if it, ok := lines[l.ProductID]; ok {
+			value += it.Gross * l.Qty / it.Quantity
+		}
+	}
+	refunded := int64(0)
+	for _, r := range returns {
+		if r.Status == RMARefunded && r.ID != ret.ID {
+			refunded += r.RefundAmount
+		}
+	}
+	return min(value*order.GrandTotal/itemsValue, order.GrandTotal-refunded)
+}
+
+// returnedItems строки заказа с возвращаемым количеством и пропорциональными суммами, для кредит-ноты
+func returnedItems(items []ItemRow, ret Return) []ItemRow {
+	byID := make(map[uuid.UUID]ItemRow, len(items))
+	for _, it := range items {
+		byID[it.ID] = it
+	}
+	result := make([]ItemRow, 0, len(ret.Lines))
+	for _, l := range ret.Lines {
+		it := byID[l.ProductID]
+		if it.Quantity > 0 {
+			it.Gross, it.Tax = it.Gross*l.Qty/it.Quantity, it.Tax*l.Qty/it.Quantity
+			it.Net = it.Gross - it.Tax
+		}
+		it.ID, it.Quantity = l.ProductID, l.Qty
+		result = append(result, it)
+	}
+	return result
+}
+
+// shippedFrom склад, с которого отгружался товар
+func shippedFrom(reservations []Reservation, productID uuid.UUID) uuid.UUID {
+	for _, r := range reservations {
+		if r.ProductID == productID && r.WarehouseID != uuid.Nil {
+			return r.WarehouseID
+		}
+	}
+	return uuid.Nil
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestReturnRefund(t *testing.T) {
+	productA := uuid.MustParse("00000000-0000-0000-0000-0000000000a1")
+	productB := uuid.MustParse("00000000-0000-0000-0000-0000000000a2")
+	productC := uuid.MustParse("00000000-0000-0000-0000-0000000000a3")
+	retID := uuid.MustParse("00000000-0000-0000-0000-000000000201")
+	otherID := uuid.MustParse("00000000-0000-0000-0000-000000000202")
+	canceledAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	// стоимость неотмененных строк 3000, итог заказа 2700 - скидка заказа распределяется пропорционально
+	items := []ItemRow{
+		{ID: productA, Quantity: 2, Gross: 2000},
+		{ID: productB, Quantity: 1, Gross: 1000},
+		{ID: productC, Quantity: 1, Gross: 500, CanceledAt: &canceledAt},
+	}
+	order := OrderRow{GrandTotal: 2700}
+
+	tests := []struct {
+		name    string
+		order   OrderRow
+		items   []ItemRow
+		returns []Return
+		lines   []ReturnLine
+		want    int64
+	}{
+		{
+			name:  "part of a line",
+			order: order,
+			items: items,
+			lines: []ReturnLine{{ProductID: productA, Qty: 1}},
+			want:  900,
+		},
+		{
+			name:  "whole order",
+			order: order,
+			items: items,
+			lines: []ReturnLine{{ProductID: productA, Qty: 2}, {ProductID: productB, Qty: 1}},
+			want:  2700,
+		},
+		{
+			name:  "canceled line is not refunded",
+			order: order,
+			items: items,
+			lines: []ReturnLine{{ProductID: productC, Qty: 1}},
+			want:  0,
+		},
+		{
+			name:  "unknown product",
+			order: order,
+			items: items,
+			lines: []ReturnLine{{ProductID: uuid.New(), Qty: 1}},
+			want:  0,
+		},
+		{
+			name:    "capped by what is left after earlier refunds",
+			order:   order,
+			items:   items,
+			returns: []Return{{ID: otherID, Status: RMARefunded, RefundAmount: 2000}},
+			lines:   []ReturnLine{{ProductID: productA, Qty: 2}, {ProductID: productB, Qty: 1}},
+			want:    700,
+		},
+		{
+			name:    "not yet refunded returns do not reduce the cap",
+			order:   order,
+			items:   items,
+			returns: []Return{{ID: otherID, Status: RMAApproved, RefundAmount: 2000}},
+			lines:   []ReturnLine{{ProductID: productA, Qty: 2}, {ProductID: productB, Qty: 1}},
+			want:    2700,
+		},
+		{
+			name:    "own earlier refund is not counted",
+			order:   order,
+			items:   items,
+			returns: []Return{{ID: retID, Status: RMARefunded, RefundAmount: 2700}},
+			lines:   []ReturnLine{{ProductID: productA, Qty: 2}, {ProductID: productB, Qty: 1}},
+			want:    2700,
+		},
+		{
+			name:  "rounds down",
+			order: OrderRow{GrandTotal: 1000},
+			items: []ItemRow{{ID: productA, Quantity: 3, Gross: 1000}},
+			lines: []ReturnLine{{ProductID: productA, Qty: 1}},
+			want:  333,
+		},
+		{
+			name:  "all lines canceled",
+			order: order,
+			items: []ItemRow{{ID: productC, Quantity: 1, Gross: 500, CanceledAt: &canceledAt}},
+			lines: []ReturnLine{{ProductID: productC, Qty: 1}},
+			want:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ret := Return{ID: retID, Lines: tt.lines}
+			if got := returnRefund(tt.order, tt.items, tt.returns, ret); got != tt.want {
+				t.Errorf("returnRefund = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"net/http"
+	"time"
+)
+
+// Сага оформления заказа: reserve_stock -> price_lines -> init_payment.
+// Состояние сохраняется после каждого шага, поэтому после падения сервиса
+// SagaRecovery продолжает сагу с того шага, на котором она остановилась.
+// Временные ошибки (сеть, 5xx) повторяются до MaxAttempts, ошибки 4xx сразу
+// запускают компенсацию: отмену платежа и снятие резерва в обратном порядке.
+
+// errSagaLeaseLost аренду саги перехватил другой исполнитель, дальше сагу ведет он
+var errSagaLeaseLost = errors.New("saga lease lost")
+
+// advanceSaga выполняет шаги саги, пока она не завершится или не упрется во временную ошибку.
+// Компенсация повторяется без ограничения попыток: оставить резерв висеть хуже, чем ждать.
+// Сага должна быть в аренде у вызывающего: каждый шаг продлевает аренду, по выходе она снимается.
+func (s *Service) advanceSaga(saga *Saga) (err error) {
+	defer func() {
+		if saga.LeaseID == nil || errors.Is(err, errSagaLeaseLost) {
+			return
+		}
+		if releaseErr := s.repo.ReleaseSaga(saga.OrderID, *saga.LeaseID); releaseErr != nil {
+			err = errors.Join(err, fmt.Errorf("order service: release saga %s: %w", saga.OrderID, releaseErr))
+		}
+	}()
+	for saga.State == SagaRunning || saga.State == SagaCompensating {
+		var err error
+		if saga.State == SagaRunning {
+			err = s.forward(saga)
+		} else {
+			err = s.compensate(saga)
+		}
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, errSagaLeaseLost) {
+			return fmt.Errorf("order service: saga %s: %w", saga.OrderID, err)
+		}
+		failed := *saga
+		failed.Attempts++
+		failed.LastError = err.Error()
+		failed.UpdatedAt = time.Now()
+		failed.LeasedUntil = s.leaseUntil(failed.UpdatedAt)
+		giveUp := saga.State == SagaRunning && (isPermanent(err) || failed.Attempts >= s.cfg.MaxAttempts)
+		if giveUp {
+			failed.State, failed.Attempts, failed.FailReason = SagaCompensating, 0, err.Error()
+		}
+		saveErr := s.withTx("save saga", func(tx *sqlx.Tx) error {
+			return s.repo.SaveSaga(tx, failed)
+		})
+		if errors.Is(saveErr, errSagaLeaseLost) {
+			return fmt.Errorf("order service: saga %s: %w", saga.OrderID, saveErr)
+		}
+		if saveErr != nil {
+			return errors.Join(err, saveErr)
+		}
+		*saga = failed
+		if !giveUp {
+			return fmt.Errorf("order service: saga %s: %s: %w", saga.OrderID, saga.Step, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) forward(saga *Saga) error {
+	next := *saga
+	switch saga.Step {
+	case StepReserveStock:
+		if err := s.reserveStock(saga.OrderID); err != nil {
+			return err
+		}
+		next.Step = StepPriceLines
+		return s.commitStep(saga, next, nil)
+	case StepPriceLines:
+		return s.priceLines(saga)
+	case StepInitPayment:
+		order, err := s.repo.GetOrder(saga.OrderID)
+		if err != nil {
+			return err
+		}
+		payment, err := s.payments.CreatePayment(PaymentRequest{
+			UserID:   order.UserID,
+			OrderID:  order.ID.String(),
+			Amount:   order.GrandTotal,
+			Currency: s.cfg.Currency,
+		})
+		if err != nil {
+			return err
+		}
+		next.PaymentID, next.Step, next.State = &payment.PaymentID, StepDone, SagaCompleted
+		return s.commitStep(saga, next, func(tx *sqlx.Tx) error {
+			_, err := s.transition(tx, order.ID, PendingPayment, SystemActor, "payment initiated")
+			return err
+		})
+	default:
+		return fmt.Errorf("unknown saga step %s", saga.Step)
+	}
+}
+
+// reserveStock резервирует неотмененные строки заказа. Если живой резерв уже есть, значит шаг
+// выполнился до сбоя. Истекший или снятый резерв не в счет: после него заказ резервируется заново.
+func (s *Service) reserveStock(orderID uuid.UUID) error {
+	reservations, err := s.inventory.GetReservations(orderID)
+	if err != nil && !hasStatus(err, http.StatusNotFound) {
+		return err
+	}
+	for _, r := range reservations {
+		if r.State != "expired" && r.State != "released" {
+			return nil
+		}
+	}
+	items, err := s.repo.GetOrderItems(orderID)
+	if err != nil {
+		return err
+	}
+	lines := make([]ReserveLine, 0, len(items))
+	for _, it := range items {
+		if it.CanceledAt == nil {
+			lines = append(lines, ReserveLine{Id: it.ID, Qty: it.Quantity})
+		}
+	}
+	return s.inventory.ReserveOrder(ReserveOrderRequest{OrderID: orderID, Lines: lines})
+}
+
+// priceLines фиксирует в строках заказа название, цену и налоговую категорию из каталога,
+// распределяет пересчитанные в Cart скидки промо по строкам пропорционально стоимости
+// и считает налог по строкам
+func (s *Service) priceLines(saga *Saga) error {
+	order, err := s.repo.GetOrder(saga.OrderID)
+	if err != nil {
+		return err
+	}
+	items, err := s.repo.GetOrderItems(saga.OrderID)
+	if err != nil {
+		return err
+	}
+	promotions, err := s.quotePromotions(order, items)
+	if err != nil {
+		return err
+	}
+	weights := make([]int64, 0, len(items))
+	var value, discount int64
+	for i := range items {
+		product, err := s.catalog.GetProduct(items[i].ID)
+		if err != nil {
+			return err
+		}
+		if product.TaxClass == "" {
+			product.TaxClass = defaultTaxClass
+		}
+		items[i].Name, items[i].UnitPrice, items[i].TaxClass = product.Name, product.UnitPrice, product.TaxClass
+		weights = append(weights, product.UnitPrice*items[i].Quantity)
+		value += product.UnitPrice * items[i].Quantity
+	}
+	for _, p := range promotions {
+		discount += p.Discount
+	}
+	// итог не может уйти ниже нуля, поэтому скидка не больше стоимости товаров
+	shares := allocate(min(discount, value), weights)
+	req := TaxRequest{Region: order.Region, Currency: s.cfg.Currency, Lines: make([]TaxableLine, 0, len(items))}
+	for i, it := range items {
+		req.Lines = append(req.Lines, TaxableLine{ProductID: it.ID, TaxClass: it.TaxClass, Amount: weights[i] - shares[i]})
+	}
+	taxed, err := s.taxes.Calculate(req)
+	if err != nil {
+		return err
+	}
+	if len(taxed.Lines) != len(items) {
+		return fmt.Errorf("tax calculator returned %d lines for %d", len(taxed.Lines), len(items))
+	}
+	for i, l := range taxed.Lines {
+		items[i].TaxRate, items[i].Net, items[i].Tax, items[i].Gross = l.Rate, l.Net, l.Tax, l.Gross
+	}
+	next := *saga
+	next.Step = StepInitPayment
+	return s.commitStep(saga, next, func(tx *sqlx.Tx) error {
+		for _, it := range items {
+			if err := s.repo.UpdateOrderItem(tx, it); err != nil {
+				return err
+			}
+		}
+		if err := s.repo.ReplaceOrderPromotions(tx, saga.OrderID, promotions); err != nil {
+			return err
+		}
+		return s.repo.UpdateOrderPricing(tx, saga.OrderID, taxed.Region, taxed.Mode, orderTotals(items))
+	})
+}
+
+// quotePromotions проверяет акции заказа в Cart и получает скидки, посчитанные сервером.
+// Недействующая акция - ошибка 4xx, сага отменит заказ, а не спишет полную цену молча.
+func (s *Service) quotePromotions(order OrderRow, items []ItemRow) ([]AppliedPromotion, error) {
+	requested, err := s.repo.GetOrderPromotions(order.ID)
+	if err != nil || len(requested) == 0 {
+		return nil, err
+	}
+	req := PromotionQuoteRequest{
+		UserID:       order.UserID,
+		Items:        make([]QuoteItem, 0, len(items)),
+		PromotionIDs: make([]uuid.UUID, 0, len(requested)),
+	}
+	for _, it := range items {
+		req.Items = append(req.Items, QuoteItem{ProductID: it.ID, Qty: it.Quantity})
+	}
+	for _, p := range requested {
+		req.PromotionIDs = append(req.PromotionIDs, p.PromotionID)
+	}
+	quote, err := s.cart.QuotePromotions(req)
+	if err != nil {
+		return nil, err
+	}
+	return quote.Promotions, nil
+}
+
+// compensate откатывает шаг, на котором стоит сага, и переводит ее на предыдущий
+func (s *Service) compensate(saga *Saga) error {
+	next := *saga
+	switch saga.Step {
+	case StepInitPayment:
+		// ответ на создание платежа мог потеряться, поэтому отменяем в любом случае
+		if err := s.payments.CancelPayment(saga.OrderID); err != nil && !hasStatus(err, http.StatusNotFound) {
+			return err
+		}
+		next.Step = StepPriceLines
+	case StepPriceLines:
+		next.Step = StepReserveStock
+	case StepReserveStock:
+		// резерва может не быть (404) или он уже истек (409)
+		err := s.inventory.ReleaseOrder(saga.OrderID)
+		if err != nil && !hasStatus(err, http.StatusNotFound, http.StatusConflict) {
+			return err
+		}
+		next.State = SagaCompensated
+		return s.commitStep(saga, next, func(tx *sqlx.Tx) error {
+			_, err := s.transition(tx, saga.OrderID, Canceled, SystemActor, saga.FailReason)
+			return err
+		})
+	default:
+		return fmt.Errorf("unknown saga step %s", saga.Step)
+	}
+	return s.commitStep(saga, next, nil)
+}
+
+// commitStep сохраняет новое состояние саги вместе с изменениями заказа одной транзакцией
+func (s *Service) commitStep(saga *Saga, next Saga, fn func(tx *sqlx.Tx) error) error {
+	next.Attempts, next.LastError, next.UpdatedAt = 0, "", time.Now()
+	next.LeasedUntil = s.leaseUntil(next.UpdatedAt)
+	err := s.withTx("saga step", func(tx *sqlx.Tx) error {
+		if fn != nil {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+		return s.repo.SaveSaga(tx, next)
+	})
+	if err != nil {
+		return err
+	}
+	*saga = next
+	return nil
+}
+
+func (s *Service) leaseUntil(now time.Time) *time.Time {
+	until := now.Add(s.cfg.Lease)
+	return &until
+}
+
+// ResumeSagas продолжает саги, которые не двигались дольше StaleAfter: сервис упал посреди шага
+// или шаг ждет повтора после временной ошибки.
+func (s *Service) ResumeSagas(now time.Time) (int, error) {
+	sagas, err := s.repo.ClaimStaleSagas(now.Add(-s.cfg.StaleAfter), uuid.New(), now.Add(s.cfg.Lease), s.cfg.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("order service: resume sagas: %w", err)
+	}
+	var errs []error
+	for i := range sagas {
+		if err = s.advanceSaga(&sagas[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return len(sagas), errors.Join(errs...)
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"context"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"go.uber.org/zap"
+	"time"
+)
+
+type SagaResumer interface {
+	ResumeSagas(now time.Time) (int, error)
+}
+
+// SagaRecovery периодически подбирает незавершенные саги оформления заказа и доводит их
+// до конца или до компенсации
+type SagaRecovery struct {
+	svc      SagaResumer
+	logger   *common.Logger
+	interval time.Duration
+}
+
+func NewSagaRecovery(svc SagaResumer, logger *common.Logger, cfg common.SagaConfig) *SagaRecovery {
+	return &SagaRecovery{svc: svc, logger: logger, interval: cfg.RecoveryInterval}
+}
+
+func (r *SagaRecovery) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			resumed, err := r.svc.ResumeSagas(time.Now())
+			if err != nil {
+				r.logger.Error("failed to resume sagas", zap.Error(err))
+			}
+			if resumed > 0 {
+				r.logger.Info("sagas resumed", zap.Int("count", resumed))
+			}
+		}
+	}
+}
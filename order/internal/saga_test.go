@@ -0,0 +1,535 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"github.com/madrabit/mini-market/order/internal/validator"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// sagaRepo хранит заказы и саги в памяти
+type sagaRepo struct {
+	Repo
+	orders     map[uuid.UUID]OrderRow
+	items      map[uuid.UUID][]ItemRow
+	promotions map[uuid.UUID][]AppliedPromotion
+	sagas      map[uuid.UUID]Saga
+	history    map[uuid.UUID][]StatusChange
+	events     []OutboxEvent
+	invoices   []Invoice
+}
+
+func newSagaRepo() *sagaRepo {
+	return &sagaRepo{
+		orders:     map[uuid.UUID]OrderRow{},
+		items:      map[uuid.UUID][]ItemRow{},
+		promotions: map[uuid.UUID][]AppliedPromotion{},
+		sagas:      map[uuid.UUID]Saga{},
+		history:    map[uuid.UUID][]StatusChange{},
+	}
+}
+
+func (r *sagaRepo) BeginTransaction() (*sqlx.Tx, error) { return beginNoopTx() }
+
+func (r *sagaRepo) CreateOrder(_ *sqlx.Tx, order OrderRow) error {
+	r.orders[order.ID] = order
+	return nil
+}
+
+func (r *sagaRepo) GetOrder(id uuid.UUID) (OrderRow, error) {
+	order, ok := r.orders[id]
+	if !ok {
+		return OrderRow{}, sql.ErrNoRows
+	}
+	return order, nil
+}
+
+func (r *sagaRepo) GetOrderForUpdate(_ *sqlx.Tx, id uuid.UUID) (OrderRow, error) {
+	return r.GetOrder(id)
+}
+
+func (r *sagaRepo) AddStatusChange(_ *sqlx.Tx, change StatusChange) error {
+	r.history[change.OrderID] = append(r.history[change.OrderID], change)
+	return nil
+}
+
+func (r *sagaRepo) GetStatusHistory(orderID uuid.UUID) ([]StatusChange, error) {
+	return r.history[orderID], nil
+}
+
+func (r *sagaRepo) UpdateOrderStatus(_ *sqlx.Tx, id uuid.UUID, status Status) error {
+	order := r.orders[id]
+	order.Status = status
+	r.orders[id] = order
+	return nil
+}
+
+func (r *sagaRepo) SetCancellation(_ *sqlx.Tx, id uuid.UUID, key string, from Status) error {
+	order := r.orders[id]
+	order.CancelKey, order.CancelFrom = key, from
+	r.orders[id] = order
+	return nil
+}
+
+func (r *sagaRepo) UpdateOrderTotals(_ *sqlx.Tx, id uuid.UUID, totals OrderTotals) error {
+	order := r.orders[id]
+	order.NetTotal, order.TaxTotal, order.GrandTotal = totals.Net, totals.Tax, totals.Gross
+	r.orders[id] = order
+	return nil
+}
+
+func (r *sagaRepo) UpdateOrderPricing(_ *sqlx.Tx, id uuid.UUID, region string, mode TaxMode, totals OrderTotals) error {
+	order := r.orders[id]
+	order.Region, order.TaxMode = region, mode
+	r.orders[id] = order
+	return r.UpdateOrderTotals(nil, id, totals)
+}
+
+// GetTaxRates одна ставка 20% на все регионы
+func (r *sagaRepo) GetTaxRates([]string) ([]TaxRate, error) {
+	return []TaxRate{{TaxClass: defaultTaxClass, Region: anyRegion, Rate: 2000}}, nil
+}
+
+func (r *sagaRepo) AddOrderItems(_ *sqlx.Tx, items []ItemRow) error {
+	for _, it := range items {
+		r.items[it.OrderID] = append(r.items[it.OrderID], it)
+	}
+	return nil
+}
+
+func (r *sagaRepo) GetOrderItems(orderID uuid.UUID) ([]ItemRow, error) {
+	return append([]ItemRow(nil), r.items[orderID]...), nil
+}
+
+func (r *sagaRepo) UpdateOrderItem(_ *sqlx.Tx, item ItemRow) error {
+	for i, it := range r.items[item.OrderID] {
+		if it.ID == item.ID {
+			r.items[item.OrderID][i] = item
+		}
+	}
+	return nil
+}
+
+func (r *sagaRepo) CancelOrderItems(_ *sqlx.Tx, orderID uuid.UUID, productIDs []uuid.UUID, at time.Time) error {
+	for _, id := range productIDs {
+		for i, it := range r.items[orderID] {
+			if it.ID == id {
+				r.items[orderID][i].CanceledAt = &at
+			}
+		}
+	}
+	return nil
+}
+
+func (r *sagaRepo) AddOrderPromotions(_ *sqlx.Tx, orderID uuid.UUID, promos []AppliedPromotion) error {
+	r.promotions[orderID] = promos
+	return nil
+}
+
+func (r *sagaRepo) ReplaceOrderPromotions(_ *sqlx.Tx, orderID uuid.UUID, promos []AppliedPromotion) error {
+	r.promotions[orderID] = promos
+	return nil
+}
+
+func (r *sagaRepo) GetOrderPromotions(orderID uuid.UUID) ([]AppliedPromotion, error) {
+	return r.promotions[orderID], nil
+}
+
+func (r *sagaRepo) AddOutboxEvent(_ *sqlx.Tx, event OutboxEvent) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *sagaRepo) GetSaga(_ *sqlx.Tx, orderID uuid.UUID) (Saga, error) {
+	saga, ok := r.sagas[orderID]
+	if !ok {
+		return Saga{}, sql.ErrNoRows
+	}
+	return saga, nil
+}
+
+func (r *sagaRepo) SetOrderPayment(_ *sqlx.Tx, id, paymentID uuid.UUID, paidAt time.Time) error {
+	order := r.orders[id]
+	order.PaymentID, order.PaidAt = &paymentID, &paidAt
+	r.orders[id] = order
+	return nil
+}
+
+func (r *sagaRepo) NextInvoiceNumber(_ *sqlx.Tx, kind InvoiceKind) (int64, error) {
+	var n int64 = 1
+	for _, inv := range r.invoices {
+		if inv.Kind == kind {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *sagaRepo) CreateInvoice(_ *sqlx.Tx, inv Invoice) error {
+	r.invoices = append(r.invoices, inv)
+	return nil
+}
+
+func (r *sagaRepo) GetOrderInvoice(_ *sqlx.Tx, orderID uuid.UUID) (Invoice, error) {
+	for _, inv := range r.invoices {
+		if inv.OrderID == orderID && inv.Kind == KindInvoice {
+			return inv, nil
+		}
+	}
+	return Invoice{}, sql.ErrNoRows
+}
+
+func (r *sagaRepo) CreateSaga(_ *sqlx.Tx, saga Saga) error {
+	r.sagas[saga.OrderID] = saga
+	return nil
+}
+
+func (r *sagaRepo) SaveSaga(_ *sqlx.Tx, saga Saga) error {
+	if !sameLease(r.sagas[saga.OrderID].LeaseID, saga.LeaseID) {
+		return errSagaLeaseLost
+	}
+	r.sagas[saga.OrderID] = saga
+	return nil
+}
+
+func (r *sagaRepo) ClaimStaleSagas(_ time.Time, leaseID uuid.UUID, leasedUntil time.Time, limit int) ([]Saga, error) {
+	var sagas []Saga
+	for id, saga := range r.sagas {
+		if saga.State != SagaRunning && saga.State != SagaCompensating || len(sagas) == limit {
+			continue
+		}
+		if saga.LeasedUntil != nil && saga.LeasedUntil.After(time.Now()) {
+			continue
+		}
+		saga.LeaseID, saga.LeasedUntil = &leaseID, &leasedUntil
+		r.sagas[id] = saga
+		sagas = append(sagas, saga)
+	}
+	return sagas, nil
+}
+
+func (r *sagaRepo) ReleaseSaga(orderID, leaseID uuid.UUID) error {
+	saga := r.sagas[orderID]
+	if sameLease(saga.LeaseID, &leaseID) {
+		saga.LeaseID, saga.LeasedUntil = nil, nil
+		r.sagas[orderID] = saga
+	}
+	return nil
+}
+
+func sameLease(a, b *uuid.UUID) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+// sagaDeps подменяет Inventory, Catalog, Cart и Payments. Ошибки из очередей отдаются по одной на вызов.
+type sagaDeps struct {
+	calls       []string
+	reserveErrs []error
+	releaseErrs []error
+	catalogErrs []error
+	quoteErrs   []error
+	paymentErrs []error
+	reserved    map[uuid.UUID]bool
+	refunds     []RefundRequest
+}
+
+func pop(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+func (d *sagaDeps) ReserveOrder(req ReserveOrderRequest) error {
+	d.calls = append(d.calls, "reserve")
+	if err := pop(&d.reserveErrs); err != nil {
+		return err
+	}
+	d.reserved[req.OrderID] = true
+	return nil
+}
+
+func (d *sagaDeps) GetReservations(orderID uuid.UUID) ([]Reservation, error) {
+	if d.reserved[orderID] {
+		return []Reservation{{ID: uuid.New(), State: "held"}}, nil
+	}
+	return nil, &StatusError{Code: http.StatusNotFound}
+}
+
+func (d *sagaDeps) ReleaseOrder(orderID uuid.UUID) error {
+	d.calls = append(d.calls, "release")
+	if err := pop(&d.releaseErrs); err != nil {
+		return err
+	}
+	if !d.reserved[orderID] {
+		return &StatusError{Code: http.StatusNotFound}
+	}
+	delete(d.reserved, orderID)
+	return nil
+}
+
+func (d *sagaDeps) ReleaseLine(orderID, productID uuid.UUID) error {
+	d.calls = append(d.calls, "release "+productID.String())
+	if err := pop(&d.releaseErrs); err != nil {
+		return err
+	}
+	if !d.reserved[orderID] {
+		return &StatusError{Code: http.StatusNotFound}
+	}
+	return nil
+}
+
+func (d *sagaDeps) CommitOrder(uuid.UUID) error {
+	d.calls = append(d.calls, "commit")
+	return nil
+}
+
+func (d *sagaDeps) RecordMovement(MovementRequest) error { return nil }
+
+func (d *sagaDeps) GetProduct(id uuid.UUID) (CatalogItem, error) {
+	d.calls = append(d.calls, "price")
+	if err := pop(&d.catalogErrs); err != nil {
+		return CatalogItem{}, err
+	}
+	return CatalogItem{Id: id, Name: "tea", UnitPrice: 250}, nil
+}
+
+// QuotePromotions скидка каждой акции - 100 независимо от того, что пришло в заказе
+func (d *sagaDeps) QuotePromotions(req PromotionQuoteRequest) (PromotionQuote, error) {
+	if err := pop(&d.quoteErrs); err != nil {
+		return PromotionQuote{}, err
+	}
+	quote := PromotionQuote{}
+	for _, id := range req.PromotionIDs {
+		quote.Promotions = append(quote.Promotions, AppliedPromotion{PromotionID: id, Code: "SALE", Type: "fixed_amount", Discount: 100})
+	}
+	return quote, nil
+}
+
+func (d *sagaDeps) CreatePayment(PaymentRequest) (PaymentResponse, error) {
+	d.calls = append(d.calls, "pay")
+	if err := pop(&d.paymentErrs); err != nil {
+		return PaymentResponse{}, err
+	}
+	return PaymentResponse{PaymentID: uuid.New(), Status: "pending"}, nil
+}
+
+func (d *sagaDeps) CancelPayment(uuid.UUID) error {
+	d.calls = append(d.calls, "cancel payment")
+	return nil
+}
+
+func (d *sagaDeps) Refund(_ uuid.UUID, req RefundRequest) (Refund, error) {
+	d.calls = append(d.calls, "refund")
+	d.refunds = append(d.refunds, req)
+	return Refund{}, nil
+}
+
+func newSagaService(deps *sagaDeps) (*Service, *sagaRepo) {
+	deps.reserved = map[uuid.UUID]bool{}
+	repo := newSagaRepo()
+	cfg := common.SagaConfig{MaxAttempts: 2, BatchSize: 10, Lease: time.Minute, Currency: "RUB"}
+	signer := NewPaymentSigner(common.CallbackConfig{PaymentSecret: callbackSecret, MaxSkew: 5 * time.Minute})
+	return NewService(repo, validator.New(), cfg, common.InvoiceConfig{},
+		NewTableTaxCalculator(repo, common.TaxConfig{Mode: string(TaxInclusive), DefaultRegion: "RU"}), deps, deps, deps, deps, signer), repo
+}
+
+var sagaOrder = CreatOrderRequest{
+	UserID: uuid.New(),
+	Items:  []ItemQty{{ID: uuid.New(), Quantity: 2}},
+	Promotions: []AppliedPromotion{
+		// скидку из тела Cart пересчитает в 100
+		{PromotionID: uuid.New(), Code: "SALE", Type: "fixed_amount", Discount: 5000},
+	},
+}
+
+func assertCalls(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("calls = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestCreateOrderSagaCompletes(t *testing.T) {
+	deps := &sagaDeps{}
+	svc, repo := newSagaService(deps)
+	order, err := svc.CreateOrder(sagaOrder, "")
+	if err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	assertCalls(t, deps.calls, "reserve", "price", "pay")
+	if order.Status != PendingPayment || order.GrandTotal != 400 {
+		t.Errorf("status/total = %s/%d, want pending_payment/400", order.Status, order.GrandTotal)
+	}
+	if order.Items[0].Name != "tea" || order.Items[0].UnitPrice != 250 {
+		t.Errorf("line snapshot = %s/%d, want tea/250", order.Items[0].Name, order.Items[0].UnitPrice)
+	}
+	if p := repo.promotions[order.ID]; len(p) != 1 || p[0].Discount != 100 {
+		t.Errorf("promotions = %+v, want one quoted at 100", p)
+	}
+	if h := repo.history[order.ID]; len(h) != 2 || h[0].To != New || h[1].To != PendingPayment {
+		t.Errorf("status history = %+v, want new -> pending_payment", h)
+	}
+	if len(repo.events) != 1 || repo.events[0].Type != EventOrderCreated || repo.events[0].OrderID != order.ID {
+		t.Errorf("outbox = %+v, want one order.created", repo.events)
+	}
+	saga := repo.sagas[order.ID]
+	if saga.State != SagaCompleted || saga.Step != StepDone || saga.PaymentID == nil {
+		t.Errorf("saga = %s/%s payment %v, want completed/done with payment", saga.State, saga.Step, saga.PaymentID)
+	}
+}
+
+func TestCreateOrderSagaCompensatesPermanentFailure(t *testing.T) {
+	tests := []struct {
+		name  string
+		deps  *sagaDeps
+		calls []string
+	}{
+		{
+			name:  "out of stock",
+			deps:  &sagaDeps{reserveErrs: []error{&StatusError{Code: http.StatusConflict}}},
+			calls: []string{"reserve", "release"},
+		},
+		{
+			name:  "promotion expired",
+			deps:  &sagaDeps{quoteErrs: []error{&StatusError{Code: http.StatusConflict}}},
+			calls: []string{"reserve", "release"},
+		},
+		{
+			name:  "product removed from catalog",
+			deps:  &sagaDeps{catalogErrs: []error{&StatusError{Code: http.StatusNotFound}}},
+			calls: []string{"reserve", "price", "release"},
+		},
+		{
+			name:  "payment rejected",
+			deps:  &sagaDeps{paymentErrs: []error{&StatusError{Code: http.StatusUnprocessableEntity}}},
+			calls: []string{"reserve", "price", "pay", "cancel payment", "release"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, repo := newSagaService(tt.deps)
+			_, err := svc.CreateOrder(sagaOrder, "")
+			var conflict *common.ConflictError
+			if !errors.As(err, &conflict) {
+				t.Fatalf("err = %v, want ConflictError", err)
+			}
+			assertCalls(t, tt.deps.calls, tt.calls...)
+			if len(tt.deps.reserved) != 0 {
+				t.Errorf("reservation left after compensation")
+			}
+			for id, saga := range repo.sagas {
+				if saga.State != SagaCompensated || saga.FailReason == "" {
+					t.Errorf("saga = %s, reason %q; want compensated with reason", saga.State, saga.FailReason)
+				}
+				if status := repo.orders[id].Status; status != Canceled {
+					t.Errorf("order status = %s, want canceled", status)
+				}
+			}
+		})
+	}
+}
+
+func TestSagaRecoveryResumesAfterTransientFailure(t *testing.T) {
+	deps := &sagaDeps{catalogErrs: []error{&StatusError{Code: http.StatusServiceUnavailable}}}
+	svc, repo := newSagaService(deps)
+	order, err := svc.CreateOrder(sagaOrder, "")
+	if err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	if order.Status != New {
+		t.Fatalf("status = %s, want new while the saga waits for retry", order.Status)
+	}
+	saga := repo.sagas[order.ID]
+	if saga.State != SagaRunning || saga.Step != StepPriceLines || saga.Attempts != 1 {
+		t.Fatalf("saga = %s/%s attempts %d, want running/price_lines attempts 1", saga.State, saga.Step, saga.Attempts)
+	}
+
+	resumed, err := svc.ResumeSagas(time.Now())
+	if err != nil || resumed != 1 {
+		t.Fatalf("resume = %d, %v; want 1, nil", resumed, err)
+	}
+	// резерв уже есть, повторно не резервируем
+	assertCalls(t, deps.calls, "reserve", "price", "price", "pay")
+	if status := repo.orders[order.ID].Status; status != PendingPayment {
+		t.Errorf("status = %s, want pending_payment", status)
+	}
+}
+
+func TestSagaRecoverySkipsLeasedSaga(t *testing.T) {
+	deps := &sagaDeps{catalogErrs: []error{errors.New("connection refused")}}
+	svc, repo := newSagaService(deps)
+	order, err := svc.CreateOrder(sagaOrder, "")
+	if err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	if saga := repo.sagas[order.ID]; saga.LeaseID != nil {
+		t.Fatalf("lease after failed step = %v, want released", *saga.LeaseID)
+	}
+
+	// другой экземпляр взял сагу и еще держит аренду
+	other, until := uuid.New(), time.Now().Add(time.Minute)
+	saga := repo.sagas[order.ID]
+	saga.LeaseID, saga.LeasedUntil = &other, &until
+	repo.sagas[order.ID] = saga
+	if resumed, err := svc.ResumeSagas(time.Now()); err != nil || resumed != 0 {
+		t.Fatalf("resume leased = %d, %v; want 0, nil", resumed, err)
+	}
+
+	// исполнитель, у которого аренду перехватили, не может сохранить шаг
+	stale := saga
+	mine := uuid.New()
+	stale.LeaseID = &mine
+	if err := svc.advanceSaga(&stale); !errors.Is(err, errSagaLeaseLost) {
+		t.Fatalf("advance with lost lease: err = %v, want errSagaLeaseLost", err)
+	}
+	if got := repo.sagas[order.ID]; *got.LeaseID != other || got.Step != StepPriceLines {
+		t.Errorf("saga = %s lease %v, want price_lines leased by the other instance", got.Step, *got.LeaseID)
+	}
+}
+
+func TestSagaCompensatesAfterMaxAttempts(t *testing.T) {
+	unavailable := &StatusError{Code: http.StatusBadGateway}
+	deps := &sagaDeps{
+		paymentErrs: []error{unavailable, unavailable},
+		releaseErrs: []error{errors.New("connection refused")},
+	}
+	svc, repo := newSagaService(deps)
+	order, err := svc.CreateOrder(sagaOrder, "")
+	if err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+
+	// вторая неудача исчерпывает попытки, компенсация упирается в недоступный Inventory
+	if _, err = svc.ResumeSagas(time.Now()); err == nil {
+		t.Fatal("resume: want error from failed release")
+	}
+	saga := repo.sagas[order.ID]
+	if saga.State != SagaCompensating || saga.Step != StepReserveStock {
+		t.Fatalf("saga = %s/%s, want compensating/reserve_stock", saga.State, saga.Step)
+	}
+
+	// компенсация повторяется, пока не пройдет
+	if _, err = svc.ResumeSagas(time.Now()); err != nil {
+		t.Fatalf("resume compensation: %v", err)
+	}
+	assertCalls(t, deps.calls, "reserve", "price", "pay", "pay", "cancel payment", "release", "release")
+	if saga = repo.sagas[order.ID]; saga.State != SagaCompensated {
+		t.Errorf("saga state = %s, want compensated", saga.State)
+	}
+	if status := repo.orders[order.ID].Status; status != Canceled {
+		t.Errorf("order status = %s, want canceled", status)
+	}
+}
@@ -13,68 +13,317 @@ import (
 type Service struct {
 	repo      Repo
 	validator Validator
+	cfg       common.SagaConfig
+	invoicing common.InvoiceConfig
+	taxes     TaxCalculator
+	inventory Inventory
+	catalog   Catalog
+	cart      Cart
+	payments  Payments
+	callbacks CallbackVerifier
+	watchers  []Watcher
 }
 
 type Repo interface {
 	BeginTransaction() (tx *sqlx.Tx, err error)
-	FindItemById(tx *sqlx.Tx, productID uuid.UUID) (bool, error)
-	CreateOrder(tx *sqlx.Tx, req CreatOrderRequest) error
+	CreateOrder(tx *sqlx.Tx, order OrderRow) error
+	GetOrder(id uuid.UUID) (OrderRow, error)
+	UpdateOrderStatus(tx *sqlx.Tx, id uuid.UUID, status Status) error
+	UpdateOrderTotals(tx *sqlx.Tx, id uuid.UUID, totals OrderTotals) error
+	UpdateOrderPricing(tx *sqlx.Tx, id uuid.UUID, region string, mode TaxMode, totals OrderTotals) error
+	SetOrderPayment(tx *sqlx.Tx, id, paymentID uuid.UUID, paidAt time.Time) error
+	AddOrderItems(tx *sqlx.Tx, items []ItemRow) error
+	GetOrderItems(orderID uuid.UUID) ([]ItemRow, error)
+	UpdateOrderItem(tx *sqlx.Tx, item ItemRow) error
+	CancelOrderItems(tx *sqlx.Tx, orderID uuid.UUID, productIDs []uuid.UUID, at time.Time) error
+	AddOrderPromotions(tx *sqlx.Tx, orderID uuid.UUID, promos []AppliedPromotion) error
+	ReplaceOrderPromotions(tx *sqlx.Tx, orderID uuid.UUID, promos []AppliedPromotion) error
+	GetOrderPromotions(orderID uuid.UUID) ([]AppliedPromotion, error)
+	SaveIdempotencyKey(tx *sqlx.Tx, key IdempotencyKey) (bool, error)
+	GetIdempotencyKey(tx *sqlx.Tx, userID uuid.UUID, key string) (IdempotencyKey, error)
+	CompleteIdempotencyKey(key IdempotencyKey) error
+	CreateSaga(tx *sqlx.Tx, saga Saga) error
+	GetSaga(tx *sqlx.Tx, orderID uuid.UUID) (Saga, error)
+	SaveSaga(tx *sqlx.Tx, saga Saga) error
+	ClaimStaleSagas(staleBefore time.Time, leaseID uuid.UUID, leasedUntil time.Time, limit int) ([]Saga, error)
+	ReleaseSaga(orderID, leaseID uuid.UUID) error
+	GetOrderForUpdate(tx *sqlx.Tx, id uuid.UUID) (OrderRow, error)
+	SetCancellation(tx *sqlx.Tx, id uuid.UUID, key string, from Status) error
+	AddStatusChange(tx *sqlx.Tx, change StatusChange) error
+	GetStatusHistory(orderID uuid.UUID) ([]StatusChange, error)
 	GetStatus(user, order uuid.UUID) (StatusResponse, error)
-	UpdatePaymentStatus(req UpdatePaymentStatusRequest) error
+	GetUserOrders(filter OrdersFilter) ([]OrderSummary, error)
+	CreateReturn(tx *sqlx.Tx, ret Return) error
+	GetReturns(orderID uuid.UUID) ([]Return, error)
+	GetOrderReturns(tx *sqlx.Tx, orderID uuid.UUID) ([]Return, error)
+	GetReturnForUpdate(tx *sqlx.Tx, id uuid.UUID) (Return, error)
+	UpdateReturn(tx *sqlx.Tx, ret Return) error
+	MarkReturnLineRestocked(tx *sqlx.Tx, returnID, productID uuid.UUID, at time.Time) error
+	AddOutboxEvent(tx *sqlx.Tx, event OutboxEvent) error
+	CreateSubscription(sub Subscription) (Subscription, error)
+	GetSubscriptions() ([]Subscription, error)
+	DeleteSubscription(id uuid.UUID) (bool, error)
+	GetDelivery(id uuid.UUID) (Delivery, error)
+	GetDeadLetters(limit, offset int) ([]Delivery, error)
+	SaveDelivery(delivery Delivery) error
+	NextInvoiceNumber(tx *sqlx.Tx, kind InvoiceKind) (int64, error)
+	CreateInvoice(tx *sqlx.Tx, inv Invoice) error
+	GetOrderInvoice(tx *sqlx.Tx, orderID uuid.UUID) (Invoice, error)
+	GetInvoices(orderID uuid.UUID) ([]Invoice, error)
+	GetInvoice(id uuid.UUID) (Invoice, error)
 }
 
 type Validator interface {
 	Validate(request any) error
 }
 
-func NewService(repo Repo, validator Validator) *Service {
-	return &Service{repo, validator}
+type Inventory interface {
+	ReserveOrder(req ReserveOrderRequest) error
+	GetReservations(orderID uuid.UUID) ([]Reservation, error)
+	ReleaseOrder(orderID uuid.UUID) error
+	CommitOrder(orderID uuid.UUID) error
+	ReleaseLine(orderID, productID uuid.UUID) error
+	RecordMovement(req MovementRequest) error
 }
 
-func (s *Service) CreateOrder(req CreatOrderRequest) (OrderResponse, error) {
+type Catalog interface {
+	GetProduct(id uuid.UUID) (CatalogItem, error)
+}
+
+type Cart interface {
+	QuotePromotions(req PromotionQuoteRequest) (PromotionQuote, error)
+}
+
+type Payments interface {
+	CreatePayment(req PaymentRequest) (PaymentResponse, error)
+	CancelPayment(orderID uuid.UUID) error
+	Refund(orderID uuid.UUID, req RefundRequest) (Refund, error)
+}
+
+type CallbackVerifier interface {
+	Verify(req UpdatePaymentStatusRequest, now time.Time) error
+}
+
+func NewService(repo Repo, validator Validator, cfg common.SagaConfig, invoicing common.InvoiceConfig, taxes TaxCalculator,
+	inventory Inventory, catalog Catalog, cart Cart, payments Payments, callbacks CallbackVerifier, watchers ...Watcher) *Service {
+	return &Service{
+		repo:      repo,
+		validator: validator,
+		cfg:       cfg,
+		invoicing: invoicing,
+		taxes:     taxes,
+		inventory: inventory,
+		catalog:   catalog,
+		cart:      cart,
+		payments:  payments,
+		callbacks: callbacks,
+		watchers:  watchers,
+	}
+}
+
+// CreateOrder сохраняет заказ и проводит его через сагу оформления: резерв, цены, платеж.
+// Если шаг упал с временной ошибкой, заказ возвращается в статусе new, сагу доведет SagaRecovery.
+// С ключом идемпотентности повторный запрос не создает второй заказ, а получает ответ первого.
+func (s *Service) CreateOrder(req CreatOrderRequest, idempotencyKey string) (OrderResponse, error) {
 	if err := s.validator.Validate(req); err != nil {
 		return OrderResponse{}, &common.RequestValidationError{Message: err.Error()}
 	}
-	tx, err := s.repo.BeginTransaction()
-	if err != nil {
-		return OrderResponse{}, fmt.Errorf("order service: create order: error starting transaction")
+	if len(idempotencyKey) > maxIdempotencyKeyLen {
+		return OrderResponse{}, &common.RequestValidationError{
+			Message: fmt.Sprintf("idempotency key must not exceed %d characters", maxIdempotencyKeyLen)}
 	}
-	defer func() {
-		if p := recover(); p != nil {
-			_ = tx.Rollback()
-			err = fmt.Errorf("order service: create order: panic add product: %v", p)
-			return
+	now := time.Now()
+	order := OrderRow{
+		ID:        uuid.New(),
+		UserID:    req.UserID,
+		Region:    req.Region,
+		Status:    New,
+		CreatedAt: now,
+	}
+	lines := make(map[uuid.UUID]int, len(req.Items))
+	items := make([]ItemRow, 0, len(req.Items))
+	for _, it := range req.Items {
+		if i, ok := lines[it.ID]; ok {
+			items[i].Quantity += int64(it.Quantity)
+			continue
 		}
-		if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				err = fmt.Errorf("rollback failed: original error: %w", err)
+		lines[it.ID] = len(items)
+		items = append(items, ItemRow{ID: it.ID, OrderID: order.ID, Quantity: int64(it.Quantity)})
+	}
+	// скидки из запроса не принимаются на веру, их пересчитает Cart на шаге price_lines
+	promotions := make([]AppliedPromotion, 0, len(req.Promotions))
+	for _, p := range req.Promotions {
+		p.Discount = 0
+		promotions = append(promotions, p)
+	}
+	// сага сразу в аренде у этого запроса: SagaRecovery не возьмет ее, пока запрос ее выполняет
+	leaseID, leasedUntil := uuid.New(), now.Add(s.cfg.Lease)
+	saga := Saga{
+		OrderID:     order.ID,
+		Step:        StepReserveStock,
+		State:       SagaRunning,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		LeaseID:     &leaseID,
+		LeasedUntil: &leasedUntil,
+	}
+	key := IdempotencyKey{
+		UserID:      req.UserID,
+		Key:         idempotencyKey,
+		RequestHash: requestHash(req),
+		OrderID:     order.ID,
+		CreatedAt:   now,
+	}
+	var replay *IdempotencyKey
+	err := s.withTx("create order", func(tx *sqlx.Tx) error {
+		if idempotencyKey != "" {
+			var err error
+			if replay, err = s.claimIdempotencyKey(tx, key); err != nil || replay != nil {
+				return err
 			}
-			return
 		}
-		if commitErr := tx.Commit(); commitErr != nil {
-			err = fmt.Errorf("order service: create order: committing transaction failed: %w", commitErr)
+		if err := s.repo.CreateOrder(tx, order); err != nil {
+			return err
 		}
-	}()
-	order := OrderResponse{
-		ID:         uuid.New(),
-		UserId:     req.UserID,
-		Status:     New,
-		GrandTotal: 0,
-		Created:    time.Now(),
-		Items:      []ItemResponse{},
-	}
-	isExists, err := s.repo.FindItemById(tx, order.ID)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return OrderResponse{}, fmt.Errorf("order service: create order: error checking exists of order")
-	}
-	if isExists {
-		return OrderResponse{}, &common.AlreadyExistsError{Message: fmt.Sprintf("order with id %s already exists", order.ID)}
-	}
-	err = s.repo.CreateOrder(tx, req)
+		if err := s.repo.AddOrderItems(tx, items); err != nil {
+			return err
+		}
+		if len(promotions) > 0 {
+			if err := s.repo.AddOrderPromotions(tx, order.ID, promotions); err != nil {
+				return err
+			}
+		}
+		err := s.repo.AddStatusChange(tx, StatusChange{
+			ID:        uuid.New(),
+			OrderID:   order.ID,
+			To:        New,
+			Actor:     req.UserID.String(),
+			Reason:    "order created",
+			CreatedAt: now,
+		})
+		if err != nil {
+			return err
+		}
+		if err = s.publishOrderCreated(tx, order, items, promotions); err != nil {
+			return err
+		}
+		return s.repo.CreateSaga(tx, saga)
+	})
 	if err != nil {
-		return OrderResponse{}, fmt.Errorf("order service: create order: error adding order")
+		return OrderResponse{}, err
+	}
+	if replay != nil {
+		return s.replayOrder(*replay)
+	}
+	resp, err := s.runSaga(&saga)
+	if idempotencyKey != "" && saga.State != SagaRunning {
+		s.completeIdempotencyKey(key, resp, err)
 	}
-	return order, nil
+	return resp, err
+}
+
+// runSaga проводит новый заказ по саге и собирает ответ на создание
+func (s *Service) runSaga(saga *Saga) (OrderResponse, error) {
+	err := s.advanceSaga(saga)
+	if err != nil && saga.State == SagaRunning {
+		// временный сбой: заказ принят, оформление продолжится в фоне
+		return s.getOrder(saga.OrderID)
+	}
+	if saga.State == SagaCompensating || saga.State == SagaCompensated {
+		return OrderResponse{}, &common.ConflictError{Message: fmt.Sprintf("order %s canceled: %s", saga.OrderID, saga.FailReason)}
+	}
+	if err != nil {
+		return OrderResponse{}, err
+	}
+	return s.getOrder(saga.OrderID)
+}
+
+// GetOrder заказ со снапшотом строк, итогом и историей статусов
+func (s *Service) GetOrder(id uuid.UUID) (OrderResponse, error) {
+	if id == uuid.Nil {
+		return OrderResponse{}, &common.RequestValidationError{Message: "invalid order id"}
+	}
+	return s.getOrder(id)
+}
+
+// GetUserOrders заказы пользователя, новые первыми
+func (s *Service) GetUserOrders(filter OrdersFilter) (OrderListResponse, error) {
+	if filter.UserID == uuid.Nil {
+		return OrderListResponse{}, &common.RequestValidationError{Message: "invalid user id"}
+	}
+	if err := s.validator.Validate(filter); err != nil {
+		return OrderListResponse{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.To.Before(filter.From) {
+		return OrderListResponse{}, &common.RequestValidationError{Message: "to must not be before from"}
+	}
+	if filter.Limit <= 0 || filter.Limit > 100 {
+		filter.Limit = 20
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	limit := filter.Limit
+	filter.Limit++
+	orders, err := s.repo.GetUserOrders(filter)
+	if err != nil {
+		return OrderListResponse{}, fmt.Errorf("order service: get user orders: %w", err)
+	}
+	resp := OrderListResponse{Orders: orders}
+	if len(orders) > limit {
+		resp.Orders, resp.HasMore = orders[:limit], true
+	}
+	return resp, nil
+}
+
+func (s *Service) getOrder(id uuid.UUID) (OrderResponse, error) {
+	order, err := s.repo.GetOrder(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return OrderResponse{}, &common.NotFoundError{Message: fmt.Sprintf("order %s not found", id)}
+	}
+	if err != nil {
+		return OrderResponse{}, fmt.Errorf("order service: get order: %w", err)
+	}
+	items, err := s.repo.GetOrderItems(id)
+	if err != nil {
+		return OrderResponse{}, fmt.Errorf("order service: get order: %w", err)
+	}
+	promotions, err := s.repo.GetOrderPromotions(id)
+	if err != nil {
+		return OrderResponse{}, fmt.Errorf("order service: get order: %w", err)
+	}
+	history, err := s.repo.GetStatusHistory(id)
+	if err != nil {
+		return OrderResponse{}, fmt.Errorf("order service: get order: %w", err)
+	}
+	resp := OrderResponse{
+		ID:         order.ID,
+		UserId:     order.UserID,
+		Status:     order.Status,
+		Region:     order.Region,
+		TaxMode:    order.TaxMode,
+		NetTotal:   order.NetTotal,
+		TaxTotal:   order.TaxTotal,
+		GrandTotal: order.GrandTotal,
+		Created:    order.CreatedAt,
+		Items:      make([]ItemResponse, 0, len(items)),
+		Promotions: promotions,
+		History:    history,
+	}
+	for _, it := range items {
+		resp.Items = append(resp.Items, ItemResponse{
+			ID:         it.ID,
+			Name:       it.Name,
+			Quantity:   int(it.Quantity),
+			UnitPrice:  it.UnitPrice,
+			TaxRate:    it.TaxRate,
+			Net:        it.Net,
+			Tax:        it.Tax,
+			Gross:      it.Gross,
+			CanceledAt: it.CanceledAt,
+		})
+	}
+	return resp, nil
 }
 
 func (s *Service) GetStatus(user, order uuid.UUID) (StatusResponse, error) {
@@ -82,19 +331,38 @@ func (s *Service) GetStatus(user, order uuid.UUID) (StatusResponse, error) {
 		return StatusResponse{}, errors.New("order service: get status: invalid id")
 	}
 	status, err := s.repo.GetStatus(user, order)
+	if errors.Is(err, sql.ErrNoRows) {
+		return StatusResponse{}, &common.NotFoundError{Message: fmt.Sprintf("order %s not found", order)}
+	}
 	if err != nil {
 		return StatusResponse{}, fmt.Errorf("order service: get status: failed to get order status: %w", err)
 	}
 	return status, nil
 }
 
-func (s *Service) UpdatePaymentStatus(req UpdatePaymentStatusRequest) error {
-	if err := s.validator.Validate(req); err != nil {
-		return &common.RequestValidationError{Message: err.Error()}
-	}
-	err := s.repo.UpdatePaymentStatus(req)
+func (s *Service) withTx(op string, fn func(tx *sqlx.Tx) error) (err error) {
+	tx, err := s.repo.BeginTransaction()
 	if err != nil {
-		return fmt.Errorf("order service: update payment status: error update product")
+		return fmt.Errorf("order service: %s: error starting transaction", op)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			err = fmt.Errorf("order service: %s: panic: %v", op, p)
+			return
+		}
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				err = fmt.Errorf("rollback failed: original error: %w", err)
+			}
+			return
+		}
+		if commitErr := tx.Commit(); commitErr != nil {
+			err = fmt.Errorf("order service: %s: committing transaction failed: %w", op, commitErr)
+		}
+	}()
+	if err = fn(tx); err != nil {
+		return fmt.Errorf("order service: %s: %w", op, err)
 	}
 	return nil
 }
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/madrabit/mini-market/order/internal/common"
+	"strings"
+)
+
+// Налог считается по строкам заказа: ставка зависит от налоговой категории товара и региона
+// доставки. Суммы в копейках, налог строки округляется до копейки половиной вверх, итоги заказа -
+// суммы по строкам, поэтому net + tax = gross сходится и в строке, и в заказе.
+// TaxCalculator можно заменить внешним провайдером, по умолчанию ставки берутся из order_tax_rates.
+
+// defaultTaxClass категория товаров, для которых каталог ее не вернул
+const defaultTaxClass = "standard"
+
+// anyRegion ставка для всех регионов, если для региона доставки своей нет
+const anyRegion = "*"
+
+type TaxCalculator interface {
+	Calculate(req TaxRequest) (TaxResult, error)
+}
+
+type TaxRates interface {
+	GetTaxRates(regions []string) ([]TaxRate, error)
+}
+
+// TableTaxCalculator считает налог по таблице ставок
+type TableTaxCalculator struct {
+	rates         TaxRates
+	mode          TaxMode
+	defaultRegion string
+}
+
+func NewTableTaxCalculator(rates TaxRates, cfg common.TaxConfig) *TableTaxCalculator {
+	return &TableTaxCalculator{rates: rates, mode: TaxMode(cfg.Mode), defaultRegion: strings.ToUpper(cfg.DefaultRegion)}
+}
+
+func (c *TableTaxCalculator) Calculate(req TaxRequest) (TaxResult, error) {
+	if c.mode != TaxInclusive && c.mode != TaxExclusive {
+		return TaxResult{}, fmt.Errorf("tax calculator: unknown tax mode %q", c.mode)
+	}
+	region := strings.ToUpper(strings.TrimSpace(req.Region))
+	if region == "" {
+		region = c.defaultRegion
+	}
+	regions := regionChain(region)
+	rates, err := c.rates.GetTaxRates(regions)
+	if err != nil {
+		return TaxResult{}, fmt.Errorf("tax calculator: %w", err)
+	}
+	byClass := make(map[string]map[string]int)
+	for _, r := range rates {
+		if byClass[r.TaxClass] == nil {
+			byClass[r.TaxClass] = make(map[string]int)
+		}
+		byClass[r.TaxClass][r.Region] = r.Rate
+	}
+	result := TaxResult{Region: region, Mode: c.mode, Lines: make([]TaxedLine, 0, len(req.Lines))}
+	for _, l := range req.Lines {
+		rate, ok := lookupRate(byClass[l.TaxClass], regions)
+		if !ok {
+			return TaxResult{}, fmt.Errorf("tax calculator: no tax rate for class %s in region %s", l.TaxClass, region)
+		}
+		line := TaxedLine{ProductID: l.ProductID, Rate: rate}
+		if c.mode == TaxInclusive {
+			line.Gross, line.Tax = l.Amount, inclusiveTax(l.Amount, rate)
+			line.Net = line.Gross - line.Tax
+		} else {
+			line.Net, line.Tax = l.Amount, exclusiveTax(l.Amount, rate)
+			line.Gross = line.Net + line.Tax
+		}
+		result.Lines = append(result.Lines, line)
+	}
+	return result, nil
+}
+
+// regionChain регионы от точного к общему: RU-MOW -> RU -> *
+func regionChain(region string) []string {
+	chain := []string{region}
+	if country, _, ok := strings.Cut(region, "-"); ok && country != "" {
+		chain = append(chain, country)
+	}
+	if region != anyRegion {
+		chain = append(chain, anyRegion)
+	}
+	return chain
+}
+
+func lookupRate(rates map[string]int, regions []string) (int, bool) {
+	for _, region := range regions {
+		if rate, ok := rates[region]; ok {
+			return rate, true
+		}
+	}
+	return 0, false
+}
+
+// inclusiveTax налог, включенный в gross, при ставке rate в сотых долях процента, с округлением до копейки
+func inclusiveTax(gross int64, rate int) int64 {
+	d := int64(10000 + rate)
+	return (2*gross*int64(rate) + d) / (2 * d)
+}
+
+// exclusiveTax налог сверху net при ставке rate в сотых долях процента, с округлением до копейки
+func exclusiveTax(net int64, rate int) int64 {
+	return (net*int64(rate) + 5000) / 10000
+}
+
+// orderTotals итоги заказа по неотмененным строкам
+func orderTotals(items []ItemRow) OrderTotals {
+	var totals OrderTotals
+	for _, it := range items {
+		if it.CanceledAt == nil {
+			totals.Net += it.Net
+			totals.Tax += it.Tax
+			totals.Gross += it.Gross
+		}
+	}
+	return totals
+}
@@ -0,0 +1,49 @@
+package internal
+
+import "testing"
+
+func TestInclusiveTax(t *testing.T) {
+	tests := []struct {
+		name  string
+		gross int64
+		rate  int
+		want  int64
+	}{
+		{name: "exact", gross: 12000, rate: 2000, want: 2000},
+		{name: "rounds up", gross: 100, rate: 2000, want: 17},
+		{name: "rounds down", gross: 1000, rate: 1000, want: 91},
+		{name: "half rounds up", gross: 3, rate: 2000, want: 1},
+		{name: "zero rate", gross: 999, rate: 0, want: 0},
+		{name: "zero gross", gross: 0, rate: 2000, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inclusiveTax(tt.gross, tt.rate); got != tt.want {
+				t.Errorf("inclusiveTax(%d, %d) = %d, want %d", tt.gross, tt.rate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExclusiveTax(t *testing.T) {
+	tests := []struct {
+		name string
+		net  int64
+		rate int
+		want int64
+	}{
+		{name: "exact", net: 1000, rate: 2000, want: 200},
+		{name: "rounds up", net: 333, rate: 1800, want: 60},
+		{name: "rounds down", net: 4, rate: 1000, want: 0},
+		{name: "half rounds up", net: 5, rate: 1000, want: 1},
+		{name: "zero rate", net: 999, rate: 0, want: 0},
+		{name: "zero net", net: 0, rate: 2000, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exclusiveTax(tt.net, tt.rate); got != tt.want {
+				t.Errorf("exclusiveTax(%d, %d) = %d, want %d", tt.net, tt.rate, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Моноширинный шрифт DejaVu Sans Mono (лицензия Bitstream Vera, см. fonts/LICENSE-DejaVu.txt)
+// встраивается в PDF целиком, из файла читаются только метрики и таблица cmap.
+
+//go:embed fonts/DejaVuSansMono.ttf
+var invoiceFontData []byte
+
+const invoiceFontName = "DejaVuSansMono"
+
+// trueTypeFont метрики TrueType-шрифта в единицах PDF (1/1000 кегля)
+type trueTypeFont struct {
+	data       []byte
+	unitsPerEm int
+	bbox       [4]int
+	ascent     int
+	descent    int
+	advances   []int           // ширина по номеру глифа
+	glyphs     map[rune]uint16 // символ -> номер глифа, только BMP
+}
+
+var invoiceFont = mustParseTrueType(invoiceFontData)
+
+func mustParseTrueType(data []byte) *trueTypeFont {
+	f, err := parseTrueType(data)
+	if err != nil {
+		panic(fmt.Sprintf("invoice font: %v", err))
+	}
+	return f
+}
+
+func parseTrueType(data []byte) (*trueTypeFont, error) {
+	tables, err := ttfTables(data)
+	if err != nil {
+		return nil, err
+	}
+	head, hhea, hmtx, cmap := tables["head"], tables["hhea"], tables["hmtx"], tables["cmap"]
+	if len(head) < 54 || len(hhea) < 36 || hmtx == nil || cmap == nil {
+		return nil, errors.New("missing required tables")
+	}
+	f := &trueTypeFont{data: data, unitsPerEm: int(binary.BigEndian.Uint16(head[18:]))}
+	if f.unitsPerEm == 0 {
+		return nil, errors.New("invalid unitsPerEm")
+	}
+	for i := range f.bbox {
+		f.bbox[i] = f.scale(int(int16(binary.BigEndian.Uint16(head[36+2*i:]))))
+	}
+	f.ascent = f.scale(int(int16(binary.BigEndian.Uint16(hhea[4:]))))
+	f.descent = f.scale(int(int16(binary.BigEndian.Uint16(hhea[6:]))))
+	metrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	if len(hmtx) < 4*metrics {
+		return nil, errors.New("truncated hmtx")
+	}
+	f.advances = make([]int, metrics)
+	for i := range f.advances {
+		f.advances[i] = f.scale(int(binary.BigEndian.Uint16(hmtx[4*i:])))
+	}
+	if f.glyphs, err = ttfCmap(cmap); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *trueTypeFont) scale(v int) int {
+	return v * 1000 / f.unitsPerEm
+}
+
+// glyph номер глифа для символа, 0 (.notdef) - если символа в шрифте нет
+func (f *trueTypeFont) glyph(r rune) uint16 {
+	return f.glyphs[r]
+}
+
+// advance ширина глифа. Глифы за пределами hmtx берут ширину последней записи.
+func (f *trueTypeFont) advance(gid uint16) int {
+	if int(gid) < len(f.advances) {
+		return f.advances[gid]
+	}
+	return f.advances[len(f.advances)-1]
+}
+
+func ttfTables(data []byte) (map[string][]byte, error) {
+	if len(data) < 12 {
+		return nil, errors.New("truncated font")
+	}
+	n := int(binary.BigEndian.Uint16(data[4:]))
+	if len(data) < 12+16*n {
+		return nil, errors.New("truncated table directory")
+	}
+	tables := make(map[string][]byte, n)
+	for i := 0; i < n; i++ {
+		rec := data[12+16*i:]
+		offset, length := binary.BigEndian.Uint32(rec[8:]), binary.BigEndian.Uint32(rec[12:])
+		if uint64(offset)+uint64(length) > uint64(len(data)) {
+			return nil, fmt.Errorf("table %s out of range", rec[:4])
+		}
+		tables[string(rec[:4])] = data[offset : offset+length]
+	}
+	return tables, nil
+}
+
+// ttfCmap читает подтаблицу Unicode BMP (платформа 3, кодировка 1, формат 4)
+func ttfCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, errors.New("truncated cmap")
+	}
+	n := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < n && 4+8*i+8 <= len(cmap); i++ {
+		rec := cmap[4+8*i:]
+		platform, encoding := binary.BigEndian.Uint16(rec), binary.BigEndian.Uint16(rec[2:])
+		offset := int(binary.BigEndian.Uint32(rec[4:]))
+		if platform != 3 || encoding != 1 || offset+4 > len(cmap) {
+			continue
+		}
+		if binary.BigEndian.Uint16(cmap[offset:]) == 4 {
+			return ttfCmapFormat4(cmap[offset:])
+		}
+	}
+	return nil, errors.New("no unicode bmp cmap")
+}
+
+func ttfCmapFormat4(t []byte) (map[rune]uint16, error) {
+	if len(t) < 14 {
+		return nil, errors.New("truncated cmap format 4")
+	}
+	segs := int(binary.BigEndian.Uint16(t[6:])) / 2
+	ends, starts := 14, 16+2*segs
+	deltas, ranges := starts+2*segs, starts+4*segs
+	if len(t) < ranges+2*segs {
+		return nil, errors.New("truncated cmap format 4")
+	}
+	u16 := func(off int) uint16 { return binary.BigEndian.Uint16(t[off:]) }
+	glyphs := make(map[rune]uint16)
+	for s := 0; s < segs; s++ {
+		end, start := u16(ends+2*s), u16(starts+2*s)
+		delta, rangeOffset := u16(deltas+2*s), u16(ranges+2*s)
+		for c := uint32(start); c <= uint32(end) && c != 0xffff; c++ {
+			var gid uint16
+			if rangeOffset == 0 {
+				gid = uint16(c) + delta
+			} else {
+				off := ranges + 2*s + int(rangeOffset) + 2*int(c-uint32(start))
+				if off+2 > len(t) {
+					continue
+				}
+				if gid = u16(off); gid != 0 {
+					gid += delta
+				}
+			}
+			if gid != 0 {
+				glyphs[rune(c)] = gid
+			}
+		}
+	}
+	return glyphs, nil
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS order_promotions
+(
+    order_id     UUID        NOT NULL,
+    promotion_id UUID        NOT NULL,
+    code         VARCHAR(32) NOT NULL,
+    type         VARCHAR(20) NOT NULL,
+    discount     BIGINT      NOT NULL DEFAULT 0,
+    created_at   TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (order_id, promotion_id)
+);
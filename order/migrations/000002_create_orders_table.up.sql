@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS orders
+(
+    id          UUID PRIMARY KEY,
+    user_id     UUID        NOT NULL,
+    status      VARCHAR(20) NOT NULL,
+    grand_total BIGINT      NOT NULL DEFAULT 0,
+    created_at  TIMESTAMP DEFAULT NOW(),
+    updated_at  TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders (user_id, created_at);
+
+-- name и unit_price снапшот из каталога, заполняются шагом саги price_lines
+CREATE TABLE IF NOT EXISTS order_items
+(
+    order_id   UUID         NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    id         UUID         NOT NULL,
+    name       VARCHAR(255) NOT NULL DEFAULT '',
+    quantity   BIGINT       NOT NULL CHECK (quantity > 0),
+    unit_price BIGINT       NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (order_id, id)
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS order_sagas
+(
+    order_id    UUID PRIMARY KEY REFERENCES orders (id) ON DELETE CASCADE,
+    step        VARCHAR(20) NOT NULL,
+    state       VARCHAR(20) NOT NULL,
+    payment_id  UUID,
+    attempts    INT         NOT NULL DEFAULT 0,
+    last_error  TEXT        NOT NULL DEFAULT '',
+    fail_reason TEXT        NOT NULL DEFAULT '',
+    created_at  TIMESTAMP DEFAULT NOW(),
+    updated_at  TIMESTAMP DEFAULT NOW()
+);
+
+-- SagaRecovery ищет только незавершенные саги
+CREATE INDEX IF NOT EXISTS idx_order_sagas_pending ON order_sagas (updated_at)
+    WHERE state IN ('running', 'compensating');
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS order_status_history
+(
+    id          UUID PRIMARY KEY,
+    order_id    UUID         NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    from_status VARCHAR(20)  NOT NULL DEFAULT '', -- пусто для первой записи при создании заказа
+    to_status   VARCHAR(20)  NOT NULL,
+    actor       VARCHAR(64)  NOT NULL,
+    reason      VARCHAR(255) NOT NULL DEFAULT '',
+    created_at  TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order_id ON order_status_history (order_id, created_at);
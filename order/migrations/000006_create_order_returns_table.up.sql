@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS order_returns
+(
+    id            UUID PRIMARY KEY,
+    order_id      UUID         NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    user_id       UUID         NOT NULL,
+    status        VARCHAR(20)  NOT NULL,
+    refund_amount BIGINT       NOT NULL DEFAULT 0,
+    note          VARCHAR(255) NOT NULL DEFAULT '',
+    updated_by    VARCHAR(64)  NOT NULL,
+    created_at    TIMESTAMP    NOT NULL DEFAULT NOW(),
+    updated_at    TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_returns_order_id ON order_returns (order_id);
+
+-- restocked_at ставится, когда товар проведен возвратом в журнале склада
+CREATE TABLE IF NOT EXISTS order_return_lines
+(
+    return_id    UUID         NOT NULL REFERENCES order_returns (id) ON DELETE CASCADE,
+    product_id   UUID         NOT NULL,
+    qty          BIGINT       NOT NULL CHECK (qty > 0),
+    reason       VARCHAR(255) NOT NULL,
+    restocked_at TIMESTAMP,
+    PRIMARY KEY (return_id, product_id)
+);
@@ -0,0 +1,15 @@
+-- Ключи идемпотентности создания заказа. Ключ действует в пределах пользователя,
+-- response заполняется, когда оформление дошло до итогового ответа. Ключ пишется
+-- раньше заказа, чтобы параллельный повтор ждал на нем, поэтому проверка FK отложена до коммита.
+CREATE TABLE IF NOT EXISTS order_idempotency_keys
+(
+    user_id         UUID         NOT NULL,
+    idempotency_key VARCHAR(100) NOT NULL,
+    request_hash    CHAR(64)     NOT NULL,
+    order_id        UUID         NOT NULL REFERENCES orders (id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+    response        JSONB,
+    error           TEXT         NOT NULL DEFAULT '',
+    created_at      TIMESTAMP    NOT NULL DEFAULT NOW(),
+    completed_at    TIMESTAMP,
+    PRIMARY KEY (user_id, idempotency_key)
+);
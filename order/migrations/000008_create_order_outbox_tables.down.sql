@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS order_event_deliveries;
+DROP TABLE IF EXISTS order_event_subscriptions;
+DROP TABLE IF EXISTS order_outbox;
@@ -0,0 +1,45 @@
+-- События заказа пишутся в outbox в одной транзакции с изменением заказа.
+-- dispatched_at ставится, когда по событию созданы доставки всем подписчикам.
+CREATE TABLE IF NOT EXISTS order_outbox
+(
+    id            UUID PRIMARY KEY,
+    type          VARCHAR(50) NOT NULL,
+    version       INT         NOT NULL,
+    order_id      UUID        NOT NULL,
+    payload       JSONB       NOT NULL,
+    created_at    TIMESTAMP   NOT NULL DEFAULT NOW(),
+    dispatched_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_outbox_pending ON order_outbox (created_at)
+    WHERE dispatched_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS order_event_subscriptions
+(
+    id         UUID PRIMARY KEY,
+    event_type VARCHAR(50)  NOT NULL,
+    url        VARCHAR(500) NOT NULL,
+    created_at TIMESTAMP    NOT NULL DEFAULT NOW(),
+    UNIQUE (event_type, url)
+);
+
+-- Доставка события одному подписчику. state = dead - dead letter: попытки кончились
+-- или подписчик ответил 4xx, доставку можно повторить вручную.
+CREATE TABLE IF NOT EXISTS order_event_deliveries
+(
+    id              UUID PRIMARY KEY,
+    event_id        UUID        NOT NULL REFERENCES order_outbox (id) ON DELETE CASCADE,
+    subscription_id UUID        NOT NULL REFERENCES order_event_subscriptions (id) ON DELETE CASCADE,
+    state           VARCHAR(20) NOT NULL,
+    attempts        INT         NOT NULL DEFAULT 0,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP   NOT NULL DEFAULT NOW(),
+    delivered_at    TIMESTAMP,
+    updated_at      TIMESTAMP   NOT NULL DEFAULT NOW(),
+    UNIQUE (event_id, subscription_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_event_deliveries_pending ON order_event_deliveries (next_attempt_at)
+    WHERE state = 'pending';
+CREATE INDEX IF NOT EXISTS idx_order_event_deliveries_dead ON order_event_deliveries (updated_at)
+    WHERE state = 'dead';
@@ -0,0 +1,3 @@
+-- платеж, которым оплачен заказ: повторный callback по нему ничего не меняет
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_id UUID;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS paid_at TIMESTAMP;
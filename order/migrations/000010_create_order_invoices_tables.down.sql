@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS order_invoice_lines;
+DROP TABLE IF EXISTS order_invoices;
+DROP TABLE IF EXISTS order_invoice_counters;
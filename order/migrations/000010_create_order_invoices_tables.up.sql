@@ -0,0 +1,50 @@
+-- Счетчики номеров: строка блокируется в транзакции выпуска документа,
+-- поэтому номера идут подряд без пропусков, в отличие от SEQUENCE.
+CREATE TABLE IF NOT EXISTS order_invoice_counters
+(
+    kind        VARCHAR(20) PRIMARY KEY,
+    last_number BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO order_invoice_counters (kind, last_number)
+VALUES ('invoice', 0),
+       ('credit_note', 0)
+ON CONFLICT (kind) DO NOTHING;
+
+-- Счет выставляется при оплате, кредит-ноты - на каждый возврат денег по оплаченному заказу.
+-- Суммы в минимальных единицах валюты, gross включает налог.
+CREATE TABLE IF NOT EXISTS order_invoices
+(
+    id         UUID PRIMARY KEY,
+    number     VARCHAR(20)  NOT NULL UNIQUE,
+    kind       VARCHAR(20)  NOT NULL,
+    order_id   UUID         NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    user_id    UUID         NOT NULL,
+    invoice_id UUID REFERENCES order_invoices (id),
+    reason     VARCHAR(255) NOT NULL DEFAULT '',
+    currency   CHAR(3)      NOT NULL,
+    net        BIGINT       NOT NULL,
+    tax        BIGINT       NOT NULL,
+    gross      BIGINT       NOT NULL,
+    issued_at  TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_invoices_order_id ON order_invoices (order_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_order_invoices_one_per_order ON order_invoices (order_id)
+    WHERE kind = 'invoice';
+
+-- tax_rate в сотых долях процента: 2000 = 20%
+CREATE TABLE IF NOT EXISTS order_invoice_lines
+(
+    invoice_id UUID         NOT NULL REFERENCES order_invoices (id) ON DELETE CASCADE,
+    line_no    INT          NOT NULL,
+    product_id UUID         NOT NULL,
+    name       VARCHAR(255) NOT NULL,
+    qty        BIGINT       NOT NULL,
+    unit_price BIGINT       NOT NULL,
+    tax_rate   INT          NOT NULL,
+    net        BIGINT       NOT NULL,
+    tax        BIGINT       NOT NULL,
+    gross      BIGINT       NOT NULL,
+    PRIMARY KEY (invoice_id, line_no)
+);
@@ -0,0 +1,12 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS gross;
+ALTER TABLE order_items DROP COLUMN IF EXISTS tax;
+ALTER TABLE order_items DROP COLUMN IF EXISTS net;
+ALTER TABLE order_items DROP COLUMN IF EXISTS tax_rate;
+ALTER TABLE order_items DROP COLUMN IF EXISTS tax_class;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_total;
+ALTER TABLE orders DROP COLUMN IF EXISTS net_total;
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_mode;
+ALTER TABLE orders DROP COLUMN IF EXISTS region;
+
+DROP TABLE IF EXISTS order_tax_rates;
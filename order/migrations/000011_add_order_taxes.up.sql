@@ -0,0 +1,88 @@
+-- Ставки налога по налоговой категории товара и региону доставки, в сотых долях процента.
+-- Регион ищется от точного к общему: RU-MOW, затем RU, затем '*'.
+CREATE TABLE IF NOT EXISTS order_tax_rates
+(
+    tax_class VARCHAR(30) NOT NULL,
+    region    VARCHAR(10) NOT NULL,
+    rate      INT         NOT NULL CHECK (rate >= 0),
+    PRIMARY KEY (tax_class, region)
+);
+
+INSERT INTO order_tax_rates (tax_class, region, rate)
+VALUES ('standard', '*', 2000),
+       ('reduced', '*', 1000),
+       ('zero', '*', 0)
+ON CONFLICT (tax_class, region) DO NOTHING;
+
+-- Снапшот налогов: режим и регион, по которым считался заказ, итоги без налога и налог.
+-- grand_total остается суммой к оплате (gross).
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS region VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_mode VARCHAR(10) NOT NULL DEFAULT 'inclusive';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS net_total BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_total BIGINT NOT NULL DEFAULT 0;
+
+-- net/tax/gross строки - после распределения скидок заказа
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_class VARCHAR(30) NOT NULL DEFAULT 'standard';
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_rate INT NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS net BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS gross BIGINT NOT NULL DEFAULT 0;
+
+-- Заказы, оцененные до налогового модуля, считались по единой ставке 20% с налогом в цене.
+-- gross строк получается так же, как в allocate: grand_total (уже со скидками промо) делится между
+-- неотмененными строками пропорционально unit_price * quantity, остаток копеек уходит строкам
+-- с наибольшим дробным остатком. Отмененные строки получают gross по той же доле скидки;
+-- если отменены все строки, grand_total делится между всеми.
+WITH lines AS (SELECT i.order_id,
+                      i.id,
+                      i.created_at,
+                      i.unit_price * i.quantity                     AS weight,
+                      o.grand_total                                 AS total,
+                      i.canceled_at IS NULL
+                          OR NOT EXISTS (SELECT 1
+                                         FROM order_items a
+                                         WHERE a.order_id = i.order_id
+                                           AND a.canceled_at IS NULL) AS allocated
+               FROM order_items i
+                        JOIN orders o ON o.id = i.order_id
+               WHERE i.gross = 0
+                 AND i.unit_price > 0
+                 AND o.grand_total > 0),
+     shares AS (SELECT l.*,
+                       (SUM(weight) FILTER (WHERE allocated) OVER (PARTITION BY order_id))::BIGINT AS base
+                FROM lines l),
+     parts AS (SELECT s.*,
+                      total * weight / base AS part,
+                      total * weight % base AS remainder
+               FROM shares s
+               WHERE allocated),
+     ranked AS (SELECT p.*,
+                       total - SUM(part) OVER (PARTITION BY order_id) AS leftover,
+                       ROW_NUMBER() OVER (PARTITION BY order_id ORDER BY remainder DESC, created_at, id) AS pos
+                FROM parts p),
+     gross AS (SELECT order_id, id, part + CASE WHEN pos <= leftover THEN 1 ELSE 0 END AS gross
+               FROM ranked
+               UNION ALL
+               SELECT order_id, id, ROUND(weight::NUMERIC * total / base)::BIGINT AS gross
+               FROM shares
+               WHERE NOT allocated
+                 AND base > 0)
+UPDATE order_items i
+SET tax_rate = 2000,
+    gross    = g.gross,
+    tax      = ROUND(g.gross * 2000.0 / 12000),
+    net      = g.gross - ROUND(g.gross * 2000.0 / 12000)
+FROM gross g
+WHERE i.order_id = g.order_id
+  AND i.id = g.id;
+
+-- итоги заказа - сумма неотмененных строк, как в orderTotals
+UPDATE orders o
+SET tax_total = t.tax,
+    net_total = o.grand_total - t.tax
+FROM (SELECT order_id, COALESCE(SUM(tax) FILTER (WHERE canceled_at IS NULL), 0) AS tax
+      FROM order_items
+      GROUP BY order_id) t
+WHERE t.order_id = o.id
+  AND o.tax_total = 0
+  AND o.grand_total > 0;
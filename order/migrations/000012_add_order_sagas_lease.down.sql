@@ -0,0 +1,2 @@
+ALTER TABLE order_sagas DROP COLUMN IF EXISTS leased_until;
+ALTER TABLE order_sagas DROP COLUMN IF EXISTS lease_id;
@@ -0,0 +1,4 @@
+-- Аренда саги: кто ее держит, тот и выполняет шаги. CreateOrder берет аренду при создании,
+-- SagaRecovery - при захвате, поэтому одну сагу не двигают два исполнителя сразу.
+ALTER TABLE order_sagas ADD COLUMN IF NOT EXISTS lease_id UUID;
+ALTER TABLE order_sagas ADD COLUMN IF NOT EXISTS leased_until TIMESTAMP;
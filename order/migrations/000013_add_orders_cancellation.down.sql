@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS cancel_from;
+ALTER TABLE orders DROP COLUMN IF EXISTS cancel_key;
@@ -0,0 +1,4 @@
+-- отмена в процессе: cancel_key - какие строки отменяются, cancel_from - статус до отмены,
+-- в него заказ возвращается после отмены части строк
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS cancel_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS cancel_from VARCHAR(20) NOT NULL DEFAULT '';
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/madrabit/mini-market/payment/internal/common"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OrderClient сообщает Order об оплате заказа. Callback подписывается общим ключом:
+// HMAC-SHA256 в hex от строки "<timestamp>.<order_id>.<user_id>.<payment_id>.<amount>.<currency>",
+// timestamp - unix-время в секундах. Подпись и время уходят в заголовках
+// X-Payment-Signature и X-Payment-Timestamp.
+type OrderClient struct {
+	baseURL string
+	secret  []byte
+	client  *http.Client
+}
+
+func NewOrderClient(cfg common.OrderConfig) *OrderClient {
+	return &OrderClient{baseURL: cfg.URL, secret: []byte(cfg.CallbackSecret), client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// PaymentCallback тело callback об оплате, как его ждет Order
+type PaymentCallback struct {
+	PaymentID uuid.UUID `json:"payment_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Amount    int64     `json:"amount"`
+	Currency  string    `json:"currency"`
+}
+
+func (c *OrderClient) sign(timestamp string, orderID uuid.UUID, cb PaymentCallback) string {
+	mac := hmac.New(sha256.New, c.secret)
+	_, _ = fmt.Fprintf(mac, "%s.%s.%s.%s.%d.%s", timestamp, orderID, cb.UserID, cb.PaymentID, cb.Amount, cb.Currency)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PaymentCaptured отправляет подписанный callback о списании денег по заказу.
+// Order принимает повтор того же платежа, поэтому callback можно слать повторно.
+func (c *OrderClient) PaymentCaptured(payment Payment) error {
+	cb := PaymentCallback{
+		PaymentID: payment.ID,
+		UserID:    payment.UserID,
+		Amount:    payment.Amount,
+		Currency:  payment.Currency,
+	}
+	payload, err := json.Marshal(cb)
+	if err != nil {
+		return fmt.Errorf("order client: payment captured: %w", err)
+	}
+	url := c.baseURL + "/api/v1/orders/" + payment.OrderID.String() + "/payment-status"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("order client: payment captured: %w", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Payment-Timestamp", timestamp)
+	req.Header.Set("X-Payment-Signature", c.sign(timestamp, payment.OrderID, cb))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("order client: payment captured: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("order client: payment captured: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"fmt"
 	"github.com/kelseyhightower/envconfig"
 	"os"
 	"strings"
@@ -9,6 +10,8 @@ import (
 type Config struct {
 	DB             DBConfig
 	Server         ServerConfig
+	Order          OrderConfig
+	PSP            PSPConfig
 	LogLevel       string
 	LogDevelopMode bool
 	AllowedOrigins []string
@@ -22,6 +25,17 @@ type DBConfig struct {
 	Database string `envconfig:"DATABASE" required:"true"`
 }
 
+// OrderConfig куда слать callback об оплате и общий с Order ключ подписи
+type OrderConfig struct {
+	URL            string `envconfig:"URL" required:"true"`
+	CallbackSecret string `envconfig:"CALLBACK_SECRET" required:"true"`
+}
+
+// PSPConfig ключ, которым провайдер подписывает вебхуки
+type PSPConfig struct {
+	WebhookSecret string `envconfig:"WEBHOOK_SECRET" required:"true"`
+}
+
 type ServerConfig struct {
 	Address string `envconfig:"ADDRESS" required:"true"`
 	Port    string `envconfig:"PORT" required:"true"`
@@ -43,6 +57,16 @@ func Load() (Config, error) {
 	} else {
 		cfg.Server = server
 	}
+	if order, err := LoadOrderConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.Order = order
+	}
+	if psp, err := LoadPSPConfig(); err != nil {
+		return Config{}, err
+	} else {
+		cfg.PSP = psp
+	}
 	return cfg, nil
 }
 
@@ -55,6 +79,24 @@ func LoadDbConfig() (DBConfig, error) {
 	return cfg, nil
 }
 
+func LoadOrderConfig() (OrderConfig, error) {
+	var cfg OrderConfig
+	err := envconfig.Process("ORDER", &cfg)
+	if err != nil {
+		return OrderConfig{}, err
+	}
+	return cfg, nil
+}
+
+func LoadPSPConfig() (PSPConfig, error) {
+	var cfg PSPConfig
+	err := envconfig.Process("PSP", &cfg)
+	if err != nil {
+		return PSPConfig{}, err
+	}
+	return cfg, nil
+}
+
 func LoadServerConfig() (ServerConfig, error) {
 	var cfg ServerConfig
 	err := envconfig.Process("SERVER", &cfg)
@@ -63,3 +105,10 @@ func LoadServerConfig() (ServerConfig, error) {
 	}
 	return cfg, nil
 }
+
+func (db DBConfig) DSN() string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		db.Server, db.Port, db.User, db.Pass, db.Database,
+	)
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/madrabit/mini-market/payment/internal/common"
@@ -102,6 +103,8 @@ type Svc interface {
 	CreateOrder(req PaymentRequest) (CreatePaymentResponse, error)
 	PSPWebhook(req PSPWebhookRequest) (PaymentStatusResponse, error)
 	GetStatus(userID, orderID uuid.UUID) (PaymentStatusResponse, error)
+	CancelPayment(orderID uuid.UUID) error
+	Refund(orderID uuid.UUID, req RefundRequest) (Refund, error)
 }
 
 func (c *Controller) Routes() chi.Router {
@@ -112,6 +115,10 @@ func (c *Controller) Routes() chi.Router {
 	r.Post("/payment", c.PSPWebhook)
 	// Получить статус оплаты
 	r.Get("{/orderID}", c.GetStatus)
+	// отменить платеж по заказу (компенсация саги оформления в Order)
+	r.Post("/{orderID}/cancel", c.CancelPayment)
+	// вернуть деньги по заказу: void до списания, refund после
+	r.Post("/{orderID}/refunds", c.Refund)
 	return r
 }
 
@@ -132,7 +139,7 @@ func (c *Controller) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	resp, err := c.svc.CreateOrder(req)
 	if err != nil {
 		c.logger.Error("failed to create order", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
 		return
 	}
 	common.OkResponse(w, resp)
@@ -155,7 +162,7 @@ func (c *Controller) PSPWebhook(w http.ResponseWriter, r *http.Request) {
 	status, err := c.svc.PSPWebhook(req)
 	if err != nil {
 		c.logger.Error("failed to process webhook", zap.Error(err))
-		common.ErrResponse(w, http.StatusBadRequest, error.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -180,3 +187,69 @@ func (c *Controller) GetStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	common.OkResponse(w, status)
 }
+
+func (c *Controller) CancelPayment(w http.ResponseWriter, r *http.Request) {
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	err = c.svc.CancelPayment(orderID)
+	if err != nil {
+		c.logger.Error("failed to cancel payment", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) Refund(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to close body", zap.Error(err))
+		}
+	}()
+	orderID, err := uuid.Parse(chi.URLParam(r, "orderID"))
+	if err != nil || orderID == uuid.Nil {
+		c.logger.Warn("invalid param")
+		common.ErrResponse(w, http.StatusBadRequest, "invalid param")
+		return
+	}
+	var req RefundRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.logger.Error("failed to refund", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	refund, err := c.svc.Refund(orderID, req)
+	if err != nil {
+		c.logger.Error("failed to refund", zap.Error(err))
+		common.ErrResponse(w, errStatus(err), error.Error(err))
+		return
+	}
+	common.OkResponse(w, refund)
+}
+
+func errStatus(err error) int {
+	var validationErr *common.RequestValidationError
+	var notFoundErr *common.NotFoundError
+	var existsErr *common.AlreadyExistsError
+	var conflictErr *common.ConflictError
+	var unauthorizedErr *common.UnauthorizedError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.As(err, &unauthorizedErr):
+		return http.StatusUnauthorized
+	case errors.As(err, &notFoundErr):
+		return http.StatusNotFound
+	case errors.As(err, &existsErr), errors.As(err, &conflictErr):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
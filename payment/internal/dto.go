@@ -14,25 +14,28 @@ const (
 	Rejected   Status = "rejected"
 	Failed     Status = "failed"
 	Canceled   Status = "canceled"
+	Refunded   Status = "refunded" // списанная сумма возвращена полностью
 )
 
 type Payment struct {
-	ID         uuid.UUID
-	UserID     uuid.UUID
-	OrderID    uuid.UUID
-	Amount     int64
-	Currency   string
-	Status     Status
-	ExternalID string // id транзакции в PSP (если есть)
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
+	ID         uuid.UUID `db:"id"`
+	UserID     uuid.UUID `db:"user_id"`
+	OrderID    uuid.UUID `db:"order_id"`
+	Amount     int64     `db:"amount"`   // при частичной отмене до списания уменьшается
+	Refunded   int64     `db:"refunded"` // сколько уже возвращено после списания
+	Currency   string    `db:"currency"`
+	Status     Status    `db:"status"`
+	ExternalID string    `db:"external_id"` // id транзакции в PSP (если есть)
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
 }
 
+// PaymentRequest на заказ создается один платеж, повтор с теми же данными возвращает его же
 type PaymentRequest struct {
-	UserID   uuid.UUID
-	OrderID  string `json:"orderId"` // Важно: uuid.UUID -> string провайдеры ожидают строку
-	Amount   int64
-	Currency string
+	UserID   uuid.UUID `validate:"required"`
+	OrderID  string    `json:"orderId" validate:"required,uuid"` // Важно: uuid.UUID -> string провайдеры ожидают строку
+	Amount   int64     `validate:"gt=0"`
+	Currency string    `validate:"required,len=3"`
 }
 
 type CreatePaymentResponse struct {
@@ -65,10 +68,34 @@ type PaymentStatusResponse struct {
 type PSPWebhookRequest struct {
 	EventID   string    `json:"eventId"`   // ID события у провайдера
 	PaymentID string    `json:"paymentId"` // ExternalID (их ID платежа) это айди вебхука, чтобы его не дублировать например
-	OrderID   string    `json:"orderId"`
-	Status    Status    `json:"status"` // Но часто статус у них свой, строка: "succeeded", "failed"
+	OrderID   string    `json:"orderId" validate:"required,uuid"`
+	Status    Status    `json:"status" validate:"required"` // Но часто статус у них свой, строка: "succeeded", "failed"
 	Amount    int64     `json:"amount"`
 	Currency  string    `json:"currency"`
 	Signature string    `json:"signature"` // Цифровая подпись для проверки валидности вебхука
 	CreatedAt time.Time `json:"created_at"`
 }
+
+type RefundAction string
+
+const (
+	ActionVoid   RefundAction = "void"   // деньги еще не списаны, снимаем блокировку
+	ActionRefund RefundAction = "refund" // деньги списаны, возвращаем
+)
+
+type RefundRequest struct {
+	Amount int64  `json:"amount" validate:"gt=0"`
+	Key    string `json:"key" validate:"required,max=128"` // повтор с тем же ключом вернет уже сделанный возврат
+	Reason string `json:"reason" validate:"max=255"`
+}
+
+type Refund struct {
+	ID        uuid.UUID    `json:"id" db:"id"`
+	PaymentID uuid.UUID    `json:"payment_id" db:"payment_id"`
+	OrderID   uuid.UUID    `json:"order_id" db:"order_id"`
+	Key       string       `json:"key" db:"key"`
+	Action    RefundAction `json:"action" db:"action"`
+	Amount    int64        `json:"amount" db:"amount"`
+	Reason    string       `json:"reason" db:"reason"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/madrabit/mini-market/payment/internal/common"
+)
+
+// PSPSigner проверяет подпись вебхука провайдера. Провайдер подписывает ключом магазина строку
+// "<eventId>.<paymentId>.<orderId>.<status>.<amount>.<currency>", подпись - HMAC-SHA256 в hex.
+type PSPSigner struct {
+	secret []byte
+}
+
+func NewPSPSigner(cfg common.PSPConfig) *PSPSigner {
+	return &PSPSigner{secret: []byte(cfg.WebhookSecret)}
+}
+
+func (p *PSPSigner) Sign(req PSPWebhookRequest) string {
+	mac := hmac.New(sha256.New, p.secret)
+	_, _ = fmt.Fprintf(mac, "%s.%s.%s.%s.%d.%s", req.EventID, req.PaymentID, req.OrderID, req.Status, req.Amount, req.Currency)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *PSPSigner) Verify(req PSPWebhookRequest) error {
+	if req.Signature == "" || !hmac.Equal([]byte(p.Sign(req)), []byte(req.Signature)) {
+		return &common.UnauthorizedError{Message: "invalid webhook signature"}
+	}
+	return nil
+}
+
+// webhookTransitions переходы, которые может сделать вебхук. Статусы идут только вперед:
+// запоздавший или подделанный вебхук не вернет списанный платеж в authorized.
+var webhookTransitions = map[Status][]Status{
+	Pending:    {Authorized, Failed, Rejected},
+	Authorized: {Captured},
+}
+
+func canApplyWebhook(from, to Status) bool {
+	for _, allowed := range webhookTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/payment/internal/common"
+	"github.com/madrabit/mini-market/payment/internal/validator"
+	"testing"
+)
+
+// paymentRepo хранит один платеж и его возвраты в памяти
+type paymentRepo struct {
+	Repo
+	payment Payment
+	refunds []Refund
+}
+
+func (r *paymentRepo) BeginTransaction() (*sqlx.Tx, error) { return beginNoopTx() }
+
+func (r *paymentRepo) GetPaymentByOrderForUpdate(_ *sqlx.Tx, orderID uuid.UUID) (Payment, error) {
+	if orderID != r.payment.OrderID {
+		return Payment{}, sql.ErrNoRows
+	}
+	return r.payment, nil
+}
+
+func (r *paymentRepo) UpdateStatus(_ *sqlx.Tx, _ uuid.UUID, status Status) error {
+	r.payment.Status = status
+	return nil
+}
+
+func (r *paymentRepo) UpdatePayment(_ *sqlx.Tx, payment Payment) error {
+	r.payment = payment
+	return nil
+}
+
+func (r *paymentRepo) GetRefundByKey(_ *sqlx.Tx, paymentID uuid.UUID, key string) (Refund, error) {
+	for _, refund := range r.refunds {
+		if refund.PaymentID == paymentID && refund.Key == key {
+			return refund, nil
+		}
+	}
+	return Refund{}, sql.ErrNoRows
+}
+
+func (r *paymentRepo) AddRefund(_ *sqlx.Tx, refund Refund) error {
+	r.refunds = append(r.refunds, refund)
+	return nil
+}
+
+func (r *paymentRepo) PSPWebhook(_ *sqlx.Tx, req PSPWebhookRequest) error {
+	r.payment.Status, r.payment.ExternalID = req.Status, req.PaymentID
+	return nil
+}
+
+// capturedOrders запоминает платежи, о списании которых сообщили Order
+type capturedOrders []Payment
+
+func (o *capturedOrders) PaymentCaptured(payment Payment) error {
+	*o = append(*o, payment)
+	return nil
+}
+
+func newPaymentService(status Status) (*Service, *paymentRepo) {
+	repo := &paymentRepo{payment: Payment{ID: uuid.New(), OrderID: uuid.New(), Amount: 1000, Currency: "RUB", Status: status}}
+	return NewService(repo, validator.New(), &capturedOrders{}, pspSigner), repo
+}
+
+func TestRefundVoidsAuthorizedPayment(t *testing.T) {
+	svc, repo := newPaymentService(Authorized)
+	orderID := repo.payment.OrderID
+	refund, err := svc.Refund(orderID, RefundRequest{Amount: 300, Key: "cancel:a"})
+	if err != nil {
+		t.Fatalf("void: %v", err)
+	}
+	if refund.Action != ActionVoid || repo.payment.Amount != 700 || repo.payment.Status != Authorized {
+		t.Errorf("action %s, amount %d, status %s; want void, 700, authorized", refund.Action, repo.payment.Amount, repo.payment.Status)
+	}
+
+	// повтор с тем же ключом не уменьшает блокировку второй раз
+	again, err := svc.Refund(orderID, RefundRequest{Amount: 300, Key: "cancel:a"})
+	if err != nil || again.ID != refund.ID || repo.payment.Amount != 700 {
+		t.Fatalf("repeated void = %v, %v, amount %d; want same refund, amount 700", again.ID, err, repo.payment.Amount)
+	}
+
+	var conflict *common.ConflictError
+	if _, err = svc.Refund(orderID, RefundRequest{Amount: 701, Key: "cancel:b"}); !errors.As(err, &conflict) {
+		t.Errorf("void above authorized: err = %v, want ConflictError", err)
+	}
+	if _, err = svc.Refund(orderID, RefundRequest{Amount: 700, Key: "cancel:b"}); err != nil {
+		t.Fatalf("void rest: %v", err)
+	}
+	if repo.payment.Status != Canceled || len(repo.refunds) != 2 {
+		t.Errorf("status %s, refunds %d; want canceled, 2", repo.payment.Status, len(repo.refunds))
+	}
+}
+
+func TestRefundCapturedPayment(t *testing.T) {
+	svc, repo := newPaymentService(Captured)
+	orderID := repo.payment.OrderID
+	refund, err := svc.Refund(orderID, RefundRequest{Amount: 400, Key: "cancel:a"})
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if refund.Action != ActionRefund || repo.payment.Refunded != 400 || repo.payment.Amount != 1000 {
+		t.Errorf("action %s, refunded %d, amount %d; want refund, 400, 1000", refund.Action, repo.payment.Refunded, repo.payment.Amount)
+	}
+	var conflict *common.ConflictError
+	if _, err = svc.Refund(orderID, RefundRequest{Amount: 601, Key: "cancel:b"}); !errors.As(err, &conflict) {
+		t.Errorf("refund above remaining: err = %v, want ConflictError", err)
+	}
+	if _, err = svc.Refund(orderID, RefundRequest{Amount: 600, Key: "cancel:b"}); err != nil {
+		t.Fatalf("refund rest: %v", err)
+	}
+	if repo.payment.Status != Refunded {
+		t.Errorf("status = %s, want refunded", repo.payment.Status)
+	}
+	if _, err = svc.Refund(orderID, RefundRequest{Amount: 1, Key: "cancel:c"}); !errors.As(err, &conflict) {
+		t.Errorf("refund of refunded payment: err = %v, want ConflictError", err)
+	}
+}
+
+func TestCancelPayment(t *testing.T) {
+	tests := []struct {
+		status  Status
+		want    Status
+		wantErr bool
+	}{
+		{status: Pending, want: Canceled},
+		{status: Authorized, want: Canceled},
+		{status: Canceled, want: Canceled},
+		{status: Rejected, want: Rejected},
+		{status: Captured, want: Captured, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			svc, repo := newPaymentService(tt.status)
+			err := svc.CancelPayment(repo.payment.OrderID)
+			var conflict *common.ConflictError
+			if tt.wantErr != errors.As(err, &conflict) || !tt.wantErr && err != nil {
+				t.Fatalf("err = %v, want conflict %v", err, tt.wantErr)
+			}
+			if repo.payment.Status != tt.want {
+				t.Errorf("status = %s, want %s", repo.payment.Status, tt.want)
+			}
+		})
+	}
+
+	svc, _ := newPaymentService(Pending)
+	var notFound *common.NotFoundError
+	if err := svc.CancelPayment(uuid.New()); !errors.As(err, &notFound) {
+		t.Errorf("unknown order: err = %v, want NotFoundError", err)
+	}
+}
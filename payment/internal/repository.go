@@ -1 +1,108 @@
 package internal
+
+import (
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) BeginTransaction() (tx *sqlx.Tx, err error) {
+	return r.db.Beginx()
+}
+
+func (r *Repository) FindItemById(tx *sqlx.Tx, paymentID uuid.UUID) (bool, error) {
+	var exists bool
+	err := tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM payments WHERE id = $1)`, paymentID)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// CreateOrder создает платеж, если по заказу его еще нет. Уже созданный платеж не трогает.
+func (r *Repository) CreateOrder(tx *sqlx.Tx, req PaymentRequest) error {
+	now := time.Now()
+	_, err := tx.Exec(`INSERT INTO payments (id, user_id, order_id, amount, currency, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		ON CONFLICT (order_id) DO NOTHING`,
+		uuid.New(), req.UserID, req.OrderID, req.Amount, req.Currency, Pending, now)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// PSPWebhook переводит платеж заказа в статус из вебхука и запоминает id транзакции у провайдера
+func (r *Repository) PSPWebhook(tx *sqlx.Tx, req PSPWebhookRequest) error {
+	_, err := tx.Exec(`UPDATE payments SET status = $1, external_id = $2, updated_at = NOW() WHERE order_id = $3`,
+		req.Status, req.PaymentID, req.OrderID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetStatus(userID, orderID uuid.UUID) (PaymentStatusResponse, error) {
+	var status PaymentStatusResponse
+	err := r.db.QueryRowx(`SELECT order_id, id, status, amount, currency FROM payments
+		WHERE user_id = $1 AND order_id = $2`, userID, orderID).
+		Scan(&status.OrderID, &status.PaymentID, &status.Status, &status.Amount, &status.Currency)
+	if err != nil {
+		return PaymentStatusResponse{}, err
+	}
+	return status, nil
+}
+
+func (r *Repository) GetPaymentByOrderForUpdate(tx *sqlx.Tx, orderID uuid.UUID) (Payment, error) {
+	var payment Payment
+	err := tx.Get(&payment, `SELECT id, user_id, order_id, amount, refunded, currency, status, external_id,
+       created_at, updated_at FROM payments WHERE order_id = $1 FOR UPDATE`, orderID)
+	if err != nil {
+		return Payment{}, err
+	}
+	return payment, nil
+}
+
+func (r *Repository) UpdateStatus(tx *sqlx.Tx, paymentID uuid.UUID, status Status) error {
+	_, err := tx.Exec(`UPDATE payments SET status = $1, updated_at = NOW() WHERE id = $2`, status, paymentID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) UpdatePayment(tx *sqlx.Tx, payment Payment) error {
+	_, err := tx.NamedExec(`UPDATE payments SET amount = :amount, refunded = :refunded, status = :status,
+		external_id = :external_id, updated_at = :updated_at WHERE id = :id`, payment)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetRefundByKey(tx *sqlx.Tx, paymentID uuid.UUID, key string) (Refund, error) {
+	var refund Refund
+	err := tx.Get(&refund, `SELECT id, payment_id, order_id, key, action, amount, reason, created_at
+		FROM payment_refunds WHERE payment_id = $1 AND key = $2`, paymentID, key)
+	if err != nil {
+		return Refund{}, err
+	}
+	return refund, nil
+}
+
+func (r *Repository) AddRefund(tx *sqlx.Tx, refund Refund) error {
+	_, err := tx.NamedExec(`INSERT INTO payment_refunds (id, payment_id, order_id, key, action, amount, reason, created_at)
+		VALUES (:id, :payment_id, :order_id, :key, :action, :amount, :reason, :created_at)`, refund)
+	if err != nil {
+		return err
+	}
+	return nil
+}
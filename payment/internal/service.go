@@ -7,33 +7,57 @@ import (
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/madrabit/mini-market/payment/internal/common"
+	"time"
 )
 
 type Service struct {
 	repo      Repo
 	validator Validator
+	orders    Orders
+	webhooks  WebhookVerifier
 }
 
 type Repo interface {
 	BeginTransaction() (tx *sqlx.Tx, err error)
 	FindItemById(tx *sqlx.Tx, productID uuid.UUID) (bool, error)
-	CreateOrder(tx *sqlx.Tx, req PaymentRequest) (CreatePaymentResponse, error)
+	CreateOrder(tx *sqlx.Tx, req PaymentRequest) error
 	PSPWebhook(tx *sqlx.Tx, req PSPWebhookRequest) error
 	GetStatus(userID, orderID uuid.UUID) (PaymentStatusResponse, error)
+	GetPaymentByOrderForUpdate(tx *sqlx.Tx, orderID uuid.UUID) (Payment, error)
+	UpdateStatus(tx *sqlx.Tx, paymentID uuid.UUID, status Status) error
+	UpdatePayment(tx *sqlx.Tx, payment Payment) error
+	GetRefundByKey(tx *sqlx.Tx, paymentID uuid.UUID, key string) (Refund, error)
+	AddRefund(tx *sqlx.Tx, refund Refund) error
+}
+
+// Orders получатель callback об оплате, см. OrderClient
+type Orders interface {
+	PaymentCaptured(payment Payment) error
+}
+
+// WebhookVerifier проверка подписи вебхука провайдера, см. PSPSigner
+type WebhookVerifier interface {
+	Verify(req PSPWebhookRequest) error
 }
 
 type Validator interface {
 	Validate(request any) error
 }
 
-func NewService(repo Repo, validator Validator) *Service {
-	return &Service{repo, validator}
+func NewService(repo Repo, validator Validator, orders Orders, webhooks WebhookVerifier) *Service {
+	return &Service{repo, validator, orders, webhooks}
 }
 
-func (s *Service) CreateOrder(req PaymentRequest) (CreatePaymentResponse, error) {
+// CreateOrder создает платеж по заказу. На заказ один платеж: повтор после потерянного ответа
+// возвращает уже созданный, а запрос с другой суммой или валютой - конфликт.
+func (s *Service) CreateOrder(req PaymentRequest) (resp CreatePaymentResponse, err error) {
 	if err := s.validator.Validate(req); err != nil {
 		return CreatePaymentResponse{}, &common.RequestValidationError{Message: err.Error()}
 	}
+	orderID, err := uuid.Parse(req.OrderID)
+	if err != nil {
+		return CreatePaymentResponse{}, &common.RequestValidationError{Message: "invalid order id"}
+	}
 	tx, err := s.repo.BeginTransaction()
 	if err != nil {
 		return CreatePaymentResponse{}, fmt.Errorf("payment service: create order: error starting transaction")
@@ -54,24 +78,23 @@ func (s *Service) CreateOrder(req PaymentRequest) (CreatePaymentResponse, error)
 			err = fmt.Errorf("payment service: create order: committing transaction failed: %w", commitErr)
 		}
 	}()
-	order := CreatePaymentResponse{
-		PaymentID: uuid.New(),
-		Status:    Pending,
-		Amount:    req.Amount,
-		Currency:  req.Currency,
-	}
-	isExists, err := s.repo.FindItemById(tx, order.PaymentID)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return CreatePaymentResponse{}, fmt.Errorf("payment service: create order: error checking exists of order")
-	}
-	if isExists {
-		return CreatePaymentResponse{}, &common.AlreadyExistsError{Message: fmt.Sprintf("payment with id %s already exists", order.PaymentID)}
+	if err = s.repo.CreateOrder(tx, req); err != nil {
+		return CreatePaymentResponse{}, fmt.Errorf("payment service: create order: error adding order: %w", err)
 	}
-	order, err = s.repo.CreateOrder(tx, req)
+	payment, err := s.repo.GetPaymentByOrderForUpdate(tx, orderID)
 	if err != nil {
-		return CreatePaymentResponse{}, fmt.Errorf("payment service: create order: error adding order")
+		return CreatePaymentResponse{}, fmt.Errorf("payment service: create order: error getting payment: %w", err)
 	}
-	return order, nil
+	if payment.UserID != req.UserID || payment.Amount != req.Amount || payment.Currency != req.Currency {
+		err = &common.ConflictError{Message: fmt.Sprintf("payment for order %s already exists with other amount", orderID)}
+		return CreatePaymentResponse{}, err
+	}
+	return CreatePaymentResponse{
+		PaymentID: payment.ID,
+		Status:    payment.Status,
+		Amount:    payment.Amount,
+		Currency:  payment.Currency,
+	}, nil
 }
 
 func (s *Service) GetStatus(userID, orderID uuid.UUID) (PaymentStatusResponse, error) {
@@ -85,13 +108,43 @@ func (s *Service) GetStatus(userID, orderID uuid.UUID) (PaymentStatusResponse, e
 	return status, nil
 }
 
+// PSPWebhook обновляет платеж по вебхуку провайдера. Когда деньги списаны, Order получает
+// подписанный callback. Если Order недоступен, вебхук отвечает ошибкой и провайдер его повторит:
+// повторное обновление ничего не меняет, а callback уйдет еще раз.
+// Вебхук без подписи провайдера отклоняется до того, как платеж будет прочитан.
 func (s *Service) PSPWebhook(req PSPWebhookRequest) (PaymentStatusResponse, error) {
+	if err := s.webhooks.Verify(req); err != nil {
+		return PaymentStatusResponse{}, err
+	}
 	if err := s.validator.Validate(req); err != nil {
 		return PaymentStatusResponse{}, &common.RequestValidationError{Message: err.Error()}
 	}
+	payment, err := s.applyWebhook(req)
+	if err != nil {
+		return PaymentStatusResponse{}, err
+	}
+	if payment.Status == Captured {
+		if err = s.orders.PaymentCaptured(payment); err != nil {
+			return PaymentStatusResponse{}, fmt.Errorf("payment service: pspwebhook: %w", err)
+		}
+	}
+	return PaymentStatusResponse{
+		OrderID:   payment.OrderID,
+		PaymentID: payment.ID,
+		Status:    payment.Status,
+		Amount:    payment.Amount,
+		Currency:  payment.Currency,
+	}, nil
+}
+
+func (s *Service) applyWebhook(req PSPWebhookRequest) (payment Payment, err error) {
+	orderID, err := uuid.Parse(req.OrderID)
+	if err != nil {
+		return Payment{}, &common.RequestValidationError{Message: "invalid order id"}
+	}
 	tx, err := s.repo.BeginTransaction()
 	if err != nil {
-		return PaymentStatusResponse{}, fmt.Errorf("payment service: pspwebhook: error starting transaction")
+		return Payment{}, fmt.Errorf("payment service: pspwebhook: error starting transaction")
 	}
 	defer func() {
 		if p := recover(); p != nil {
@@ -109,15 +162,151 @@ func (s *Service) PSPWebhook(req PSPWebhookRequest) (PaymentStatusResponse, erro
 			err = fmt.Errorf("payment service: pspwebhook: committing transaction failed: %w", commitErr)
 		}
 	}()
-	resp := PaymentStatusResponse{
-		PaymentID: uuid.New(),
-		Status:    Authorized,
-		Amount:    req.Amount,
-		Currency:  req.Currency,
+	payment, err = s.repo.GetPaymentByOrderForUpdate(tx, orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Payment{}, &common.NotFoundError{Message: fmt.Sprintf("payment for order %s not found", orderID)}
+	}
+	if err != nil {
+		return Payment{}, fmt.Errorf("payment service: pspwebhook: error getting payment: %w", err)
+	}
+	if payment.Status == req.Status {
+		return payment, nil
+	}
+	if !canApplyWebhook(payment.Status, req.Status) {
+		return Payment{}, &common.ConflictError{Message: fmt.Sprintf("payment for order %s cannot move from %s to %s",
+			orderID, payment.Status, req.Status)}
 	}
 	err = s.repo.PSPWebhook(tx, req)
 	if err != nil {
-		return PaymentStatusResponse{}, fmt.Errorf("payment service: pspwebhook: error create pspwebhook")
+		return Payment{}, fmt.Errorf("payment service: pspwebhook: error create pspwebhook")
+	}
+	payment.Status, payment.ExternalID = req.Status, req.PaymentID
+	return payment, nil
+}
+
+// CancelPayment отменяет платеж по заказу, пока деньги не списаны. Повторная отмена ничего не делает.
+func (s *Service) CancelPayment(orderID uuid.UUID) (err error) {
+	if orderID == uuid.Nil {
+		return &common.RequestValidationError{Message: "invalid order id"}
+	}
+	tx, err := s.repo.BeginTransaction()
+	if err != nil {
+		return fmt.Errorf("payment service: cancel payment: error starting transaction")
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			err = fmt.Errorf("payment service: cancel payment: panic cancel payment: %v", p)
+			return
+		}
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				err = fmt.Errorf("rollback failed: original error: %w", err)
+			}
+			return
+		}
+		if commitErr := tx.Commit(); commitErr != nil {
+			err = fmt.Errorf("payment service: cancel payment: committing transaction failed: %w", commitErr)
+		}
+	}()
+	payment, err := s.repo.GetPaymentByOrderForUpdate(tx, orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &common.NotFoundError{Message: fmt.Sprintf("payment for order %s not found", orderID)}
+	}
+	if err != nil {
+		return fmt.Errorf("payment service: cancel payment: %w", err)
+	}
+	switch payment.Status {
+	case Canceled, Rejected, Failed:
+		return nil
+	case Captured:
+		return &common.ConflictError{Message: fmt.Sprintf("payment %s is already captured", payment.ID)}
+	}
+	if err = s.repo.UpdateStatus(tx, payment.ID, Canceled); err != nil {
+		return fmt.Errorf("payment service: cancel payment: %w", err)
+	}
+	return nil
+}
+
+// Refund возвращает часть или всю сумму по заказу. Пока деньги только заблокированы, блокировка
+// уменьшается (void), после списания оформляется возврат (refund).
+func (s *Service) Refund(orderID uuid.UUID, req RefundRequest) (refund Refund, err error) {
+	if err = s.validator.Validate(req); err != nil {
+		return Refund{}, &common.RequestValidationError{Message: err.Error()}
+	}
+	tx, err := s.repo.BeginTransaction()
+	if err != nil {
+		return Refund{}, fmt.Errorf("payment service: refund: error starting transaction")
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			err = fmt.Errorf("payment service: refund: panic refund: %v", p)
+			return
+		}
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				err = fmt.Errorf("rollback failed: original error: %w", err)
+			}
+			return
+		}
+		if commitErr := tx.Commit(); commitErr != nil {
+			err = fmt.Errorf("payment service: refund: committing transaction failed: %w", commitErr)
+		}
+	}()
+	payment, err := s.repo.GetPaymentByOrderForUpdate(tx, orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Refund{}, &common.NotFoundError{Message: fmt.Sprintf("payment for order %s not found", orderID)}
+	}
+	if err != nil {
+		return Refund{}, fmt.Errorf("payment service: refund: %w", err)
+	}
+	refund, err = s.repo.GetRefundByKey(tx, payment.ID, req.Key)
+	if err == nil {
+		return refund, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Refund{}, fmt.Errorf("payment service: refund: %w", err)
+	}
+	refund = Refund{
+		ID:        uuid.New(),
+		PaymentID: payment.ID,
+		OrderID:   orderID,
+		Key:       req.Key,
+		Amount:    req.Amount,
+		Reason:    req.Reason,
+		CreatedAt: time.Now(),
+	}
+	switch payment.Status {
+	case Pending, Authorized:
+		if req.Amount > payment.Amount {
+			return Refund{}, &common.ConflictError{Message: fmt.Sprintf("payment %s: void %d exceeds authorized %d",
+				payment.ID, req.Amount, payment.Amount)}
+		}
+		refund.Action = ActionVoid
+		payment.Amount -= req.Amount
+		if payment.Amount == 0 {
+			payment.Status = Canceled
+		}
+	case Captured:
+		if left := payment.Amount - payment.Refunded; req.Amount > left {
+			return Refund{}, &common.ConflictError{Message: fmt.Sprintf("payment %s: refund %d exceeds remaining %d",
+				payment.ID, req.Amount, left)}
+		}
+		refund.Action = ActionRefund
+		payment.Refunded += req.Amount
+		if payment.Refunded == payment.Amount {
+			payment.Status = Refunded
+		}
+	default:
+		return Refund{}, &common.ConflictError{Message: fmt.Sprintf("payment %s is %s, nothing to refund", payment.ID, payment.Status)}
+	}
+	payment.UpdatedAt = refund.CreatedAt
+	if err = s.repo.UpdatePayment(tx, payment); err != nil {
+		return Refund{}, fmt.Errorf("payment service: refund: %w", err)
+	}
+	if err = s.repo.AddRefund(tx, refund); err != nil {
+		return Refund{}, fmt.Errorf("payment service: refund: %w", err)
 	}
-	return resp, nil
+	return refund, nil
 }
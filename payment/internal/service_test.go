@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/madrabit/mini-market/payment/internal/common"
+	"github.com/madrabit/mini-market/payment/internal/validator"
+	"testing"
+)
+
+// CreateOrder как ON CONFLICT (order_id) DO NOTHING: платеж создается только первый раз
+func (r *paymentRepo) CreateOrder(_ *sqlx.Tx, req PaymentRequest) error {
+	if r.payment.ID != uuid.Nil {
+		return nil
+	}
+	r.payment = Payment{ID: uuid.New(), UserID: req.UserID, OrderID: uuid.MustParse(req.OrderID),
+		Amount: req.Amount, Currency: req.Currency, Status: Pending}
+	return nil
+}
+
+func TestCreatePaymentOncePerOrder(t *testing.T) {
+	repo := &paymentRepo{}
+	svc := NewService(repo, validator.New(), &capturedOrders{}, pspSigner)
+	req := PaymentRequest{UserID: uuid.New(), OrderID: uuid.NewString(), Amount: 1000, Currency: "RUB"}
+	created, err := svc.CreateOrder(req)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.Status != Pending || created.Amount != 1000 {
+		t.Errorf("payment = %s/%d, want pending/1000", created.Status, created.Amount)
+	}
+
+	// повтор после потерянного ответа возвращает тот же платеж
+	again, err := svc.CreateOrder(req)
+	if err != nil || again.PaymentID != created.PaymentID {
+		t.Fatalf("repeated create = %v, %v; want %v", again.PaymentID, err, created.PaymentID)
+	}
+
+	other := req
+	other.Amount = 900
+	var conflict *common.ConflictError
+	if _, err = svc.CreateOrder(other); !errors.As(err, &conflict) {
+		t.Errorf("create with other amount: err = %v, want ConflictError", err)
+	}
+	if repo.payment.Amount != 1000 {
+		t.Errorf("amount = %d, want 1000", repo.payment.Amount)
+	}
+}
+
+var pspSigner = NewPSPSigner(common.PSPConfig{WebhookSecret: "psp-secret"})
+
+// webhook подписанный вебхук провайдера о платеже заказа
+func webhook(orderID uuid.UUID, status Status) PSPWebhookRequest {
+	req := PSPWebhookRequest{EventID: uuid.NewString(), PaymentID: "psp-1", OrderID: orderID.String(),
+		Status: status, Amount: 1000, Currency: "RUB"}
+	req.Signature = pspSigner.Sign(req)
+	return req
+}
+
+func TestWebhookCaptureNotifiesOrder(t *testing.T) {
+	repo := &paymentRepo{payment: Payment{ID: uuid.New(), OrderID: uuid.New(), Amount: 1000, Currency: "RUB", Status: Pending}}
+	orders := &capturedOrders{}
+	svc := NewService(repo, validator.New(), orders, pspSigner)
+	orderID := repo.payment.OrderID
+
+	if _, err := svc.PSPWebhook(webhook(orderID, Authorized)); err != nil {
+		t.Fatalf("authorized webhook: %v", err)
+	}
+	if len(*orders) != 0 {
+		t.Fatalf("callbacks after authorization = %d, want 0", len(*orders))
+	}
+	status, err := svc.PSPWebhook(webhook(orderID, Captured))
+	if err != nil {
+		t.Fatalf("captured webhook: %v", err)
+	}
+	if status.Status != Captured || len(*orders) != 1 || (*orders)[0].ID != repo.payment.ID {
+		t.Errorf("status %s, callbacks %+v; want captured and one callback for the payment", status.Status, *orders)
+	}
+
+	// повтор вебхука ничего не меняет, но callback уходит еще раз
+	if _, err = svc.PSPWebhook(webhook(orderID, Captured)); err != nil || len(*orders) != 2 {
+		t.Errorf("repeated capture: err %v, callbacks %d; want nil, 2", err, len(*orders))
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	repo := &paymentRepo{payment: Payment{ID: uuid.New(), OrderID: uuid.New(), Amount: 1000, Currency: "RUB", Status: Pending}}
+	svc := NewService(repo, validator.New(), &capturedOrders{}, pspSigner)
+	tests := []struct {
+		name   string
+		modify func(req *PSPWebhookRequest)
+	}{
+		{name: "missing signature", modify: func(req *PSPWebhookRequest) { req.Signature = "" }},
+		{name: "tampered status", modify: func(req *PSPWebhookRequest) { req.Status = Captured }},
+		{name: "other key", modify: func(req *PSPWebhookRequest) {
+			req.Signature = NewPSPSigner(common.PSPConfig{WebhookSecret: "other"}).Sign(*req)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := webhook(repo.payment.OrderID, Authorized)
+			tt.modify(&req)
+			_, err := svc.PSPWebhook(req)
+			var unauthorized *common.UnauthorizedError
+			if !errors.As(err, &unauthorized) {
+				t.Fatalf("err = %v, want UnauthorizedError", err)
+			}
+			if repo.payment.Status != Pending {
+				t.Errorf("status = %s, want pending", repo.payment.Status)
+			}
+		})
+	}
+}
+
+func TestWebhookTransitions(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		ok       bool
+	}{
+		{from: Pending, to: Authorized, ok: true},
+		{from: Pending, to: Failed, ok: true},
+		{from: Pending, to: Rejected, ok: true},
+		{from: Authorized, to: Captured, ok: true},
+		{from: Pending, to: Captured},
+		{from: Captured, to: Authorized},
+		{from: Captured, to: Failed},
+		{from: Failed, to: Authorized},
+		{from: Rejected, to: Captured},
+		{from: Canceled, to: Captured},
+		{from: Refunded, to: Captured},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			repo := &paymentRepo{payment: Payment{ID: uuid.New(), OrderID: uuid.New(), Amount: 1000, Currency: "RUB", Status: tt.from}}
+			svc := NewService(repo, validator.New(), &capturedOrders{}, pspSigner)
+			_, err := svc.PSPWebhook(webhook(repo.payment.OrderID, tt.to))
+			if tt.ok {
+				if err != nil || repo.payment.Status != tt.to {
+					t.Fatalf("err %v, status %s; want nil, %s", err, repo.payment.Status, tt.to)
+				}
+				return
+			}
+			var conflict *common.ConflictError
+			if !errors.As(err, &conflict) || repo.payment.Status != tt.from {
+				t.Errorf("err %v, status %s; want ConflictError, %s", err, repo.payment.Status, tt.from)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS payments
+(
+    id          UUID PRIMARY KEY,
+    user_id     UUID        NOT NULL,
+    order_id    UUID        NOT NULL,
+    amount      BIGINT      NOT NULL CHECK (amount >= 0),
+    refunded    BIGINT      NOT NULL DEFAULT 0 CHECK (refunded >= 0),
+    currency    VARCHAR(3)  NOT NULL,
+    status      VARCHAR(20) NOT NULL,
+    external_id VARCHAR(64) NOT NULL DEFAULT '',
+    created_at  TIMESTAMP DEFAULT NOW(),
+    updated_at  TIMESTAMP DEFAULT NOW(),
+    CHECK (refunded <= amount)
+);
+
+-- один платеж на заказ: повтор создания после потерянного ответа вернет уже созданный
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_order ON payments (order_id);
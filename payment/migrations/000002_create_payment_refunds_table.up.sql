@@ -0,0 +1,14 @@
+-- void и refund по платежу. Ключ идемпотентности задает Order, повтор с тем же ключом
+-- возвращает уже сделанный возврат.
+CREATE TABLE IF NOT EXISTS payment_refunds
+(
+    id         UUID PRIMARY KEY,
+    payment_id UUID         NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+    order_id   UUID         NOT NULL,
+    key        VARCHAR(128) NOT NULL,
+    action     VARCHAR(10)  NOT NULL,
+    amount     BIGINT       NOT NULL CHECK (amount > 0),
+    reason     VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (payment_id, key)
+);
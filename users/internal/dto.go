@@ -1,13 +1,18 @@
 package internal
 
-import "github.com/google/uuid"
+import (
+	"github.com/google/uuid"
+	"time"
+)
 
 type User struct {
-	Id           uuid.UUID `json:"id" db:"id"`
-	Name         string    `json:"name"  db:"name"`
-	Email        string    `json:"email" db:"email"`
-	PasswordHash string    `json:"-" db:"password_hash"`
-	Roles        []Role    `json:"roles" db:"-"`
+	Id           uuid.UUID  `json:"id" db:"id"`
+	Name         string     `json:"name"  db:"name"`
+	Email        string     `json:"email" db:"email"`
+	PasswordHash string     `json:"-" db:"password_hash"`
+	BirthDate    *time.Time `json:"birth_date" db:"birth_date"`
+	Region       string     `json:"region" db:"region"`
+	Roles        []Role     `json:"roles" db:"-"`
 }
 
 type CreateUserReq struct {
@@ -16,9 +21,12 @@ type CreateUserReq struct {
 	Password string `json:"password" validate:"required,min=8"`
 }
 
+// UpdateUserReq пустые BirthDate и Region не меняют сохраненные значения
 type UpdateUserReq struct {
-	Name  string `json:"name" validate:"required,min=2"`
-	Email string `json:"email" validate:"required,email"`
+	Name      string     `json:"name" validate:"required,min=2"`
+	Email     string     `json:"email" validate:"required,email"`
+	BirthDate *time.Time `json:"birth_date"`
+	Region    string     `json:"region" validate:"omitempty,max=10"`
 }
 
 type UserResponse struct {
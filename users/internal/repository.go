@@ -57,8 +57,10 @@ func (r *Repository) AddUserRoles(ctx context.Context, tx *sqlx.Tx, userId uuid.
 }
 
 func (r *Repository) UpdateUser(ctx context.Context, user User) error {
-	result, err := r.db.ExecContext(ctx, `UPDATE users SET name = $1, email = $2  WHERE id = $3 `,
-		user.Name, user.Email, user.Id)
+	result, err := r.db.ExecContext(ctx, `UPDATE users SET name = $1, email = $2,
+		birth_date = COALESCE($3, birth_date), region = COALESCE(NULLIF($4, ''), region), updated_at = NOW()
+		WHERE id = $5`,
+		user.Name, user.Email, user.BirthDate, user.Region, user.Id)
 	if err != nil {
 		return err
 	}
@@ -82,7 +84,7 @@ func (r *Repository) DeleteUser(ctx context.Context, userID uuid.UUID) error {
 
 func (r *Repository) GetUserByID(ctx context.Context, userID uuid.UUID) (User, error) {
 	var user User
-	if err := r.db.GetContext(ctx, &user, "SELECT id, name, email, password_hash, created_at, updated_at FROM users WHERE id=$1", userID); err != nil {
+	if err := r.db.GetContext(ctx, &user, "SELECT id, name, email, password_hash, birth_date, region FROM users WHERE id=$1", userID); err != nil {
 		return User{}, err
 	}
 	return user, nil
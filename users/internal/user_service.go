@@ -86,9 +86,11 @@ func (s *UserService) UpdateUser(ctx context.Context, id uuid.UUID, req UpdateUs
 		return &common.RequestValidationError{Message: err.Error()}
 	}
 	user := User{
-		Id:    id,
-		Name:  req.Name,
-		Email: req.Email,
+		Id:        id,
+		Name:      req.Name,
+		Email:     req.Email,
+		BirthDate: req.BirthDate,
+		Region:    req.Region,
 	}
 	err := s.userRepo.UpdateUser(ctx, user)
 	if err != nil {
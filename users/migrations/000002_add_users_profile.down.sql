@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS birth_date,
+    DROP COLUMN IF EXISTS region;